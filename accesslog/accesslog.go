@@ -0,0 +1,100 @@
+// Package accesslog writes one JSON line per request: time, request id, method, path, route pattern,
+// status, latency, bytes written, client IP and authenticated user. It replaces chi's text logger so
+// the access log can be parsed by log shippers, and goes to stdout or to a size rotated file (see File).
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Entry is one line of the access log
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Route      string    `json:"route,omitempty"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int       `json:"bytes"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	// Set once the token of the request was verified
+	UserID int `json:"user_id,omitempty"`
+}
+
+type userKey struct{}
+
+// userHolder is filled by SetUser further down the middleware chain
+type userHolder struct {
+	mu sync.Mutex
+	id int
+}
+
+// SetUser records the authenticated user of the request of ctx, a no-op without the middleware
+func SetUser(ctx context.Context, userID int) {
+	if h, ok := ctx.Value(userKey{}).(*userHolder); ok {
+		h.mu.Lock()
+		h.id = userID
+		h.mu.Unlock()
+	}
+}
+
+// Middleware writes the entry of every request to out, one JSON object per line. It must be registered
+// on the root router after the request id and real IP middlewares. The query string is left out, as it
+// can hold tokens.
+func Middleware(out io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			user := &userHolder{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+
+			entry := Entry{
+				Time:       start.UTC(),
+				RequestID:  middleware.GetReqID(r.Context()),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Proto:      r.Proto,
+				Status:     ww.Status(),
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+				Bytes:      ww.BytesWritten(),
+				IP:         r.RemoteAddr,
+				UserAgent:  r.UserAgent(),
+			}
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				entry.Route = rctx.RoutePattern()
+			}
+			// Handlers that write nothing answer 200
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			user.mu.Lock()
+			entry.UserID = user.id
+			user.mu.Unlock()
+
+			line, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("[AccessLog:Middleware] Error encoding the entry of request %s: %v", entry.RequestID, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, err := out.Write(append(line, '\n')); err != nil {
+				log.Printf("[AccessLog:Middleware] Error writing the access log: %v", err)
+			}
+		})
+	}
+}
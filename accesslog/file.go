@@ -0,0 +1,113 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the time suffix of the rotated files, sortable as text
+const backupTimeLayout = "20060102T150405.000"
+
+// File is an append-only log file rotated by size: the write that would take it past MaxSize first
+// renames it to <path>.<UTC time> and starts a new one, and only the MaxBackups newest renamed files
+// are kept. It is safe for concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenFile opens path for appending, creating it and its directory if needed. maxBackups 0 keeps every
+// rotated file.
+func OpenFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("accesslog: rotating %s: %w", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.path+"."+time.Now().UTC().Format(backupTimeLayout)); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups deletes the rotated files past maxBackups, the oldest first. Failures are left for
+// the next rotation.
+func (f *File) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	// Only the files named by rotate, not other files sharing the prefix
+	kept := backups[:0]
+	for _, backup := range backups {
+		if _, err := time.Parse(backupTimeLayout, strings.TrimPrefix(backup, f.path+".")); err == nil {
+			kept = append(kept, backup)
+		}
+	}
+	sort.Strings(kept)
+	for len(kept) > f.maxBackups {
+		os.Remove(kept[0])
+		kept = kept[1:]
+	}
+}
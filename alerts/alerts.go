@@ -0,0 +1,255 @@
+// Package alerts warns the operators of suspicious activity: repeated failed logins on an admin account,
+// lockouts (see package lockout) and bursts of tokens failing verification, which can mean forged or
+// stolen tokens. Each alert is sent to every configured destination (a JSON webhook, a Slack incoming
+// webhook, an email address) by a background job, so a destination being down never slows a request.
+//
+// Counters are kept per instance over fixed windows: with several instances, each one alerts on what
+// it sees.
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Alert kinds
+const (
+	AdminLoginFailures = "admin_login_failures"
+	LoginLocked        = "login_locked"
+	TokenFailures      = "token_failures"
+)
+
+// Job kind of the sendings
+const sendJob = "alert.send"
+
+// Destinations of the sendings
+const (
+	toWebhook = "webhook"
+	toSlack   = "slack"
+	toEmail   = "email"
+)
+
+type Config struct {
+	WebhookURL      string
+	SlackWebhookURL string
+	Email           string
+	// Failed logins on an admin account, and tokens failing verification, within Window raising an alert.
+	// 0 turns the alert off.
+	AdminLoginFailures int
+	TokenFailures      int
+	Window             time.Duration
+	// How long a destination gets to answer
+	Timeout time.Duration
+	// Key of the encrypted emails of the users table (PII_ENCRYPTION_KEY), nil when they are in clear
+	PIIKey []byte
+}
+
+// Alert is the JSON body posted to the webhook destination
+type Alert struct {
+	Kind    string            `json:"kind"`
+	Summary string            `json:"summary"`
+	Details map[string]string `json:"details,omitempty"`
+	At      time.Time         `json:"at"`
+}
+
+// sending is the payload of a send job, one per destination so a failing one is retried alone
+type sending struct {
+	To    string `json:"to"`
+	Alert Alert  `json:"alert"`
+}
+
+type Alerter struct {
+	db     *pgxpool.Pool
+	queue  *jobs.Queue
+	mailer mailer.Mailer
+	client *http.Client
+	cfg    Config
+
+	mu sync.Mutex
+	// Failed logins by lower cased email, and tokens rejected, in the current window
+	loginFailures map[string]int
+	tokenFailures int
+	windowEnd     time.Time
+}
+
+// New registers the send jobs on queue
+func New(db *pgxpool.Pool, queue *jobs.Queue, m mailer.Mailer, cfg Config) *Alerter {
+	a := &Alerter{db: db, queue: queue, mailer: m, client: &http.Client{Timeout: cfg.Timeout}, cfg: cfg, loginFailures: map[string]int{}}
+	queue.Register(sendJob, a.send)
+	return a
+}
+
+// Subscribe watches the failed logins and the lockouts published on bus
+func (a *Alerter) Subscribe(bus *events.Bus) {
+	bus.Subscribe(a.loginFailed, events.LoginFailed)
+	bus.Subscribe(a.loginLocked, events.LoginLocked)
+}
+
+// TokenRejected counts a token failing verification, and raises an alert once TokenFailures is reached in a window
+func (a *Alerter) TokenRejected(ctx context.Context) {
+	if a.cfg.TokenFailures <= 0 {
+		return
+	}
+	a.mu.Lock()
+	a.rollWindow()
+	a.tokenFailures++
+	failures := a.tokenFailures
+	a.mu.Unlock()
+
+	// Only the failure reaching the threshold alerts, the next ones of the window are part of the same burst
+	if failures == a.cfg.TokenFailures {
+		a.raise(ctx, Alert{
+			Kind:    TokenFailures,
+			Summary: fmt.Sprintf("%d tokens failed verification in less than %v", failures, a.cfg.Window),
+			Details: map[string]string{"failures": fmt.Sprint(failures), "window": a.cfg.Window.String()},
+		})
+	}
+}
+
+// loginFailed raises an alert when an admin account reaches AdminLoginFailures in a window
+func (a *Alerter) loginFailed(ctx context.Context, e events.Event) {
+	login, ok := e.Data.(events.Login)
+	if !ok || a.cfg.AdminLoginFailures <= 0 {
+		return
+	}
+	email := strings.ToLower(login.Email)
+	a.mu.Lock()
+	a.rollWindow()
+	a.loginFailures[email]++
+	failures := a.loginFailures[email]
+	a.mu.Unlock()
+
+	if failures != a.cfg.AdminLoginFailures {
+		return
+	}
+	// Only checked at the threshold, so failed logins don't cost a query each
+	var admin bool
+	query := `SELECT EXISTS(SELECT 1 FROM users u JOIN user_roles ur ON ur.user_id = u.id JOIN roles r ON r.id = ur.role_id
+		WHERE u.email_lookup = $1 AND u.deleted_at IS NULL AND r.name = $2);`
+	if err := a.db.QueryRow(ctx, query, pii.Lookup(login.Email, a.cfg.PIIKey), rbac.RoleAdmin).Scan(&admin); err != nil {
+		log.Printf("[Alerts:loginFailed] Error checking the roles of %s: %v", login.Email, err)
+		return
+	}
+	if admin {
+		a.raise(ctx, Alert{
+			Kind:    AdminLoginFailures,
+			Summary: fmt.Sprintf("%d failed logins on admin account %s", failures, login.Email),
+			Details: map[string]string{"email": login.Email, "last_ip": login.IP, "failures": fmt.Sprint(failures), "window": a.cfg.Window.String()},
+		})
+	}
+}
+
+func (a *Alerter) loginLocked(ctx context.Context, e events.Event) {
+	lockout, ok := e.Data.(events.Lockout)
+	if !ok {
+		return
+	}
+	a.raise(ctx, Alert{
+		Kind:    LoginLocked,
+		Summary: fmt.Sprintf("Logins locked out after failures on %s from %s", lockout.Email, lockout.IP),
+		Details: map[string]string{"email": lockout.Email, "ip": lockout.IP, "until": lockout.Until.Format(time.RFC3339)},
+	})
+}
+
+// rollWindow starts a new window once the current one is over, a.mu must be held
+func (a *Alerter) rollWindow() {
+	if now := time.Now(); now.After(a.windowEnd) {
+		a.loginFailures, a.tokenFailures, a.windowEnd = map[string]int{}, 0, now.Add(a.cfg.Window)
+	}
+}
+
+// raise queues the sending of alert to every destination. Errors are only logged.
+func (a *Alerter) raise(ctx context.Context, alert Alert) {
+	alert.At = time.Now().UTC()
+	log.Printf("[Alerts:raise] %s: %s", alert.Kind, alert.Summary)
+	for to, set := range map[string]bool{toWebhook: a.cfg.WebhookURL != "", toSlack: a.cfg.SlackWebhookURL != "", toEmail: a.cfg.Email != ""} {
+		if !set {
+			continue
+		}
+		if err := a.queue.Enqueue(ctx, sendJob, sending{To: to, Alert: alert}); err != nil {
+			log.Printf("[Alerts:raise] Error queuing the %s alert for %s: %v", alert.Kind, to, err)
+		}
+	}
+}
+
+// send delivers an alert to one destination, an error makes the job retry
+func (a *Alerter) send(ctx context.Context, payload json.RawMessage) error {
+	var job sending
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("alerts: decoding sending: %w", err)
+	}
+	alert := job.Alert
+
+	switch job.To {
+	case toWebhook:
+		return a.post(ctx, a.cfg.WebhookURL, alert)
+	case toSlack:
+		return a.post(ctx, a.cfg.SlackWebhookURL, map[string]string{"text": ":rotating_light: *Security alert*: " + alert.Summary + "\n" + details(alert)})
+	case toEmail:
+		return mailer.SendTemplate(ctx, a.mailer, a.cfg.Email, "alert", map[string]interface{}{
+			"Summary": alert.Summary,
+			"Details": details(alert),
+			"At":      alert.At.Format(time.RFC1123),
+		})
+	}
+	// The destination was removed from the configuration since
+	log.Printf("[Alerts:send] Dropping the %s alert for unknown destination %q", alert.Kind, job.To)
+	return nil
+}
+
+func (a *Alerter) post(ctx context.Context, url string, body interface{}) error {
+	if url == "" {
+		return nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("alerts: encoding alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("alerts: building request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jwt-with-go-alerts")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alerts: posting alert: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("alerts: destination answered %s", res.Status)
+	}
+	return nil
+}
+
+// details lists the details of alert as "key: value" lines, sorted by key
+func details(alert Alert) string {
+	keys := make([]string, 0, len(alert.Details))
+	for key := range alert.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", key, alert.Details[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+// Package apperrors is the registry of the errors the API answers with. Each constructor fixes the
+// HTTP status, the code and the message of one kind of error, handlers only describe the detail.
+package apperrors
+
+import "net/http"
+
+// Detail of every server error, the real cause is only logged
+const internalDetail = "Something went wrong. Contact support or try again later"
+
+// Response is the JSON body of an error
+type Response struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+	// Problem of each invalid field of the request body by json field name, or of each invalid
+	// path or query parameter by name
+	Fields map[string]string `json:"fields,omitempty"`
+	// Set by the adapters from RequestIDMiddleware, to quote when contacting support
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error is an error answered to the client with Status and the Message body
+type Error struct {
+	Status  int `json:"-"`
+	Message Response
+	// What the error comes from, see FromError
+	Cause error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message.Code + " " + e.Message.Message + ": " + e.Message.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func newError(status int, code, message, detail string) *Error {
+	return &Error{Status: status, Message: Response{Code: code, Message: message, Detail: detail}}
+}
+
+// BadRequest is for malformed path or query parameters and other invalid input
+func BadRequest(detail string) *Error {
+	return newError(http.StatusBadRequest, "E400", "Bad request", detail)
+}
+
+// InvalidBody is for a request body that can't be parsed or doesn't pass validation
+func InvalidBody(detail string) *Error {
+	return newError(http.StatusBadRequest, "E400", "Invalid request body", detail)
+}
+
+// InvalidFields is InvalidBody with the problem of each field
+func InvalidFields(detail string, fields map[string]string) *Error {
+	err := InvalidBody(detail)
+	err.Message.Fields = fields
+	return err
+}
+
+// InvalidParams is BadRequest with the problem of each path or query parameter
+func InvalidParams(detail string, fields map[string]string) *Error {
+	err := BadRequest(detail)
+	err.Message.Fields = fields
+	return err
+}
+
+// CaptchaRequired is answered when the request needs a solved CAPTCHA it doesn't carry
+func CaptchaRequired(detail string) *Error {
+	return newError(http.StatusBadRequest, "E400", "CAPTCHA required", detail)
+}
+
+func Unauthorized(detail string) *Error {
+	return newError(http.StatusUnauthorized, "E401", "Unauthorized", detail)
+}
+
+func Forbidden(detail string) *Error {
+	return newError(http.StatusForbidden, "E403", "Forbidden", detail)
+}
+
+func NotFound(detail string) *Error {
+	return newError(http.StatusNotFound, "E404", "Not found", detail)
+}
+
+func Conflict(detail string) *Error {
+	return newError(http.StatusConflict, "E409", "Conflict", detail)
+}
+
+func PayloadTooLarge(detail string) *Error {
+	return newError(http.StatusRequestEntityTooLarge, "E413", "Payload too large", detail)
+}
+
+func Unprocessable(detail string) *Error {
+	return newError(http.StatusUnprocessableEntity, "E422", "Unprocessable entity", detail)
+}
+
+// ProfileIncomplete is answered until the user fills the required profile fields
+func ProfileIncomplete(detail string) *Error {
+	return newError(http.StatusPreconditionRequired, "E428", "Profile incomplete", detail)
+}
+
+func TooManyRequests(detail string) *Error {
+	return newError(http.StatusTooManyRequests, "E429", "Too Many Requests", detail)
+}
+
+// Maintenance is answered by every route but the probes while the maintenance mode is on
+func Maintenance(detail string) *Error {
+	return newError(http.StatusServiceUnavailable, "E503", "Maintenance", detail)
+}
+
+// Internal hides the cause from the client, log it before answering
+func Internal() *Error {
+	return newError(http.StatusInternalServerError, "E500", "Internal Server Error", internalDetail)
+}
+
+// FromError is a server error caused by err. The adapters answer it with what err means when they know
+// it, like a conflict for a unique constraint violation of the database, and log err otherwise.
+func FromError(err error) *Error {
+	e := Internal()
+	e.Cause = err
+	return e
+}
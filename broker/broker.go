@@ -0,0 +1,62 @@
+// Package broker forwards the domain events of the events bus to a message broker, NATS or Kafka,
+// so other services can react to user changes. Every event is published as the JSON encoded
+// events.Event on the subject (NATS) or topic (Kafka) named prefix + type, e.g. "jwtapi.user.created".
+// The JSON schema of each type is served by GET /events/schemas/{type}.
+//
+// Events go through the jobs queue: a broker outage delays them instead of losing them, and it never
+// slows down the requests. Retries and concurrent workers mean consumers can see an event twice or out
+// of order, the event id tells duplicates apart.
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+)
+
+// Publisher sends a message to a subject or topic of a broker
+type Publisher interface {
+	Publish(ctx context.Context, subject string, data []byte) error
+}
+
+// Job kind of the forwarded events
+const forwardJob = "events.forward"
+
+type Forwarder struct {
+	pub    Publisher
+	queue  *jobs.Queue
+	prefix string
+}
+
+// NewForwarder registers the forwarding jobs on queue, events are published to pub under prefix
+func NewForwarder(pub Publisher, queue *jobs.Queue, prefix string) *Forwarder {
+	f := &Forwarder{pub: pub, queue: queue, prefix: prefix}
+	queue.Register(forwardJob, f.forward)
+	return f
+}
+
+// Subscribe forwards every event published on bus
+func (f *Forwarder) Subscribe(bus *events.Bus) {
+	bus.SubscribeAll(f.enqueue)
+}
+
+func (f *Forwarder) enqueue(ctx context.Context, e events.Event) {
+	if err := f.queue.Enqueue(ctx, forwardJob, e); err != nil {
+		log.Printf("[Broker:enqueue] Error queuing %s event %s: %v", e.Type, e.ID, err)
+	}
+}
+
+// forward publishes the event of a job as it was encoded when it was queued
+func (f *Forwarder) forward(ctx context.Context, payload json.RawMessage) error {
+	var e struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return fmt.Errorf("broker: decoding event: %w", err)
+	}
+	return f.pub.Publish(ctx, f.prefix+e.Type, payload)
+}
@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Kafka publishes through a Kafka REST Proxy (the v2 API of Confluent's proxy, also served by
+// Redpanda), so no broker protocol client is needed. Each message is sent as a JSON record.
+type Kafka struct {
+	baseURL string
+	user    *url.Userinfo
+	client  *http.Client
+}
+
+// NewKafka publishes to the REST Proxy at rawURL, e.g. http://localhost:8082. Basic auth
+// credentials can be given in the URL.
+func NewKafka(rawURL string, timeout time.Duration) (*Kafka, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("kafka: unsupported scheme %q", u.Scheme)
+	}
+	user := u.User
+	u.User = nil
+	return &Kafka{baseURL: strings.TrimSuffix(u.String(), "/"), user: user, client: &http.Client{Timeout: timeout}}, nil
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaOffsets struct {
+	Offsets []struct {
+		Partition *int   `json:"partition"`
+		Offset    *int64 `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish sends data, a JSON document, as one record of topic
+func (k *Kafka) Publish(ctx context.Context, topic string, data []byte) error {
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Value: data}}})
+	if err != nil {
+		return fmt.Errorf("kafka: encoding record: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.baseURL+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.user != nil {
+		password, _ := k.user.Password()
+		req.SetBasicAuth(k.user.Username(), password)
+	}
+
+	res, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kafka: publishing to %s: %w", topic, err)
+	}
+	defer res.Body.Close()
+	resBody, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka: publishing to %s: %s: %s", topic, res.Status, resBody)
+	}
+
+	// The proxy answers 200 even when a record was refused, the error is in its offset
+	var offsets kafkaOffsets
+	if err := json.Unmarshal(resBody, &offsets); err != nil {
+		return fmt.Errorf("kafka: decoding the answer of the proxy: %w", err)
+	}
+	for _, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil || offset.Error != "" {
+			return fmt.Errorf("kafka: publishing to %s: %s", topic, offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Package buildinfo describes the binary: its version, the commit and date it was built from and the Go
+// runtime. Release builds set them with the linker:
+//
+//	go build -ldflags "-X github.com/hi-im-yan/jwt-with-go/buildinfo.Version=v1.2.0 \
+//		-X github.com/hi-im-yan/jwt-with-go/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/hi-im-yan/jwt-with-go/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Otherwise they come from what the Go toolchain records: the module version for `go install`, the VCS
+// revision and commit time for builds from a checkout.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Set with -ldflags "-X ...", see the package documentation
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info is the description of the binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	// The commit has changes that were not committed
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the description of the binary. The version is "dev" when neither the linker nor the
+// toolchain gave one.
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+		if build, ok := debug.ReadBuildInfo(); ok {
+			if info.Version == "" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+				info.Version = build.Main.Version
+			}
+			for _, setting := range build.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					if info.Commit == "" {
+						info.Commit = setting.Value
+					}
+				case "vcs.time":
+					if info.BuildDate == "" {
+						info.BuildDate = setting.Value
+					}
+				case "vcs.modified":
+					info.Modified = setting.Value == "true"
+				}
+			}
+		}
+		if info.Version == "" {
+			info.Version = "dev"
+		}
+	})
+	return info
+}
+
+// String describes the binary on one line, like "v1.2.0 (commit 3f2a9c1, built 2026-01-02T15:04:05Z, go1.24.2)"
+func (i Info) String() string {
+	details := []string{}
+	if i.Commit != "" {
+		commit := i.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		if i.Modified {
+			commit += "-dirty"
+		}
+		details = append(details, "commit "+commit)
+	}
+	if i.BuildDate != "" {
+		details = append(details, "built "+i.BuildDate)
+	}
+	details = append(details, i.GoVersion)
+	return i.Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+// Release returns the version of the binary, empty for development builds. It is what the API
+// documentation and the error reports are tagged with.
+func Release() string {
+	if v := Get().Version; v != "dev" {
+		return v
+	}
+	return ""
+}
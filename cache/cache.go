@@ -0,0 +1,17 @@
+// Package cache keeps copies of read-heavy data (user lookups) so they don't hit the database
+// on every request. Values are opaque bytes, callers encode them. Backends: Redis, shared by every
+// instance, and an in-process LRU for single instance deployments.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+type Cache interface {
+	// Get returns the value of key, ok is false when it is missing or expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys. Deleting a missing key is not an error
+	Delete(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,88 @@
+// Package captcha checks the CAPTCHA tokens solved in browsers with the siteverify API of hCaptcha or
+// reCAPTCHA. Both take the same form (the secret, the token as response and the address of the client)
+// and answer whether the challenge was solved.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrFailed is returned for a token that was not solved, already used or expired
+var ErrFailed = errors.New("captcha: verification failed")
+
+// VerifyURLs are the siteverify endpoints of the providers
+var VerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+}
+
+// Widget is what a page needs to show the CAPTCHA of a provider. Both widgets put the solved token in
+// the g-recaptcha-response field of their form (hCaptcha in h-captcha-response too).
+type Widget struct {
+	Script string // URL of the script rendering the widgets
+	Class  string // class of the elements it renders a widget in, with the site key as data-sitekey
+	// Origins the script, its styles and frames are loaded from, to allow in a Content-Security-Policy
+	Origins string
+}
+
+// Widgets are the widgets of the providers
+var Widgets = map[string]Widget{
+	"hcaptcha":  {Script: "https://js.hcaptcha.com/1/api.js", Class: "h-captcha", Origins: "https://hcaptcha.com https://*.hcaptcha.com"},
+	"recaptcha": {Script: "https://www.google.com/recaptcha/api.js", Class: "g-recaptcha", Origins: "https://www.google.com https://www.gstatic.com"},
+}
+
+type Verifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// New returns a Verifier posting to verifyURL, like one of VerifyURLs
+func New(verifyURL, secret string, timeout time.Duration) *Verifier {
+	return &Verifier{url: verifyURL, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+// Verify checks token was solved by the client at remoteIP. It returns ErrFailed when it wasn't, other
+// errors when the provider couldn't tell.
+func (v *Verifier) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" {
+		return ErrFailed
+	}
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha: %s answered %s", v.url, res.Status)
+	}
+
+	var answer struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil {
+		return fmt.Errorf("captcha: decoding the answer of %s: %w", v.url, err)
+	}
+	if !answer.Success {
+		return fmt.Errorf("%w: %s", ErrFailed, strings.Join(answer.ErrorCodes, ", "))
+	}
+	return nil
+}
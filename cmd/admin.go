@@ -0,0 +1,372 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/cache"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const adminUsage = `usage: admin <command> [-config file] [flags] [user]
+
+Commands, users are given by id or email:
+  list-users [-search text] [-deleted]          list the users, with their roles
+  create-user -name name -email email [-password password] [-role role]
+                                                create a user, with a random password printed when none is given
+  promote user                                  grant the admin role
+  reset-password [-password password] user      set a new password, random when none is given, and revoke the sessions
+  revoke-sessions user                          revoke every session, the tokens of the user stop working right away`
+
+// adminCommand is one of the admin commands. It gets the arguments left once its flags are parsed.
+type adminCommand struct {
+	flags func(fs *flag.FlagSet)
+	run   func(a *admin, ctx context.Context, args []string) error
+}
+
+// admin holds what the admin commands share: the database and the settings they need
+type admin struct {
+	db     *pgxpool.Pool
+	cfg    *config.Config
+	out    io.Writer
+	values map[string]*string
+	bools  map[string]*bool
+	// The user cache of the servers when it is in Redis, nil otherwise
+	cached *repository.CachedUserRepository
+}
+
+var adminCommands = map[string]adminCommand{
+	"list-users": {
+		flags: func(fs *flag.FlagSet) {
+			fs.String("search", "", "only the users whose name or email contains text")
+			fs.Bool("deleted", false, "include the deleted users")
+		},
+		run: (*admin).listUsers,
+	},
+	"create-user": {
+		flags: func(fs *flag.FlagSet) {
+			fs.String("name", "", "name of the user (required)")
+			fs.String("email", "", "email of the user (required)")
+			fs.String("password", "", "password, a random one is generated and printed when empty")
+			fs.String("role", rbac.RoleUser, "role of the user")
+		},
+		run: (*admin).createUser,
+	},
+	"promote": {run: (*admin).promote},
+	"reset-password": {
+		flags: func(fs *flag.FlagSet) {
+			fs.String("password", "", "new password, a random one is generated and printed when empty")
+		},
+		run: (*admin).resetPassword,
+	},
+	"revoke-sessions": {run: (*admin).revokeSessions},
+}
+
+// AdminCommand runs "admin <command> [flags]": the operational tasks on the users, done on the database
+// with the configuration of the server, so they work without the HTTP API (e.g. to recover a lost admin
+// account). It returns the exit status.
+func AdminCommand(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, adminUsage)
+		return 2
+	}
+	command, ok := adminCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(out, "unknown admin command %q\n\n%s\n", args[0], adminUsage)
+		return 2
+	}
+
+	a := &admin{out: out, values: map[string]*string{}, bools: map[string]*bool{}}
+	fs := flag.NewFlagSet("admin "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", "", "configuration file (CONFIG_FILE, default "+defaultConfigFile+")")
+	if command.flags != nil {
+		command.flags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch v := getter.Get().(type) {
+			case string:
+				value := v
+				a.values[f.Name] = &value
+			case bool:
+				value := v
+				a.bools[f.Name] = &value
+			}
+		}
+	})
+
+	var flagArgs []string
+	if *configFile != "" {
+		flagArgs = []string{"-config", *configFile}
+	}
+	flags, err := ParseFlags(flagArgs)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	if a.cfg, err = flags.LoadConfig(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if a.db, err = pgxpool.New(ctx, a.cfg.DB.URL()); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	defer a.db.Close()
+	// The in-memory cache of a running server can't be reached from here, its entries expire after
+	// USER_CACHE_TTL
+	if a.cfg.CacheBackend == "redis" {
+		c, err := cache.NewRedis(a.cfg.RedisURL, "jwtapi:")
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		a.cached = repository.NewCachedUserRepository(nil, c, a.cfg.UserCacheTTL, a.cfg.PIIEncryptionKey)
+	}
+
+	if err := command.run(a, ctx, fs.Args()); err != nil {
+		fmt.Fprintln(out, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func (a *admin) value(name string) string {
+	return *a.values[name]
+}
+
+// userChanged drops the cached lookups of the user, so the servers sharing the Redis cache see the change
+func (a *admin) userChanged(ctx context.Context, id int) {
+	if a.cached != nil {
+		a.cached.Invalidate(ctx, id)
+	}
+}
+
+func (a *admin) listUsers(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument %q", args[0])
+	}
+	query := `SELECT u.id, u.name, u.email, ` + repository.UserRolesColumn + `, u.created_at, u.deleted_at FROM users u`
+	if !*a.bools["deleted"] {
+		query += ` WHERE u.deleted_at IS NULL`
+	}
+	rows, err := a.db.Query(ctx, query+` ORDER BY u.id;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	search := strings.ToLower(a.value("search"))
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tROLES\tCREATED\tDELETED")
+	for rows.Next() {
+		var (
+			id               int
+			name, email      string
+			roles            []string
+			createdAt        time.Time
+			deletedAt        *time.Time
+			deleted, created string
+		)
+		if err := rows.Scan(&id, &name, &email, &roles, &createdAt, &deletedAt); err != nil {
+			return err
+		}
+		// The emails can be encrypted, so the search is done here rather than in SQL
+		if email, err = pii.Decrypt(email, a.cfg.PIIEncryptionKey); err != nil {
+			return err
+		}
+		if search != "" && !strings.Contains(strings.ToLower(name), search) && !strings.Contains(strings.ToLower(email), search) {
+			continue
+		}
+		created = createdAt.Format(time.DateTime)
+		if deletedAt != nil {
+			deleted = deletedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", id, name, email, strings.Join(roles, ","), created, deleted)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (a *admin) createUser(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument %q", args[0])
+	}
+	name, email, role := strings.TrimSpace(a.value("name")), strings.TrimSpace(a.value("email")), a.value("role")
+	if name == "" || email == "" {
+		return errors.New("-name and -email are required")
+	}
+	password, generated, err := passwordOrRandom(a.value("password"))
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	encrypted, err := pii.Encrypt(email, a.cfg.PIIEncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = repository.WithTx(ctx, a.db, func(tx pgx.Tx) error {
+		var roleID int
+		if err := tx.QueryRow(ctx, `SELECT id FROM roles WHERE name = $1;`, role).Scan(&roleID); errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("there is no role %q", role)
+		} else if err != nil {
+			return err
+		}
+		query := `INSERT INTO users (name, email, email_lookup, password) VALUES ($1, $2, $3, $4) RETURNING id;`
+		if err := tx.QueryRow(ctx, query, name, encrypted, pii.Lookup(email, a.cfg.PIIEncryptionKey), string(hash)).Scan(&id); err != nil {
+			return translateUniqueEmail(err)
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2);`, id, roleID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a.userChanged(ctx, id)
+
+	fmt.Fprintf(a.out, "Created user %d (%s) with the role %s\n", id, email, role)
+	if generated {
+		fmt.Fprintf(a.out, "Password: %s\n", password)
+	}
+	return nil
+}
+
+func (a *admin) promote(ctx context.Context, args []string) error {
+	id, err := a.userArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = $2 ON CONFLICT DO NOTHING;`
+	tag, err := a.db.Exec(ctx, query, id, rbac.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		fmt.Fprintf(a.out, "User %d already is an admin\n", id)
+		return nil
+	}
+	a.userChanged(ctx, id)
+	fmt.Fprintf(a.out, "User %d is now an admin\n", id)
+	return nil
+}
+
+func (a *admin) resetPassword(ctx context.Context, args []string) error {
+	id, err := a.userArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	password, generated, err := passwordOrRandom(a.value("password"))
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var revoked int64
+	err = repository.WithTx(ctx, a.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `UPDATE users SET password = $2 WHERE id = $1;`, id, string(hash)); err != nil {
+			return err
+		}
+		revoked, err = revokeSessions(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a.userChanged(ctx, id)
+
+	fmt.Fprintf(a.out, "Password of user %d reset, %d sessions revoked\n", id, revoked)
+	if generated {
+		fmt.Fprintf(a.out, "Password: %s\n", password)
+	}
+	return nil
+}
+
+func (a *admin) revokeSessions(ctx context.Context, args []string) error {
+	id, err := a.userArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	revoked, err := revokeSessions(ctx, a.db, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "%d sessions of user %d revoked\n", revoked, id)
+	return nil
+}
+
+// userArg finds the active user given as the only argument, by id or by email
+func (a *admin) userArg(ctx context.Context, args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected one user, by id or email")
+	}
+	var id int
+	var err error
+	if n, convErr := strconv.Atoi(args[0]); convErr == nil {
+		err = a.db.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL;`, n).Scan(&id)
+	} else {
+		err = a.db.QueryRow(ctx, `SELECT id FROM users WHERE email_lookup = $1 AND deleted_at IS NULL;`, pii.Lookup(args[0], a.cfg.PIIEncryptionKey)).Scan(&id)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("no active user %s", args[0])
+	}
+	return id, err
+}
+
+func revokeSessions(ctx context.Context, db repository.Querier, userID int) (int64, error) {
+	tag, err := db.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW();`, userID)
+	return tag.RowsAffected(), err
+}
+
+// passwordOrRandom returns password, or a random one when it is empty. generated tells which.
+func passwordOrRandom(password string) (string, bool, error) {
+	if len(password) > 72 {
+		return "", false, errors.New("the password can't be over 72 bytes")
+	}
+	if password != "" {
+		return password, false, nil
+	}
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), true, nil
+}
+
+func translateUniqueEmail(err error) error {
+	if errors.Is(repository.Translate(err), repository.ErrConflict) {
+		return errors.New("an active user already has this email")
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+)
+
+// ConfigCommand runs "config validate [flags]": it loads the configuration like the server would and
+// prints it with the secrets masked, or every problem found. It returns the exit status.
+func ConfigCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(out, "usage: config validate [-config file] [flags]")
+		return 2
+	}
+
+	flags, err := ParseFlags(args[1:])
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	cfg, err := flags.LoadConfig()
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "# Effective configuration from %s and the environment\n", flags.ConfigFile)
+	cfg.Print(out)
+	return 0
+}
@@ -0,0 +1,149 @@
+// Package cmd parses the command line of the API. Each flag stands for an environment variable
+// (-addr for LISTEN_ADDR...) and wins over it, config.Load then validates everything together.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/secrets"
+)
+
+const defaultConfigFile = ".env"
+
+type Flags struct {
+	// File the settings are loaded from, CONFIG_FILE or .env by default
+	ConfigFile string
+	ListenAddr string
+	LogLevel   string
+	Migrate    string
+
+	configFileSet bool
+	// Variables set from the config file and the secrets manager, a reload replaces them
+	fileKeys []string
+}
+
+// ParseFlags parses args, the command line without the program name. -h prints the usage and
+// returns flag.ErrHelp.
+func ParseFlags(args []string) (*Flags, error) {
+	f := &Flags{}
+	fs := flag.NewFlagSet("jwt-with-go", flag.ContinueOnError)
+	fs.StringVar(&f.ConfigFile, "config", "", "configuration file: .yaml, .yml, .toml or a .env file (CONFIG_FILE, default "+defaultConfigFile+")")
+	fs.StringVar(&f.ListenAddr, "addr", "", "address to listen on, like :8080 or 127.0.0.1:8080 (LISTEN_ADDR, default :PORT)")
+	fs.StringVar(&f.LogLevel, "log-level", "", "debug, info, warn or error (LOG_LEVEL, default info)")
+	fs.StringVar(&f.Migrate, "migrate", "", "up runs the migrations before serving, skip leaves the schema alone, only runs them and exits (MIGRATE, default up)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if f.ConfigFile == "" {
+		f.ConfigFile = os.Getenv("CONFIG_FILE")
+	}
+	f.configFileSet = f.ConfigFile != ""
+	if !f.configFileSet {
+		f.ConfigFile = defaultConfigFile
+	}
+	return f, nil
+}
+
+// LoadConfig loads the config file into the environment (see config.LoadFile), then the settings of the
+// secrets manager over it, puts the flags over them and loads the configuration. A missing .env is fine
+// when no file was asked for, the settings may all come from the environment.
+func (f *Flags) LoadConfig() (*config.Config, error) {
+	env, err := config.ReadFile(f.ConfigFile)
+	if err != nil && (f.configFileSet || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, fmt.Errorf("loading %s: %w", f.ConfigFile, err)
+	}
+	// Like config.LoadFile, but remembering what came from the file
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+			f.fileKeys = append(f.fileKeys, key)
+		}
+	}
+	if err := f.loadSecrets(); err != nil {
+		return nil, err
+	}
+
+	for key, value := range map[string]string{"LISTEN_ADDR": f.ListenAddr, "LOG_LEVEL": f.LogLevel, "MIGRATE": f.Migrate} {
+		if value != "" {
+			os.Setenv(key, value)
+		}
+	}
+	return config.Load()
+}
+
+// loadSecrets puts the settings of the secrets manager in the environment. They win over the
+// config file but not over the variables of the process environment.
+func (f *Flags) loadSecrets() error {
+	secretsCfg, err := config.LoadSecrets()
+	if err != nil {
+		return err
+	}
+	provider := secrets.New(secretsCfg)
+	if provider == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	env, err := provider.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching secrets from %s: %w", secretsCfg.Provider, err)
+	}
+	for key, value := range env {
+		_, set := os.LookupEnv(key)
+		if set && !slices.Contains(f.fileKeys, key) {
+			continue
+		}
+		os.Setenv(key, value)
+		if !set {
+			f.fileKeys = append(f.fileKeys, key)
+		}
+	}
+	return nil
+}
+
+// Reload reads the config file and the secrets again, for a configuration reload of the running server, and applies the
+// log level. The variables of the process environment and the flags still win over the file.
+func (f *Flags) Reload() (*config.Config, error) {
+	previous := map[string]string{}
+	for _, key := range f.fileKeys {
+		previous[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	f.fileKeys = nil
+
+	cfg, err := f.LoadConfig()
+	if err != nil {
+		// Keep the environment of the running configuration
+		for _, key := range f.fileKeys {
+			os.Unsetenv(key)
+		}
+		f.fileKeys = nil
+		for key, value := range previous {
+			os.Setenv(key, value)
+			f.fileKeys = append(f.fileKeys, key)
+		}
+		return nil, err
+	}
+	ConfigureLogging(cfg)
+	return cfg, nil
+}
+
+// ConfigureLogging applies LOG_LEVEL to the leveled logs (log/slog). The log.Printf traces are always written.
+func ConfigureLogging(cfg *config.Config) {
+	levels := map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+	slog.SetLogLoggerLevel(levels[cfg.LogLevel])
+}
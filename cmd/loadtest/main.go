@@ -0,0 +1,209 @@
+// Command loadtest drives realistic traffic against a running instance of the API
+// and prints latency percentiles per operation.
+//
+// Every virtual user registers a new account, logs in, refreshes its session and lists
+// users. When admin credentials are given it also runs the admin-only CRUD operations
+// (create, read, update and delete a user). Answers wrapped in the envelope of
+// RESPONSE_ENVELOPE=true are read as well as the bare ones.
+//
+//	go run ./cmd/loadtest -url http://localhost:8080 -c 20 -d 30s -admin-email admin@admin.com -admin-password 4dm1n
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type config struct {
+	baseURL       string
+	concurrency   int
+	duration      time.Duration
+	adminEmail    string
+	adminPassword string
+}
+
+// tokens are the answer of a login or a refresh
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type client struct {
+	cfg   config
+	http  *http.Client
+	stats *stats
+	runID string
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.baseURL, "url", "http://localhost:8080", "base URL of the running API")
+	flag.IntVar(&cfg.concurrency, "c", 10, "number of concurrent virtual users")
+	flag.DurationVar(&cfg.duration, "d", 30*time.Second, "how long to generate load")
+	flag.StringVar(&cfg.adminEmail, "admin-email", "", "admin email, enables the admin CRUD scenario")
+	flag.StringVar(&cfg.adminPassword, "admin-password", "", "admin password")
+	flag.Parse()
+
+	c := &client{
+		cfg:   cfg,
+		http:  &http.Client{Timeout: 10 * time.Second},
+		stats: newStats(),
+		runID: strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+
+	var adminToken string
+	if cfg.adminEmail != "" {
+		admin, err := c.login(cfg.adminEmail, cfg.adminPassword)
+		if err != nil {
+			log.Fatalf("Could not login as admin: %v", err)
+		}
+		adminToken = admin.Token
+	}
+
+	fmt.Printf("Running %d virtual users against %s for %s\n\n", cfg.concurrency, cfg.baseURL, cfg.duration)
+
+	start := time.Now()
+	deadline := start.Add(cfg.duration)
+	var wg sync.WaitGroup
+	for worker := 0; worker < cfg.concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline); i++ {
+				c.userScenario(worker, i)
+				if adminToken != "" {
+					c.adminScenario(adminToken, worker, i)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	c.stats.report(os.Stdout, time.Since(start))
+}
+
+// userScenario is what a regular client does: sign up, sign in, renew the session and browse
+func (c *client) userScenario(worker, i int) {
+	email := fmt.Sprintf("loadtest-%s-%d-%d@example.com", c.runID, worker, i)
+	password := "loadtest-password"
+
+	if _, err := c.do("register", http.MethodPost, "/auth/register", "", map[string]string{"name": "Load Test", "email": email, "password": password}, http.StatusCreated); err != nil {
+		return
+	}
+
+	session, err := c.login(email, password)
+	if err != nil {
+		return
+	}
+
+	session, err = c.refresh(session.RefreshToken)
+	if err != nil {
+		return
+	}
+
+	c.do("list_users", http.MethodGet, "/users", session.Token, nil, http.StatusOK)
+}
+
+func (c *client) adminScenario(token string, worker, i int) {
+	email := fmt.Sprintf("loadtest-crud-%s-%d-%d@example.com", c.runID, worker, i)
+
+	body, err := c.do("create_user", http.MethodPost, "/users", token, map[string]string{"name": "Load Test", "email": email}, http.StatusCreated)
+	if err != nil {
+		return
+	}
+	var created struct {
+		ID int `json:"id"`
+	}
+	if err := decode(body, &created); err != nil {
+		return
+	}
+	path := "/users/" + strconv.Itoa(created.ID)
+
+	c.do("get_user", http.MethodGet, path, token, nil, http.StatusOK)
+	c.do("update_user", http.MethodPut, path, token, map[string]string{"name": "Load Test Updated", "email": email}, http.StatusOK)
+	c.do("delete_user", http.MethodDelete, path, token, nil, http.StatusNoContent)
+}
+
+func (c *client) login(email, password string) (*tokens, error) {
+	body, err := c.do("login", http.MethodPost, "/auth/login", "", map[string]string{"email": email, "password": password}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var res tokens
+	if err := decode(body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// refresh trades the refresh token of a session for new tokens. Every request of the load test has
+// the same user agent, so it is always the device the session was opened on.
+func (c *client) refresh(refreshToken string) (*tokens, error) {
+	body, err := c.do("refresh", http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var res tokens
+	if err := decode(body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// decode reads an answer of the API into v, from the data of the envelope when there is one
+func decode(body []byte, v any) error {
+	var env struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &env); err == nil && len(env.Data) > 0 {
+		body = env.Data
+	}
+	return json.Unmarshal(body, v)
+}
+
+// do sends one request, records its latency under op and fails when the status is not the expected one
+func (c *client) do(op, method, path, token string, payload any, expected int) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.cfg.baseURL+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.stats.record(op, time.Since(start), true)
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	c.stats.record(op, time.Since(start), err != nil || res.StatusCode != expected)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != expected {
+		return nil, fmt.Errorf("%s %s: expected status %d, got %d", method, path, expected, res.StatusCode)
+	}
+	return body, nil
+}
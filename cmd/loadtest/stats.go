@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stats collects the latency of every request grouped by operation name
+type stats struct {
+	mu         sync.Mutex
+	latencies  map[string][]time.Duration
+	errors     map[string]int
+	operations []string
+}
+
+func newStats() *stats {
+	return &stats{latencies: make(map[string][]time.Duration), errors: make(map[string]int)}
+}
+
+func (s *stats) record(op string, d time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.latencies[op]; !ok {
+		s.operations = append(s.operations, op)
+	}
+	s.latencies[op] = append(s.latencies[op], d)
+	if failed {
+		s.errors[op]++
+	}
+}
+
+func (s *stats) report(out io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	fmt.Fprintf(out, "%-14s %8s %8s %10s %10s %10s %10s\n", "operation", "count", "errors", "p50", "p90", "p99", "max")
+	for _, op := range s.operations {
+		l := s.latencies[op]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		total += len(l)
+		fmt.Fprintf(out, "%-14s %8d %8d %10s %10s %10s %10s\n", op, len(l), s.errors[op],
+			percentile(l, 50), percentile(l, 90), percentile(l, 99), l[len(l)-1].Round(time.Microsecond))
+	}
+	fmt.Fprintf(out, "\n%d requests in %s (%.1f req/s)\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+}
+
+// percentile expects a sorted, non empty slice
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Lists are joined with "," in the environment, except these
+var listSeparators = map[string]string{"OIDC_SCOPES": " "}
+
+// LoadFile puts the settings of a configuration file in the environment, where Load reads them.
+// Variables already set in the environment win over the file, so a deployment can override single settings.
+func LoadFile(path string) error {
+	env, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, env[key])
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the settings of a configuration file as environment variables.
+// .yaml, .yml and .toml files nest the settings: the keys of a section are joined with "_" and upper
+// cased, so db.host is DB_HOST and jwt.access_token_ttl is JWT_ACCESS_TOKEN_TTL. Lists are allowed
+// for the list settings. Any other file is read as a .env file.
+func ReadFile(path string) (map[string]string, error) {
+	var settings map[string]interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		settings, err = decodeFile(path, yaml.Unmarshal)
+	case ".toml":
+		settings, err = decodeFile(path, toml.Unmarshal)
+	default:
+		return godotenv.Read(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	env := map[string]string{}
+	if err := flatten("", settings, env); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+func decodeFile(path string, unmarshal func([]byte, interface{}) error) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]interface{}{}
+	if err := unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// flatten turns the nested settings into environment variables
+func flatten(prefix string, value interface{}, env map[string]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			name := strings.ToUpper(key)
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			if err := flatten(name, inner, env); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, nested := item.(map[string]interface{}); nested {
+				return fmt.Errorf("%s: lists can only hold values", prefix)
+			}
+			items[i] = fmt.Sprint(item)
+		}
+		sep, ok := listSeparators[prefix]
+		if !ok {
+			sep = ","
+		}
+		env[prefix] = strings.Join(items, sep)
+	case nil:
+		env[prefix] = ""
+	default:
+		env[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys holds the key material of the tokens: the HMAC secrets of the JWTs and the keys of the encrypted
+// ones. Every copy of the JWT settings shares it, so keys read again from their files (see Watch) are
+// used everywhere at once.
+//
+// JWT_SECRET can list several secrets separated by commas, to rotate it: tokens are signed with the
+// first one and accepted when signed with any of them. The new secret goes first and the old one is
+// removed once the tokens it signed have expired.
+type Keys struct {
+	mu            sync.RWMutex
+	secrets       [][]byte
+	encryptionKey []byte
+	pasetoKey     []byte
+
+	// Files the keys are read from, empty for the ones set in the environment
+	secretFile        string
+	encryptionKeyFile string
+	pasetoKeyFile     string
+	// What was last read from each file, only a change is looked at
+	read map[string]fileRead
+}
+
+type fileRead struct {
+	value string
+	err   string
+}
+
+// NewKeys returns the keys of tokens signed with secret, encrypted with encryptionKey (JWE) when it is
+// set, and the key of the PASETO tokens
+func NewKeys(secret, encryptionKey, pasetoKey []byte) *Keys {
+	return &Keys{secrets: [][]byte{secret}, encryptionKey: encryptionKey, pasetoKey: pasetoKey}
+}
+
+// Secret returns the HMAC secret the JWTs are signed with, the first one of JWT_SECRET
+func (k *Keys) Secret() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.secrets[0]
+}
+
+// Secrets returns every HMAC secret the JWTs are accepted with, the signing one first
+func (k *Keys) Secrets() [][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.secrets
+}
+
+// EncryptionKey returns the AES-256 key of the encrypted JWTs, nil when they are not encrypted
+func (k *Keys) EncryptionKey() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.encryptionKey
+}
+
+// PasetoKey returns the key of the PASETO tokens, nil when it is not set
+func (k *Keys) PasetoKey() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.pasetoKey
+}
+
+// String tells which keys are set and where they come from, without them
+func (k *Keys) String() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	describe := func(key []byte, file string) string {
+		switch {
+		case len(key) == 0:
+			return "(not set)"
+		case file != "":
+			return "******** from " + file
+		default:
+			return "********"
+		}
+	}
+	secrets := "secret " + describe(k.secrets[0], k.secretFile)
+	if len(k.secrets) > 1 {
+		secrets += " (+" + strconv.Itoa(len(k.secrets)-1) + " previous)"
+	}
+	return secrets +
+		", encryption key " + describe(k.encryptionKey, k.encryptionKeyFile) +
+		", PASETO key " + describe(k.pasetoKey, k.pasetoKeyFile)
+}
+
+// Watch reads the key files again every interval until ctx is done, and uses their new content once
+// they change. Kubernetes updates mounted secrets in place, so rotated keys are picked up without a
+// restart. A file that can't be read or holds an invalid key is logged and the current key kept.
+func (k *Keys) Watch(ctx context.Context, interval time.Duration) {
+	if k.secretFile == "" && k.encryptionKeyFile == "" && k.pasetoKeyFile == "" {
+		return
+	}
+	k.read = map[string]fileRead{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.reload()
+		}
+	}
+}
+
+func (k *Keys) reload() {
+	reload := func(name, file string, current [][]byte, parse func(string) ([][]byte, error)) [][]byte {
+		if file == "" {
+			return current
+		}
+		value, err := readSecretFile(file)
+		read := fileRead{value: value}
+		if err != nil {
+			read.err = err.Error()
+		}
+		if last, ok := k.read[file]; ok && last == read {
+			return current
+		}
+		k.read[file] = read
+		if err == nil {
+			var key [][]byte
+			if key, err = parse(value); err == nil {
+				if !slices.EqualFunc(key, current, bytes.Equal) {
+					log.Printf("[Config:Keys] %s changed in %s, using the new one", name, file)
+				}
+				return key
+			}
+		}
+		log.Printf("[Config:Keys] Keeping the current %s: %v", name, err)
+		return current
+	}
+
+	// The keys are reloaded like the secrets, as lists of one key
+	parseOne := func(v string) ([][]byte, error) {
+		key, err := parseKey(v)
+		return [][]byte{key}, err
+	}
+	k.mu.RLock()
+	secrets, encryptionKey, pasetoKey := k.secrets, [][]byte{k.encryptionKey}, [][]byte{k.pasetoKey}
+	k.mu.RUnlock()
+
+	secrets = reload("JWT secret", k.secretFile, secrets, parseSecrets)
+	encryptionKey = reload("JWT encryption key", k.encryptionKeyFile, encryptionKey, parseOne)
+	pasetoKey = reload("PASETO key", k.pasetoKeyFile, pasetoKey, parseOne)
+
+	k.mu.Lock()
+	k.secrets, k.encryptionKey, k.pasetoKey = secrets, encryptionKey[0], pasetoKey[0]
+	k.mu.Unlock()
+}
+
+// readSecretFile returns the content of a secret file without its final line break, which editors and
+// `echo` add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parseSecrets splits the comma separated secrets of JWT_SECRET
+func parseSecrets(v string) ([][]byte, error) {
+	var secrets [][]byte
+	for _, secret := range strings.Split(v, ",") {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			return nil, errors.New("one of them is empty")
+		}
+		secrets = append(secrets, []byte(secret))
+	}
+	return secrets, nil
+}
+
+// parseKey decodes a base64 AES-256 key
+func parseKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("must be 32 bytes encoded in base64, like the output of openssl rand -base64 32")
+	}
+	return key, nil
+}
+
+// fromFile returns the value of key, or the content of the file named by key_FILE, the way Docker and
+// Kubernetes mount secrets. The name of the file is returned too, empty when the value comes from the
+// environment.
+func (l *loader) fromFile(key string) (value, file string) {
+	file = os.Getenv(key + "_FILE")
+	if file == "" {
+		return os.Getenv(key), ""
+	}
+	if os.Getenv(key) != "" {
+		l.fail("%s and %s_FILE can't be used together", key, key)
+	}
+	value, err := readSecretFile(file)
+	if err != nil {
+		l.fail("%s_FILE: %v", key, err)
+	}
+	return value, file
+}
+
+// keys reads the keys of the tokens from the environment or their files
+func (l *loader) keys(format string) *Keys {
+	k := &Keys{}
+	var secret string
+	secret, k.secretFile = l.fromFile("JWT_SECRET")
+	if secret == "" {
+		l.fail("JWT_SECRET or JWT_SECRET_FILE is required")
+	}
+	secrets, err := parseSecrets(secret)
+	if err != nil && secret != "" {
+		l.fail("JWT_SECRET must be secrets separated by commas, %v", err)
+	}
+	if len(secrets) == 0 {
+		// Keeps Secret working while the configuration is reported invalid
+		secrets = [][]byte{nil}
+	}
+	k.secrets = secrets
+
+	parse := func(key, value string) []byte {
+		if value == "" {
+			return nil
+		}
+		parsed, err := parseKey(value)
+		if err != nil {
+			l.fail("%s %v", key, err)
+		}
+		return parsed
+	}
+	var value string
+	value, k.encryptionKeyFile = l.fromFile("JWT_ENCRYPTION_KEY")
+	k.encryptionKey = parse("JWT_ENCRYPTION_KEY", value)
+	value, k.pasetoKeyFile = l.fromFile("PASETO_KEY")
+	k.pasetoKey = parse("PASETO_KEY", value)
+	if value == "" && format == "paseto" {
+		l.fail("PASETO_KEY is required when TOKEN_FORMAT=paseto")
+	}
+	return k
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sign returns a token signed with secret, the way handlers sign the access tokens
+func sign(t *testing.T, secret string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "2"}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return token
+}
+
+// verify checks token against every secret of k, the way handlers verify the access tokens
+func verify(k *Keys, token string) error {
+	_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		set := jwt.VerificationKeySet{}
+		for _, secret := range k.Secrets() {
+			set.Keys = append(set.Keys, secret)
+		}
+		return set, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	return err
+}
+
+// writeFile replaces the content of path, like Kubernetes updating a mounted secret
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
+
+// loadKeys reads the keys from the environment like Load does
+func loadKeys(t *testing.T) *Keys {
+	t.Helper()
+	l := &loader{}
+	k := l.keys("jwt")
+	if len(l.errs) > 0 {
+		t.Fatalf("loading keys: %v", errors.Join(l.errs...))
+	}
+	return k
+}
+
+// waitFor calls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRotatedSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "new-secret, old-secret")
+	k := loadKeys(t)
+
+	if got := string(k.Secret()); got != "new-secret" {
+		t.Errorf("Secret = %q, want the first one", got)
+	}
+	tests := []struct {
+		name   string
+		secret string
+		valid  bool
+	}{
+		{name: "signed with the new secret", secret: "new-secret", valid: true},
+		{name: "signed with the previous secret during the overlap", secret: "old-secret", valid: true},
+		{name: "signed with another secret", secret: "older-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(k, sign(t, tt.secret))
+			if tt.valid && err != nil {
+				t.Errorf("verify = %v, want the token accepted", err)
+			}
+			if !tt.valid && !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+				t.Errorf("verify = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+			}
+		})
+	}
+}
+
+func TestInvalidSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "new-secret,,old-secret")
+	l := &loader{}
+	l.keys("jwt")
+	if len(l.errs) == 0 {
+		t.Error("an empty secret in JWT_SECRET was accepted")
+	}
+}
+
+func TestWatchSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	writeFile(t, path, "old-secret\n")
+	t.Setenv("JWT_SECRET_FILE", path)
+	k := loadKeys(t)
+	oldToken := sign(t, "old-secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.Watch(ctx, 5*time.Millisecond)
+
+	// The new secret goes first, the tokens of the old one keep working until it is removed
+	writeFile(t, path, "new-secret,old-secret\n")
+	waitFor(t, "the new secret", func() bool { return string(k.Secret()) == "new-secret" })
+	if err := verify(k, oldToken); err != nil {
+		t.Errorf("token of the previous secret refused during the overlap: %v", err)
+	}
+	if err := verify(k, sign(t, "new-secret")); err != nil {
+		t.Errorf("token of the new secret refused: %v", err)
+	}
+
+	writeFile(t, path, "new-secret\n")
+	waitFor(t, "the old secret removed", func() bool { return len(k.Secrets()) == 1 })
+	if err := verify(k, oldToken); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("token of the removed secret: verify = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+	}
+
+	// A broken file is logged and the current secret kept
+	writeFile(t, path, "new-secret,,\n")
+	time.Sleep(30 * time.Millisecond)
+	if got := k.Secrets(); len(got) != 1 || string(got[0]) != "new-secret" {
+		t.Errorf("Secrets after an invalid file = %q, want the current secret kept", got)
+	}
+	os.Remove(path)
+	time.Sleep(30 * time.Millisecond)
+	if got := string(k.Secret()); got != "new-secret" {
+		t.Errorf("Secret after the file was removed = %q, want the current secret kept", got)
+	}
+}
+
+func TestWatchEncryptionKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	newKey[0] = 1
+	path := filepath.Join(dir, "jwt_encryption_key")
+	writeFile(t, path, base64.StdEncoding.EncodeToString(oldKey))
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("JWT_ENCRYPTION_KEY_FILE", path)
+	k := loadKeys(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.Watch(ctx, 5*time.Millisecond)
+
+	writeFile(t, path, base64.StdEncoding.EncodeToString(newKey))
+	waitFor(t, "the new encryption key", func() bool { return k.EncryptionKey()[0] == 1 })
+
+	// 16 bytes are not an AES-256 key
+	writeFile(t, path, base64.StdEncoding.EncodeToString(newKey[:16]))
+	time.Sleep(30 * time.Millisecond)
+	if got := k.EncryptionKey(); len(got) != 32 || got[0] != 1 {
+		t.Errorf("EncryptionKey after an invalid file = %v, want the current key kept", got)
+	}
+	if got := string(k.Secret()); got != "secret" {
+		t.Errorf("Secret = %q, want the one of JWT_SECRET, which has no file to watch", got)
+	}
+}
+
+func TestWatchWithoutFiles(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	k := loadKeys(t)
+
+	done := make(chan struct{})
+	go func() {
+		k.Watch(context.Background(), time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch kept running without key files to watch")
+	}
+}
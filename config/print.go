@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Fields whose name contains one of these are printed masked
+var secretFields = []string{"Secret", "Password", "VaultToken", "SessionToken", "ReplicaURL", "RedisURL", "NATSURL", "KafkaRESTURL"}
+
+// Print writes the effective configuration, one field per line like "DB.Host = localhost".
+// Passwords and secrets only show whether they are set.
+func (cfg *Config) Print(w io.Writer) {
+	printStruct(w, "", reflect.ValueOf(cfg).Elem())
+}
+
+func printStruct(w io.Writer, prefix string, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		name := prefix + field.Name
+
+		if value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(time.Time{}) {
+			printStruct(w, name+".", value)
+			continue
+		}
+		fmt.Fprintf(w, "%s = %s\n", name, formatField(field.Name, value))
+	}
+}
+
+func formatField(name string, value reflect.Value) string {
+	for _, secret := range secretFields {
+		if strings.Contains(name, secret) {
+			if value.Len() == 0 {
+				return "(not set)"
+			}
+			return "********"
+		}
+	}
+
+	switch v := value.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
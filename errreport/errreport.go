@@ -0,0 +1,154 @@
+// Package errreport sends the failures of the API to an error tracker: every 5xx response and every
+// panic caught by the recoverer, with the request id, the route, the status and the authenticated user.
+// Reports are sent in the background by a single sender; when the tracker is slower than the failures,
+// the reports over the buffer are dropped and logged rather than holding requests.
+//
+// The tracker is chosen by the DSN: a Sentry DSN (https://<key>@<host>/<project id>) or
+// rollbar://<access token>.
+package errreport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is one failure
+type Report struct {
+	At        time.Time
+	Message   string
+	RequestID string
+	Method    string
+	// Path without the query, which can hold tokens
+	Path string
+	// Route pattern, like /users/{id}
+	Route  string
+	Status int
+	// 0 for anonymous requests
+	UserID int
+	// Set for panics
+	Panic string
+	Stack string
+}
+
+// Tracker delivers reports to an error tracking service
+type Tracker interface {
+	Send(ctx context.Context, r Report) error
+}
+
+// Reports waiting for the sender, the next ones are dropped
+const bufferSize = 100
+
+// Reporter queues the reports for its Tracker. A nil Reporter drops them.
+type Reporter struct {
+	tracker Tracker
+	queue   chan Report
+	timeout time.Duration
+}
+
+// New returns the Reporter of dsn, nil when dsn is empty. environment and release (the version of the
+// binary, can be empty) are attached to every report. Each report gets timeout to be sent.
+func New(dsn, environment, release string, timeout time.Duration) (*Reporter, error) {
+	if dsn == "" {
+		return nil, nil
+	}
+	tracker, err := newTracker(dsn, environment, release, &http.Client{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	r := &Reporter{tracker: tracker, queue: make(chan Report, bufferSize), timeout: timeout}
+	go r.run()
+	return r, nil
+}
+
+func newTracker(dsn, environment, release string, client *http.Client) (Tracker, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("errreport: invalid DSN: %w", err)
+	}
+	switch u.Scheme {
+	case "rollbar":
+		if u.Host == "" {
+			return nil, fmt.Errorf("errreport: the rollbar DSN must be rollbar://<access token>")
+		}
+		return &rollbar{token: u.Host, environment: environment, release: release, client: client}, nil
+	case "https", "http":
+		project := strings.Trim(u.Path, "/")
+		if u.User == nil || u.User.Username() == "" || project == "" {
+			return nil, fmt.Errorf("errreport: the Sentry DSN must be https://<key>@<host>/<project id>")
+		}
+		// A project under a path prefix keeps it: https://key@host/prefix/42 posts to /prefix/api/42/envelope/
+		prefix := ""
+		if i := strings.LastIndex(project, "/"); i >= 0 {
+			prefix, project = "/"+project[:i], project[i+1:]
+		}
+		endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: prefix + "/api/" + project + "/envelope/"}
+		return &sentry{endpoint: endpoint.String(), key: u.User.Username(), environment: environment, release: release, client: client}, nil
+	}
+	return nil, fmt.Errorf("errreport: unsupported DSN scheme %q, expected https (Sentry) or rollbar", u.Scheme)
+}
+
+// Report queues r, it never blocks
+func (rep *Reporter) Report(r Report) {
+	if rep == nil {
+		return
+	}
+	if r.At.IsZero() {
+		r.At = time.Now()
+	}
+	select {
+	case rep.queue <- r:
+	default:
+		log.Printf("[ErrReport:Report] Buffer full, dropping the report of request %s: %s", r.RequestID, r.Message)
+	}
+}
+
+func (rep *Reporter) run() {
+	for r := range rep.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), rep.timeout)
+		if err := rep.tracker.Send(ctx, r); err != nil {
+			log.Printf("[ErrReport:run] Error sending the report of request %s: %v", r.RequestID, err)
+		}
+		cancel()
+	}
+}
+
+type scopeKey struct{}
+
+// scope is what the handlers learn about a request after the middleware started it
+type scope struct {
+	mu     sync.Mutex
+	userID int
+	panic  string
+	stack  string
+}
+
+func scopeOf(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+// SetUser records the authenticated user of the request of ctx, a no-op without the middleware
+func SetUser(ctx context.Context, userID int) {
+	if s := scopeOf(ctx); s != nil {
+		s.mu.Lock()
+		s.userID = userID
+		s.mu.Unlock()
+	}
+}
+
+// RecordPanic records a panic recovered while serving the request of ctx, so its report holds the panic
+// value and the stack instead of the bare status
+func RecordPanic(ctx context.Context, value interface{}, stack []byte) {
+	if s := scopeOf(ctx); s != nil {
+		s.mu.Lock()
+		s.panic = fmt.Sprint(value)
+		s.stack = string(stack)
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,50 @@
+package errreport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware reports the requests answered with a 5xx. It must be registered on the root router after
+// the request id middleware and before the recoverer, so it sees the 500 answered for panics.
+func Middleware(rep *Reporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rep == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := &scope{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), scopeKey{}, s)))
+
+			if ww.Status() < http.StatusInternalServerError {
+				return
+			}
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			report := Report{
+				Message:   r.Method + " " + route + " answered " + http.StatusText(ww.Status()),
+				RequestID: middleware.GetReqID(r.Context()),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Route:     route,
+				Status:    ww.Status(),
+				UserID:    s.userID,
+				Panic:     s.panic,
+				Stack:     s.stack,
+			}
+			if s.panic != "" {
+				report.Message = "panic serving " + r.Method + " " + route + ": " + s.panic
+			}
+			rep.Report(report)
+		})
+	}
+}
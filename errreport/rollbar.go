@@ -0,0 +1,67 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Item endpoint of the Rollbar API
+const rollbarEndpoint = "https://api.rollbar.com/api/1/item/"
+
+// rollbar posts the reports as items to Rollbar with a post_server_item access token
+type rollbar struct {
+	token       string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+func (rb *rollbar) Send(ctx context.Context, r Report) error {
+	hostname, _ := os.Hostname()
+	message := map[string]interface{}{"body": r.Message}
+	level := "error"
+	if r.Panic != "" {
+		level = "critical"
+		message["stack"] = r.Stack
+	}
+
+	data := map[string]interface{}{
+		"environment": rb.environment,
+		"level":       level,
+		"timestamp":   r.At.Unix(),
+		"platform":    "go",
+		"language":    "go",
+		"framework":   "chi",
+		"context":     r.Method + " " + r.Route,
+		"body":        map[string]interface{}{"message": message},
+		"request":     map[string]string{"method": r.Method, "url": r.Path},
+		"server":      map[string]string{"host": hostname},
+		"custom": map[string]interface{}{
+			"request_id": r.RequestID,
+			"route":      r.Route,
+			"status":     r.Status,
+		},
+	}
+	if rb.release != "" {
+		data["code_version"] = rb.release
+	}
+	if r.UserID != 0 {
+		data["person"] = map[string]string{"id": strconv.Itoa(r.UserID)}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rollbarEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Rollbar-Access-Token", rb.token)
+	return post(rb.client, req)
+}
@@ -0,0 +1,100 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sentry posts the reports as events to the envelope endpoint of a Sentry project
+type sentry struct {
+	endpoint    string
+	key         string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+func (s *sentry) Send(ctx context.Context, r Report) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	eventID := hex.EncodeToString(id)
+	hostname, _ := os.Hostname()
+
+	event := map[string]interface{}{
+		"event_id":    eventID,
+		"timestamp":   r.At.UTC().Format(time.RFC3339Nano),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "errreport",
+		"server_name": hostname,
+		"environment": s.environment,
+		"message":     map[string]string{"formatted": r.Message},
+		"transaction": r.Method + " " + r.Route,
+		"request":     map[string]string{"method": r.Method, "url": r.Path},
+		"tags": map[string]string{
+			"request_id": r.RequestID,
+			"route":      r.Route,
+			"status":     strconv.Itoa(r.Status),
+		},
+	}
+	if s.release != "" {
+		event["release"] = s.release
+	}
+	if r.UserID != 0 {
+		event["user"] = map[string]string{"id": strconv.Itoa(r.UserID)}
+	}
+	if r.Panic != "" {
+		event["level"] = "fatal"
+		event["exception"] = map[string]interface{}{
+			"values": []map[string]interface{}{{"type": "panic", "value": r.Panic, "mechanism": map[string]interface{}{"type": "recover", "handled": true}}},
+		}
+		event["extra"] = map[string]string{"stack": r.Stack}
+	}
+
+	// An envelope is a header line and one item, itself a header line and the payload
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, line := range []interface{}{
+		map[string]string{"event_id": eventID, "sent_at": time.Now().UTC().Format(time.RFC3339Nano)},
+		map[string]string{"type": "event"},
+		event,
+	} {
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=jwt-with-go/1.0, sentry_key="+s.key)
+	return post(s.client, req)
+}
+
+// post sends req and turns an answer other than 2xx into an error
+func post(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("answered %s: %s", res.Status, bytes.TrimSpace(detail))
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,137 @@
+// Package events decouples the side effects of a change from the handler making it. Handlers publish
+// domain events (a user was created, a login failed...) on a Bus, and subsystems like webhooks or the
+// audit log subscribe to the types they care about.
+//
+// Subscribers run synchronously, in the goroutine and with the context of the publisher, once the change
+// is done. They must be quick and handle their own errors: anything slow or that can fail belongs in a
+// background job (see package jobs).
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	UserCreated    = "user.created"
+	UserUpdated    = "user.updated"
+	UserDeleted    = "user.deleted"
+	LoginSucceeded = "login.succeeded"
+	LoginFailed    = "login.failed"
+	LoginLocked    = "login.locked"
+)
+
+// Event is a change that happened. Data is one of the payloads below, depending on Type.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// User is the payload of user.created and user.updated
+type User struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	AvatarURL string   `json:"avatar_url,omitempty"`
+}
+
+// UserRef is the payload of user.deleted
+type UserRef struct {
+	ID int `json:"id"`
+}
+
+// Login is the payload of login.succeeded and login.failed. UserID is 0 for failed logins.
+type Login struct {
+	UserID int    `json:"user_id,omitempty"`
+	Email  string `json:"email"`
+	IP     string `json:"ip"`
+}
+
+// Lockout is the payload of login.locked: failed logins locked out the account of Email or the IP
+// until Until. Email and IP are those of the failed login that placed the lock.
+type Lockout struct {
+	Email string    `json:"email"`
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// Handler reacts to an event
+type Handler func(ctx context.Context, e Event)
+
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[string][]Handler
+	all  []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[string][]Handler{}}
+}
+
+// Subscribe calls h for every event of the given types
+func (b *Bus) Subscribe(h Handler, types ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range types {
+		b.subs[t] = append(b.subs[t], h)
+	}
+}
+
+// SubscribeAll calls h for every event
+func (b *Bus) SubscribeAll(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.all = append(b.all, h)
+}
+
+// Publish builds an event of eventType with data and hands it to its subscribers, in the order
+// they subscribed. A panicking subscriber is logged and does not stop the others. A nil Bus drops the event.
+func (b *Bus) Publish(ctx context.Context, eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("[Events:Publish] Error generating the id of a %s event: %v", eventType, err)
+		return
+	}
+	e := Event{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.subs[eventType]...), b.all...)
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		deliver(ctx, h, e)
+	}
+}
+
+func deliver(ctx context.Context, h Handler, e Event) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[Events:Publish] Subscriber of %s panicked: %v", e.Type, rec)
+		}
+	}()
+	h(ctx, e)
+}
+
+// Log is a subscriber writing every event to the log, as an audit trail of who did what
+func Log(ctx context.Context, e Event) {
+	log.Printf("[Events:Log] %s %s: %+v", e.Type, e.ID, e.Data)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
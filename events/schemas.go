@@ -0,0 +1,20 @@
+package events
+
+import (
+	"embed"
+	"encoding/json"
+)
+
+// The JSON schema of each event type, named after it
+//
+//go:embed schemas/*.json
+var schemas embed.FS
+
+// Schema returns the JSON schema of the events of eventType, false for an unknown type
+func Schema(eventType string) (json.RawMessage, bool) {
+	schema, err := schemas.ReadFile("schemas/" + eventType + ".json")
+	if err != nil {
+		return nil, false
+	}
+	return schema, true
+}
@@ -0,0 +1,38 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package model
+
+type AuthPayload struct {
+	Token string `json:"token"`
+	// Renews the session with the refresh mutation once the token expired
+	RefreshToken string `json:"refreshToken"`
+}
+
+type Mutation struct {
+}
+
+type Query struct {
+}
+
+type User struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	AvatarURL *string  `json:"avatarUrl,omitempty"`
+	// Set by updateUser while the new email waits for confirmation
+	PendingEmail *string `json:"pendingEmail,omitempty"`
+}
+
+type UserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Viewer struct {
+	User *User `json:"user"`
+	// Permissions granted by the roles of the user
+	Permissions []string `json:"permissions"`
+	// Session of the token, what logout revokes
+	SessionID int `json:"sessionId"`
+}
@@ -0,0 +1,130 @@
+// Package graph serves the user queries and the auth mutations of schema.graphqls on /graphql, for
+// frontends that prefer GraphQL. Resolvers call the same handler methods as the REST routes, so they
+// share the validation, permissions, events and errors; the token is checked by OptionalJWTAuthMiddleware.
+//
+// generated.go, model/models_gen.go and the stubs of schema.resolvers.go are generated from the schema
+// with gqlgen (configured by gqlgen.yml at the root of the module):
+//
+//	go run github.com/99designs/gqlgen@v0.17.70 generate
+package graph
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/graph/model"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+type Resolver struct {
+	auth  *handlers.AuthenticationHandler
+	users *handlers.UserHandler
+}
+
+type contextKey string
+
+// Device the request comes from, for the sessions opened by login
+const contextClientKey = contextKey("client")
+
+// Most fields a query can select, so a single request can't make the server do unbounded work
+const complexityLimit = 200
+
+// REST route of each root field that needs a token, matched against the prefixes exempt from the
+// profile completion check (config.ProfileExemptRoutes)
+var fieldRoutes = map[string]string{
+	"me":         "/users/me",
+	"user":       "/users/{id}",
+	"users":      "/users",
+	"logout":     "/auth/sessions/{id}",
+	"createUser": "/users",
+	"updateUser": "/users/{id}",
+	"deleteUser": "/users/{id}",
+}
+
+// NewHandler returns the /graphql endpoint, taking queries and mutations as POSTed JSON
+func NewHandler(auth *handlers.AuthenticationHandler, users *handlers.UserHandler) http.Handler {
+	srv := handler.New(NewExecutableSchema(Config{Resolvers: &Resolver{auth: auth, users: users}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.FixedComplexityLimit(complexityLimit))
+	srv.AroundFields(profileCompletion(auth))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextClientKey, handlers.ClientOf(r))
+		srv.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// toError turns the error the REST API would answer into a GraphQL error. Its extensions hold the
+// code and status of the REST error, so clients can handle both APIs the same way.
+func toError(ctx context.Context, herr *apperrors.Error) error {
+	herr = handlers.ResolveError(herr)
+	if herr.Cause != nil && herr.Status >= http.StatusInternalServerError {
+		log.Printf("[GraphQL:toError] Cause of the error: %v", herr.Cause)
+	}
+	extensions := map[string]interface{}{"code": herr.Message.Code, "status": herr.Status}
+	if len(herr.Message.Fields) > 0 {
+		extensions["fields"] = herr.Message.Fields
+	}
+	return &gqlerror.Error{
+		Message:    herr.Message.Detail,
+		Path:       graphql.GetPath(ctx),
+		Extensions: extensions,
+	}
+}
+
+// profileCompletion answers the root fields of authenticated requests with the error of
+// ProfileCompletionMiddleware while the user has not filled in every required profile field
+func profileCompletion(auth *handlers.AuthenticationHandler) graphql.FieldMiddleware {
+	return func(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+		fc := graphql.GetFieldContext(ctx)
+		if fc.Object != "Query" && fc.Object != "Mutation" {
+			return next(ctx)
+		}
+		route, ok := fieldRoutes[fc.Field.Name]
+		if _, authenticated := ctx.Value(handlers.ContextUserIDKey).(int); !ok || !authenticated {
+			return next(ctx)
+		}
+		if herr := handlers.CheckProfileComplete(ctx, auth.DB, auth.Config.ProfileExemptRoutes, route); herr != nil {
+			return nil, toError(ctx, herr)
+		}
+		return next(ctx)
+	}
+}
+
+// authenticated returns the id of the user of the token, or an error when the request has none
+func authenticated(ctx context.Context) (int, error) {
+	userID, ok := ctx.Value(handlers.ContextUserIDKey).(int)
+	if !ok {
+		return 0, toError(ctx, apperrors.Unauthorized("Missing token"))
+	}
+	return userID, nil
+}
+
+// require checks the user of the token has permission, like RequirePermission
+func require(ctx context.Context, permission string) error {
+	if _, err := authenticated(ctx); err != nil {
+		return err
+	}
+	if !handlers.PermissionsFrom(ctx).Has(permission) {
+		return toError(ctx, apperrors.Forbidden("Missing permission "+permission))
+	}
+	return nil
+}
+
+func userModel(id int, name, email string, roles []string, avatarURL, pendingEmail string) *model.User {
+	u := &model.User{ID: id, Name: name, Email: email, Roles: roles}
+	if avatarURL != "" {
+		u.AvatarURL = &avatarURL
+	}
+	if pendingEmail != "" {
+		u.PendingEmail = &pendingEmail
+	}
+	return u
+}
@@ -0,0 +1,158 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.70
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/graph/model"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+)
+
+// Login is the resolver for the login field.
+func (r *mutationResolver) Login(ctx context.Context, email string, password string, deviceName *string, captchaToken *string) (*model.AuthPayload, error) {
+	device, captcha := "", ""
+	if deviceName != nil {
+		device = *deviceName
+	}
+	if captchaToken != nil {
+		captcha = *captchaToken
+	}
+	client, _ := ctx.Value(contextClientKey).(handlers.Client)
+	tokens, herr := r.auth.PasswordLogin(ctx, client, email, password, device, captcha)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	return &model.AuthPayload{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+// Refresh is the resolver for the refresh field.
+func (r *mutationResolver) Refresh(ctx context.Context, refreshToken string) (*model.AuthPayload, error) {
+	client, _ := ctx.Value(contextClientKey).(handlers.Client)
+	tokens, herr := r.auth.RefreshSession(ctx, client, refreshToken)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	return &model.AuthPayload{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
+	if _, err := authenticated(ctx); err != nil {
+		return false, err
+	}
+	sessionID, _ := ctx.Value(handlers.ContextSessionIDKey).(int64)
+	if herr := r.auth.RevokeSession(ctx, sessionID); herr != nil {
+		return false, toError(ctx, herr)
+	}
+	return true, nil
+}
+
+// CreateUser is the resolver for the createUser field.
+func (r *mutationResolver) CreateUser(ctx context.Context, input model.UserInput) (*model.User, error) {
+	if err := require(ctx, rbac.UsersCreate); err != nil {
+		return nil, err
+	}
+	u, herr := r.users.CreateUser(ctx, input.Name, input.Email)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	return userModel(u.ID, u.Name, u.Email, u.Roles, u.AvatarURL, ""), nil
+}
+
+// UpdateUser is the resolver for the updateUser field.
+func (r *mutationResolver) UpdateUser(ctx context.Context, id int, input model.UserInput) (*model.User, error) {
+	userID, err := authenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Users can update themselves, like OwnerOrAdminMiddleware
+	if userID != id && !handlers.PermissionsFrom(ctx).Has(rbac.UsersUpdate) {
+		return nil, toError(ctx, apperrors.Forbidden("Missing permission "+rbac.UsersUpdate))
+	}
+	u, herr := r.users.UpdateUser(ctx, id, input.Name, input.Email)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	return userModel(u.ID, u.Name, u.Email, u.Roles, u.AvatarURL, u.PendingEmail), nil
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, id int) (bool, error) {
+	if err := require(ctx, rbac.UsersDelete); err != nil {
+		return false, err
+	}
+	if herr := r.users.DeleteUser(ctx, id); herr != nil {
+		return false, toError(ctx, herr)
+	}
+	return true, nil
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*model.Viewer, error) {
+	userID, err := authenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, herr := r.users.GetUser(ctx, userID)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+
+	permissions := []string{}
+	for permission, granted := range handlers.PermissionsFrom(ctx) {
+		if granted {
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Strings(permissions)
+
+	sessionID, _ := ctx.Value(handlers.ContextSessionIDKey).(int64)
+	return &model.Viewer{
+		User:        userModel(u.ID, u.Name, u.Email, u.Roles, u.AvatarURL, ""),
+		Permissions: permissions,
+		SessionID:   int(sessionID),
+	}, nil
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, id int) (*model.User, error) {
+	if err := require(ctx, rbac.UsersRead); err != nil {
+		return nil, err
+	}
+	u, herr := r.users.GetUser(ctx, id)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	return userModel(u.ID, u.Name, u.Email, u.Roles, u.AvatarURL, ""), nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, tags []string) ([]*model.User, error) {
+	if err := require(ctx, rbac.UsersList); err != nil {
+		return nil, err
+	}
+	users, herr := r.users.ListUsers(ctx, tags)
+	if herr != nil {
+		return nil, toError(ctx, herr)
+	}
+	res := []*model.User{}
+	for _, u := range users {
+		res = append(res, userModel(u.ID, u.Name, u.Email, u.Roles, u.AvatarURL, ""))
+	}
+	return res, nil
+}
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
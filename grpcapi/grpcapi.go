@@ -0,0 +1,162 @@
+// Package grpcapi serves the AuthService and UserService of proto/jwtapi/v1 over gRPC, for internal
+// services that would rather avoid HTTP and JSON. The services run the same operations as the REST routes
+// (see handlers.UserHandler and handlers.AuthenticationHandler.PasswordLogin), with the same tokens and
+// permissions: clients send "authorization: Bearer <token>" metadata, checked by the interceptor.
+//
+// The code in pb is generated from the proto files:
+//
+//	protoc -I proto --go_out=. --go_opt=module=github.com/hi-im-yan/jwt-with-go \
+//		--go-grpc_out=. --go-grpc_opt=module=github.com/hi-im-yan/jwt-with-go proto/jwtapi/v1/*.proto
+package grpcapi
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/grpcapi/pb"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Methods callable without a token
+var publicMethods = map[string]bool{
+	pb.AuthService_Login_FullMethodName:   true,
+	pb.AuthService_Refresh_FullMethodName: true,
+}
+
+// Permission each method needs, on top of a valid token. UpdateUser checks its own, since users can update themselves.
+var methodPermissions = map[string]string{
+	pb.UserService_GetUser_FullMethodName:    rbac.UsersRead,
+	pb.UserService_ListUsers_FullMethodName:  rbac.UsersList,
+	pb.UserService_CreateUser_FullMethodName: rbac.UsersCreate,
+	pb.UserService_DeleteUser_FullMethodName: rbac.UsersDelete,
+}
+
+// REST route of each method, matched against the prefixes exempt from the profile completion check
+// (config.ProfileExemptRoutes)
+var methodRoutes = map[string]string{
+	pb.AuthService_Me_FullMethodName:         "/users/me",
+	pb.UserService_GetUser_FullMethodName:    "/users/{id}",
+	pb.UserService_ListUsers_FullMethodName:  "/users",
+	pb.UserService_CreateUser_FullMethodName: "/users",
+	pb.UserService_UpdateUser_FullMethodName: "/users/{id}",
+	pb.UserService_DeleteUser_FullMethodName: "/users/{id}",
+}
+
+// NewServer returns a gRPC server with both services registered
+func NewServer(cfg *config.Config, db *pgxpool.Pool, auth *handlers.AuthenticationHandler, users *handlers.UserHandler) *grpc.Server {
+	i := &interceptor{db: db, jwt: cfg.JWT, resolver: rbac.NewResolver(db), queryTimeout: cfg.QueryTimeout, profileExempt: cfg.ProfileExemptRoutes}
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(recoverer, i.authenticate))
+	pb.RegisterAuthServiceServer(s, &authService{auth: auth, users: users})
+	pb.RegisterUserServiceServer(s, &userService{users: users})
+	return s
+}
+
+type interceptor struct {
+	db            *pgxpool.Pool
+	jwt           config.JWT
+	resolver      *rbac.Resolver
+	queryTimeout  time.Duration
+	profileExempt []string
+}
+
+// authenticate checks the bearer token of the call, the profile of its user and the permission of its
+// method, like JWTAuthMiddleware, ProfileCompletionMiddleware and RequirePermission do for the REST routes.
+// It also bounds the time the call can spend in the database, like QueryTimeoutMiddleware.
+func (i *interceptor) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, i.queryTimeout)
+	defer cancel()
+
+	if !publicMethods[info.FullMethod] {
+		token, found := strings.CutPrefix(firstMetadata(ctx, "authorization"), "Bearer ")
+		if !found {
+			return nil, status.Error(codes.Unauthenticated, "Missing token")
+		}
+		var herr *apperrors.Error
+		ctx, herr = handlers.AuthenticateToken(ctx, i.db, i.resolver, i.jwt, token)
+		if herr != nil {
+			return nil, toStatus(herr)
+		}
+		if herr := handlers.CheckProfileComplete(ctx, i.db, i.profileExempt, methodRoutes[info.FullMethod]); herr != nil {
+			return nil, toStatus(herr)
+		}
+		if permission, ok := methodPermissions[info.FullMethod]; ok && !handlers.PermissionsFrom(ctx).Has(permission) {
+			return nil, status.Error(codes.PermissionDenied, "Missing permission "+permission)
+		}
+	}
+
+	res, err := handler(ctx, req)
+	log.Printf("[GRPC:authenticate] %s %s in %v", info.FullMethod, status.Code(err), time.Since(start))
+	return res, err
+}
+
+// recoverer answers Internal instead of crashing the server when a method panics
+func recoverer(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[GRPC:recoverer] Panic in %s: %v\n%s", info.FullMethod, rec, debug.Stack())
+			err = status.Error(codes.Internal, "Something went wrong")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// toStatus turns the error the REST API would answer into the matching gRPC status
+func toStatus(herr *apperrors.Error) error {
+	herr = handlers.ResolveError(herr)
+	if herr.Cause != nil && herr.Status >= http.StatusInternalServerError {
+		log.Printf("[GRPC:toStatus] Cause of the error: %v", herr.Cause)
+	}
+	code := codes.Internal
+	switch herr.Status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusUnprocessableEntity, http.StatusPreconditionRequired:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, herr.Message.Detail)
+}
+
+// clientOf returns the device of a call, recorded with the sessions it opens
+func clientOf(ctx context.Context) handlers.Client {
+	client := handlers.Client{UserAgent: firstMetadata(ctx, "user-agent")}
+	if p, ok := peer.FromContext(ctx); ok {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+	return client
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
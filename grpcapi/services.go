@@ -0,0 +1,111 @@
+package grpcapi
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hi-im-yan/jwt-with-go/grpcapi/pb"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type authService struct {
+	pb.UnimplementedAuthServiceServer
+	auth  *handlers.AuthenticationHandler
+	users *handlers.UserHandler
+}
+
+func (s *authService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	tokens, herr := s.auth.PasswordLogin(ctx, clientOf(ctx), req.GetEmail(), req.GetPassword(), req.GetDeviceName(), req.GetCaptchaToken())
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.LoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+func (s *authService) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.LoginResponse, error) {
+	tokens, herr := s.auth.RefreshSession(ctx, clientOf(ctx), req.GetRefreshToken())
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.LoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+func (s *authService) Me(ctx context.Context, _ *pb.MeRequest) (*pb.MeResponse, error) {
+	userID, _ := ctx.Value(handlers.ContextUserIDKey).(int)
+	u, herr := s.users.GetUser(ctx, userID)
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+
+	var permissions []string
+	for permission, granted := range handlers.PermissionsFrom(ctx) {
+		if granted {
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Strings(permissions)
+
+	sessionID, _ := ctx.Value(handlers.ContextSessionIDKey).(int64)
+	return &pb.MeResponse{
+		User:        &pb.User{Id: int64(u.ID), Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarUrl: u.AvatarURL},
+		Permissions: permissions,
+		SessionId:   sessionID,
+	}, nil
+}
+
+type userService struct {
+	pb.UnimplementedUserServiceServer
+	users *handlers.UserHandler
+}
+
+func (s *userService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	u, herr := s.users.GetUser(ctx, int(req.GetId()))
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.User{Id: int64(u.ID), Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarUrl: u.AvatarURL}, nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	users, herr := s.users.ListUsers(ctx, req.GetTags())
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	res := &pb.ListUsersResponse{}
+	for _, u := range users {
+		res.Users = append(res.Users, &pb.User{Id: int64(u.ID), Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarUrl: u.AvatarURL})
+	}
+	return res, nil
+}
+
+func (s *userService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.User, error) {
+	u, herr := s.users.CreateUser(ctx, req.GetName(), req.GetEmail())
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.User{Id: int64(u.ID), Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarUrl: u.AvatarURL}, nil
+}
+
+// UpdateUser lets users update themselves, like PUT /users/{id}
+func (s *userService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	userID, _ := ctx.Value(handlers.ContextUserIDKey).(int)
+	if int64(userID) != req.GetId() && !handlers.PermissionsFrom(ctx).Has(rbac.UsersUpdate) {
+		return nil, status.Error(codes.PermissionDenied, "Missing permission "+rbac.UsersUpdate)
+	}
+
+	u, herr := s.users.UpdateUser(ctx, int(req.GetId()), req.GetName(), req.GetEmail())
+	if herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.User{Id: int64(u.ID), Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarUrl: u.AvatarURL, PendingEmail: u.PendingEmail}, nil
+}
+
+func (s *userService) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	if herr := s.users.DeleteUser(ctx, int(req.GetId())); herr != nil {
+		return nil, toStatus(herr)
+	}
+	return &pb.DeleteUserResponse{}, nil
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdminHandler holds support tooling that only admins can use, like notes and tags on user accounts
+type AdminHandler struct {
+	cfg    *config.Config
+	db     *pgxpool.Pool
+	admin  repository.AdminRepository
+	users  repository.UserRepository
+	mailer mailer.Mailer
+	// Reload reloads the configuration for POST /admin/config/reload, set by the server
+	Reload func() error
+	// UserChanged is called for the users whose roles change, set by the server
+	UserChanged UserChanged
+	// Events receives the users whose roles change, set by the server
+	Events *events.Bus
+	// Maintenance is the switch of /admin/maintenance, set by the server
+	Maintenance *Maintenance
+}
+
+// Note Response Model
+type note struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Note Request Model
+type noteRequest struct {
+	Body string `json:"body" validate:"required"`
+}
+
+type rolesResponse struct {
+	UserID int      `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+type tagsResponse struct {
+	UserID int      `json:"user_id"`
+	Tags   []string `json:"tags"`
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_:-]{0,49}$`)
+
+func NewAdminHandler(cfg *config.Config, db *pgxpool.Pool, admin repository.AdminRepository, users repository.UserRepository, m mailer.Mailer) *AdminHandler {
+	return &AdminHandler{cfg: cfg, db: db, admin: admin, users: users, mailer: m}
+}
+
+func noteFromRecord(n *repository.Note) *note {
+	return &note{ID: n.ID, UserID: n.UserID, Author: n.Author, Body: n.Body, CreatedAt: n.CreatedAt}
+}
+
+// Configuration of routes
+func (adh *AdminHandler) AdminRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(adh.db, adh.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(adh.db, adh.cfg.ProfileExemptRoutes)))
+
+	// Routes
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(RequirePermission(rbac.UsersAnnotate)))
+
+		r.HandleFunc("POST /users/{id}/notes", ApiHandlerAdapter(Handle(http.StatusCreated, adh.addNote)))
+		r.HandleFunc("GET /users/{id}/notes", ApiHandlerAdapter(adh.getNotes))
+		r.HandleFunc("GET /users/{id}/tags", ApiHandlerAdapter(adh.getTags))
+		r.HandleFunc("PUT /users/{id}/tags/{tag}", ApiHandlerAdapter(adh.addTag))
+		r.HandleFunc("DELETE /users/{id}/tags/{tag}", ApiHandlerAdapter(adh.removeTag))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(RequirePermission(rbac.RolesAssign)))
+
+		r.HandleFunc("PUT /users/{id}/roles/{role}", ApiHandlerAdapter(adh.grantRole))
+		r.HandleFunc("DELETE /users/{id}/roles/{role}", ApiHandlerAdapter(adh.revokeRole))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(RequirePermission(rbac.UsersInvite)))
+
+		r.HandleFunc("POST /invites", ApiHandlerAdapter(Handle(http.StatusCreated, adh.createInvite)))
+		r.HandleFunc("GET /invites", ApiHandlerAdapter(adh.getInvites))
+		r.HandleFunc("DELETE /invites/{id}", ApiHandlerAdapter(adh.revokeInvite))
+	})
+	r.With(MiddlewareAdapter(RequirePermission(rbac.ConfigReload))).HandleFunc("POST /config/reload", ApiHandlerAdapter(adh.reloadConfig))
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(RequirePermission(rbac.MaintenanceManage)))
+
+		r.HandleFunc("GET /maintenance", ApiHandlerAdapter(adh.getMaintenance))
+		r.HandleFunc("PUT /maintenance", ApiHandlerAdapter(Handle(http.StatusOK, adh.setMaintenance)))
+	})
+
+	return r
+}
+
+// Configuration of the maintenance routes of the internal listener. They have no JWT check:
+// reaching the internal address is the authorization, like for /metrics.
+func (adh *AdminHandler) InternalRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Routes
+	r.HandleFunc("POST /config/reload", ApiHandlerAdapter(adh.reloadConfig))
+	r.HandleFunc("GET /maintenance", ApiHandlerAdapter(adh.getMaintenance))
+	r.HandleFunc("PUT /maintenance", ApiHandlerAdapter(Handle(http.StatusOK, adh.setMaintenance)))
+
+	return r
+}
+
+// @Summary      Add a note to a user
+// @Description  Adds an internal support note to a user account (Admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        request body noteRequest true "Note"
+// @Success      201 {object} note
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/notes [post]
+func (adh *AdminHandler) addNote(r *http.Request, noteReq *noteRequest) (*note, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AdminHandler:addNote] start")
+
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	// Blank bodies pass the required rule
+	if strings.TrimSpace(noteReq.Body) == "" {
+		return nil, apperrors.InvalidBody("body is required")
+	}
+
+	if herr := adh.ensureUserExists(r.Context(), id, idStr); herr != nil {
+		return nil, herr
+	}
+
+	author, _ := r.Context().Value(ContextUsernameKey).(string)
+	log.Printf("[AdminHandler:addNote] Adding note to user %d by %s", id, author)
+
+	n, err := adh.admin.AddNote(r.Context(), id, author, noteReq.Body)
+	if err != nil {
+		log.Printf("[AdminHandler:addNote] Error inserting note: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	log.Printf("[AdminHandler:addNote] end. Took %v", time.Since(start))
+	return noteFromRecord(n), nil
+}
+
+// @Summary      List notes of a user
+// @Description  Lists the support notes of a user account, newest first (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      200 {array} note
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/notes [get]
+func (adh *AdminHandler) getNotes(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AdminHandler:getNotes] start")
+
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	if herr := adh.ensureUserExists(r.Context(), id, idStr); herr != nil {
+		return nil, herr
+	}
+
+	records, err := adh.admin.Notes(r.Context(), id)
+	if err != nil {
+		log.Printf("[AdminHandler:getNotes] Error querying notes: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	notes := []note{}
+	for i := range records {
+		notes = append(notes, *noteFromRecord(&records[i]))
+	}
+
+	log.Printf("[AdminHandler:getNotes] end. Took %v", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   notes,
+	}, nil
+}
+
+// @Summary      List tags of a user
+// @Description  Lists the tags of a user account (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      200 {object} tagsResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/tags [get]
+func (adh *AdminHandler) getTags(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	if herr := adh.ensureUserExists(r.Context(), id, idStr); herr != nil {
+		return nil, herr
+	}
+	return adh.tagsOf(r.Context(), id)
+}
+
+// @Summary      Tag a user
+// @Description  Adds a tag (lowercase letters, digits, "-", "_" and ":") to a user account. Adding an existing tag is a no-op (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        tag path string true "Tag"
+// @Success      200 {object} tagsResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/tags/{tag} [put]
+func (adh *AdminHandler) addTag(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AdminHandler:addTag] start")
+
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	tag := chi.URLParam(r, "tag")
+	if !tagPattern.MatchString(tag) {
+		return nil, apperrors.BadRequest("Tags must be 1-50 lowercase letters, digits, '-', '_' or ':'")
+	}
+	if herr := adh.ensureUserExists(r.Context(), id, idStr); herr != nil {
+		return nil, herr
+	}
+
+	log.Printf("[AdminHandler:addTag] Tagging user %d with %s", id, tag)
+	if err := adh.admin.AddTag(r.Context(), id, tag); err != nil {
+		log.Printf("[AdminHandler:addTag] Error inserting tag: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	return adh.tagsOf(r.Context(), id)
+}
+
+// @Summary      Untag a user
+// @Description  Removes a tag from a user account (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        tag path string true "Tag"
+// @Success      200 {object} tagsResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/tags/{tag} [delete]
+func (adh *AdminHandler) removeTag(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AdminHandler:removeTag] start")
+
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	tag := chi.URLParam(r, "tag")
+
+	err := adh.admin.RemoveTag(r.Context(), id, tag)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, apperrors.NotFound("User " + strconv.Itoa(id) + " has no tag " + tag)
+	}
+	if err != nil {
+		log.Printf("[AdminHandler:removeTag] Error deleting tag: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	return adh.tagsOf(r.Context(), id)
+}
+
+// @Summary      Grant a role
+// @Description  Grants a role to a user, on top of the roles they already hold. Permissions apply on the next request
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        role path string true "Role name"
+// @Success      200 {object} rolesResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/roles/{role} [put]
+func (adh *AdminHandler) grantRole(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AdminHandler:grantRole] start")
+
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	res, herr := adh.GrantRole(r.Context(), id, chi.URLParam(r, "role"))
+	if herr != nil {
+		return nil, herr
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: res}, nil
+}
+
+// GrantRole grants role to the user id, shared with the admin dashboard
+func (adh *AdminHandler) GrantRole(ctx context.Context, id int, role string) (*rolesResponse, *apperrors.Error) {
+	if herr := adh.ensureUserExists(ctx, id, strconv.Itoa(id)); herr != nil {
+		return nil, herr
+	}
+
+	log.Printf("[AdminHandler:GrantRole] Granting role %s to user %d", role, id)
+	if err := adh.admin.GrantRole(ctx, id, role); err != nil {
+		log.Printf("[AdminHandler:GrantRole] Error granting role: %v", err)
+		return nil, apperrors.Internal()
+	}
+	adh.UserChanged.notify(ctx, id)
+	adh.publishUserUpdated(ctx, id)
+
+	res, err := adh.rolesOf(ctx, id)
+	if err != nil {
+		log.Printf("[AdminHandler:GrantRole] Error querying roles: %v", err)
+		return nil, apperrors.Internal()
+	}
+	if !containsString(res.Roles, role) {
+		return nil, apperrors.NotFound("Role " + role + " not found")
+	}
+	return res, nil
+}
+
+// @Summary      Revoke a role
+// @Description  Revokes a role from a user. Permissions apply on the next request
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        role path string true "Role name"
+// @Success      200 {object} rolesResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/users/{id}/roles/{role} [delete]
+func (adh *AdminHandler) revokeRole(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AdminHandler:revokeRole] start")
+
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	res, herr := adh.RevokeRole(r.Context(), id, chi.URLParam(r, "role"))
+	if herr != nil {
+		return nil, herr
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: res}, nil
+}
+
+// RevokeRole revokes role from the user id, shared with the admin dashboard
+func (adh *AdminHandler) RevokeRole(ctx context.Context, id int, role string) (*rolesResponse, *apperrors.Error) {
+	log.Printf("[AdminHandler:RevokeRole] Revoking role %s from user %d", role, id)
+	err := adh.admin.RevokeRole(ctx, id, role)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, apperrors.NotFound("User " + strconv.Itoa(id) + " has no role " + role)
+	}
+	if err != nil {
+		log.Printf("[AdminHandler:RevokeRole] Error revoking role: %v", err)
+		return nil, apperrors.Internal()
+	}
+	adh.UserChanged.notify(ctx, id)
+	adh.publishUserUpdated(ctx, id)
+
+	res, err := adh.rolesOf(ctx, id)
+	if err != nil {
+		log.Printf("[AdminHandler:RevokeRole] Error querying roles: %v", err)
+		return nil, apperrors.Internal()
+	}
+	return res, nil
+}
+
+// publishUserUpdated publishes the user.updated event of a user whose roles changed
+func (adh *AdminHandler) publishUserUpdated(ctx context.Context, id int) {
+	if adh.Events == nil {
+		return
+	}
+	u := &user{}
+	query := `SELECT u.id, u.name, u.email, ` + userRolesColumn + `, COALESCE(u.avatar_url, '') FROM users u WHERE u.id = $1 AND u.deleted_at IS NULL;`
+	err := adh.db.QueryRow(ctx, query, id).Scan(&u.ID, &u.Name, &u.Email, &u.Roles, &u.AvatarURL)
+	if err == nil {
+		u.Email, err = pii.Decrypt(u.Email, adh.cfg.PIIEncryptionKey)
+	}
+	if err != nil {
+		log.Printf("[AdminHandler:publishUserUpdated] Error querying user %d: %v", id, err)
+		return
+	}
+	adh.Events.Publish(ctx, events.UserUpdated, u.event())
+}
+
+func (adh *AdminHandler) rolesOf(ctx context.Context, id int) (*rolesResponse, error) {
+	roles, err := adh.admin.Roles(ctx, id)
+	return &rolesResponse{UserID: id, Roles: roles}, err
+}
+
+func (adh *AdminHandler) tagsOf(ctx context.Context, id int) (*HandlerSuccess, *apperrors.Error) {
+	tags, err := adh.admin.Tags(ctx, id)
+	if err != nil {
+		log.Printf("[AdminHandler:tagsOf] Error querying tags: %v", err)
+		return nil, apperrors.Internal()
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: &tagsResponse{UserID: id, Tags: tags}}, nil
+}
+
+func (adh *AdminHandler) ensureUserExists(ctx context.Context, id int, idStr string) *apperrors.Error {
+	exists, err := adh.users.Exists(ctx, id)
+	if err != nil {
+		log.Printf("[AdminHandler:ensureUserExists] Error querying user: %v", err)
+		return apperrors.Internal()
+	}
+	if !exists {
+		return apperrors.NotFound("User with id " + idStr + " not found")
+	}
+	return nil
+}
+
+// userIDParam parses the {id} path parameter
+func userIDParam(r *http.Request) (int, string, *apperrors.Error) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, idStr, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+	return id, idStr, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type reloadResponse struct {
+	Message string `json:"message"`
+}
+
+// @Summary      Reload the configuration
+// @Description  Reads the configuration again and applies the settings that can change while running: log level, rate limits, CORS and maintenance mode. Same as sending SIGHUP to the process. Other settings need a restart (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} reloadResponse
+// @Failure      422 {object} apperrors.Response "The new configuration is invalid, the current one is kept"
+// @Router       /admin/config/reload [post]
+func (adh *AdminHandler) reloadConfig(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	if adh.Reload == nil {
+		return nil, apperrors.Internal()
+	}
+	if err := adh.Reload(); err != nil {
+		log.Printf("[AdminHandler:reloadConfig] Error reloading configuration: %v", err)
+		return nil, apperrors.Unprocessable(err.Error())
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: &reloadResponse{Message: "Configuration reloaded"}}, nil
+}
@@ -0,0 +1,268 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/repository/mocks"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+func newAdminHandler(admin *mocks.AdminRepository, users *mocks.UserRepository) *handlers.AdminHandler {
+	return handlers.NewAdminHandler(&config.Config{}, nil, admin, users, nil)
+}
+
+// aliceExists is the ExistsFunc of a repository holding alice only
+func aliceExists(ctx context.Context, id int) (bool, error) {
+	return id == alice.ID, nil
+}
+
+func TestAddNote(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		body      interface{}
+		status    int
+		code      string
+		field     string
+		userCalls []string
+		calls     []string
+	}{
+		{name: "added", id: "2", body: map[string]string{"body": "Called about billing"}, status: http.StatusCreated, userCalls: []string{"Exists"}, calls: []string{"AddNote"}},
+		{name: "id not a number", id: "abc", body: map[string]string{"body": "Called about billing"}, status: http.StatusBadRequest, code: "E400"},
+		{name: "missing body", id: "2", body: map[string]string{}, status: http.StatusBadRequest, code: "E400", field: "body"},
+		{name: "blank body", id: "2", body: map[string]string{"body": "  "}, status: http.StatusBadRequest, code: "E400"},
+		{name: "user not found", id: "99", body: map[string]string{"body": "Called about billing"}, status: http.StatusNotFound, code: "E404", userCalls: []string{"Exists"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ExistsFunc: aliceExists}
+			admins := &mocks.AdminRepository{
+				AddNoteFunc: func(ctx context.Context, userID int, author, body string) (*repository.Note, error) {
+					return &repository.Note{ID: 1, UserID: userID, Author: author, Body: body}, nil
+				},
+			}
+			r := adminRequest(t, http.MethodPost, "/admin/users/"+tt.id+"/notes", tt.body, "id", tt.id)
+
+			rec := testutil.Serve(newAdminHandler(admins, users).AddNoteRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got struct {
+					UserID int    `json:"user_id"`
+					Author string `json:"author"`
+				}
+				testutil.DecodeJSON(t, rec, &got)
+				if got.UserID != alice.ID || got.Author != admin.Name {
+					t.Errorf("note = %+v, want the note of %s on user %d", got, admin.Name, alice.ID)
+				}
+			}
+			assertCalls(t, users, tt.userCalls)
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
+
+func TestGetNotes(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "listed", id: "2", status: http.StatusOK, calls: []string{"Notes"}},
+		{name: "user not found", id: "99", status: http.StatusNotFound, code: "E404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ExistsFunc: aliceExists}
+			admins := &mocks.AdminRepository{
+				NotesFunc: func(ctx context.Context, userID int) ([]repository.Note, error) {
+					return []repository.Note{{ID: 2, UserID: userID, Body: "second"}, {ID: 1, UserID: userID, Body: "first"}}, nil
+				},
+			}
+			r := adminRequest(t, http.MethodGet, "/admin/users/"+tt.id+"/notes", nil, "id", tt.id)
+
+			rec := testutil.Serve(newAdminHandler(admins, users).GetNotesRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got []struct {
+					ID int `json:"id"`
+				}
+				testutil.DecodeJSON(t, rec, &got)
+				if len(got) != 2 || got[0].ID != 2 {
+					t.Errorf("notes = %+v, want the 2 notes newest first", got)
+				}
+			}
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
+
+func TestAddTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		tag    string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "added", id: "2", tag: "vip", status: http.StatusOK, calls: []string{"AddTag", "Tags"}},
+		{name: "id not a number", id: "abc", tag: "vip", status: http.StatusBadRequest, code: "E400"},
+		{name: "uppercase tag", id: "2", tag: "VIP", status: http.StatusBadRequest, code: "E400"},
+		{name: "user not found", id: "99", tag: "vip", status: http.StatusNotFound, code: "E404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ExistsFunc: aliceExists}
+			admins := &mocks.AdminRepository{
+				AddTagFunc: func(ctx context.Context, userID int, tag string) error { return nil },
+				TagsFunc:   func(ctx context.Context, userID int) ([]string, error) { return []string{"vip"}, nil },
+			}
+			r := adminRequest(t, http.MethodPut, "/admin/users/"+tt.id+"/tags/"+tt.tag, nil, "id", tt.id, "tag", tt.tag)
+
+			rec := testutil.Serve(newAdminHandler(admins, users).AddTagRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
+
+func TestRemoveTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		tag    string
+		err    error // returned by RemoveTag
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "removed", tag: "vip", status: http.StatusOK, calls: []string{"RemoveTag", "Tags"}},
+		{name: "not tagged", tag: "churned", err: repository.ErrNotFound, status: http.StatusNotFound, code: "E404", calls: []string{"RemoveTag"}},
+		{name: "database error", tag: "vip", err: errors.New("connection reset"), status: http.StatusInternalServerError, code: "E500", calls: []string{"RemoveTag"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admins := &mocks.AdminRepository{
+				RemoveTagFunc: func(ctx context.Context, userID int, tag string) error { return tt.err },
+				TagsFunc:      func(ctx context.Context, userID int) ([]string, error) { return []string{}, nil },
+			}
+			r := adminRequest(t, http.MethodDelete, "/admin/users/2/tags/"+tt.tag, nil, "id", "2", "tag", tt.tag)
+
+			rec := testutil.Serve(newAdminHandler(admins, nil).RemoveTagRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
+
+func TestGrantRole(t *testing.T) {
+	// Granting an unknown role inserts nothing, the user keeps the roles they had
+	grant := func(ctx context.Context, userID int, role string) error { return nil }
+	roles := func(ctx context.Context, userID int) ([]string, error) { return []string{"support", "user"}, nil }
+
+	tests := []struct {
+		name   string
+		id     string
+		role   string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "granted", id: "2", role: "support", status: http.StatusOK, calls: []string{"GrantRole", "Roles"}},
+		{name: "id not a number", id: "abc", role: "support", status: http.StatusBadRequest, code: "E400"},
+		{name: "user not found", id: "99", role: "support", status: http.StatusNotFound, code: "E404"},
+		{name: "unknown role", id: "2", role: "nothing", status: http.StatusNotFound, code: "E404", calls: []string{"GrantRole", "Roles"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ExistsFunc: aliceExists}
+			admins := &mocks.AdminRepository{GrantRoleFunc: grant, RolesFunc: roles}
+			r := adminRequest(t, http.MethodPut, "/admin/users/"+tt.id+"/roles/"+tt.role, nil, "id", tt.id, "role", tt.role)
+
+			rec := testutil.Serve(newAdminHandler(admins, users).GrantRoleRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got struct {
+					UserID int      `json:"user_id"`
+					Roles  []string `json:"roles"`
+				}
+				testutil.DecodeJSON(t, rec, &got)
+				if got.UserID != alice.ID || len(got.Roles) != 2 {
+					t.Errorf("roles = %+v", got)
+				}
+			}
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
+
+func TestRevokeRole(t *testing.T) {
+	revoke := func(ctx context.Context, userID int, role string) error {
+		if role != "support" {
+			return repository.ErrNotFound
+		}
+		return nil
+	}
+	roles := func(ctx context.Context, userID int) ([]string, error) { return []string{"user"}, nil }
+
+	tests := []struct {
+		name   string
+		role   string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "revoked", role: "support", status: http.StatusOK, calls: []string{"RevokeRole", "Roles"}},
+		{name: "not held", role: "auditor", status: http.StatusNotFound, code: "E404", calls: []string{"RevokeRole"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admins := &mocks.AdminRepository{RevokeRoleFunc: revoke, RolesFunc: roles}
+			r := adminRequest(t, http.MethodDelete, "/admin/users/2/roles/"+tt.role, nil, "id", "2", "role", tt.role)
+
+			rec := testutil.Serve(newAdminHandler(admins, nil).RevokeRoleRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, admins, tt.calls)
+		})
+	}
+}
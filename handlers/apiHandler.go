@@ -1,20 +1,22 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/jwe"
 )
 
 // This file contains a http.HandleFunc wrapper to always return a success or error.
-// The "success" and "error" responses are defined in the "HandlerSuccess" and "HandlerError" structs
-// and can be used as json responses.
+// The "success" responses are defined in the "HandlerSuccess" struct, the "error" ones are built with
+// the constructors of the apperrors package, and both are sent as json responses.
 // See indexHandler.go for an example
-type ApiHandlerFunc func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError)
+type ApiHandlerFunc func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error)
 
 type ApiMiddlewareFunc func(ApiHandlerFunc) ApiHandlerFunc
 
@@ -23,17 +25,6 @@ type HandlerSuccess struct {
 	Data   interface{}
 }
 
-type HandlerError struct {
-	Status  int `json:"-"`
-	Message ErrorResponse
-}
-
-type ErrorResponse struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
-	Detail  string `json:"detail"`
-}
-
 // This function is a http.HandlerFunc adapter for my custom HandlerFunc called ApiHandlerFunc.
 func ApiHandlerAdapter(handler ApiHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -42,15 +33,68 @@ func ApiHandlerAdapter(handler ApiHandlerFunc) http.HandlerFunc {
 		success, err := handler(w, r)
 
 		if err != nil {
-			w.WriteHeader(err.Status)
-			json.NewEncoder(w).Encode(err.Message)
+			if bodyTooLarge(r) {
+				err = apperrors.PayloadTooLarge("Request body is too large")
+			}
+			writeError(w, r, err)
 			return
 		}
 
 		if success != nil {
-			w.WriteHeader(success.Status)
-			json.NewEncoder(w).Encode(success.Data)
+			data := success.Data
+			if fields := requestedFields(r); data != nil && fields != nil {
+				// The fields of a page are the ones of its items
+				page, isPage := data.(*listPage)
+				target := data
+				if isPage {
+					target = page.Items
+				}
+				if selected, err := selectFields(target, fields); err != nil {
+					log.Printf("[APIHandler:ApiHandlerAdapter] Error selecting fields %v: %v", fields, err)
+				} else if isPage {
+					trimmed := *page
+					trimmed.Items = selected
+					data = &trimmed
+				} else {
+					data = selected
+				}
+			}
+
+			var body interface{}
+			if data != nil {
+				body = wrapInEnvelope(r, data, nil)
+			}
+			writeBody(w, r, success.Status, body)
+		}
+	}
+}
+
+// TypedHandlerFunc handles a request once req was bound from its path, query and JSON body and validated,
+// see Handle. What it returns is the response body.
+type TypedHandlerFunc[TReq, TResp any] func(r *http.Request, req *TReq) (TResp, *apperrors.Error)
+
+// Handle turns fn into an ApiHandlerFunc, so it goes through ApiHandlerAdapter and the ApiMiddlewareFuncs
+// like any other: a TReq is bound from the path parameters, the query and the body (see bindRequest) and
+// its `validate` rules checked, answering 400 without calling fn when it isn't valid, and the response
+// of fn is sent with status.
+//
+//	r.HandleFunc("POST /", ApiHandlerAdapter(Handle(http.StatusCreated, uh.insertUser)))
+func Handle[TReq, TResp any](status int, fn TypedHandlerFunc[TReq, TResp]) ApiHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+		defer r.Body.Close()
+
+		var req TReq
+		if herr := bindRequest(r, &req); herr != nil {
+			return nil, herr
 		}
+		if herr := validateRequest(&req); herr != nil {
+			return nil, herr
+		}
+		resp, herr := fn(r, &req)
+		if herr != nil {
+			return nil, herr
+		}
+		return &HandlerSuccess{Status: status, Data: resp}, nil
 	}
 }
 
@@ -58,7 +102,7 @@ func ApiHandlerAdapter(handler ApiHandlerFunc) http.HandlerFunc {
 func MiddlewareAdapter(mw ApiMiddlewareFunc) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		// Convert http.Handler to your ApiHandlerFunc
-		handler := func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+		handler := func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 			// This "fake" ApiHandlerFunc just calls the next handler
 			next.ServeHTTP(w, r)
 			return nil, nil
@@ -67,36 +111,75 @@ func MiddlewareAdapter(mw ApiMiddlewareFunc) func(http.Handler) http.Handler {
 		// Wrap it with your middleware
 		wrapped := mw(handler)
 
-		// Return a standard http.HandlerFunc that calls your middleware-wrapped handler
+		// Return a standard http.HandlerFunc that calls your middleware-wrapped handler.
+		// The content type is only set when the middleware answers itself (by writeBody), next sets its own.
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-
 			success, err := wrapped(w, r)
 
 			if err != nil {
-				w.WriteHeader(err.Status)
-				_ = json.NewEncoder(w).Encode(err.Message)
+				writeError(w, r, err)
 				return
 			}
 
 			if success != nil {
-				w.WriteHeader(success.Status)
+				var body interface{}
 				if success.Data != nil {
-					_ = json.NewEncoder(w).Encode(success.Data)
+					body = wrapInEnvelope(r, success.Data, nil)
 				}
+				writeBody(w, r, success.Status, body)
 			}
 		})
 	}
 }
 
-// This function verifies a JWT token and it will be used by many handlers
-func VerifyJwtToken(tokenString string) (jwt.MapClaims, error) {
+// writeError sends the error body tagged with the request id. Server errors are logged with the id,
+// so the log lines can be found from what the client reports. Errors with a cause are answered with
+// what it means (see ResolveError).
+func writeError(w http.ResponseWriter, r *http.Request, err *apperrors.Error) {
+	err = ResolveError(err)
+	message := err.Message
+	message.RequestID = RequestID(r.Context())
+	if err.Status >= http.StatusInternalServerError {
+		log.Printf("[APIHandler:writeError] %s %s answered %d %s (request %s)", r.Method, r.URL.Path, err.Status, message.Code, message.RequestID)
+		if err.Cause != nil {
+			log.Printf("[APIHandler:writeError] Cause of request %s: %v", message.RequestID, err.Cause)
+		}
+	}
+
+	if p, ok := asProblem(r, err.Status, &message); ok {
+		writeProblem(w, r, p)
+		return
+	}
+	writeBody(w, r, err.Status, wrapInEnvelope(r, nil, &message))
+}
+
+// This function verifies a token of the configured format and it will be used by many handlers.
+// Encrypted JWTs are decrypted first, plain ones are still accepted so enabling the encryption
+// doesn't invalidate the tokens already handed out. Expiry and not-before times are checked with
+// the leeway of cfg.
+func VerifyJwtToken(tokenString string, cfg config.JWT) (jwt.MapClaims, error) {
+	if cfg.Format == "paseto" {
+		return verifyPasetoToken(tokenString, cfg.Keys.PasetoKey(), cfg.Leeway)
+	}
+	if jwe.IsEncrypted(tokenString) {
+		key := cfg.Keys.EncryptionKey()
+		if len(key) == 0 {
+			return nil, errors.New("encrypted token while JWT_ENCRYPTION_KEY is not set")
+		}
+		decrypted, err := jwe.Decrypt(tokenString, key)
+		if err != nil {
+			log.Printf("[APIHandler:VerifyJwtToken] Error decrypting JWT token: %v", err)
+			return nil, err
+		}
+		tokenString = decrypted
+	}
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+		// Signed with any of the secrets, while JWT_SECRET is rotated
+		return verificationKeys(cfg.Keys.Secrets(), nil), nil
+	}, jwt.WithLeeway(cfg.Leeway))
 	if err != nil {
 		log.Printf("[APIHandler:VerifyJwtToken] Error verifying JWT token: %v", err)
 		return nil, err
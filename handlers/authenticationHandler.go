@@ -1,72 +1,165 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"log"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/hi-im-yan/jwt-with-go/captcha"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/lockout"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/servertiming"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthenticationHandler struct {
-	DB *pgxpool.Pool
+	Config   *config.Config
+	DB       *pgxpool.Pool
+	Accounts repository.AccountRepository
+	Verifier CredentialVerifier
+	// UserChanged is called for the users created or changed by these routes, set by the server
+	UserChanged UserChanged
+	// Events receives the registrations and logins, set by the server
+	Events *events.Bus
+	// Lockout locks out the accounts and IPs with too many failed logins, set by the server
+	Lockout *lockout.Guard
+	// Captcha checks the CAPTCHA tokens of registrations and logins, set by the server. Nil turns it off
+	Captcha *captcha.Verifier
+	// ipLimiter throttles the unauthenticated routes per client IP, accountLimiter the logins
+	// per email so credential stuffing spread over many IPs is slowed down too
+	ipLimiter      *RateLimiter
+	accountLimiter *RateLimiter
 }
 
-func NewAuthenticationHandler(db *pgxpool.Pool) *AuthenticationHandler {
-	return &AuthenticationHandler{DB: db}
+func NewAuthenticationHandler(cfg *config.Config, db *pgxpool.Pool, accounts repository.AccountRepository, verifier CredentialVerifier) *AuthenticationHandler {
+	return &AuthenticationHandler{
+		Config:         cfg,
+		DB:             db,
+		Accounts:       accounts,
+		Verifier:       verifier,
+		ipLimiter:      NewRateLimiter(cfg.AuthRateLimitRPS, cfg.AuthRateLimitBurst),
+		accountLimiter: NewRateLimiter(cfg.LoginAccountRateLimitRPS, cfg.LoginAccountRateLimitBurst),
+	}
 }
 
 type newAccountRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name       string `json:"name" validate:"required,max=100"`
+	Email      string `json:"email" validate:"required,email,max=100"`
+	Password   string `json:"password" validate:"required,max=72"`      // bcrypt ignores what comes after 72 bytes
+	DeviceName string `json:"device_name,omitempty" validate:"max=100"` // optional, shown in the session listing
+	// Token of the solved CAPTCHA, required when CAPTCHA_PROVIDER is set
+	CaptchaToken string `json:"captcha_token,omitempty"`
 }
 
 type loginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	DeviceName string `json:"device_name,omitempty" validate:"max=100"` // optional, shown in the session listing
+	// Token of the solved CAPTCHA, required after CAPTCHA_LOGIN_AFTER_FAILURES failed logins
+	CaptchaToken string `json:"captcha_token,omitempty"`
 }
 
 type authResponse struct {
 	Message string `json:"message"`
-	Token   string `json:"token"`
+	// Left out when a retry with the Idempotency-Key of a registration is replayed
+	Token string `json:"token,omitempty"`
+	// Renews the session with POST /auth/refresh once the token expired
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+func newAuthResponse(message string, tokens Tokens) *authResponse {
+	return &authResponse{Message: message, Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}
+}
+
+// idempotentAnswer keeps the tokens out of the idempotency_keys table: a retry of the registration
+// gets the message only, and logs in to open a session
+func (ar *authResponse) idempotentAnswer() interface{} {
+	return &authResponse{Message: ar.Message + ". Login to get a token"}
+}
+
+// SetRateLimits applies the auth rate limits of cfg, on a configuration reload
+func (ah *AuthenticationHandler) SetRateLimits(cfg *config.Config) {
+	ah.ipLimiter.SetLimit(cfg.AuthRateLimitRPS, cfg.AuthRateLimitBurst)
+	ah.accountLimiter.SetLimit(cfg.LoginAccountRateLimitRPS, cfg.LoginAccountRateLimitBurst)
 }
 
 func (ah *AuthenticationHandler) AuthRouter() http.Handler {
 	r := chi.NewRouter()
 
-	r.HandleFunc("POST /register", ApiHandlerAdapter(ah.RegisterNewAccount))
-	r.HandleFunc("POST /login", ApiHandlerAdapter(ah.Login))
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(RateLimitMiddleware(ah.ipLimiter)))
+
+		r.HandleFunc("POST /register", ApiHandlerAdapter(IdempotencyMiddleware(ah.DB, ah.Config.IdempotencyKeyTTL)(ah.RegisterNewAccount)))
+		r.HandleFunc("POST /login", ApiHandlerAdapter(ah.Login))
+		r.HandleFunc("POST /refresh", ApiHandlerAdapter(Handle(http.StatusOK, ah.refresh)))
+		r.HandleFunc("POST /email-confirmation", ApiHandlerAdapter(Handle(http.StatusOK, ah.confirmEmailChange)))
+		r.HandleFunc("POST /invites/accept", ApiHandlerAdapter(Handle(http.StatusCreated, ah.acceptInvite)))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(MiddlewareAdapter(JWTAuthMiddleware(ah.DB, ah.Config.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(ah.DB, ah.Config.ProfileExemptRoutes)))
+
+		r.HandleFunc("GET /sessions", ApiHandlerAdapter(ah.listSessions))
+		r.HandleFunc("DELETE /sessions/{id}", ApiHandlerAdapter(ah.revokeSession))
+	})
 	return r
 }
 
-// This function creates a JWT token with the given username and role
-func (ah *AuthenticationHandler) CreateJwtToken(username string, role string) (string, error) {
+// This function opens a new session for the user, recording the device of the request,
+// and creates a JWT token bound to it with the refresh token of the session
+func (ah *AuthenticationHandler) CreateJwtToken(r *http.Request, u *user, deviceName string) (Tokens, error) {
+	return ah.createJwtToken(r.Context(), ah.Accounts.OpenSession, ClientOf(r), u, deviceName)
+}
+
+// createJwtToken is CreateJwtToken with the session stored by open, which can store it in the
+// transaction creating the user so the account and its first session are stored together
+func (ah *AuthenticationHandler) createJwtToken(ctx context.Context, open repository.SessionOpener, client Client, u *user, deviceName string) (Tokens, error) {
+	s, refreshToken, err := newSession(client, u.ID, deviceName, ah.Config.JWT.RefreshTokenTTL)
+	var sessionID int64
+	if err == nil {
+		sessionID, err = open(ctx, s)
+	}
+	if err != nil {
+		log.Printf("[APIHandler:CreateJwtToken] Error creating session: %v", err)
+		return Tokens{}, err
+	}
+
+	tokenString, err := ah.sessionToken(u, sessionID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{AccessToken: tokenString, RefreshToken: refreshToken}, nil
+}
+
+// sessionToken issues an access token of session sessionID for u
+func (ah *AuthenticationHandler) sessionToken(u *user, sessionID int64) (string, error) {
 	claims := jwt.MapClaims{
-		"username": username,
-		"role":     role,
-		"exp":      time.Now().Add(time.Minute * 15).Unix(),
+		"sub":      strconv.Itoa(u.ID),
+		"sid":      sessionID,
+		"username": u.Name,
+		"roles":    u.Roles,
+		"exp":      time.Now().Add(ah.Config.JWT.AccessTokenTTL).Unix(),
 	}
 	log.Printf("[APIHandler:CreateJwtToken] Creating JWT token with claims %v", claims)
-	// Create a new token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with a secret key
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	tokenString, err := issueToken(claims, ah.Config.JWT)
 	if err != nil {
 		log.Printf("[APIHandler:CreateJwtToken] Error creating JWT token: %v", err)
 		return "", err
 	}
 
 	log.Printf("[APIHandler:CreateJwtToken] Successfully created JWT token")
+	metrics.ObserveTokenIssued()
 	return tokenString, nil
 }
 
@@ -77,92 +170,86 @@ func (ah *AuthenticationHandler) CreateJwtToken(username string, role string) (s
 // @Accept       json
 // @Produce      json
 // @Param        user  body      newAccountRequest  true  "New Account Info"
+// @Param        Idempotency-Key header string false "Retries with the same key replay the first answer, without its tokens"
 // @Success      201   {object}  authResponse
-// @Failure      400   {object}  ErrorResponse "Invalid request body"
-// @Failure      409   {object}  ErrorResponse "Email already in use"
-// @Failure      500   {object}  ErrorResponse "Internal server error"
+// @Failure      400   {object}  apperrors.Response "Invalid request body"
+// @Failure      403   {object}  apperrors.Response "Registration is invite only"
+// @Failure      409   {object}  apperrors.Response "Email already in use"
+// @Failure      422   {object}  apperrors.Response "Idempotency-Key reused with another body"
+// @Failure      500   {object}  apperrors.Response "Internal server error"
 // @Router       /register [post]
-func (ah *AuthenticationHandler) RegisterNewAccount(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (ah *AuthenticationHandler) RegisterNewAccount(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[AuthenticationHandler:registerNewAccount] start")
 
+	if !registrationOpen(ah.Config) {
+		return nil, apperrors.Forbidden("Registration is by invitation only")
+	}
+
 	defer r.Body.Close()
 
-	// parse request to userRequest struct
+	// parse and validate request
 	var newAccountReq newAccountRequest
-	err := json.NewDecoder(r.Body).Decode(&newAccountReq)
-
-	// Could not parse json to request
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "Not a valid JSON"},
-		}
+	if herr := decodeRequest(r, &newAccountReq); herr != nil {
+		return nil, herr
 	}
 
 	log.Printf("[AuthenticationHandler:registerNewAccount] Request body received with {name: %s, email: %s}", newAccountReq.Name, newAccountReq.Email)
 
-	// validate request body
-	if newAccountReq.Email == "" || newAccountReq.Password == "" || newAccountReq.Name == "" {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "email, name and password are required"},
+	if ah.Captcha != nil && ah.Config.Captcha.Register {
+		if herr := ah.checkCaptcha(r.Context(), ClientOf(r), newAccountReq.CaptchaToken); herr != nil {
+			return nil, herr
 		}
 	}
 
+	// the email may still be held by a deleted account, depending on EMAIL_REUSE_POLICY
+	blocked, err := emailBlockedByDeletedAccount(r.Context(), ah.DB, ah.Config.EmailReusePolicy, ah.Config.PIIEncryptionKey, newAccountReq.Email)
+	if err != nil {
+		log.Printf("[AuthenticationHandler:registerNewAccount] Error checking email reuse policy: %v", err)
+		return nil, apperrors.Internal()
+	}
+	if blocked {
+		return nil, apperrors.Conflict("Email belonged to a deleted account and can't be reused. Please use a different email.")
+	}
+
+	stopTiming := servertiming.Track(r.Context(), "bcrypt")
 	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(newAccountReq.Password), bcrypt.DefaultCost)
+	stopTiming()
 	if err != nil {
 		log.Printf("[AuthenticationHandler:login] Error hashing password: %v", err)
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
+		return nil, apperrors.Internal()
 	}
-
 	log.Printf("[AuthenticationHandler:registerNewAccount] Inserting new user with {name: %s} and {email: %s}", newAccountReq.Name, newAccountReq.Email)
 
-	// insert user
-	query := `INSERT INTO users (name, email, password, role) VALUES ($1, $2, $3, 'user') RETURNING id, name, email, role;`
-	insertedAccount := &user{}
-	err = ah.DB.QueryRow(r.Context(), query, newAccountReq.Name, newAccountReq.Email, encryptedPassword).Scan(&insertedAccount.ID, &insertedAccount.Name, &insertedAccount.Email, &insertedAccount.Role)
+	// the user and its first session are stored together, so a failed session doesn't leave an
+	// account the client never got a token for (and can't register again)
+	s, refreshToken, err := newSession(ClientOf(r), 0, newAccountReq.DeviceName, ah.Config.JWT.RefreshTokenTTL)
+	var inserted *repository.User
+	var sessionID int64
+	if err == nil {
+		inserted, sessionID, err = ah.Accounts.Register(r.Context(), newAccountReq.Name, newAccountReq.Email, string(encryptedPassword), s)
+	}
 	if err != nil {
-		log.Printf("[AuthenticationHandler:registerNewAccount] Error inserting user: %v", err)
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == "23505" { // Unique constraint violation (email already exists)
-				return nil, &HandlerError{
-					Status: http.StatusConflict,
-					Message: ErrorResponse{
-						Code:    "E409",
-						Message: "Conflict",
-						Detail:  "Email is already in use. Please use a different email.",
-					},
-				}
-			}
-		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
+		log.Printf("[AuthenticationHandler:registerNewAccount] Error creating account: %v", err)
+		return nil, apperrors.FromError(err)
 	}
-
+	insertedAccount := userFromRecord(inserted)
 	log.Printf("[AuthenticationHandler:registerNewAccount] User inserted: %+v", insertedAccount)
 
-	token, err := ah.CreateJwtToken(insertedAccount.Name, insertedAccount.Role)
-
+	accessToken, err := ah.sessionToken(insertedAccount, sessionID)
 	if err != nil {
-		log.Printf("[AuthenticationHandler:registerNewAccount] Error creating JWT token: %v", err)
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
+		return nil, apperrors.Internal()
 	}
+	tokens := Tokens{AccessToken: accessToken, RefreshToken: refreshToken}
+	ah.UserChanged.notify(r.Context(), insertedAccount.ID)
+	ah.Events.Publish(r.Context(), events.UserCreated, insertedAccount.event())
+	metrics.ObserveRegistration(metrics.RegistrationPassword)
 
 	log.Printf("[AuthenticationHandler:registerNewAccount] end in %s", time.Since(start))
 
 	return &HandlerSuccess{
 		Status: http.StatusCreated,
-		Data:   &authResponse{Message: "Account created successfully", Token: token},
+		Data:   newAuthResponse("Account created successfully", tokens),
 	}, nil
 }
 
@@ -174,92 +261,139 @@ func (ah *AuthenticationHandler) RegisterNewAccount(w http.ResponseWriter, r *ht
 // @Produce      json
 // @Param        credentials  body      loginRequest  true  "User Credentials"
 // @Success      200          {object}  authResponse
-// @Failure      400          {object}  ErrorResponse "Invalid request body"
-// @Failure      401          {object}  ErrorResponse "Invalid email or password"
-// @Failure      500          {object}  ErrorResponse "Internal server error"
+// @Failure      400          {object}  apperrors.Response "Invalid request body"
+// @Failure      401          {object}  apperrors.Response "Invalid email or password"
+// @Failure      429          {object}  apperrors.Response "Too many attempts"
+// @Failure      500          {object}  apperrors.Response "Internal server error"
 // @Router       /login [post]
-func (ah *AuthenticationHandler) Login(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (ah *AuthenticationHandler) Login(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[AuthenticationHandler:login] start")
 
 	defer r.Body.Close()
 
-	// parse request to userRequest struct
+	// parse and validate request
 	var loginReq loginRequest
-	err := json.NewDecoder(r.Body).Decode(&loginReq)
+	if herr := decodeRequest(r, &loginReq); herr != nil {
+		return nil, herr
+	}
 
-	// Could not parse json to request
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "Not a valid JSON"},
+	log.Printf("[AuthenticationHandler:login] Request body received for login: %s", loginReq.Email)
+
+	tokens, herr := ah.PasswordLogin(r.Context(), ClientOf(r), loginReq.Email, loginReq.Password, loginReq.DeviceName, loginReq.CaptchaToken)
+	if herr != nil {
+		if herr.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", ah.loginRetryAfter(r.Context(), ClientOf(r), loginReq.Email))
 		}
+		return nil, herr
 	}
 
-	log.Printf("[AuthenticationHandler:login] Request body received for login: %s", loginReq.Email)
+	log.Printf("[AuthenticationHandler:login] end in %s", time.Since(start))
+
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   newAuthResponse("Login successful", tokens),
+	}, nil
+}
 
-	// validate request body
-	if loginReq.Email == "" || loginReq.Password == "" {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "email and password are required"},
+// PasswordLogin checks the CAPTCHA token, when the login needs one, and the credentials and opens a
+// session for client, returning its tokens. It is shared by POST /auth/login, the gRPC AuthService, the
+// GraphQL login mutation and the admin dashboard.
+func (ah *AuthenticationHandler) PasswordLogin(ctx context.Context, client Client, email, password, deviceName, captchaToken string) (Tokens, *apperrors.Error) {
+	if herr := validateRequest(&loginRequest{Email: email, Password: password, DeviceName: deviceName}); herr != nil {
+		return Tokens{}, herr
+	}
+
+	if ah.loginNeedsCaptcha(ctx, client, email) {
+		if herr := ah.checkCaptcha(ctx, client, captchaToken); herr != nil {
+			return Tokens{}, herr
 		}
 	}
 
-	log.Printf("[AuthenticationHandler:login] Validating user with {email: %s}", loginReq.Email)
+	account := strings.ToLower(email)
+	if !ah.accountLimiter.Allow(account) {
+		log.Printf("[AuthenticationHandler:PasswordLogin] Too many login attempts for {email: %s}", email)
+		return Tokens{}, apperrors.TooManyRequests("Too many login attempts for this account. Try again later")
+	}
+	if ah.Lockout != nil && ah.Lockout.Locked(ctx, account, client.IP) > 0 {
+		log.Printf("[AuthenticationHandler:PasswordLogin] Login locked out for {email: %s, ip: %s}", email, client.IP)
+		return Tokens{}, apperrors.TooManyRequests("Too many failed logins. Try again later")
+	}
+
+	log.Printf("[AuthenticationHandler:PasswordLogin] Validating user with {email: %s}", email)
 
 	// validate user
-	query := `SELECT id, name, email, role, password FROM users WHERE email = $1`
-	user := &user{}
-	var hashedPassword string
-	err = ah.DB.QueryRow(r.Context(), query, loginReq.Email).Scan(&user.ID, &user.Name, &user.Email, &user.Role, &hashedPassword)
+	user, err := ah.Verifier.Verify(ctx, email, password)
 	if err != nil {
-		log.Printf("[AuthenticationHandler:login] Error validating user: %v", err)
-		if err == pgx.ErrNoRows {
-			return nil, &HandlerError{
-				Status: http.StatusUnauthorized,
-				Message: ErrorResponse{
-					Code:    "E401",
-					Message: "Unauthorized",
-					Detail:  "Invalid email or password",
-				},
+		log.Printf("[AuthenticationHandler:PasswordLogin] Error validating user: %v", err)
+		if errors.Is(err, errInvalidCredentials) {
+			metrics.ObserveLogin(metrics.LoginFailure)
+			ah.Events.Publish(ctx, events.LoginFailed, events.Login{Email: email, IP: client.IP})
+			if ah.Lockout != nil {
+				if d := ah.Lockout.Fail(ctx, account, client.IP); d > 0 {
+					metrics.ObserveLockout()
+					ah.Events.Publish(ctx, events.LoginLocked, events.Lockout{Email: email, IP: client.IP, Until: time.Now().Add(d).UTC()})
+				}
 			}
+			return Tokens{}, apperrors.Unauthorized("Invalid email or password")
 		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
+		if errors.Is(err, errExternalAccountConflict) {
+			metrics.ObserveLogin(metrics.LoginFailure)
+			return Tokens{}, apperrors.Conflict("An account already uses this email. Sign in with its password")
 		}
+		metrics.ObserveLogin(metrics.LoginError)
+		return Tokens{}, apperrors.Internal()
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(loginReq.Password))
+	log.Printf("[AuthenticationHandler:PasswordLogin] User validated: %+v", user)
+
+	tokens, err := ah.createJwtToken(ctx, ah.Accounts.OpenSession, client, user, deviceName)
 	if err != nil {
-		log.Printf("[AuthenticationHandler:login] Error validating user: %v", err)
-		return nil, &HandlerError{
-			Status: http.StatusUnauthorized,
-			Message: ErrorResponse{
-				Code:    "E401",
-				Message: "Unauthorized",
-				Detail:  "Invalid email or password",
-			},
-		}
+		log.Printf("[AuthenticationHandler:PasswordLogin] Error creating JWT token: %v", err)
+		metrics.ObserveLogin(metrics.LoginError)
+		return Tokens{}, apperrors.Internal()
 	}
 
-	log.Printf("[AuthenticationHandler:login] User validated: %+v", user)
-
-	token, err := ah.CreateJwtToken(user.Name, user.Role)
+	if ah.Lockout != nil {
+		ah.Lockout.Succeed(ctx, account)
+	}
+	metrics.ObserveLogin(metrics.LoginSuccess)
+	ah.Events.Publish(ctx, events.LoginSucceeded, events.Login{UserID: user.ID, Email: user.Email, IP: client.IP})
+	return tokens, nil
+}
 
-	if err != nil {
-		log.Printf("[AuthenticationHandler:login] Error creating JWT token: %v", err)
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
+// loginRetryAfter is the Retry-After of a refused login: what is left of the lock when locked out,
+// the delay of the account rate limit otherwise
+func (ah *AuthenticationHandler) loginRetryAfter(ctx context.Context, client Client, email string) string {
+	if ah.Lockout != nil {
+		if d := ah.Lockout.Locked(ctx, strings.ToLower(email), client.IP); d > 0 {
+			return strconv.Itoa(int(math.Ceil(d.Seconds())))
 		}
 	}
+	return ah.accountLimiter.RetryAfter()
+}
 
-	log.Printf("[AuthenticationHandler:login] end in %s", time.Since(start))
+// loginNeedsCaptcha tells if a login of email from client must carry a solved CAPTCHA: always, or once
+// the account or the IP failed CAPTCHA_LOGIN_AFTER_FAILURES times within the lockout window
+func (ah *AuthenticationHandler) loginNeedsCaptcha(ctx context.Context, client Client, email string) bool {
+	after := ah.Config.Captcha.LoginAfterFailures
+	if ah.Captcha == nil || after < 0 {
+		return false
+	}
+	return after == 0 || (ah.Lockout != nil && ah.Lockout.Failures(ctx, strings.ToLower(email), client.IP) >= after)
+}
 
-	return &HandlerSuccess{
-		Status: http.StatusOK,
-		Data:   &authResponse{Message: "Login successful", Token: token},
-	}, nil
+// checkCaptcha verifies the CAPTCHA token sent by client. A provider that can't be reached refuses the
+// request rather than letting it through unchecked.
+func (ah *AuthenticationHandler) checkCaptcha(ctx context.Context, client Client, token string) *apperrors.Error {
+	err := ah.Captcha.Verify(ctx, token, client.IP)
+	if errors.Is(err, captcha.ErrFailed) {
+		log.Printf("[AuthenticationHandler:checkCaptcha] CAPTCHA refused for {ip: %s}: %v", client.IP, err)
+		return apperrors.CaptchaRequired("Solve the CAPTCHA and send its token as captcha_token")
+	}
+	if err != nil {
+		log.Printf("[AuthenticationHandler:checkCaptcha] Error verifying CAPTCHA: %v", err)
+		return apperrors.Internal()
+	}
+	return nil
 }
@@ -0,0 +1,223 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/repository/mocks"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+// authBody is the JSON answered by the registration and the logins
+type authBody struct {
+	Message      string `json:"message"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newAuthHandler returns an AuthenticationHandler storing accounts in accounts and checking the
+// passwords with verify, without database like newUserHandler
+func newAuthHandler(accounts *mocks.AccountRepository, verify handlers.VerifierFunc) *handlers.AuthenticationHandler {
+	cfg := &config.Config{EmailReusePolicy: handlers.EmailReuseImmediate}
+	cfg.JWT.Keys = config.NewKeys(testutil.Secret, nil, nil)
+	cfg.JWT.AccessTokenTTL = 15 * time.Minute
+	cfg.JWT.RefreshTokenTTL = 24 * time.Hour
+	return handlers.NewAuthenticationHandler(cfg, nil, accounts, verify)
+}
+
+// openSession is the OpenSessionFunc of a repository opening sessions of alice only
+func openSession(ctx context.Context, s *repository.Session) (int64, error) {
+	if s.UserID != alice.ID || s.RefreshTokenHash == "" {
+		return 0, errors.New("unexpected session")
+	}
+	return 30, nil
+}
+
+func TestRegisterNewAccount(t *testing.T) {
+	register := func(ctx context.Context, name, email, passwordHash string, s *repository.Session) (*repository.User, int64, error) {
+		if email == "taken@example.com" {
+			return nil, 0, &repository.Error{Kind: repository.ErrConflict, Constraint: "users_email_lookup_active_key"}
+		}
+		if passwordHash == "" || s.RefreshTokenHash == "" {
+			return nil, 0, errors.New("password or session missing")
+		}
+		return &repository.User{ID: 3, Name: name, Email: email, Roles: []string{"user"}}, 40, nil
+	}
+
+	tests := []struct {
+		name       string
+		body       interface{}
+		inviteOnly bool
+		status     int
+		code       string
+		field      string
+		calls      []string
+	}{
+		{
+			name:   "registered",
+			body:   map[string]string{"name": "Bob", "email": "bob@example.com", "password": "s3cret-pass"},
+			status: http.StatusCreated,
+			calls:  []string{"Register"},
+		},
+		{name: "missing name", body: map[string]string{"email": "bob@example.com", "password": "s3cret-pass"}, status: http.StatusBadRequest, code: "E400", field: "name"},
+		{name: "invalid email", body: map[string]string{"name": "Bob", "email": "bob", "password": "s3cret-pass"}, status: http.StatusBadRequest, code: "E400", field: "email"},
+		{
+			name:   "email taken",
+			body:   map[string]string{"name": "Bob", "email": "taken@example.com", "password": "s3cret-pass"},
+			status: http.StatusConflict,
+			code:   "E409",
+			calls:  []string{"Register"},
+		},
+		{
+			name:       "invite only",
+			body:       map[string]string{"name": "Bob", "email": "bob@example.com", "password": "s3cret-pass"},
+			inviteOnly: true,
+			status:     http.StatusForbidden,
+			code:       "E403",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := &mocks.AccountRepository{RegisterFunc: register}
+			ah := newAuthHandler(accounts, nil)
+			if tt.inviteOnly {
+				ah.Config.RegistrationMode = "invite_only"
+			}
+			r := testutil.NewRequest(t, http.MethodPost, "/register", tt.body)
+
+			rec := testutil.Serve(ah.RegisterNewAccount, r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got authBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.Token == "" || got.RefreshToken == "" {
+					t.Errorf("answer = %+v, want the tokens of the first session", got)
+				}
+			}
+			assertCalls(t, accounts, tt.calls)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	verify := func(ctx context.Context, email, password string) (*repository.User, error) {
+		switch {
+		case email == "external@example.com":
+			return nil, handlers.ErrExternalAccountConflict
+		case email != alice.Email || password != "s3cret-pass":
+			return nil, handlers.ErrInvalidCredentials
+		}
+		u := alice
+		return &u, nil
+	}
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+		code   string
+		field  string
+		calls  []string
+	}{
+		{name: "logged in", body: map[string]string{"email": alice.Email, "password": "s3cret-pass"}, status: http.StatusOK, calls: []string{"OpenSession"}},
+		{name: "missing password", body: map[string]string{"email": alice.Email}, status: http.StatusBadRequest, code: "E400", field: "password"},
+		{name: "wrong password", body: map[string]string{"email": alice.Email, "password": "guess"}, status: http.StatusUnauthorized, code: "E401"},
+		{name: "external account", body: map[string]string{"email": "external@example.com", "password": "s3cret-pass"}, status: http.StatusConflict, code: "E409"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := &mocks.AccountRepository{OpenSessionFunc: openSession}
+			r := testutil.NewRequest(t, http.MethodPost, "/login", tt.body)
+
+			rec := testutil.Serve(newAuthHandler(accounts, verify).Login, r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got authBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.Token == "" || got.RefreshToken == "" {
+					t.Errorf("answer = %+v, want the tokens of the session", got)
+				}
+			}
+			assertCalls(t, accounts, tt.calls)
+		})
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	accounts := &mocks.AccountRepository{
+		SessionsFunc: func(ctx context.Context, userID int) ([]repository.Session, error) {
+			return []repository.Session{{ID: 11, UserID: userID}, {ID: admin.SessionID, UserID: userID}}, nil
+		},
+	}
+	r := adminRequest(t, http.MethodGet, "/auth/sessions", nil)
+
+	rec := testutil.Serve(newAuthHandler(accounts, nil).ListSessionsRoute(), r)
+
+	testutil.AssertStatus(t, rec, http.StatusOK)
+	var got []struct {
+		ID      int64 `json:"id"`
+		Current bool  `json:"current"`
+	}
+	testutil.DecodeJSON(t, rec, &got)
+	if len(got) != 2 || got[0].Current || !got[1].Current {
+		t.Errorf("sessions = %+v, want session %d only marked as current", got, admin.SessionID)
+	}
+	assertCalls(t, accounts, []string{"Sessions"})
+}
+
+func TestRevokeSession(t *testing.T) {
+	revoke := func(ctx context.Context, userID int, id int64) error {
+		if userID != admin.ID || id != 11 {
+			return repository.ErrNotFound
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "revoked", id: "11", status: http.StatusNoContent, calls: []string{"RevokeSession"}},
+		{name: "id not a number", id: "abc", status: http.StatusBadRequest, code: "E400"},
+		{name: "not found", id: "12", status: http.StatusNotFound, code: "E404", calls: []string{"RevokeSession"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := &mocks.AccountRepository{RevokeSessionFunc: revoke}
+			r := adminRequest(t, http.MethodDelete, "/auth/sessions/"+tt.id, nil, "id", tt.id)
+
+			rec := testutil.Serve(newAuthHandler(accounts, nil).RevokeSessionRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, accounts, tt.calls)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// Largest accepted upload, before resizing
+	maxAvatarBytes = 5 << 20
+	// Larger images are refused before decoding so a small file can't expand into a huge bitmap
+	maxAvatarSourcePixels = 4096 * 4096
+	// Stored avatars fit in avatarSize x avatarSize
+	avatarSize = 256
+)
+
+var errInvalidAvatar = errors.New("invalid avatar")
+
+// @Summary      Upload avatar
+// @Description  Uploads a PNG, JPEG or GIF avatar (5MB max) in the "avatar" multipart field. The image is resized to fit 256x256 and stored as PNG (only self or users with users:update)
+// @Tags         users
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Param        avatar formData file true "Avatar image"
+// @Success      200 {object} user
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      413 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/{id}/avatar [put]
+func (uh *UserHandler) uploadAvatar(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[UserHandler:uploadAvatar] start")
+
+	// OwnerOrAdminMiddleware already validated the id
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+1<<10)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, apperrors.PayloadTooLarge("Avatar must be at most 5MB")
+		}
+		return nil, apperrors.BadRequest("Multipart field 'avatar' is required")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err == nil {
+		data, err = resizeAvatar(data)
+	}
+	if err != nil {
+		log.Printf("[UserHandler:uploadAvatar] Rejected avatar of user %d: %v", id, err)
+		return nil, apperrors.BadRequest("Avatar must be a PNG, JPEG or GIF image of at most 4096x4096 pixels")
+	}
+
+	exists, err := uh.users.Exists(r.Context(), id)
+	if err == nil && !exists {
+		return nil, apperrors.NotFound("User with id " + strconv.Itoa(id) + " not found")
+	}
+
+	// The key is stable so a new upload replaces the previous file, the version busts caches.
+	// Files without a public URL are downloaded through the signed links of GET /users/{id}/avatar/link.
+	var url string
+	if err == nil {
+		url, err = uh.avatars.Put(r.Context(), fmt.Sprintf("avatars/%d.png", id), "image/png", data)
+	}
+	if err != nil {
+		log.Printf("[UserHandler:uploadAvatar] Error storing avatar of user %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	if url == "" {
+		url = uh.cfg.PublicBaseURL + "/users/" + strconv.Itoa(id) + "/avatar/link"
+	} else {
+		url += "?v=" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	log.Printf("[UserHandler:uploadAvatar] Saving avatar url of user %d", id)
+	actorID, _ := r.Context().Value(ContextUserIDKey).(int)
+	updated, err := uh.users.UpdateAvatar(r.Context(), actorID, id, url)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, apperrors.NotFound("User with id " + strconv.Itoa(id) + " not found")
+		}
+		log.Printf("[UserHandler:uploadAvatar] Error saving avatar url: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	updatedUser := userFromRecord(updated)
+	uh.Events.Publish(r.Context(), events.UserUpdated, updatedUser.event())
+
+	log.Printf("[UserHandler:uploadAvatar] end. Took %v", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   updatedUser,
+	}, nil
+}
+
+// resizeAvatar checks data is a PNG, JPEG or GIF image and returns it as a PNG fitting in avatarSize x avatarSize
+func resizeAvatar(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxAvatarSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", errInvalidAvatar, cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the aspect ratio, never upscale
+	width, height := cfg.Width, cfg.Height
+	if width > avatarSize || height > avatarSize {
+		if width >= height {
+			width, height = avatarSize, max(1, height*avatarSize/width)
+		} else {
+			width, height = max(1, width*avatarSize/height), avatarSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
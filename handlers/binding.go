@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// Handler inputs can take more than the JSON body: a field tagged `path:"id"` gets the chi URL parameter
+// id and one tagged `query:"status"` the query parameter status, converted to the type of the field.
+// The fields without those tags come from the body, which is only read when there is one such field.
+//
+//	type emailRequest struct {
+//		ID     int64    `path:"id"`
+//		Status *string  `query:"status"`  // nil when not in the query
+//		Tags   []string `query:"tag"`     // every value of a repeated parameter
+//		Note   string   `json:"note"`     // from the body
+//	}
+//
+// Every value that doesn't fit its field is reported at once, in a 400 with Response.Fields, the same
+// way as the validation rules.
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// bindRequest fills req, a pointer to a struct, from the path, the query and the body of r as its tags
+// say (see above)
+func bindRequest(r *http.Request, req interface{}) *apperrors.Error {
+	v := reflect.ValueOf(req).Elem()
+	if hasBodyFields(v.Type()) {
+		if herr := decodeBody(r, req); herr != nil {
+			return herr
+		}
+	}
+
+	problems := &bindingProblems{fields: map[string]string{}}
+	bindParams(r, v, problems)
+	if len(problems.details) > 0 {
+		return apperrors.InvalidParams(strings.Join(problems.details, "; "), problems.fields)
+	}
+	return nil
+}
+
+// bindingProblems collects the values that don't fit their field
+type bindingProblems struct {
+	details []string
+	fields  map[string]string
+}
+
+func (p *bindingProblems) add(detail, field, problem string) {
+	p.details = append(p.details, detail)
+	p.fields[field] = problem
+}
+
+// paramTag returns the source ("path" or "query") and the name of a parameter field, empty for a body field
+func paramTag(field reflect.StructField) (source, name string) {
+	if name, ok := field.Tag.Lookup("path"); ok {
+		return "path", name
+	}
+	if name, ok := field.Tag.Lookup("query"); ok {
+		return "query", name
+	}
+	return "", ""
+}
+
+func hasBodyFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if hasBodyFields(field.Type) {
+				return true
+			}
+			continue
+		}
+		if source, _ := paramTag(field); source == "" && field.IsExported() && field.Tag.Get("json") != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// bindParams sets the parameter fields of v, embedded structs included. They are reset first, the JSON
+// decoder matches field names case-insensitively and could have filled them from the body.
+func bindParams(r *http.Request, v reflect.Value, problems *bindingProblems) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindParams(r, v.Field(i), problems)
+			continue
+		}
+		source, name := paramTag(field)
+		if source == "" || !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		fv.SetZero()
+
+		var values []string
+		label := "Path parameter"
+		if source == "path" {
+			if value := chi.URLParam(r, name); value != "" {
+				values = []string{value}
+			}
+		} else {
+			label = "Query parameter"
+			values = r.URL.Query()[name]
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		if err := setParam(fv, values); err != nil {
+			problem := typeProblem(fv.Type())
+			problems.add(label+" '"+name+"' "+problem, name, problem)
+		}
+	}
+}
+
+// setParam converts values into v: every value for a slice, the last one otherwise
+func setParam(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && !v.Type().Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return setValue(v, values[len(values)-1])
+}
+
+func setValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		elem := reflect.New(v.Type().Elem())
+		if err := setValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return errors.New("unsupported field type " + v.Type().String())
+	}
+	return nil
+}
+
+// typeProblem describes what a value of type t must look like, it is prefixed with the field name
+func typeProblem(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer || (t.Kind() == reflect.Slice && !t.Implements(textUnmarshalerType)) {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return "must be an RFC 3339 date-time"
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "must be a duration, like 90s or 1h"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "must be true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "must be an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "must be a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "must be a number"
+	case reflect.String:
+		return "must be a string"
+	case reflect.Slice, reflect.Array:
+		return "must be an array"
+	case reflect.Map, reflect.Struct:
+		return "must be an object"
+	default:
+		return "is invalid"
+	}
+}
+
+// decodeBody parses the JSON body into req. A value of the wrong type is reported on its field.
+func decodeBody(r *http.Request, req interface{}) *apperrors.Error {
+	err := json.NewDecoder(r.Body).Decode(req)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		problem := typeProblem(typeErr.Type)
+		return apperrors.InvalidFields(typeErr.Field+" "+problem, map[string]string{typeErr.Field: problem})
+	}
+	if err != nil {
+		return apperrors.InvalidBody("Not a valid JSON")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// limitedBody remembers that the body went over the limit, whatever the handler did with the error
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// BodyLimitMiddleware stops reading request bodies after limit bytes. Handlers don't need to check:
+// ApiHandlerAdapter turns the error they answer with into a 413 once the limit was hit.
+// Multipart requests are skipped, the upload handlers set a limit fitting their files.
+func BodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+				r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyTooLarge tells if the request body went over the BodyLimitMiddleware limit
+func bodyTooLarge(r *http.Request) bool {
+	body, ok := r.Body.(*limitedBody)
+	return ok && body.exceeded
+}
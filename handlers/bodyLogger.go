@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Bodies are logged up to this size, bigger ones only by their size
+const maxLoggedBody = 64 << 10
+
+const redacted = "[REDACTED]"
+
+// Headers never written to the logs
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true, "Set-Cookie": true}
+
+// JSON keys whose value is redacted wherever they appear, matched by substring so that
+// "new_password", "refresh_token" and the like are covered too
+var redactedKeys = []string{"password", "token", "secret", "authorization"}
+
+// BodyLoggingMiddleware logs the headers and JSON bodies of each request and response for troubleshooting.
+// Passwords, tokens and secrets are redacted, and bodies that aren't JSON are only logged by size since
+// their content can't be checked. It is turned on with LOG_BODIES and must come before BodyLimitMiddleware,
+// so the body is logged as far as the handler read it.
+func BodyLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody := &cappedBuffer{}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+
+		respBody := &cappedBuffer{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(respBody)
+
+		next.ServeHTTP(ww, r)
+
+		id := RequestID(r.Context())
+		log.Printf("[Middleware:BodyLoggingMiddleware] request %s: %s %s headers=%s body=%s",
+			id, r.Method, r.URL.RequestURI(), redactHeaders(r.Header), redactBody(reqBody, r.Header.Get("Content-Type")))
+		log.Printf("[Middleware:BodyLoggingMiddleware] response %s: %d headers=%s body=%s",
+			id, ww.Status(), redactHeaders(ww.Header()), redactBody(respBody, ww.Header().Get("Content-Type")))
+	})
+}
+
+// cappedBuffer keeps the first maxLoggedBody bytes written to it and counts the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	total int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if room := maxLoggedBody - b.Buffer.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func redactHeaders(header http.Header) string {
+	shown := make(map[string]string, len(header))
+	for name, values := range header {
+		if redactedHeaders[name] {
+			shown[name] = redacted
+		} else {
+			shown[name] = strings.Join(values, ", ")
+		}
+	}
+	out, _ := json.Marshal(shown)
+	return string(out)
+}
+
+func redactBody(body *cappedBuffer, contentType string) string {
+	if body.total == 0 {
+		return "-"
+	}
+	var decoded interface{}
+	if body.total > maxLoggedBody || !strings.Contains(contentType, "json") || json.Unmarshal(body.Bytes(), &decoded) != nil {
+		return "(" + contentTypeOrUnknown(contentType) + ", " + strconv.Itoa(body.total) + " bytes not shown)"
+	}
+	out, _ := json.Marshal(redactValue(decoded))
+	return string(out)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if isSecretKey(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = redactValue(inner)
+		}
+	}
+	return value
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range redactedKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func contentTypeOrUnknown(contentType string) string {
+	if contentType == "" {
+		return "unknown content type"
+	}
+	return contentType
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/hi-im-yan/jwt-with-go/ldap"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/servertiming"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidCredentials = errors.New("invalid email or password")
+
+// CredentialVerifier checks the email and password sent to Login and returns the local user they belong to.
+// It must return errInvalidCredentials when the credentials are wrong, any other error is treated as a 500.
+type CredentialVerifier interface {
+	Verify(ctx context.Context, email, password string) (*user, error)
+}
+
+// LocalVerifier checks the password against the bcrypt hash in the users table
+type LocalVerifier struct {
+	DB *pgxpool.Pool
+	// Key of the encrypted emails (PII_ENCRYPTION_KEY), nil when they are in clear
+	PIIKey []byte
+}
+
+func (lv *LocalVerifier) Verify(ctx context.Context, email, password string) (*user, error) {
+	query := `SELECT u.id, u.name, ` + userRolesColumn + `, u.password FROM users u WHERE u.email_lookup = $1 AND u.deleted_at IS NULL`
+	// The lookup matched, so the email is the one sent
+	u := &user{Email: email}
+	var hashedPassword string
+	err := lv.DB.QueryRow(ctx, query, pii.Lookup(email, lv.PIIKey)).Scan(&u.ID, &u.Name, &u.Roles, &hashedPassword)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errInvalidCredentials
+		}
+		return nil, err
+	}
+
+	stopTiming := servertiming.Track(ctx, "bcrypt")
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	stopTiming()
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	return u, nil
+}
+
+// LDAPVerifier binds against a directory server instead of using the local password.
+// The first successful login provisions a local user row (without password) so the rest of the API
+// keeps working with local ids. The roles are re-mapped from the directory groups on every login.
+type LDAPVerifier struct {
+	DB               *pgxpool.Pool
+	LDAP             *ldap.Authenticator
+	AdminGroup       string // members of this group DN also get the admin role
+	EmailReusePolicy string
+	PIIKey           []byte
+	// UserChanged is called for the users refreshed from the directory
+	UserChanged UserChanged
+}
+
+func (lv *LDAPVerifier) Verify(ctx context.Context, email, password string) (*user, error) {
+	stopTiming := servertiming.Track(ctx, "ldap")
+	dirUser, err := lv.LDAP.Authenticate(email, password)
+	stopTiming()
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, err
+	}
+
+	roles := []string{rbac.RoleUser}
+	if lv.AdminGroup != "" && dirUser.MemberOf(lv.AdminGroup) {
+		roles = append(roles, rbac.RoleAdmin)
+	}
+	name := dirUser.Name
+	if name == "" {
+		name = dirUser.Email
+	}
+
+	log.Printf("[LDAPVerifier:Verify] Provisioning {email: %s} from %s with roles %v", dirUser.Email, dirUser.DN, roles)
+	identity := externalIdentity{Issuer: lv.LDAP.URL(), Subject: dirUser.DN}
+	return provisionExternalUser(ctx, lv.DB, lv.UserChanged, lv.EmailReusePolicy, lv.PIIKey, identity, name, dirUser.Email, roles)
+}
+
+// externalIdentity is who an external identity provider says a user is: the OIDC issuer and subject,
+// or the LDAP server and DN
+type externalIdentity struct {
+	Issuer  string
+	Subject string
+}
+
+// errExternalAccountConflict is an external identity whose email belongs to an account it doesn't own
+var errExternalAccountConflict = errors.New("the email belongs to an account of another identity")
+
+// provisionExternalUser creates (or refreshes) the local row of a user authenticated by an external identity
+// provider. Those users have no local password, so they can't login with the local backend.
+// The row is found by the identity, never by the email: an identity whose email belongs to any other
+// account, a local one (with a password) or one created for another identity or verifier, gets
+// errExternalAccountConflict.
+// The provider is authoritative for their roles: they are replaced by the mapped roles on every login.
+// A deleted account, or a deleted account whose email can't be reused yet, is rejected with errInvalidCredentials.
+func provisionExternalUser(ctx context.Context, db *pgxpool.Pool, changed UserChanged, reusePolicy string, piiKey []byte, identity externalIdentity, name, email string, roles []string) (*user, error) {
+	blocked, err := emailBlockedByDeletedAccount(ctx, db, reusePolicy, piiKey, email)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, lookup, err := encryptEmail(email, piiKey)
+	if err != nil {
+		return nil, err
+	}
+	u := &user{Email: email}
+	err = repository.WithTx(ctx, db, func(tx pgx.Tx) error {
+		var deleted bool
+		query := `SELECT u.id, u.deleted_at IS NOT NULL FROM external_identities i JOIN users u ON u.id = i.user_id
+			WHERE i.issuer = $1 AND i.subject = $2 FOR UPDATE OF i;`
+		err := tx.QueryRow(ctx, query, identity.Issuer, identity.Subject).Scan(&u.ID, &deleted)
+		switch {
+		case err == nil && deleted:
+			return errInvalidCredentials
+		case err == nil:
+			query = `UPDATE external_identities SET last_login_at = NOW() WHERE issuer = $1 AND subject = $2;`
+			if _, err := tx.Exec(ctx, query, identity.Issuer, identity.Subject); err != nil {
+				return err
+			}
+		case errors.Is(err, pgx.ErrNoRows):
+			if err := linkExternalIdentity(ctx, tx, identity, u, name, encrypted, lookup, blocked); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		if err := tx.QueryRow(ctx, `UPDATE users SET name = $2 WHERE id = $1 RETURNING name;`, u.ID, name).Scan(&u.Name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM user_roles WHERE user_id = $1;`, u.ID); err != nil {
+			return err
+		}
+		query = `INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = ANY($2);`
+		_, err = tx.Exec(ctx, query, u.ID, roles)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	changed.notify(ctx, u.ID)
+	u.Roles = roles
+	return u, nil
+}
+
+// linkExternalIdentity creates the account of an identity seen for the first time and sets u.ID
+func linkExternalIdentity(ctx context.Context, tx pgx.Tx, identity externalIdentity, u *user, name, encrypted, lookup string, blocked bool) error {
+	var taken bool
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email_lookup = $1 AND deleted_at IS NULL);`
+	if err := tx.QueryRow(ctx, query, lookup).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return errExternalAccountConflict
+	}
+	if blocked {
+		return errInvalidCredentials
+	}
+	query = `INSERT INTO users (name, email, email_lookup, password) VALUES ($1, $2, $3, '') RETURNING id;`
+	if err := tx.QueryRow(ctx, query, name, encrypted, lookup).Scan(&u.ID); err != nil {
+		return err
+	}
+
+	log.Printf("[CredentialVerifier:linkExternalIdentity] Identity %s of %s linked to user %d", identity.Subject, identity.Issuer, u.ID)
+	query = `INSERT INTO external_identities (issuer, subject, user_id) VALUES ($1, $2, $3);`
+	_, err := tx.Exec(ctx, query, identity.Issuer, identity.Subject, u.ID)
+	return err
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/captcha"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed templates/dashboard/*.html
+var dashboardTemplates embed.FS
+
+// Cookie holding the token of the dashboard session. Browsers can't send the Authorization header
+// on page loads, so the pages read the token from it and check it like JWTAuthMiddleware does.
+const dashboardCookie = "jwtapi_admin"
+
+// Rows shown by the users search and the audit log
+const dashboardPageSize = 50
+
+// DashboardHandler serves the server rendered admin pages under /admin/ui: searching users, changing
+// their roles and reading the audit log. Each page needs the same permission as the matching API route.
+type DashboardHandler struct {
+	cfg      *config.Config
+	db       *pgxpool.Pool
+	users    repository.UserRepository
+	auth     *AuthenticationHandler
+	admin    *AdminHandler
+	resolver *rbac.Resolver
+	pages    map[string]*template.Template
+}
+
+// Dashboard view models
+type dashboardPage struct {
+	Title    string
+	Username string
+	CSRF     string
+	Error    string
+	Data     interface{}
+}
+
+type dashboardUser struct {
+	ID        int
+	Name      string
+	Email     string
+	Roles     []string
+	CreatedAt time.Time
+}
+
+type dashboardUserPage struct {
+	User     *repository.User
+	AllRoles []string
+	History  []repository.HistoryEntry
+	// What the viewer may do on the page
+	CanAssign  bool
+	CanHistory bool
+}
+
+// dashboardCaptcha is the CAPTCHA widget of the login page
+type dashboardCaptcha struct {
+	captcha.Widget
+	SiteKey string
+}
+
+type dashboardAuditEntry struct {
+	repository.HistoryEntry
+	UserID int
+}
+
+func NewDashboardHandler(cfg *config.Config, db *pgxpool.Pool, users repository.UserRepository, auth *AuthenticationHandler, admin *AdminHandler) *DashboardHandler {
+	dh := &DashboardHandler{cfg: cfg, db: db, users: users, auth: auth, admin: admin, resolver: rbac.NewResolver(db), pages: map[string]*template.Template{}}
+	funcs := template.FuncMap{
+		"json": func(b []byte) string { return string(b) },
+		"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	}
+	for _, page := range []string{"login", "users", "user", "audit", "error"} {
+		dh.pages[page] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(dashboardTemplates, "templates/dashboard/layout.html", "templates/dashboard/"+page+".html"))
+	}
+	return dh
+}
+
+// Configuration of routes
+func (dh *DashboardHandler) DashboardRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(dh.headers)
+
+	// Routes
+	r.Get("/login", dh.loginPage)
+	r.Post("/login", dh.login)
+	r.Group(func(r chi.Router) {
+		r.Use(dh.requireSession)
+
+		r.Post("/logout", dh.logout)
+		r.Get("/", dh.usersPage)
+		r.Get("/users/{id}", dh.userPage)
+		r.Post("/users/{id}/roles", dh.changeRole)
+		r.Get("/audit", dh.auditPage)
+	})
+
+	return r
+}
+
+// headers keeps the pages from being framed or running scripts of another origin than the CAPTCHA provider
+func (dh *DashboardHandler) headers(next http.Handler) http.Handler {
+	csp := "default-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'none'"
+	if widget := dh.captchaWidget(); widget != nil {
+		csp = fmt.Sprintf("default-src 'self'; script-src 'self' %[1]s; frame-src %[1]s; style-src 'unsafe-inline' %[1]s; connect-src 'self' %[1]s; frame-ancestors 'none'", widget.Origins)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", csp)
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireSession authenticates the token of the dashboard cookie, and sends to the login page without one.
+// Forms must carry the CSRF token of the session on top of the cookie.
+func (dh *DashboardHandler) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(dashboardCookie)
+		if err != nil {
+			http.Redirect(w, r, "/admin/ui/login", http.StatusSeeOther)
+			return
+		}
+		ctx, herr := AuthenticateToken(r.Context(), dh.db, dh.resolver, dh.cfg.JWT, cookie.Value)
+		if herr != nil {
+			dh.clearCookie(w, r)
+			http.Redirect(w, r, "/admin/ui/login", http.StatusSeeOther)
+			return
+		}
+		r = r.WithContext(ctx)
+
+		if r.Method == http.MethodPost && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(dh.csrfToken(ctx))) {
+			dh.render(w, r, http.StatusForbidden, "error", "Forbidden", "Invalid form, reload the page and try again", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken is derived from the session, so it needs no storage and dies with the session
+func (dh *DashboardHandler) csrfToken(ctx context.Context) string {
+	sessionID, _ := ctx.Value(ContextSessionIDKey).(int64)
+	mac := hmac.New(sha256.New, dh.cfg.JWT.Keys.Secret())
+	mac.Write([]byte("dashboard-csrf:" + strconv.FormatInt(sessionID, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// allowed renders the forbidden page when the user lacks permission
+func (dh *DashboardHandler) allowed(w http.ResponseWriter, r *http.Request, permission string) bool {
+	if PermissionsFrom(r.Context()).Has(permission) {
+		return true
+	}
+	dh.render(w, r, http.StatusForbidden, "error", "Forbidden", "Missing permission "+permission, nil)
+	return false
+}
+
+func (dh *DashboardHandler) render(w http.ResponseWriter, r *http.Request, status int, page, title, errMessage string, data interface{}) {
+	username, _ := r.Context().Value(ContextUsernameKey).(string)
+	p := dashboardPage{Title: title, Username: username, Error: errMessage, Data: data}
+	if _, ok := r.Context().Value(ContextSessionIDKey).(int64); ok {
+		p.CSRF = dh.csrfToken(r.Context())
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := dh.pages[page].Execute(w, p); err != nil {
+		log.Printf("[DashboardHandler:render] Error rendering page %s: %v", page, err)
+	}
+}
+
+func (dh *DashboardHandler) renderError(w http.ResponseWriter, r *http.Request, herr *apperrors.Error) {
+	dh.render(w, r, herr.Status, "error", herr.Message.Message, herr.Message.Detail, nil)
+}
+
+// captchaWidget returns the CAPTCHA widget of the login page, nil without CAPTCHA_SITE_KEY
+func (dh *DashboardHandler) captchaWidget() *dashboardCaptcha {
+	widget, ok := captcha.Widgets[dh.cfg.Captcha.Provider]
+	if !ok || dh.cfg.Captcha.SiteKey == "" {
+		return nil
+	}
+	return &dashboardCaptcha{Widget: widget, SiteKey: dh.cfg.Captcha.SiteKey}
+}
+
+func (dh *DashboardHandler) loginPage(w http.ResponseWriter, r *http.Request) {
+	dh.render(w, r, http.StatusOK, "login", "Sign in", "", dh.captchaWidget())
+}
+
+func (dh *DashboardHandler) login(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[DashboardHandler:login] start")
+	tokens, herr := dh.auth.PasswordLogin(r.Context(), ClientOf(r), r.PostFormValue("email"), r.PostFormValue("password"), "Admin dashboard", r.PostFormValue("g-recaptcha-response"))
+	if herr != nil {
+		dh.render(w, r, herr.Status, "login", "Sign in", herr.Message.Detail, dh.captchaWidget())
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/admin/ui",
+		MaxAge:   int(dh.cfg.JWT.AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || dh.cfg.TLS.Enabled(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin/ui/", http.StatusSeeOther)
+}
+
+func (dh *DashboardHandler) logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, _ := r.Context().Value(ContextSessionIDKey).(int64)
+	if herr := dh.auth.RevokeSession(r.Context(), sessionID); herr != nil {
+		dh.renderError(w, r, herr)
+		return
+	}
+	dh.clearCookie(w, r)
+	http.Redirect(w, r, "/admin/ui/login", http.StatusSeeOther)
+}
+
+func (dh *DashboardHandler) clearCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: dashboardCookie, Path: "/admin/ui", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil || dh.cfg.TLS.Enabled(), SameSite: http.SameSiteStrictMode})
+}
+
+// usersPage lists the users whose name or email contains the q parameter, all of them without it.
+// Encrypted emails can only be searched for whole.
+func (dh *DashboardHandler) usersPage(w http.ResponseWriter, r *http.Request) {
+	if !dh.allowed(w, r, rbac.UsersList) {
+		return
+	}
+	q := r.URL.Query().Get("q")
+
+	query := `SELECT u.id, u.name, u.email, ` + userRolesColumn + `, u.created_at FROM users u
+		WHERE u.deleted_at IS NULL AND ($1 = '' OR u.name ILIKE '%' || $1 || '%' OR u.email_lookup = $3
+			OR ($4 AND u.email ILIKE '%' || $1 || '%'))
+		ORDER BY u.id LIMIT $2;`
+	key := dh.cfg.PIIEncryptionKey
+	rows, err := dh.db.Query(r.Context(), query, q, dashboardPageSize, pii.Lookup(q, key), key == nil)
+	if err != nil {
+		log.Printf("[DashboardHandler:usersPage] Error searching users: %v", err)
+		dh.renderError(w, r, apperrors.Internal())
+		return
+	}
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (dashboardUser, error) {
+		var u dashboardUser
+		err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Roles, &u.CreatedAt)
+		if err == nil {
+			u.Email, err = pii.Decrypt(u.Email, key)
+		}
+		return u, err
+	})
+	if err != nil {
+		log.Printf("[DashboardHandler:usersPage] Error reading users: %v", err)
+		dh.renderError(w, r, apperrors.Internal())
+		return
+	}
+
+	dh.render(w, r, http.StatusOK, "users", "Users", "", map[string]interface{}{"Query": q, "Users": users, "Limit": dashboardPageSize})
+}
+
+func (dh *DashboardHandler) userPage(w http.ResponseWriter, r *http.Request) {
+	if !dh.allowed(w, r, rbac.UsersRead) {
+		return
+	}
+	dh.renderUser(w, r, http.StatusOK, "")
+}
+
+// renderUser renders the page of the {id} user, with errMessage when an action failed
+func (dh *DashboardHandler) renderUser(w http.ResponseWriter, r *http.Request, status int, errMessage string) {
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		dh.renderError(w, r, herr)
+		return
+	}
+	u, err := dh.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			dh.renderError(w, r, userNotFound(id))
+			return
+		}
+		log.Printf("[DashboardHandler:renderUser] Error querying user %d: %v", id, err)
+		dh.renderError(w, r, apperrors.Internal())
+		return
+	}
+
+	perms := PermissionsFrom(r.Context())
+	data := &dashboardUserPage{User: u, CanAssign: perms.Has(rbac.RolesAssign), CanHistory: perms.Has(rbac.UsersHistory)}
+	if data.CanAssign {
+		rows, err := dh.db.Query(r.Context(), `SELECT name FROM roles ORDER BY name;`)
+		if err == nil {
+			data.AllRoles, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		}
+		if err != nil {
+			log.Printf("[DashboardHandler:renderUser] Error querying roles: %v", err)
+			dh.renderError(w, r, apperrors.Internal())
+			return
+		}
+	}
+	if data.CanHistory {
+		if data.History, err = dh.users.History(r.Context(), id); err != nil {
+			log.Printf("[DashboardHandler:renderUser] Error querying history of user %d: %v", id, err)
+			dh.renderError(w, r, apperrors.Internal())
+			return
+		}
+	}
+
+	dh.render(w, r, status, "user", u.Name, errMessage, data)
+}
+
+// changeRole grants or revokes the role of the form, then shows the user again
+func (dh *DashboardHandler) changeRole(w http.ResponseWriter, r *http.Request) {
+	if !dh.allowed(w, r, rbac.RolesAssign) {
+		return
+	}
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		dh.renderError(w, r, herr)
+		return
+	}
+
+	role := r.PostFormValue("role")
+	switch r.PostFormValue("action") {
+	case "grant":
+		_, herr = dh.admin.GrantRole(r.Context(), id, role)
+	case "revoke":
+		_, herr = dh.admin.RevokeRole(r.Context(), id, role)
+	default:
+		herr = apperrors.BadRequest("Unknown action")
+	}
+	if herr != nil {
+		dh.renderUser(w, r, herr.Status, herr.Message.Detail)
+		return
+	}
+	http.Redirect(w, r, "/admin/ui/users/"+idStr, http.StatusSeeOther)
+}
+
+// auditPage lists the last changes made to any user, from the user_history table
+func (dh *DashboardHandler) auditPage(w http.ResponseWriter, r *http.Request) {
+	if !dh.allowed(w, r, rbac.UsersHistory) {
+		return
+	}
+
+	query := `SELECT id, user_id, actor_id, operation, old_values, new_values, changed_at FROM user_history ORDER BY id DESC LIMIT $1;`
+	rows, err := dh.db.Query(r.Context(), query, dashboardPageSize)
+	if err != nil {
+		log.Printf("[DashboardHandler:auditPage] Error querying history: %v", err)
+		dh.renderError(w, r, apperrors.Internal())
+		return
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (dashboardAuditEntry, error) {
+		var e dashboardAuditEntry
+		err := row.Scan(&e.ID, &e.UserID, &e.ActorID, &e.Operation, &e.OldValues, &e.NewValues, &e.ChangedAt)
+		return e, err
+	})
+	if err != nil {
+		log.Printf("[DashboardHandler:auditPage] Error reading history: %v", err)
+		dh.renderError(w, r, apperrors.Internal())
+		return
+	}
+
+	dh.render(w, r, http.StatusOK, "audit", "Audit log", "", entries)
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// Accounts with more sessions and history entries than this get their export built by a job
+const dataExportSyncMaxRows = 1000
+
+// Job kind building a data export
+const dataExportJob = "user.data_export"
+
+// Personal Data Export Model
+type personalData struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	User       user             `json:"user"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Groups     []string         `json:"groups"`
+	Profile    *profileResponse `json:"profile"`
+	// Every session, revoked and expired ones included
+	Sessions []exportedSession `json:"sessions"`
+	History  []historyEntry    `json:"history"`
+}
+
+type exportedSession struct {
+	ID         int64      `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	DeviceName string     `json:"device_name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Data Export Status Model, answered while an export is built in the background
+type dataExport struct {
+	ID        int64     `json:"id"`
+	Format    string    `json:"format"`
+	Status    string    `json:"status"` // pending or ready
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UseJobs builds the large data exports in the background on queue. Without it they are built during
+// the request whatever their size, and erasures can't be scheduled.
+func (uh *UserHandler) UseJobs(queue *jobs.Queue) {
+	uh.jobs = queue
+	queue.Register(dataExportJob, uh.runDataExport)
+	queue.Register(erasureJob, uh.runErasure)
+}
+
+// @Summary      Export my personal data
+// @Description  Downloads everything held about the authenticated user: account, groups, profile fields, sessions with their devices and IPs, and the change history. Large accounts get 202 with the export being built in the background, to download from the Location header once ready
+// @Tags         users
+// @Produce      json
+// @Produce      application/zip
+// @Security     BearerAuth
+// @Param        format query string false "json or zip (default json)"
+// @Success      200 {object} personalData
+// @Success      202 {object} dataExport
+// @Failure      400 {object} apperrors.Response
+// @Failure      401 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me/export [get]
+func (uh *UserHandler) exportMyData(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[UserHandler:exportMyData] start")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		return nil, apperrors.BadRequest("Query parameter 'format' must be json or zip")
+	}
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	var rows int
+	query := `SELECT (SELECT COUNT(*) FROM sessions WHERE user_id = $1) + (SELECT COUNT(*) FROM user_history WHERE user_id = $1);`
+	if err := uh.db.QueryRow(r.Context(), query, userID).Scan(&rows); err != nil {
+		log.Printf("[UserHandler:exportMyData] Error counting the rows of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+
+	if rows > dataExportSyncMaxRows && uh.jobs != nil {
+		export, err := uh.queueDataExport(r.Context(), userID, format)
+		if err != nil {
+			log.Printf("[UserHandler:exportMyData] Error queueing the export of user %d: %v", userID, err)
+			return nil, apperrors.Internal()
+		}
+		log.Printf("[UserHandler:exportMyData] Queued export %d of user %d (%d rows)", export.ID, userID, rows)
+		w.Header().Set("Location", "/users/me/exports/"+strconv.FormatInt(export.ID, 10))
+		return &HandlerSuccess{Status: http.StatusAccepted, Data: export}, nil
+	}
+
+	content, err := uh.buildDataExport(r.Context(), userID, format)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, apperrors.NotFound("User with id " + strconv.Itoa(userID) + " not found")
+		}
+		log.Printf("[UserHandler:exportMyData] Error exporting user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+
+	writeDataExport(w, format, content)
+	log.Printf("[UserHandler:exportMyData] end. Took %v", time.Since(start))
+	return nil, nil
+}
+
+// @Summary      Get a personal data export
+// @Description  Downloads an export built in the background once it is ready, answers 202 with its status before
+// @Tags         users
+// @Produce      json
+// @Produce      application/zip
+// @Security     BearerAuth
+// @Param        id path int true "Export ID"
+// @Success      200 {object} personalData
+// @Success      202 {object} dataExport
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me/exports/{id} [get]
+func (uh *UserHandler) getMyDataExport(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	var export dataExport
+	var content []byte
+	query := `SELECT id, format, status, content, created_at, expires_at FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND expires_at > NOW();`
+	err = uh.db.QueryRow(r.Context(), query, id, userID).Scan(&export.ID, &export.Format, &export.Status, &content, &export.CreatedAt, &export.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperrors.NotFound("Export with id " + strconv.FormatInt(id, 10) + " not found")
+	}
+	if err != nil {
+		log.Printf("[UserHandler:getMyDataExport] Error querying export %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+
+	if export.Status != "ready" {
+		return &HandlerSuccess{Status: http.StatusAccepted, Data: &export}, nil
+	}
+	writeDataExport(w, export.Format, content)
+	return nil, nil
+}
+
+// queueDataExport stores a pending export and the job building it together
+func (uh *UserHandler) queueDataExport(ctx context.Context, userID int, format string) (*dataExport, error) {
+	export := &dataExport{Format: format, Status: "pending"}
+	err := repository.WithTx(ctx, uh.db, func(tx pgx.Tx) error {
+		query := `INSERT INTO data_exports (user_id, format, expires_at) VALUES ($1, $2, $3) RETURNING id, created_at, expires_at;`
+		err := tx.QueryRow(ctx, query, userID, format, time.Now().Add(uh.cfg.DataExportTTL)).Scan(&export.ID, &export.CreatedAt, &export.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		return uh.jobs.EnqueueTx(ctx, tx, dataExportJob, map[string]int64{"export_id": export.ID})
+	})
+	return export, err
+}
+
+// runDataExport is the job filling a pending export
+func (uh *UserHandler) runDataExport(ctx context.Context, payload json.RawMessage) error {
+	var job struct {
+		ExportID int64 `json:"export_id"`
+	}
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	var userID int
+	var format string
+	err := uh.db.QueryRow(ctx, `SELECT user_id, format FROM data_exports WHERE id = $1 AND status = 'pending';`, job.ExportID).Scan(&userID, &format)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Expired and deleted, or built by an earlier attempt
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content, err := uh.buildDataExport(ctx, userID, format)
+	if err != nil {
+		return fmt.Errorf("exporting user %d: %w", userID, err)
+	}
+	_, err = uh.db.Exec(ctx, `UPDATE data_exports SET status = 'ready', content = $2, finished_at = NOW() WHERE id = $1;`, job.ExportID, content)
+	if err == nil {
+		log.Printf("[UserHandler:runDataExport] Export %d of user %d is ready (%d bytes)", job.ExportID, userID, len(content))
+	}
+	return err
+}
+
+// buildDataExport gathers the personal data of the user and encodes it in format: a JSON document, or
+// a ZIP archive with one JSON file per section
+func (uh *UserHandler) buildDataExport(ctx context.Context, userID int, format string) ([]byte, error) {
+	data, err := uh.personalData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if format == "json" {
+		return json.MarshalIndent(data, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	sections := []struct {
+		name  string
+		value interface{}
+	}{
+		{"account.json", map[string]interface{}{"exported_at": data.ExportedAt, "user": data.User, "created_at": data.CreatedAt, "groups": data.Groups}},
+		{"profile.json", data.Profile},
+		{"sessions.json", data.Sessions},
+		{"history.json", data.History},
+	}
+	for _, section := range sections {
+		f, err := archive.Create(section.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(section.value); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (uh *UserHandler) personalData(ctx context.Context, userID int) (*personalData, error) {
+	found, err := uh.users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := &personalData{ExportedAt: time.Now().UTC(), User: *userFromRecord(found), CreatedAt: found.CreatedAt}
+	if data.Groups, err = uh.users.Groups(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Profile, err = profileOf(ctx, uh.profiles, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, user_agent, ip, device_name, created_at, expires_at, revoked_at FROM sessions
+		WHERE user_id = $1 ORDER BY created_at;`
+	rows, err := uh.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	data.Sessions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (exportedSession, error) {
+		var s exportedSession
+		err := row.Scan(&s.ID, &s.UserAgent, &s.IP, &s.DeviceName, &s.CreatedAt, &s.ExpiresAt, &s.RevokedAt)
+		return s, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := uh.users.History(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data.History = make([]historyEntry, 0, len(entries))
+	for _, entry := range entries {
+		data.History = append(data.History, historyEntry{
+			ID:        entry.ID,
+			ActorID:   entry.ActorID,
+			Operation: entry.Operation,
+			OldValues: entry.OldValues,
+			NewValues: entry.NewValues,
+			ChangedAt: entry.ChangedAt,
+		})
+	}
+	return data, nil
+}
+
+// writeDataExport sends an export as a file download
+func writeDataExport(w http.ResponseWriter, format string, content []byte) {
+	filename := "personal-data-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
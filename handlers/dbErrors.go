@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// Handlers return the errors of the database they don't expect with apperrors.FromError, and the
+// adapters answer them here, once: see repository.Translate for the error codes that are told apart.
+
+// Detail of the conflict answered for a broken unique constraint, by constraint name
+var constraintConflicts = map[string]string{
+	"users_email_active_key":        "Email is already in use. Please use a different email.",
+	"users_email_lookup_active_key": "Email is already in use. Please use a different email.",
+	"groups_name_key":               "A group with this name already exists",
+}
+
+// ResolveError returns what herr, returned with apperrors.FromError, means for the client. Causes that
+// aren't constraint violations or serialization failures stay server errors, to log by the caller. The
+// gRPC and GraphQL APIs call it before turning herr into their own errors.
+func ResolveError(herr *apperrors.Error) *apperrors.Error {
+	if herr.Cause == nil {
+		return herr
+	}
+	cause := repository.Translate(herr.Cause)
+	switch {
+	case errors.Is(cause, repository.ErrConflict):
+		detail, ok := constraintConflicts[repository.ConstraintOf(cause)]
+		if !ok {
+			detail = "The request conflicts with an existing record"
+		}
+		return apperrors.Conflict(detail)
+	case errors.Is(cause, repository.ErrInvalidReference):
+		return apperrors.Unprocessable("The request refers to a record that does not exist")
+	case errors.Is(cause, repository.ErrSerialization):
+		return apperrors.Conflict("The request conflicted with a concurrent change. Try again.")
+	}
+	return herr
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/signedurl"
+	"github.com/hi-im-yan/jwt-with-go/storage"
+)
+
+// Signed Download Link Response Model
+type downloadLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Download links are signed with a key derived from JWT_SECRET, like the invite tokens. It is derived
+// again for every link, so a secret rotated in its file (see config.Keys.Watch) applies to them too.
+func downloadSigningKey(jwtCfg config.JWT) func() []byte {
+	return func() []byte {
+		return append([]byte("download:"), jwtCfg.Keys.Secret()...)
+	}
+}
+
+// SignedURLMiddleware lets through the requests whose URL was signed by signer and has not expired.
+// It stands in for the JWT middlewares: the permission was checked when the link was made.
+func SignedURLMiddleware(signer *signedurl.Signer) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if err := signer.Verify(r.URL, time.Now()); err != nil {
+				if errors.Is(err, signedurl.ErrExpired) {
+					return nil, apperrors.Forbidden("Download link expired")
+				}
+				return nil, apperrors.Forbidden("Invalid download link")
+			}
+			return next(w, r)
+		}
+	}
+}
+
+// DownloadRouter serves the signed links handed out by the /link routes, mounted at /downloads
+func (uh *UserHandler) DownloadRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(MiddlewareAdapter(SignedURLMiddleware(uh.downloads)))
+
+	r.HandleFunc("GET /users-export", ApiHandlerAdapter(uh.exportUsers))
+	r.HandleFunc("GET /avatars/{id}", ApiHandlerAdapter(uh.downloadAvatar))
+	return r
+}
+
+// link signs the download path with query and the lifetime of the links
+func (uh *UserHandler) link(path string, query url.Values) *downloadLink {
+	expiresAt := time.Now().Add(uh.cfg.SignedURLTTL).UTC().Truncate(time.Second)
+	return &downloadLink{
+		URL:       uh.cfg.PublicBaseURL + uh.downloads.Sign("/downloads"+path, query, expiresAt),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// @Summary      Get a download link for the users export
+// @Description  Returns a signed link to the file of GET /users/export, which can be downloaded without an Authorization header until it expires (SIGNED_URL_TTL, 15 minutes by default). Requires users:export
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        format query string false "csv or json (default csv)"
+// @Success      200 {object} downloadLink
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Router       /users/export/link [get]
+func (uh *UserHandler) exportLink(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		return nil, apperrors.BadRequest("Query parameter 'format' must be csv or json")
+	}
+
+	link := uh.link("/users-export", url.Values{"format": {format}})
+	log.Printf("[UserHandler:exportLink] Signed a %s export link valid until %v", format, link.ExpiresAt)
+	return &HandlerSuccess{Status: http.StatusOK, Data: link}, nil
+}
+
+// @Summary      Get a download link for an avatar
+// @Description  Returns a signed link to the avatar of the user, which can be downloaded without an Authorization header until it expires (SIGNED_URL_TTL, 15 minutes by default). Requires users:read
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      200 {object} downloadLink
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Router       /users/{id}/avatar/link [get]
+func (uh *UserHandler) avatarLink(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	found, herr := uh.GetUser(r.Context(), id)
+	if herr != nil {
+		return nil, herr
+	}
+	if found.AvatarURL == "" {
+		return nil, apperrors.NotFound("User with id " + idStr + " has no avatar")
+	}
+
+	return &HandlerSuccess{Status: http.StatusOK, Data: uh.link("/avatars/"+idStr, nil)}, nil
+}
+
+// downloadAvatar sends the avatar of a signed link. Avatars kept in S3 are a redirect to their URL.
+func (uh *UserHandler) downloadAvatar(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, idStr, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	found, herr := uh.GetUser(r.Context(), id)
+	if herr != nil {
+		return nil, herr
+	}
+	if found.AvatarURL == "" {
+		return nil, apperrors.NotFound("User with id " + idStr + " has no avatar")
+	}
+
+	local, ok := uh.avatars.(*storage.Local)
+	if !ok {
+		http.Redirect(w, r, found.AvatarURL, http.StatusFound)
+		return nil, nil
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeFile(w, r, filepath.Join(local.Dir, "avatars", fmt.Sprintf("%d.png", id)))
+	return nil, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type emailConfirmationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// requestEmailChange records newEmail as pending for the user and mails a confirmation token to it,
+// valid for cfg.EmailChangeTTL. The email is only changed once the token comes back through
+// POST /auth/email-confirmation. A new request replaces the previous pending one.
+func requestEmailChange(ctx context.Context, db *pgxpool.Pool, m mailer.Mailer, cfg *config.Config, userID int, newEmail string) *apperrors.Error {
+	var taken bool
+	err := db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email_lookup = $1 AND deleted_at IS NULL);`, pii.Lookup(newEmail, cfg.PIIEncryptionKey)).Scan(&taken)
+	if err == nil && !taken {
+		taken, err = emailBlockedByDeletedAccount(ctx, db, cfg.EmailReusePolicy, cfg.PIIEncryptionKey, newEmail)
+	}
+	if err != nil {
+		log.Printf("[Handlers:requestEmailChange] Error checking email availability: %v", err)
+		return apperrors.Internal()
+	}
+	if taken {
+		return apperrors.Conflict("Email is not available. Please use a different email.")
+	}
+
+	token, err := randomToken()
+	var encrypted string
+	if err == nil {
+		encrypted, err = pii.Encrypt(newEmail, cfg.PIIEncryptionKey)
+	}
+	if err == nil {
+		query := `INSERT INTO email_changes (user_id, new_email, token_hash, expires_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id) DO UPDATE SET new_email = EXCLUDED.new_email, token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, created_at = NOW();`
+		_, err = db.Exec(ctx, query, userID, encrypted, hashToken(token), time.Now().Add(cfg.EmailChangeTTL))
+	}
+	if err == nil {
+		err = mailer.SendTemplate(ctx, m, newEmail, "email_change", map[string]interface{}{
+			"URL": cfg.EmailConfirmationURL + "?token=" + token,
+			"TTL": humanDuration(cfg.EmailChangeTTL),
+		})
+	}
+	if err != nil {
+		log.Printf("[Handlers:requestEmailChange] Error requesting email change of user %d: %v", userID, err)
+		return apperrors.Internal()
+	}
+	return nil
+}
+
+// @Summary      Confirm an email change
+// @Description  Applies a pending email change with the token mailed to the new address
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body emailConfirmationRequest true "Confirmation token"
+// @Success      200 {object} user
+// @Failure      400 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/email-confirmation [post]
+func (ah *AuthenticationHandler) confirmEmailChange(r *http.Request, confirmReq *emailConfirmationRequest) (*user, *apperrors.Error) {
+	log.Printf("[AuthenticationHandler:confirmEmailChange] start")
+
+	// The pending change is consumed whatever happens next, a failed confirmation needs a new request
+	var userID int
+	var newEmail string
+	var expiresAt time.Time
+	query := `DELETE FROM email_changes WHERE token_hash = $1 RETURNING user_id, new_email, expires_at;`
+	err := ah.DB.QueryRow(r.Context(), query, hashToken(confirmReq.Token)).Scan(&userID, &newEmail, &expiresAt)
+	if err == pgx.ErrNoRows || (err == nil && time.Now().After(expiresAt)) {
+		return nil, apperrors.BadRequest("The confirmation link is invalid or expired. Request the change again.")
+	}
+	if err == nil {
+		newEmail, err = pii.Decrypt(newEmail, ah.Config.PIIEncryptionKey)
+	}
+
+	// The address may have been taken (or blocked by a deletion) since the request
+	blocked := false
+	if err == nil {
+		blocked, err = emailBlockedByDeletedAccount(r.Context(), ah.DB, ah.Config.EmailReusePolicy, ah.Config.PIIEncryptionKey, newEmail)
+	}
+	if err == nil && blocked {
+		return nil, apperrors.Conflict("Email is not available anymore. Please use a different email.")
+	}
+
+	updatedUser := &user{}
+	var encrypted, lookup string
+	if err == nil {
+		encrypted, lookup, err = encryptEmail(newEmail, ah.Config.PIIEncryptionKey)
+	}
+	if err == nil {
+		query = `UPDATE users u SET email = $1, email_lookup = $2 WHERE u.id = $3 AND u.deleted_at IS NULL RETURNING u.id, u.name, ` + userRolesColumn + `, COALESCE(u.avatar_url, '');`
+		// the token proves the user asked for the change, so they are the actor
+		err = repository.WithActor(r.Context(), ah.DB, userID, func(tx pgx.Tx) error {
+			return tx.QueryRow(r.Context(), query, encrypted, lookup, userID).Scan(&updatedUser.ID, &updatedUser.Name, &updatedUser.Roles, &updatedUser.AvatarURL)
+		})
+		updatedUser.Email = newEmail
+	}
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.BadRequest("The account of this confirmation link does not exist anymore")
+		}
+		log.Printf("[AuthenticationHandler:confirmEmailChange] Error confirming email change: %v", err)
+		return nil, apperrors.FromError(err)
+	}
+
+	ah.UserChanged.notify(r.Context(), userID)
+	ah.Events.Publish(r.Context(), events.UserUpdated, updatedUser.event())
+	log.Printf("[AuthenticationHandler:confirmEmailChange] Email of user %d changed", userID)
+	return updatedUser, nil
+}
+
+// hashToken is what gets stored for one-time tokens, so a database leak does not leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// humanDuration writes ttl for emails, e.g. "24 hours" or "7 days"
+func humanDuration(ttl time.Duration) string {
+	switch {
+	case ttl >= 48*time.Hour && ttl%(24*time.Hour) == 0:
+		return strconv.Itoa(int(ttl/(24*time.Hour))) + " days"
+	case ttl >= 2*time.Hour && ttl%time.Hour == 0:
+		return strconv.Itoa(int(ttl/time.Hour)) + " hours"
+	case ttl >= 2*time.Minute && ttl%time.Minute == 0:
+		return strconv.Itoa(int(ttl/time.Minute)) + " minutes"
+	default:
+		return ttl.String()
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EmailHandler shows the delivery of the outgoing emails (see mailer.Outbox) and retries the failed ones
+type EmailHandler struct {
+	cfg    *config.Config
+	db     *pgxpool.Pool
+	outbox *mailer.Outbox
+}
+
+// Email Response Model, without the bodies
+type email struct {
+	ID      int64  `json:"id"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	// pending (being sent or retried), sent or failed (out of attempts)
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	// When it was sent or failed for good
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Query of the email list
+type emailListRequest struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending sent failed"`
+	pageQuery
+}
+
+// Path of a single email
+type emailIDRequest struct {
+	ID int64 `path:"id"`
+}
+
+const (
+	emailColumns = `id, recipient, subject, status, attempts, last_error, created_at, finished_at`
+)
+
+func NewEmailHandler(cfg *config.Config, db *pgxpool.Pool, outbox *mailer.Outbox) *EmailHandler {
+	return &EmailHandler{cfg: cfg, db: db, outbox: outbox}
+}
+
+// EmailRouter is mounted at /admin/emails
+func (eh *EmailHandler) EmailRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(eh.db, eh.cfg.JWT)), MiddlewareAdapter(RequirePermission(rbac.EmailsManage)))
+
+	r.HandleFunc("GET /", ApiHandlerAdapter(Handle(http.StatusOK, eh.getEmails)))
+	r.HandleFunc("GET /{id}", ApiHandlerAdapter(Handle(http.StatusOK, eh.getEmail)))
+	r.HandleFunc("POST /{id}/retry", ApiHandlerAdapter(Handle(http.StatusOK, eh.retryEmail)))
+	return r
+}
+
+// @Summary      List outgoing emails
+// @Description  Lists the emails page by page, newest first, with their delivery status (requires emails:manage)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        status query string false "pending, sent or failed"
+// @Param        page query int false "Page number, from 1"
+// @Param        per_page query int false "Emails per page, 20 by default and 100 at most"
+// @Success      200 {object} listPage{items=[]email}
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/emails [get]
+func (eh *EmailHandler) getEmails(r *http.Request, req *emailListRequest) (*listPage, *apperrors.Error) {
+	var total int
+	err := eh.db.QueryRow(r.Context(), `SELECT COUNT(*) FROM emails WHERE $1 = '' OR status = $1;`, req.Status).Scan(&total)
+	if err == nil {
+		query := `SELECT ` + emailColumns + ` FROM emails WHERE $1 = '' OR status = $1 ORDER BY id DESC LIMIT $2 OFFSET $3;`
+		var rows pgx.Rows
+		rows, err = eh.db.Query(r.Context(), query, req.Status, req.limit(), req.offset())
+		if err == nil {
+			var emails []email
+			emails, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (email, error) {
+				return eh.scanEmail(row)
+			})
+			if err == nil {
+				return newPage(r, req.pageQuery, emails, total), nil
+			}
+		}
+	}
+	log.Printf("[EmailHandler:getEmails] Error querying emails: %v", err)
+	return nil, apperrors.Internal()
+}
+
+// @Summary      Get an outgoing email
+// @Description  Gets the delivery status of an email (requires emails:manage)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Email ID"
+// @Success      200 {object} email
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/emails/{id} [get]
+func (eh *EmailHandler) getEmail(r *http.Request, req *emailIDRequest) (*email, *apperrors.Error) {
+	return eh.emailOf(r.Context(), req.ID)
+}
+
+// @Summary      Retry a failed email
+// @Description  Queues an email that ran out of attempts again, with all its attempts (requires emails:manage)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Email ID"
+// @Success      200 {object} email
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/emails/{id}/retry [post]
+func (eh *EmailHandler) retryEmail(r *http.Request, req *emailIDRequest) (*email, *apperrors.Error) {
+	id := req.ID
+	err := eh.outbox.Retry(r.Context(), id)
+	if errors.Is(err, mailer.ErrNotFailed) {
+		// Tell a missing email from one that isn't failed
+		e, herr := eh.emailOf(r.Context(), id)
+		if herr != nil {
+			return nil, herr
+		}
+		return nil, apperrors.Conflict("Email with id " + strconv.FormatInt(id, 10) + " is " + e.Status + ", only failed emails can be retried")
+	}
+	if err != nil {
+		log.Printf("[EmailHandler:retryEmail] Error retrying email %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	log.Printf("[EmailHandler:retryEmail] Email %d queued again", id)
+	return eh.emailOf(r.Context(), id)
+}
+
+func (eh *EmailHandler) emailOf(ctx context.Context, id int64) (*email, *apperrors.Error) {
+	e, err := eh.scanEmail(eh.db.QueryRow(ctx, `SELECT `+emailColumns+` FROM emails WHERE id = $1;`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperrors.NotFound("Email with id " + strconv.FormatInt(id, 10) + " not found")
+	}
+	if err != nil {
+		log.Printf("[EmailHandler:emailOf] Error querying email %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	return &e, nil
+}
+
+// scanEmail reads a row of emailColumns, with the recipient decrypted
+func (eh *EmailHandler) scanEmail(row pgx.Row) (email, error) {
+	var e email
+	err := row.Scan(&e.ID, &e.To, &e.Subject, &e.Status, &e.Attempts, &e.LastError, &e.CreatedAt, &e.FinishedAt)
+	if err == nil {
+		e.To, err = pii.Decrypt(e.To, eh.cfg.PIIEncryptionKey)
+	}
+	return e, err
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Deleted users are only soft deleted (users.deleted_at). The partial unique index on users.email_lookup
+// only covers active accounts, so whether the email of a deleted account can be taken again is decided here.
+const (
+	// The email is free as soon as the account is deleted
+	EmailReuseImmediate = "immediate"
+	// The email stays taken while the deleted row exists, and is free once the row is purged
+	EmailReuseAfterPurge = "after_purge"
+	// The email can never be used again, even after a purge (purges must keep a tombstone row)
+	EmailReuseNever = "never"
+)
+
+// emailBlockedByDeletedAccount tells if the email belongs to a deleted account that, under the
+// policy (config.EmailReusePolicy), still holds on to it. Active accounts are covered by the unique index.
+func emailBlockedByDeletedAccount(ctx context.Context, db *pgxpool.Pool, policy string, piiKey []byte, email string) (bool, error) {
+	if policy == EmailReuseImmediate {
+		return false, nil
+	}
+
+	var blocked bool
+	err := db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email_lookup = $1 AND deleted_at IS NOT NULL);`, pii.Lookup(email, piiKey)).Scan(&blocked)
+	return blocked, err
+}
+
+// encryptEmail returns the users.email and users.email_lookup values of email. They are encrypted
+// with piiKey (PII_ENCRYPTION_KEY) and its HMAC, or email itself without a key. The emails read
+// from users.email go through pii.Decrypt.
+func encryptEmail(email string, piiKey []byte) (encrypted, lookup string, err error) {
+	encrypted, err = pii.Encrypt(email, piiKey)
+	return encrypted, pii.Lookup(email, piiKey), err
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// With RESPONSE_ENVELOPE=true every JSON answer of the adapters has the same shape:
+//
+//	{"data": ..., "meta": {"request_id": "...", "duration_ms": 1.2}}
+//	{"error": {"code": "E404", ...}, "meta": {...}}
+//
+// It is off by default so existing clients keep getting the bare objects.
+
+type envelopeStartKey struct{}
+
+// Envelope Response Model
+type envelope struct {
+	Data  interface{}         `json:"data,omitempty"`
+	Error *apperrors.Response `json:"error,omitempty"`
+	Meta  envelopeMeta        `json:"meta"`
+}
+
+type envelopeMeta struct {
+	RequestID  string  `json:"request_id,omitempty"`
+	DurationMs float64 `json:"duration_ms"` // time spent from the envelope middleware to the answer
+}
+
+// ResponseEnvelopeMiddleware turns the envelope on for the requests it handles
+func ResponseEnvelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), envelopeStartKey{}, time.Now())))
+	})
+}
+
+// wrapInEnvelope returns the body to send for data or errBody, unchanged when the envelope is off
+func wrapInEnvelope(r *http.Request, data interface{}, errBody *apperrors.Response) interface{} {
+	start, ok := r.Context().Value(envelopeStartKey{}).(time.Time)
+	if !ok {
+		if errBody != nil {
+			return errBody
+		}
+		return data
+	}
+	return envelope{
+		Data:  data,
+		Error: errBody,
+		Meta: envelopeMeta{
+			RequestID:  RequestID(r.Context()),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		},
+	}
+}
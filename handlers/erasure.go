@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// The right to be forgotten: a user asks for the erasure of their personal data with DELETE /users/me
+// and confirms it with the token mailed to them, or an admin schedules it. The account is anonymized by
+// a job once the grace period (ERASURE_GRACE_PERIOD) is over: the users row is kept, so what references
+// it stays valid, but every personal value is replaced or deleted. Until then it can be cancelled.
+
+// How long the confirmation token mailed by DELETE /users/me is valid
+const erasureConfirmationTTL = 24 * time.Hour
+
+// Job kind anonymizing an account
+const erasureJob = "user.erase"
+
+// Erasure Request Model
+type erasureRequest struct {
+	UserID      int        `json:"user_id"`
+	RequestedBy *int       `json:"requested_by"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// When the account is anonymized, set once confirmed
+	EraseAt   *time.Time `json:"erase_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type erasureConfirmationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ErasureAdminRouter is mounted at /admin/erasures
+func (uh *UserHandler) ErasureAdminRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(uh.db, uh.cfg.JWT)), MiddlewareAdapter(RequirePermission(rbac.UsersErase)))
+
+	r.HandleFunc("GET /", ApiHandlerAdapter(uh.listErasures))
+	r.HandleFunc("POST /{id}", ApiHandlerAdapter(uh.scheduleErasure))
+	r.HandleFunc("DELETE /{id}", ApiHandlerAdapter(uh.cancelErasure))
+	return r
+}
+
+// @Summary      Ask for the erasure of my account
+// @Description  Mails a confirmation link to the email of the account. Once confirmed, the personal data of the account is anonymized after the grace period (30 days by default) and the account can't be used anymore
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      202 {object} erasureRequest
+// @Failure      401 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me [delete]
+func (uh *UserHandler) requestErasure(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[UserHandler:requestErasure] start")
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	found, herr := uh.GetUser(r.Context(), userID)
+	if herr != nil {
+		return nil, herr
+	}
+
+	// A new request replaces the previous one, confirmed or not
+	token, err := randomToken()
+	request := &erasureRequest{UserID: userID, RequestedBy: &userID}
+	if err == nil {
+		query := `INSERT INTO erasure_requests (user_id, token_hash, requested_by, expires_at) VALUES ($1, $2, $1, $3)
+			ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, requested_by = EXCLUDED.requested_by,
+				expires_at = EXCLUDED.expires_at, confirmed_at = NULL, erase_at = NULL, created_at = NOW()
+			RETURNING created_at;`
+		err = uh.db.QueryRow(r.Context(), query, userID, hashToken(token), time.Now().Add(erasureConfirmationTTL)).Scan(&request.CreatedAt)
+	}
+	if err == nil {
+		err = mailer.SendTemplate(r.Context(), uh.mailer, found.Email, "erasure", map[string]interface{}{
+			"URL":         uh.cfg.ErasureConfirmationURL + "?token=" + token,
+			"TTL":         humanDuration(erasureConfirmationTTL),
+			"GracePeriod": humanDuration(uh.cfg.ErasureGracePeriod),
+		})
+	}
+	if err != nil {
+		log.Printf("[UserHandler:requestErasure] Error requesting the erasure of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+
+	return &HandlerSuccess{Status: http.StatusAccepted, Data: request}, nil
+}
+
+// @Summary      Confirm the erasure of my account
+// @Description  Schedules the erasure asked with DELETE /users/me, with the token of the mailed link
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body erasureConfirmationRequest true "Confirmation token"
+// @Success      200 {object} erasureRequest
+// @Failure      400 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me/erasure/confirm [post]
+func (uh *UserHandler) confirmErasure(r *http.Request, confirmReq *erasureConfirmationRequest) (*erasureRequest, *apperrors.Error) {
+	log.Printf("[UserHandler:confirmErasure] start")
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	request, err := uh.scheduleErasureTx(r.Context(), userID, func(tx pgx.Tx) (bool, error) {
+		var valid bool
+		query := `UPDATE erasure_requests SET token_hash = NULL WHERE user_id = $1 AND token_hash = $2 RETURNING expires_at > NOW();`
+		err := tx.QueryRow(r.Context(), query, userID, hashToken(confirmReq.Token)).Scan(&valid)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return valid, err
+	})
+	if err != nil {
+		log.Printf("[UserHandler:confirmErasure] Error confirming the erasure of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+	if request == nil {
+		return nil, apperrors.BadRequest("The confirmation link is invalid or expired. Ask for the erasure again.")
+	}
+
+	log.Printf("[UserHandler:confirmErasure] Erasure of user %d scheduled for %v", userID, *request.EraseAt)
+	return request, nil
+}
+
+// @Summary      Get the erasure of my account
+// @Description  Tells if an erasure of the account was asked for, and when it will be carried out once confirmed
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} erasureRequest
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me/erasure [get]
+func (uh *UserHandler) getMyErasure(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	var request erasureRequest
+	query := `SELECT user_id, requested_by, confirmed_at, erase_at, created_at FROM erasure_requests WHERE user_id = $1;`
+	err := uh.db.QueryRow(r.Context(), query, userID).Scan(&request.UserID, &request.RequestedBy, &request.ConfirmedAt, &request.EraseAt, &request.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperrors.NotFound("No erasure was asked for")
+	}
+	if err != nil {
+		log.Printf("[UserHandler:getMyErasure] Error querying the erasure of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: &request}, nil
+}
+
+// @Summary      Cancel the erasure of my account
+// @Description  Cancels the erasure of the account, confirmed or not, until it is carried out
+// @Tags         users
+// @Security     BearerAuth
+// @Success      204
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me/erasure [delete]
+func (uh *UserHandler) cancelMyErasure(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+	return uh.deleteErasure(r.Context(), userID)
+}
+
+// @Summary      List the erasures
+// @Description  Lists the erasures asked for and not carried out yet, the next ones first (requires users:erase)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} erasureRequest
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/erasures [get]
+func (uh *UserHandler) listErasures(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	query := `SELECT user_id, requested_by, confirmed_at, erase_at, created_at FROM erasure_requests
+		ORDER BY erase_at NULLS LAST, created_at;`
+	rows, err := uh.db.Query(r.Context(), query)
+	if err == nil {
+		var requests []erasureRequest
+		requests, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (erasureRequest, error) {
+			var request erasureRequest
+			err := row.Scan(&request.UserID, &request.RequestedBy, &request.ConfirmedAt, &request.EraseAt, &request.CreatedAt)
+			return request, err
+		})
+		if err == nil {
+			return &HandlerSuccess{Status: http.StatusOK, Data: requests}, nil
+		}
+	}
+	log.Printf("[UserHandler:listErasures] Error querying erasures: %v", err)
+	return nil, apperrors.Internal()
+}
+
+// @Summary      Erase a user
+// @Description  Schedules the erasure of the personal data of a user after the grace period, without asking them for a confirmation, e.g. for a request received by another channel (requires users:erase)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      200 {object} erasureRequest
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/erasures/{id} [post]
+func (uh *UserHandler) scheduleErasure(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	actorID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	request, err := uh.scheduleErasureTx(r.Context(), id, func(tx pgx.Tx) (bool, error) {
+		query := `INSERT INTO erasure_requests (user_id, requested_by, expires_at)
+			SELECT id, $2, NOW() FROM users WHERE id = $1 AND anonymized_at IS NULL
+			ON CONFLICT (user_id) DO UPDATE SET token_hash = NULL, requested_by = EXCLUDED.requested_by;`
+		tag, err := tx.Exec(r.Context(), query, id, actorID)
+		return tag.RowsAffected() == 1, err
+	})
+	if err != nil {
+		log.Printf("[UserHandler:scheduleErasure] Error scheduling the erasure of user %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	if request == nil {
+		return nil, userNotFound(id)
+	}
+
+	log.Printf("[UserHandler:scheduleErasure] Erasure of user %d scheduled for %v by user %d", id, *request.EraseAt, actorID)
+	return &HandlerSuccess{Status: http.StatusOK, Data: request}, nil
+}
+
+// @Summary      Cancel the erasure of a user
+// @Description  Cancels the erasure of a user until it is carried out (requires users:erase)
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      204
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/erasures/{id} [delete]
+func (uh *UserHandler) cancelErasure(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	return uh.deleteErasure(r.Context(), id)
+}
+
+func (uh *UserHandler) deleteErasure(ctx context.Context, userID int) (*HandlerSuccess, *apperrors.Error) {
+	tag, err := uh.db.Exec(ctx, `DELETE FROM erasure_requests WHERE user_id = $1;`, userID)
+	if err != nil {
+		log.Printf("[UserHandler:deleteErasure] Error cancelling the erasure of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, apperrors.NotFound("No erasure was asked for")
+	}
+	log.Printf("[UserHandler:deleteErasure] Erasure of user %d cancelled", userID)
+	return &HandlerSuccess{Status: http.StatusNoContent}, nil
+}
+
+// scheduleErasureTx confirms the erasure request of the user once prepare accepted it, and queues the
+// job carrying it out after the grace period. The request is nil when prepare refused.
+func (uh *UserHandler) scheduleErasureTx(ctx context.Context, userID int, prepare func(tx pgx.Tx) (bool, error)) (*erasureRequest, error) {
+	if uh.jobs == nil {
+		return nil, errors.New("no job queue to carry out the erasure")
+	}
+
+	var request *erasureRequest
+	err := repository.WithTx(ctx, uh.db, func(tx pgx.Tx) error {
+		ok, err := prepare(tx)
+		if err != nil || !ok {
+			return err
+		}
+
+		request = &erasureRequest{}
+		eraseAt := time.Now().Add(uh.cfg.ErasureGracePeriod)
+		query := `UPDATE erasure_requests SET confirmed_at = NOW(), erase_at = $2 WHERE user_id = $1
+			RETURNING user_id, requested_by, confirmed_at, erase_at, created_at;`
+		err = tx.QueryRow(ctx, query, userID, eraseAt).Scan(&request.UserID, &request.RequestedBy, &request.ConfirmedAt, &request.EraseAt, &request.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return uh.jobs.EnqueueAtTx(ctx, tx, erasureJob, map[string]int{"user_id": userID}, eraseAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// runErasure is the job anonymizing a user whose erasure is due. A cancelled or postponed erasure
+// leaves it with nothing to do.
+func (uh *UserHandler) runErasure(ctx context.Context, payload json.RawMessage) error {
+	var job struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	var actorID *int
+	query := `SELECT requested_by FROM erasure_requests WHERE user_id = $1 AND erase_at <= NOW();`
+	err := uh.db.QueryRow(ctx, query, job.UserID).Scan(&actorID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	actor := 0
+	if actorID != nil {
+		actor = *actorID
+	}
+	if err := uh.anonymize(ctx, actor, job.UserID); err != nil {
+		return fmt.Errorf("anonymizing user %d: %w", job.UserID, err)
+	}
+
+	uh.UserChanged.notify(ctx, job.UserID)
+	uh.Events.Publish(ctx, events.UserDeleted, events.UserRef{ID: job.UserID})
+	if err := uh.avatars.Delete(ctx, fmt.Sprintf("avatars/%d.png", job.UserID)); err != nil {
+		log.Printf("[UserHandler:runErasure] Error deleting the avatar of user %d: %v", job.UserID, err)
+	}
+	log.Printf("[UserHandler:runErasure] User %d anonymized", job.UserID)
+	return nil
+}
+
+// anonymize replaces the personal data of the user in one transaction: the account is deleted and its
+// name, email, password and avatar replaced, the devices and IPs of its sessions and the personal values
+// of its change history cleared, and the rows only holding personal data (profile values, notes, tags,
+// groups, pending email change, data exports) deleted. Invitations sent to its email get the new one.
+func (uh *UserHandler) anonymize(ctx context.Context, actorID, userID int) error {
+	return repository.WithActor(ctx, uh.db, actorID, func(tx pgx.Tx) error {
+		anonymous := "deleted-" + strconv.Itoa(userID) + "@anonymized.invalid"
+		encrypted, lookup, err := encryptEmail(anonymous, uh.cfg.PIIEncryptionKey)
+		if err != nil {
+			return err
+		}
+		var email string
+		err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 FOR UPDATE;`, userID).Scan(&email)
+		if err == nil {
+			email, err = pii.Decrypt(email, uh.cfg.PIIEncryptionKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		statements := []struct {
+			query string
+			args  []interface{}
+		}{
+			{`UPDATE users SET name = 'Deleted user', email = $2, email_lookup = $3, password = '', avatar_url = NULL,
+				deleted_at = COALESCE(deleted_at, NOW()), anonymized_at = NOW() WHERE id = $1;`, []interface{}{userID, encrypted, lookup}},
+			{`UPDATE sessions SET ip = '', user_agent = '', device_name = '', revoked_at = COALESCE(revoked_at, NOW()) WHERE user_id = $1;`, []interface{}{userID}},
+			{`UPDATE invites SET email = $2, email_lookup = $3 WHERE email_lookup = $1;`, []interface{}{pii.Lookup(email, uh.cfg.PIIEncryptionKey), encrypted, lookup}},
+			// After the users update, whose old values the trigger just recorded
+			{`UPDATE user_history SET old_values = old_values - $2::text[], new_values = new_values - $2::text[] WHERE user_id = $1;`, []interface{}{userID, repository.PersonalHistoryKeys}},
+			{`DELETE FROM user_profile_values WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM user_notes WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM user_tags WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM group_members WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM email_changes WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM data_exports WHERE user_id = $1;`, []interface{}{userID}},
+			{`DELETE FROM erasure_requests WHERE user_id = $1;`, []interface{}{userID}},
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement.query, statement.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// withETag tags a successful GET response with a hash of its body. When the client already
+// holds that version (If-None-Match) the body is dropped and 304 Not Modified is sent instead.
+func withETag(w http.ResponseWriter, r *http.Request, success *HandlerSuccess) *HandlerSuccess {
+	body, err := json.Marshal(success.Data)
+	if err != nil {
+		return success
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	// Each representation has its own tag
+	if format := negotiateFormat(r); format.name != jsonFormat.name {
+		etag = `"` + hex.EncodeToString(sum[:16]) + "-" + format.name + `"`
+	}
+
+	w.Header().Set("ETag", etag)
+	// Cached copies must be revalidated, the data changes without notice
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return &HandlerSuccess{Status: http.StatusNotModified, Data: nil}
+	}
+	return success
+}
+
+// etagMatches implements the weak comparison of If-None-Match (RFC 9110, 13.1.2)
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
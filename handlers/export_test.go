@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// The routes of UserHandler as UserRouter builds them, without its middlewares, for the tests of
+// package handlers_test
+
+func (uh *UserHandler) GetAllUsersRoute() ApiHandlerFunc {
+	return uh.getAllUsers
+}
+
+func (uh *UserHandler) GetUserRoute() ApiHandlerFunc {
+	return uh.getUser
+}
+
+func (uh *UserHandler) InsertUserRoute() ApiHandlerFunc {
+	return Handle(http.StatusCreated, uh.insertUser)
+}
+
+func (uh *UserHandler) UpdateUserRoute() ApiHandlerFunc {
+	return Handle(http.StatusOK, uh.updateUser)
+}
+
+func (uh *UserHandler) DeleteUserRoute() ApiHandlerFunc {
+	return uh.deleteUser
+}
+
+// The routes of GroupHandler, ProfileHandler, AdminHandler and AuthenticationHandler, the same way
+
+func (gh *GroupHandler) GetGroupsRoute() ApiHandlerFunc {
+	return gh.getGroups
+}
+
+func (gh *GroupHandler) CreateGroupRoute() ApiHandlerFunc {
+	return Handle(http.StatusCreated, gh.createGroup)
+}
+
+func (gh *GroupHandler) GetGroupRoute() ApiHandlerFunc {
+	return gh.getGroup
+}
+
+func (gh *GroupHandler) UpdateGroupRoute() ApiHandlerFunc {
+	return Handle(http.StatusOK, gh.updateGroup)
+}
+
+func (gh *GroupHandler) DeleteGroupRoute() ApiHandlerFunc {
+	return gh.deleteGroup
+}
+
+func (gh *GroupHandler) AddMemberRoute() ApiHandlerFunc {
+	return gh.addMember
+}
+
+func (gh *GroupHandler) RemoveMemberRoute() ApiHandlerFunc {
+	return gh.removeMember
+}
+
+func (gh *GroupHandler) GrantPermissionRoute() ApiHandlerFunc {
+	return gh.grantPermission
+}
+
+func (gh *GroupHandler) RevokePermissionRoute() ApiHandlerFunc {
+	return gh.revokePermission
+}
+
+func (ph *ProfileHandler) GetProfileRoute() ApiHandlerFunc {
+	return ph.getProfile
+}
+
+func (ph *ProfileHandler) UpdateProfileRoute() ApiHandlerFunc {
+	return Handle(http.StatusOK, ph.updateProfile)
+}
+
+func (ph *ProfileHandler) PutFieldRoute() ApiHandlerFunc {
+	return Handle(http.StatusOK, ph.putField)
+}
+
+func (ph *ProfileHandler) DeleteFieldRoute() ApiHandlerFunc {
+	return ph.deleteField
+}
+
+func (adh *AdminHandler) AddNoteRoute() ApiHandlerFunc {
+	return Handle(http.StatusCreated, adh.addNote)
+}
+
+func (adh *AdminHandler) GetNotesRoute() ApiHandlerFunc {
+	return adh.getNotes
+}
+
+func (adh *AdminHandler) AddTagRoute() ApiHandlerFunc {
+	return adh.addTag
+}
+
+func (adh *AdminHandler) RemoveTagRoute() ApiHandlerFunc {
+	return adh.removeTag
+}
+
+func (adh *AdminHandler) GrantRoleRoute() ApiHandlerFunc {
+	return adh.grantRole
+}
+
+func (adh *AdminHandler) RevokeRoleRoute() ApiHandlerFunc {
+	return adh.revokeRole
+}
+
+func (ah *AuthenticationHandler) ListSessionsRoute() ApiHandlerFunc {
+	return ah.listSessions
+}
+
+func (ah *AuthenticationHandler) RevokeSessionRoute() ApiHandlerFunc {
+	return ah.revokeSession
+}
+
+// The errors a CredentialVerifier returns
+var (
+	ErrInvalidCredentials      = errInvalidCredentials
+	ErrExternalAccountConflict = errExternalAccountConflict
+)
+
+// VerifierFunc is a CredentialVerifier returning the user of a repository record
+type VerifierFunc func(ctx context.Context, email, password string) (*repository.User, error)
+
+func (fn VerifierFunc) Verify(ctx context.Context, email, password string) (*user, error) {
+	u, err := fn(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return userFromRecord(u), nil
+}
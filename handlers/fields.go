@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Sparse fieldsets: any JSON response can be trimmed with ?fields=a,b,c. The selection applies to
+// the top level object, or to every object of a top level array or of the items of a page (see
+// pagination.go); other values are left alone.
+// Unknown field names are ignored, so clients can ask for fields that only some items have.
+
+// requestedFields returns the field names of the ?fields= parameter, nil when it is absent
+func requestedFields(r *http.Request) []string {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil
+	}
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// selectFields returns data with only the given fields kept
+func selectFields(data interface{}, fields []string) (interface{}, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	// Numbers are kept as json.Number so large ids survive the round trip
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		keep[field] = true
+	}
+
+	switch value := decoded.(type) {
+	case map[string]interface{}:
+		return pickFields(value, keep), nil
+	case []interface{}:
+		for i, item := range value {
+			if object, ok := item.(map[string]interface{}); ok {
+				value[i] = pickFields(object, keep)
+			}
+		}
+		return value, nil
+	default:
+		return decoded, nil
+	}
+}
+
+func pickFields(object map[string]interface{}, keep map[string]bool) map[string]interface{} {
+	for key := range object {
+		if !keep[key] {
+			delete(object, key)
+		}
+	}
+	return object
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GroupHandler manages user groups. Permissions granted to a group apply to every member,
+// on top of the permissions they get from their roles (see rbac.Resolver).
+type GroupHandler struct {
+	cfg    *config.Config
+	db     *pgxpool.Pool
+	groups repository.GroupRepository
+	users  repository.UserRepository
+}
+
+// Group Response Model
+type group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Members     []int     `json:"members"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Group Request Model
+type groupRequest struct {
+	Name        string `json:"name" validate:"required,max=50"`
+	Description string `json:"description"`
+}
+
+// groupRequest of the group of the path
+type updateGroupRequest struct {
+	ID int `path:"id"`
+	groupRequest
+}
+
+func NewGroupHandler(cfg *config.Config, db *pgxpool.Pool, groups repository.GroupRepository, users repository.UserRepository) *GroupHandler {
+	return &GroupHandler{cfg: cfg, db: db, groups: groups, users: users}
+}
+
+func groupFromRecord(g *repository.Group) *group {
+	return &group{ID: g.ID, Name: g.Name, Description: g.Description, Members: g.Members, Permissions: g.Permissions, CreatedAt: g.CreatedAt}
+}
+
+// Configuration of routes
+func (gh *GroupHandler) GroupRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(gh.db, gh.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(gh.db, gh.cfg.ProfileExemptRoutes)), MiddlewareAdapter(RequirePermission(rbac.GroupsManage)))
+
+	// Routes
+	r.HandleFunc("GET /", ApiHandlerAdapter(gh.getGroups))
+	r.HandleFunc("POST /", ApiHandlerAdapter(Handle(http.StatusCreated, gh.createGroup)))
+	r.HandleFunc("GET /{id}", ApiHandlerAdapter(gh.getGroup))
+	r.HandleFunc("PUT /{id}", ApiHandlerAdapter(Handle(http.StatusOK, gh.updateGroup)))
+	r.HandleFunc("DELETE /{id}", ApiHandlerAdapter(gh.deleteGroup))
+	r.HandleFunc("PUT /{id}/members/{userId}", ApiHandlerAdapter(gh.addMember))
+	r.HandleFunc("DELETE /{id}/members/{userId}", ApiHandlerAdapter(gh.removeMember))
+	r.HandleFunc("PUT /{id}/permissions/{permission}", ApiHandlerAdapter(gh.grantPermission))
+	r.HandleFunc("DELETE /{id}/permissions/{permission}", ApiHandlerAdapter(gh.revokePermission))
+
+	return r
+}
+
+// @Summary      List groups
+// @Description  Lists every group with its members and permissions
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} group
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups [get]
+func (gh *GroupHandler) getGroups(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[GroupHandler:getGroups] start")
+
+	records, err := gh.groups.List(r.Context())
+	if err != nil {
+		log.Printf("[GroupHandler:getGroups] Error querying groups: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	groups := []group{}
+	for i := range records {
+		groups = append(groups, *groupFromRecord(&records[i]))
+	}
+
+	log.Printf("[GroupHandler:getGroups] end. Took %v", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   groups,
+	}, nil
+}
+
+// @Summary      Create a group
+// @Description  Creates an empty group
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body groupRequest true "Group"
+// @Success      201 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups [post]
+func (gh *GroupHandler) createGroup(r *http.Request, groupReq *groupRequest) (*group, *apperrors.Error) {
+	log.Printf("[GroupHandler:createGroup] start")
+
+	if herr := groupReq.trimName(); herr != nil {
+		return nil, herr
+	}
+
+	created, err := gh.groups.Create(r.Context(), groupReq.Name, groupReq.Description)
+	if err != nil {
+		return nil, groupWriteError("createGroup", err)
+	}
+
+	return groupFromRecord(created), nil
+}
+
+// @Summary      Get a group
+// @Description  Returns a group with its members and permissions
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id} [get]
+func (gh *GroupHandler) getGroup(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	return gh.groupOf(r.Context(), id)
+}
+
+// @Summary      Update a group
+// @Description  Renames a group or changes its description
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Param        request body groupRequest true "Group"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id} [put]
+func (gh *GroupHandler) updateGroup(r *http.Request, groupReq *updateGroupRequest) (*group, *apperrors.Error) {
+	log.Printf("[GroupHandler:updateGroup] start")
+
+	if herr := groupReq.trimName(); herr != nil {
+		return nil, herr
+	}
+
+	updated, err := gh.groups.Update(r.Context(), groupReq.ID, groupReq.Name, groupReq.Description)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, groupNotFound(groupReq.ID)
+	}
+	if err != nil {
+		return nil, groupWriteError("updateGroup", err)
+	}
+
+	return groupFromRecord(updated), nil
+}
+
+// @Summary      Delete a group
+// @Description  Deletes a group. Its members lose the permissions granted through it
+// @Tags         groups
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Success      204
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id} [delete]
+func (gh *GroupHandler) deleteGroup(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[GroupHandler:deleteGroup] start")
+
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	err := gh.groups.Delete(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, groupNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:deleteGroup] Error deleting group: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	return &HandlerSuccess{Status: http.StatusNoContent}, nil
+}
+
+// @Summary      Add a group member
+// @Description  Adds a user to a group. Adding an existing member is a no-op
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Param        userId path int true "User ID"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id}/members/{userId} [put]
+func (gh *GroupHandler) addMember(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[GroupHandler:addMember] start")
+
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	userIDStr := chi.URLParam(r, "userId")
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		return nil, apperrors.BadRequest("Path parameter 'userId' must be an integer")
+	}
+
+	exists, err := gh.users.Exists(r.Context(), userID)
+	if err == nil && !exists {
+		return nil, apperrors.NotFound("User with id " + userIDStr + " not found")
+	}
+	if err == nil {
+		err = gh.groups.AddMember(r.Context(), id, userID)
+	}
+	if errors.Is(err, repository.ErrInvalidReference) {
+		return nil, groupNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:addMember] Error adding user %d to group %d: %v", userID, id, err)
+		return nil, apperrors.Internal()
+	}
+
+	return gh.groupOf(r.Context(), id)
+}
+
+// @Summary      Remove a group member
+// @Description  Removes a user from a group
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Param        userId path int true "User ID"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id}/members/{userId} [delete]
+func (gh *GroupHandler) removeMember(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[GroupHandler:removeMember] start")
+
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	userIDStr := chi.URLParam(r, "userId")
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		return nil, apperrors.BadRequest("Path parameter 'userId' must be an integer")
+	}
+
+	err = gh.groups.RemoveMember(r.Context(), id, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, apperrors.NotFound("User " + userIDStr + " is not a member of group " + strconv.Itoa(id))
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:removeMember] Error removing user %s from group %d: %v", userIDStr, id, err)
+		return nil, apperrors.Internal()
+	}
+
+	return gh.groupOf(r.Context(), id)
+}
+
+// @Summary      Grant a permission to a group
+// @Description  Grants a permission to every member of the group. Granting it twice is a no-op
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Param        permission path string true "Permission name, e.g. users:list"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id}/permissions/{permission} [put]
+func (gh *GroupHandler) grantPermission(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[GroupHandler:grantPermission] start")
+
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	permission := chi.URLParam(r, "permission")
+
+	err := gh.groups.GrantPermission(r.Context(), id, permission)
+	if errors.Is(err, repository.ErrInvalidReference) {
+		return nil, groupNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:grantPermission] Error granting %s to group %d: %v", permission, id, err)
+		return nil, apperrors.Internal()
+	}
+
+	res, herr := gh.groupOf(r.Context(), id)
+	if herr != nil {
+		return nil, herr
+	}
+	if !containsString(res.Data.(*group).Permissions, permission) {
+		return nil, apperrors.NotFound("Permission " + permission + " not found")
+	}
+	return res, nil
+}
+
+// @Summary      Revoke a permission from a group
+// @Description  Revokes a permission from the group. Members keep it if one of their roles grants it
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Group ID"
+// @Param        permission path string true "Permission name, e.g. users:list"
+// @Success      200 {object} group
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /groups/{id}/permissions/{permission} [delete]
+func (gh *GroupHandler) revokePermission(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[GroupHandler:revokePermission] start")
+
+	id, herr := groupIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	permission := chi.URLParam(r, "permission")
+
+	err := gh.groups.RevokePermission(r.Context(), id, permission)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, apperrors.NotFound("Group " + strconv.Itoa(id) + " has no permission " + permission)
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:revokePermission] Error revoking %s from group %d: %v", permission, id, err)
+		return nil, apperrors.Internal()
+	}
+
+	return gh.groupOf(r.Context(), id)
+}
+
+func (gh *GroupHandler) groupOf(ctx context.Context, id int) (*HandlerSuccess, *apperrors.Error) {
+	g, herr := gh.groupByID(ctx, id)
+	if herr != nil {
+		return nil, herr
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: g}, nil
+}
+
+func (gh *GroupHandler) groupByID(ctx context.Context, id int) (*group, *apperrors.Error) {
+	g, err := gh.groups.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, groupNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[GroupHandler:groupByID] Error querying group %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	return groupFromRecord(g), nil
+}
+
+// trimName drops the spaces around the name, which must not be blank
+func (groupReq *groupRequest) trimName() *apperrors.Error {
+	groupReq.Name = strings.TrimSpace(groupReq.Name)
+	if groupReq.Name == "" {
+		return apperrors.InvalidFields("name is required", map[string]string{"name": "is required"})
+	}
+	return nil
+}
+
+func groupWriteError(method string, err error) *apperrors.Error {
+	log.Printf("[GroupHandler:%s] Error writing group: %v", method, err)
+	return apperrors.FromError(err)
+}
+
+func groupIDParam(r *http.Request) (int, *apperrors.Error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+	return id, nil
+}
+
+func groupNotFound(id int) *apperrors.Error {
+	return apperrors.NotFound("Group with id " + strconv.Itoa(id) + " not found")
+}
@@ -0,0 +1,386 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/repository/mocks"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+var support = repository.Group{ID: 5, Name: "support", Description: "Support team", Members: []int{2}, Permissions: []string{rbac.UsersList}}
+
+// groupBody is the JSON of a group answered by the /groups routes
+type groupBody struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []int    `json:"members"`
+	Permissions []string `json:"permissions"`
+}
+
+func newGroupHandler(groups *mocks.GroupRepository, users *mocks.UserRepository) *handlers.GroupHandler {
+	return handlers.NewGroupHandler(&config.Config{}, nil, groups, users)
+}
+
+// getSupport is the GetFunc of a repository holding the support group only
+func getSupport(ctx context.Context, id int) (*repository.Group, error) {
+	if id != support.ID {
+		return nil, repository.ErrNotFound
+	}
+	g := support
+	return &g, nil
+}
+
+// adminRequest returns a request of the admin, with the path parameters given as name and value pairs.
+// The handlers are called without their router, which checks the permissions.
+func adminRequest(t *testing.T, method, target string, body interface{}, params ...string) *http.Request {
+	r := testutil.AuthRequest(t, admin, method, target, body)
+	for i := 0; i+1 < len(params); i += 2 {
+		r = testutil.WithPathParam(r, params[i], params[i+1])
+	}
+	return testutil.AsUser(r, admin)
+}
+
+func TestCreateGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   interface{}
+		create func(ctx context.Context, name, description string) (*repository.Group, error)
+		status int
+		code   string
+		field  string
+		calls  []string
+	}{
+		{
+			name: "created",
+			body: map[string]string{"name": "  support ", "description": "Support team"},
+			create: func(ctx context.Context, name, description string) (*repository.Group, error) {
+				if name != "support" {
+					return nil, errors.New("name not trimmed: " + name)
+				}
+				return &repository.Group{ID: 5, Name: name, Description: description}, nil
+			},
+			status: http.StatusCreated,
+			calls:  []string{"Create"},
+		},
+		{name: "missing name", body: map[string]string{"description": "Support team"}, status: http.StatusBadRequest, code: "E400", field: "name"},
+		{name: "blank name", body: map[string]string{"name": "   "}, status: http.StatusBadRequest, code: "E400", field: "name"},
+		{name: "name too long", body: map[string]string{"name": strings.Repeat("s", 51)}, status: http.StatusBadRequest, code: "E400", field: "name"},
+		{
+			name: "name taken",
+			body: map[string]string{"name": "support"},
+			create: func(ctx context.Context, name, description string) (*repository.Group, error) {
+				return nil, &repository.Error{Kind: repository.ErrConflict, Constraint: "groups_name_key"}
+			},
+			status: http.StatusConflict,
+			code:   "E409",
+			calls:  []string{"Create"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{CreateFunc: tt.create}
+			r := adminRequest(t, http.MethodPost, "/groups", tt.body)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).CreateGroupRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got groupBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.ID != 5 || got.Name != "support" {
+					t.Errorf("group = %+v", got)
+				}
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "found", id: "5", status: http.StatusOK, calls: []string{"Get"}},
+		{name: "id not a number", id: "abc", status: http.StatusBadRequest, code: "E400"},
+		{name: "not found", id: "99", status: http.StatusNotFound, code: "E404", calls: []string{"Get"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{GetFunc: getSupport}
+			r := adminRequest(t, http.MethodGet, "/groups/"+tt.id, nil, "id", tt.id)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).GetGroupRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got groupBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.ID != support.ID || len(got.Members) != 1 || len(got.Permissions) != 1 {
+					t.Errorf("group = %+v, want %+v", got, support)
+				}
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestUpdateGroup(t *testing.T) {
+	update := func(ctx context.Context, id int, name, description string) (*repository.Group, error) {
+		switch {
+		case id != support.ID:
+			return nil, repository.ErrNotFound
+		case name == "admins":
+			return nil, &repository.Error{Kind: repository.ErrConflict, Constraint: "groups_name_key"}
+		}
+		g := support
+		g.Name, g.Description = name, description
+		return &g, nil
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		body   interface{}
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "renamed", id: "5", body: map[string]string{"name": "helpdesk"}, status: http.StatusOK, calls: []string{"Update"}},
+		{name: "id not a number", id: "abc", body: map[string]string{"name": "helpdesk"}, status: http.StatusBadRequest, code: "E400"},
+		{name: "blank name", id: "5", body: map[string]string{"name": " "}, status: http.StatusBadRequest, code: "E400"},
+		{name: "name taken", id: "5", body: map[string]string{"name": "admins"}, status: http.StatusConflict, code: "E409", calls: []string{"Update"}},
+		{name: "not found", id: "99", body: map[string]string{"name": "helpdesk"}, status: http.StatusNotFound, code: "E404", calls: []string{"Update"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{UpdateFunc: update}
+			r := adminRequest(t, http.MethodPut, "/groups/"+tt.id, tt.body, "id", tt.id)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).UpdateGroupRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got groupBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.Name != "helpdesk" {
+					t.Errorf("group = %+v", got)
+				}
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		err    error // returned by Delete
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "deleted", id: "5", status: http.StatusNoContent, calls: []string{"Delete"}},
+		{name: "id not a number", id: "abc", status: http.StatusBadRequest, code: "E400"},
+		{name: "not found", id: "99", err: repository.ErrNotFound, status: http.StatusNotFound, code: "E404", calls: []string{"Delete"}},
+		{name: "database error", id: "5", err: errors.New("connection reset"), status: http.StatusInternalServerError, code: "E500", calls: []string{"Delete"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{DeleteFunc: func(ctx context.Context, id int) error { return tt.err }}
+			r := adminRequest(t, http.MethodDelete, "/groups/"+tt.id, nil, "id", tt.id)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).DeleteGroupRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestAddMember(t *testing.T) {
+	exists := func(ctx context.Context, id int) (bool, error) { return id == alice.ID, nil }
+	add := func(ctx context.Context, id, userID int) error {
+		if id != support.ID {
+			return &repository.Error{Kind: repository.ErrInvalidReference, Constraint: "group_members_group_id_fkey"}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		id, userID string
+		status     int
+		code       string
+		userCalls  []string
+		groupCalls []string
+	}{
+		{name: "added", id: "5", userID: "2", status: http.StatusOK, userCalls: []string{"Exists"}, groupCalls: []string{"AddMember", "Get"}},
+		{name: "user id not a number", id: "5", userID: "abc", status: http.StatusBadRequest, code: "E400"},
+		{name: "user not found", id: "5", userID: "99", status: http.StatusNotFound, code: "E404", userCalls: []string{"Exists"}},
+		{name: "group not found", id: "99", userID: "2", status: http.StatusNotFound, code: "E404", userCalls: []string{"Exists"}, groupCalls: []string{"AddMember"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ExistsFunc: exists}
+			groups := &mocks.GroupRepository{AddMemberFunc: add, GetFunc: getSupport}
+			r := adminRequest(t, http.MethodPut, "/groups/"+tt.id+"/members/"+tt.userID, nil, "id", tt.id, "userId", tt.userID)
+
+			rec := testutil.Serve(newGroupHandler(groups, users).AddMemberRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, users, tt.userCalls)
+			assertCalls(t, groups, tt.groupCalls)
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	remove := func(ctx context.Context, id, userID int) error {
+		if id != support.ID || userID != alice.ID {
+			return repository.ErrNotFound
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		id, userID string
+		status     int
+		code       string
+		calls      []string
+	}{
+		{name: "removed", id: "5", userID: "2", status: http.StatusOK, calls: []string{"RemoveMember", "Get"}},
+		{name: "id not a number", id: "abc", userID: "2", status: http.StatusBadRequest, code: "E400"},
+		{name: "not a member", id: "5", userID: "3", status: http.StatusNotFound, code: "E404", calls: []string{"RemoveMember"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{RemoveMemberFunc: remove, GetFunc: getSupport}
+			r := adminRequest(t, http.MethodDelete, "/groups/"+tt.id+"/members/"+tt.userID, nil, "id", tt.id, "userId", tt.userID)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).RemoveMemberRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestGrantGroupPermission(t *testing.T) {
+	grant := func(ctx context.Context, id int, permission string) error {
+		if id != support.ID {
+			return &repository.Error{Kind: repository.ErrInvalidReference, Constraint: "group_permissions_group_id_fkey"}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		permission string
+		status     int
+		code       string
+		calls      []string
+	}{
+		// support has users:list
+		{name: "granted", id: "5", permission: rbac.UsersList, status: http.StatusOK, calls: []string{"GrantPermission", "Get"}},
+		{name: "id not a number", id: "abc", permission: rbac.UsersList, status: http.StatusBadRequest, code: "E400"},
+		{name: "group not found", id: "99", permission: rbac.UsersList, status: http.StatusNotFound, code: "E404", calls: []string{"GrantPermission"}},
+		{name: "unknown permission", id: "5", permission: "nothing:here", status: http.StatusNotFound, code: "E404", calls: []string{"GrantPermission", "Get"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{GrantPermissionFunc: grant, GetFunc: getSupport}
+			r := adminRequest(t, http.MethodPut, "/groups/"+tt.id+"/permissions/"+tt.permission, nil, "id", tt.id, "permission", tt.permission)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).GrantPermissionRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
+
+func TestRevokeGroupPermission(t *testing.T) {
+	revoke := func(ctx context.Context, id int, permission string) error {
+		if id != support.ID || permission != rbac.UsersList {
+			return repository.ErrNotFound
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		permission string
+		status     int
+		code       string
+		calls      []string
+	}{
+		{name: "revoked", id: "5", permission: rbac.UsersList, status: http.StatusOK, calls: []string{"RevokePermission", "Get"}},
+		{name: "id not a number", id: "abc", permission: rbac.UsersList, status: http.StatusBadRequest, code: "E400"},
+		{name: "not granted", id: "5", permission: rbac.UsersDelete, status: http.StatusNotFound, code: "E404", calls: []string{"RevokePermission"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &mocks.GroupRepository{RevokePermissionFunc: revoke, GetFunc: getSupport}
+			r := adminRequest(t, http.MethodDelete, "/groups/"+tt.id+"/permissions/"+tt.permission, nil, "id", tt.id, "permission", tt.permission)
+
+			rec := testutil.Serve(newGroupHandler(groups, nil).RevokePermissionRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, groups, tt.calls)
+		})
+	}
+}
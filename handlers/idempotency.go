@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// Set on answers replayed from a previous request with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// IdempotencyMiddleware lets clients retry a POST safely: the first request sent with an Idempotency-Key
+// header runs normally and its successful answer is stored, retries with the same key and body get that
+// answer back without running the handler again. Keys are kept for ttl, failed requests don't keep theirs.
+// Answers holding secrets are replayed without them (see idempotentAnswer).
+// It wraps the handler directly (not through MiddlewareAdapter) because it needs the handler's answer,
+// and must run after JWTAuthMiddleware on authenticated routes so keys are scoped by caller.
+func IdempotencyMiddleware(db *pgxpool.Pool, ttl time.Duration) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(w, r)
+			}
+			if len(key) > 255 {
+				return nil, apperrors.BadRequest(IdempotencyKeyHeader + " must be at most 255 characters")
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return nil, apperrors.PayloadTooLarge("Request body is too large")
+				}
+				return nil, apperrors.InvalidBody("Could not read the request body")
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			requestHash := hex.EncodeToString(sum[:])
+
+			userID, _ := r.Context().Value(ContextUserIDKey).(int)
+			scope := r.Method + " " + r.URL.Path + " " + strconv.Itoa(userID)
+
+			// Claim the key, or take it over when the stored one expired
+			var claimed bool
+			err = db.QueryRow(r.Context(), `INSERT INTO idempotency_keys (scope, key, request_hash) VALUES ($1, $2, $3)
+				ON CONFLICT (scope, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = NULL, response = NULL, created_at = NOW()
+				WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $4)
+				RETURNING true;`, scope, key, requestHash, ttl.Seconds()).Scan(&claimed)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("[Middleware:IdempotencyMiddleware] Error claiming key %s: %v", key, err)
+				return nil, apperrors.Internal()
+			}
+
+			if !claimed {
+				return replayIdempotent(w, r, db, scope, key, requestHash)
+			}
+
+			success, herr := next(w, r)
+			if herr != nil || success == nil {
+				// Let the client retry with the same key once it fixed the request
+				if _, err := db.Exec(r.Context(), `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2;`, scope, key); err != nil {
+					log.Printf("[Middleware:IdempotencyMiddleware] Error releasing key %s: %v", key, err)
+				}
+				return success, herr
+			}
+
+			stored := success.Data
+			if answer, ok := stored.(idempotentAnswer); ok {
+				stored = answer.idempotentAnswer()
+			}
+			response, err := json.Marshal(stored)
+			if err == nil {
+				_, err = db.Exec(r.Context(), `UPDATE idempotency_keys SET status = $3, response = $4 WHERE scope = $1 AND key = $2;`, scope, key, success.Status, response)
+			}
+			if err != nil {
+				log.Printf("[Middleware:IdempotencyMiddleware] Error storing the answer of key %s: %v", key, err)
+			}
+			return success, nil
+		}
+	}
+}
+
+// idempotentAnswer is implemented by the answers holding secrets, like tokens, that must not be stored
+// for the retries. Its result is stored and replayed instead of the answer.
+type idempotentAnswer interface {
+	idempotentAnswer() interface{}
+}
+
+// replayIdempotent answers a retry with the stored answer of the first request
+func replayIdempotent(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, scope, key, requestHash string) (*HandlerSuccess, *apperrors.Error) {
+	var storedHash string
+	var status *int
+	var response []byte
+	err := db.QueryRow(r.Context(), `SELECT request_hash, status, response FROM idempotency_keys WHERE scope = $1 AND key = $2;`, scope, key).Scan(&storedHash, &status, &response)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The first request failed and released the key in the meantime
+			return nil, apperrors.Conflict("The request with this " + IdempotencyKeyHeader + " failed, retry it")
+		}
+		log.Printf("[Middleware:IdempotencyMiddleware] Error reading key %s: %v", key, err)
+		return nil, apperrors.Internal()
+	}
+
+	if storedHash != requestHash {
+		return nil, apperrors.Unprocessable(IdempotencyKeyHeader + " was already used with a different request body")
+	}
+	if status == nil {
+		return nil, apperrors.Conflict("A request with this " + IdempotencyKeyHeader + " is still being processed")
+	}
+
+	log.Printf("[Middleware:IdempotencyMiddleware] Replaying answer of key %s", key)
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	return &HandlerSuccess{Status: *status, Data: json.RawMessage(response)}, nil
+}
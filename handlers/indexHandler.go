@@ -1,24 +1,91 @@
 package handlers
 
-import "net/http"
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long the readiness probe waits for the database
+const readinessTimeout = 2 * time.Second
 
 type IndexHandler struct {
+	db *pgxpool.Pool
 }
 
-func NewIndexHandler() *IndexHandler {
-	return &IndexHandler{}
+func NewIndexHandler(db *pgxpool.Pool) *IndexHandler {
+	return &IndexHandler{db: db}
 }
 
 type healthResponse struct {
 	Health string `json:"health"`
 }
 
+// Readiness Response Model
+type readinessResponse struct {
+	Ready            bool   `json:"ready"`
+	Database         string `json:"database"`                    // "up" or "down"
+	MigrationVersion *int64 `json:"migration_version,omitempty"` // last applied migration
+	MigrationDirty   bool   `json:"migration_dirty"`             // a migration failed half way
+}
+
 // @Summary Health check endpoint
 // @Description Checks if the API is up and running
 // @Tags index
 // @Produce json
 // @Success 200 {object} healthResponse
 // @Router / [get]
-func (ih *IndexHandler) HealthCheck(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (ih *IndexHandler) HealthCheck(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	return &HandlerSuccess{Status: http.StatusOK, Data: healthResponse{Health: "Alive"}}, nil
 }
+
+// @Summary Liveness probe
+// @Description Answers as long as the process serves requests. It checks no dependency, so a database outage doesn't get the API restarted
+// @Tags index
+// @Produce json
+// @Success 200 {object} healthResponse
+// @Router /healthz [get]
+func (ih *IndexHandler) Liveness(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	return &HandlerSuccess{Status: http.StatusOK, Data: healthResponse{Health: "Alive"}}, nil
+}
+
+// @Summary Readiness probe
+// @Description Pings the database and reads the migration status. Answers 503 when the database is unreachable or the last migration failed, so no traffic is sent to this instance
+// @Tags index
+// @Produce json
+// @Success 200 {object} readinessResponse
+// @Failure 503 {object} readinessResponse
+// @Router /readyz [get]
+func (ih *IndexHandler) Readiness(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	res := readinessResponse{Database: "up"}
+	if err := ih.db.Ping(ctx); err != nil {
+		log.Printf("[IndexHandler:Readiness] Database ping failed: %v", err)
+		res.Database = "down"
+		return &HandlerSuccess{Status: http.StatusServiceUnavailable, Data: res}, nil
+	}
+
+	// schema_migrations is the table golang-migrate keeps its state in
+	var version int64
+	err := ih.db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1;`).Scan(&version, &res.MigrationDirty)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Printf("[IndexHandler:Readiness] Error reading migration status: %v", err)
+		return &HandlerSuccess{Status: http.StatusServiceUnavailable, Data: res}, nil
+	}
+	if err == nil {
+		res.MigrationVersion = &version
+	}
+
+	res.Ready = err == nil && !res.MigrationDirty
+	if !res.Ready {
+		return &HandlerSuccess{Status: http.StatusServiceUnavailable, Data: res}, nil
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: res}, nil
+}
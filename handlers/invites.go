@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/servertiming"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Invitations let admins create accounts on deployments where open registration is turned off
+// (REGISTRATION_MODE=invite_only). The link mailed to the invitee carries a signed token naming
+// the invite row; the row makes the invite single-use and revocable.
+
+// Invite Response Model
+type invite struct {
+	ID         int        `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  *int       `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Invite Request Model
+type inviteRequest struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+	Role  string `json:"role" validate:"max=50"` // defaults to "user"
+}
+
+type acceptInviteRequest struct {
+	Token      string `json:"token" validate:"required"`
+	Name       string `json:"name" validate:"required,max=100"`
+	Password   string `json:"password" validate:"required,max=72"`      // bcrypt ignores what comes after 72 bytes
+	DeviceName string `json:"device_name,omitempty" validate:"max=100"` // optional, shown in the session listing
+}
+
+const inviteColumns = `i.id, i.email, r.name, i.invited_by, i.expires_at, i.accepted_at, i.revoked_at, i.created_at`
+
+// scanInvite reads a row of inviteColumns into inv, with the email decrypted
+func scanInvite(row pgx.Row, inv *invite, piiKey []byte) error {
+	err := row.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.RevokedAt, &inv.CreatedAt)
+	if err != nil {
+		return err
+	}
+	inv.Email, err = pii.Decrypt(inv.Email, piiKey)
+	return err
+}
+
+// registrationOpen tells if POST /register is allowed. REGISTRATION_MODE=invite_only turns it off.
+func registrationOpen(cfg *config.Config) bool {
+	return cfg.RegistrationMode != "invite_only"
+}
+
+// Invite tokens are signed with a key derived from JWT_SECRET, so they can never pass as access tokens
+func inviteSigningKey(secret []byte) []byte {
+	return append([]byte("invite:"), secret...)
+}
+
+func signInviteToken(inv *invite, jwtCfg config.JWT) (string, error) {
+	claims := jwt.MapClaims{
+		"jti":   strconv.Itoa(inv.ID),
+		"email": inv.Email,
+		"exp":   inv.ExpiresAt.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(inviteSigningKey(jwtCfg.Keys.Secret()))
+}
+
+// parseInviteToken returns the invite id and email of a valid, unexpired token
+func parseInviteToken(tokenString string, jwtCfg config.JWT) (int, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return verificationKeys(jwtCfg.Keys.Secrets(), inviteSigningKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, "", err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	email, _ := claims["email"].(string)
+	id, err := strconv.Atoi(jti)
+	if err != nil || email == "" {
+		return 0, "", fmt.Errorf("malformed invite token")
+	}
+	return id, email, nil
+}
+
+// @Summary      Invite a user
+// @Description  Mails a signed invitation link to the email. Accepting it creates the account with the given role (default "user"). The link is valid 7 days
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body inviteRequest true "Invite"
+// @Success      201 {object} invite
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/invites [post]
+func (adh *AdminHandler) createInvite(r *http.Request, inviteReq *inviteRequest) (*invite, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AdminHandler:createInvite] start")
+
+	if inviteReq.Role == "" {
+		inviteReq.Role = rbac.RoleUser
+	}
+
+	var taken bool
+	err := adh.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE email_lookup = $1 AND deleted_at IS NULL);`, pii.Lookup(inviteReq.Email, adh.cfg.PIIEncryptionKey)).Scan(&taken)
+	if err == nil && !taken {
+		taken, err = emailBlockedByDeletedAccount(r.Context(), adh.db, adh.cfg.EmailReusePolicy, adh.cfg.PIIEncryptionKey, inviteReq.Email)
+	}
+	if err == nil && taken {
+		return nil, apperrors.Conflict("Email is not available. Please use a different email.")
+	}
+
+	inviterID, _ := r.Context().Value(ContextUserIDKey).(int)
+	inv := &invite{}
+	var encrypted, lookup string
+	if err == nil {
+		encrypted, lookup, err = encryptEmail(inviteReq.Email, adh.cfg.PIIEncryptionKey)
+	}
+	if err == nil {
+		query := `WITH i AS (
+				INSERT INTO invites (email, email_lookup, role_id, invited_by, expires_at)
+				SELECT $1, $2, id, $4, $5 FROM roles WHERE name = $3
+				RETURNING *
+			)
+			SELECT ` + inviteColumns + ` FROM i JOIN roles r ON r.id = i.role_id;`
+		row := adh.db.QueryRow(r.Context(), query, encrypted, lookup, inviteReq.Role, inviterID, time.Now().Add(adh.cfg.InviteTTL))
+		err = scanInvite(row, inv, adh.cfg.PIIEncryptionKey)
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.NotFound("Role " + inviteReq.Role + " not found")
+		}
+	}
+
+	var token string
+	if err == nil {
+		token, err = signInviteToken(inv, adh.cfg.JWT)
+	}
+	if err == nil {
+		inviter, _ := r.Context().Value(ContextUsernameKey).(string)
+		err = mailer.SendTemplate(r.Context(), adh.mailer, inv.Email, "invite", map[string]interface{}{
+			"Inviter": inviter,
+			"URL":     adh.cfg.InviteURL + "?token=" + token,
+			"TTL":     humanDuration(adh.cfg.InviteTTL),
+		})
+	}
+	if err != nil {
+		log.Printf("[AdminHandler:createInvite] Error creating invite: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	log.Printf("[AdminHandler:createInvite] end. Took %v", time.Since(start))
+	return inv, nil
+}
+
+// @Summary      List invites
+// @Description  Lists the invites, most recent first
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} invite
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/invites [get]
+func (adh *AdminHandler) getInvites(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	rows, err := adh.db.Query(r.Context(), `SELECT `+inviteColumns+` FROM invites i JOIN roles r ON r.id = i.role_id ORDER BY i.created_at DESC;`)
+	if err != nil {
+		log.Printf("[AdminHandler:getInvites] Error querying invites: %v", err)
+		return nil, apperrors.Internal()
+	}
+	defer rows.Close()
+
+	invites := []invite{}
+	for rows.Next() {
+		var inv invite
+		if err := scanInvite(rows, &inv, adh.cfg.PIIEncryptionKey); err != nil {
+			log.Printf("[AdminHandler:getInvites] Error scanning invite: %v", err)
+			return nil, apperrors.Internal()
+		}
+		invites = append(invites, inv)
+	}
+
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   invites,
+	}, nil
+}
+
+// @Summary      Revoke an invite
+// @Description  Revokes a pending invite, its link stops working
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        id path int true "Invite ID"
+// @Success      204
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/invites/{id} [delete]
+func (adh *AdminHandler) revokeInvite(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AdminHandler:revokeInvite] start")
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+
+	result, err := adh.db.Exec(r.Context(), `UPDATE invites SET revoked_at = NOW() WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL;`, id)
+	if err != nil {
+		log.Printf("[AdminHandler:revokeInvite] Error revoking invite %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	if result.RowsAffected() == 0 {
+		return nil, apperrors.NotFound("No pending invite with id " + idStr)
+	}
+
+	return &HandlerSuccess{Status: http.StatusNoContent, Data: nil}, nil
+}
+
+// @Summary      Accept an invite
+// @Description  Creates the invited account with the token of the invitation link and returns a JWT
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body acceptInviteRequest true "Invite token and account info"
+// @Success      201 {object} authResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/invites/accept [post]
+func (ah *AuthenticationHandler) acceptInvite(r *http.Request, acceptReq *acceptInviteRequest) (*authResponse, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AuthenticationHandler:acceptInvite] start")
+
+	inviteID, email, err := parseInviteToken(acceptReq.Token, ah.Config.JWT)
+	if err != nil {
+		log.Printf("[AuthenticationHandler:acceptInvite] Rejected invite token: %v", err)
+		return nil, invalidInvite()
+	}
+
+	stopTiming := servertiming.Track(r.Context(), "bcrypt")
+	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(acceptReq.Password), bcrypt.DefaultCost)
+	stopTiming()
+	if err != nil {
+		log.Printf("[AuthenticationHandler:acceptInvite] Error hashing password: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	var newUser *user
+	var tokens Tokens
+	err = repository.WithTx(r.Context(), ah.DB, func(tx pgx.Tx) (err error) {
+		newUser, err = createInvitedUser(r.Context(), tx, ah.Config.PIIEncryptionKey, inviteID, email, acceptReq.Name, encryptedPassword)
+		if err != nil {
+			return err
+		}
+		tokens, err = ah.createJwtToken(r.Context(), repository.OpenSessionWith(tx), ClientOf(r), newUser, acceptReq.DeviceName)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, invalidInvite()
+		}
+		log.Printf("[AuthenticationHandler:acceptInvite] Error creating invited user: %v", err)
+		return nil, apperrors.FromError(err)
+	}
+	ah.UserChanged.notify(r.Context(), newUser.ID)
+	ah.Events.Publish(r.Context(), events.UserCreated, newUser.event())
+	metrics.ObserveRegistration(metrics.RegistrationInvite)
+
+	log.Printf("[AuthenticationHandler:acceptInvite] end in %s", time.Since(start))
+	return newAuthResponse("Account created successfully", tokens), nil
+}
+
+// createInvitedUser consumes the invite and creates the user with the invite's role, in the caller's
+// transaction. It returns pgx.ErrNoRows when the invite is not pending anymore.
+func createInvitedUser(ctx context.Context, tx pgx.Tx, piiKey []byte, inviteID int, email, name string, password []byte) (*user, error) {
+	var roleID int
+	var role string
+	query := `UPDATE invites i SET accepted_at = NOW() FROM roles r
+		WHERE i.id = $1 AND i.email_lookup = $2 AND r.id = i.role_id
+		AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > NOW()
+		RETURNING i.role_id, r.name;`
+	encrypted, lookup, err := encryptEmail(email, piiKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(ctx, query, inviteID, lookup).Scan(&roleID, &role); err != nil {
+		return nil, err
+	}
+
+	u := &user{Name: name, Email: email, Roles: []string{role}}
+	query = `INSERT INTO users (name, email, email_lookup, password) VALUES ($1, $2, $3, $4) RETURNING id;`
+	if err := tx.QueryRow(ctx, query, name, encrypted, lookup, password).Scan(&u.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2);`, u.ID, roleID); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func invalidInvite() *apperrors.Error {
+	return apperrors.BadRequest("The invitation link is invalid, expired, revoked or already used")
+}
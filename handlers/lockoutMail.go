@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LockoutMailer is a subscriber of events.LoginLocked telling the owner of the account that its
+// sign-ins are blocked. Lockouts of emails without an account send nothing.
+func LockoutMailer(cfg *config.Config, db *pgxpool.Pool, m mailer.Mailer) events.Handler {
+	return func(ctx context.Context, e events.Event) {
+		lockout, ok := e.Data.(events.Lockout)
+		if !ok {
+			return
+		}
+
+		var name string
+		err := db.QueryRow(ctx, `SELECT name FROM users WHERE email_lookup = $1 AND deleted_at IS NULL;`, pii.Lookup(lockout.Email, cfg.PIIEncryptionKey)).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return
+		}
+		if err == nil {
+			err = mailer.SendTemplate(ctx, m, lockout.Email, "lockout", map[string]interface{}{
+				"Name":  name,
+				"IP":    lockout.IP,
+				"Until": lockout.Until.Format(time.RFC1123),
+			})
+		}
+		if err != nil {
+			log.Printf("[LockoutMailer] Error mailing the lockout of {email: %s}: %v", lockout.Email, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// Detail of the maintenance error when no message was given
+const defaultMaintenanceMessage = "The API is down for maintenance. Try again later"
+
+// Maintenance is the maintenance mode switch of the instance. While it is on MaintenanceMiddleware answers
+// the requests with a 503, so migrations or an incident can be handled without requests changing data.
+type Maintenance struct {
+	mu    sync.RWMutex
+	state maintenanceState
+}
+
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	// When the mode was last turned on or off
+	Since time.Time `json:"since"`
+}
+
+type maintenanceRequest struct {
+	Enabled *bool  `json:"enabled" validate:"required"`
+	Message string `json:"message"`
+}
+
+func NewMaintenance(enabled bool, message string) *Maintenance {
+	m := &Maintenance{}
+	m.Set(enabled, message)
+	return m
+}
+
+// Set turns the maintenance mode on or off, message is the detail of the errors answered meanwhile
+func (m *Maintenance) Set(enabled bool, message string) {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state.Enabled != enabled || m.state.Since.IsZero() {
+		m.state.Since = time.Now()
+	}
+	m.state.Enabled, m.state.Message = enabled, message
+}
+
+func (m *Maintenance) current() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// MaintenanceMiddleware answers 503 with the maintenance message while the mode is on. The probes and the
+// routes turning the mode off must be left out of it.
+func MaintenanceMiddleware(m *Maintenance) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if state := m.current(); state.Enabled {
+				return nil, apperrors.Maintenance(state.Message)
+			}
+			return next(w, r)
+		}
+	}
+}
+
+// @Summary      Get the maintenance mode
+// @Description  Tells if the maintenance mode is on, with the message answered meanwhile (Admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} maintenanceState
+// @Failure      403 {object} apperrors.Response
+// @Router       /admin/maintenance [get]
+func (adh *AdminHandler) getMaintenance(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	if adh.Maintenance == nil {
+		return nil, apperrors.Internal()
+	}
+	state := adh.Maintenance.current()
+	return &HandlerSuccess{Status: http.StatusOK, Data: &state}, nil
+}
+
+// @Summary      Turn the maintenance mode on or off
+// @Description  While the maintenance mode is on every route but the probes, /metrics and this one answers 503 with the code E503 and the given message. It only applies to the instance serving the request, set MAINTENANCE_MODE and reload the configuration to switch every instance (Admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body maintenanceRequest true "enabled is required, message is optional"
+// @Success      200 {object} maintenanceState
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Router       /admin/maintenance [put]
+func (adh *AdminHandler) setMaintenance(r *http.Request, req *maintenanceRequest) (*maintenanceState, *apperrors.Error) {
+	if adh.Maintenance == nil {
+		return nil, apperrors.Internal()
+	}
+
+	adh.Maintenance.Set(*req.Enabled, strings.TrimSpace(req.Message))
+	author, _ := r.Context().Value(ContextUsernameKey).(string)
+	log.Printf("[AdminHandler:setMaintenance] Maintenance mode set to %t by %q", *req.Enabled, author)
+
+	state := adh.Maintenance.current()
+	return &state, nil
+}
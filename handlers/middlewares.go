@@ -2,58 +2,258 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/accesslog"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/errreport"
+	"github.com/hi-im-yan/jwt-with-go/jwe"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/paseto"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type contextKey string
 
 const (
-	ContextUsernameKey = contextKey("username")
-	ContextRoleKey     = contextKey("role")
+	ContextUsernameKey    = contextKey("username")
+	ContextRolesKey       = contextKey("roles")
+	ContextUserIDKey      = contextKey("user_id")
+	ContextSessionIDKey   = contextKey("session_id")
+	ContextPermissionsKey = contextKey("permissions")
 )
 
-func OnlyAdminMiddleware(next ApiHandlerFunc) ApiHandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
-		// Get the role from the context
-		role := r.Context().Value(ContextRoleKey).(string)
-		if role != "admin" {
-			return nil, &HandlerError{Status: http.StatusForbidden, Message: ErrorResponse{Code: "E403", Message: "Forbidden", Detail: "You are not an admin"}}
+// PermissionsFrom returns the permissions AuthenticateToken resolved for the current user
+func PermissionsFrom(ctx context.Context) rbac.Permissions {
+	perms, _ := ctx.Value(ContextPermissionsKey).(rbac.Permissions)
+	return perms
+}
+
+// QueryTimeoutMiddleware bounds the time a request can spend in the database. Handlers run their queries
+// with the request context, which gets cancelled after timeout or as soon as the client goes away,
+// and pgx then aborts the running query.
+func QueryTimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequirePermission only lets the request through when the authenticated user holds the permission.
+// It must run after JWTAuthMiddleware, which resolves the permissions once per request.
+func RequirePermission(permission string) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if !PermissionsFrom(r.Context()).Has(permission) {
+				return nil, apperrors.Forbidden("Missing permission " + permission)
+			}
+			return next(w, r)
 		}
-		return next(w, r)
 	}
 }
 
-func JWTAuthMiddleware(next ApiHandlerFunc) ApiHandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
-		authHeader := r.Header.Get("Authorization")
+// OwnerOrAdminMiddleware lets the request through when the {id} path parameter is the authenticated user
+// (the token's sub claim), or when the user holds the permission to act on other users.
+// It must run after JWTAuthMiddleware.
+func OwnerOrAdminMiddleware(permission string) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			id, err := strconv.Atoi(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
+			}
+			userID, _ := r.Context().Value(ContextUserIDKey).(int)
+			if id != userID && !PermissionsFrom(r.Context()).Has(permission) {
+				return nil, apperrors.Forbidden("You are not authorized to access another user than yourself")
+			}
+			return next(w, r)
+		}
+	}
+}
 
-		// Check if the Authorization header is present
-		if authHeader == "" {
-			return nil, &HandlerError{Status: http.StatusUnauthorized, Message: ErrorResponse{Code: "E401", Message: "Unauthorized", Detail: "Missing token"}}
+// ProfileCompletionMiddleware answers 428 when the user has not filled in every required profile field.
+// Paths starting with one of the exempt prefixes (config.ProfileExemptRoutes) stay reachable, so users
+// can complete their profile (or log out). It must run after JWTAuthMiddleware.
+func ProfileCompletionMiddleware(db *pgxpool.Pool, exempt []string) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if herr := CheckProfileComplete(r.Context(), db, exempt, r.URL.Path); herr != nil {
+				return nil, herr
+			}
+			return next(w, r)
 		}
+	}
+}
 
-		// Token should be in the format: "Bearer <Token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			return nil, &HandlerError{Status: http.StatusUnauthorized, Message: ErrorResponse{Code: "E401", Message: "Unauthorized", Detail: "Invalid token format"}}
+// CheckProfileComplete is the check of ProfileCompletionMiddleware for the authenticated user of ctx,
+// route being the REST path of the operation matched against the exempt prefixes. The gRPC and GraphQL
+// APIs call it with the path of the REST route their operation runs.
+func CheckProfileComplete(ctx context.Context, db *pgxpool.Pool, exempt []string, route string) *apperrors.Error {
+	for _, prefix := range exempt {
+		if strings.HasPrefix(route, prefix) {
+			return nil
 		}
+	}
+
+	userID, _ := ctx.Value(ContextUserIDKey).(int)
+	missing, err := missingProfileFields(ctx, db, userID)
+	if err != nil {
+		log.Printf("[Middleware:CheckProfileComplete] Error checking profile of user %d: %v", userID, err)
+		return apperrors.Internal()
+	}
+	if len(missing) > 0 {
+		return apperrors.ProfileIncomplete("Complete the required profile fields (" + strings.Join(missing, ", ") + ") with PUT /profile")
+	}
+	return nil
+}
+
+// JWTAuthMiddleware verifies the bearer token, checks that its session was not revoked
+// and resolves the user's permissions for RequirePermission
+func JWTAuthMiddleware(db *pgxpool.Pool, jwtCfg config.JWT) ApiMiddlewareFunc {
+	resolver := rbac.NewResolver(db)
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			authHeader := r.Header.Get("Authorization")
 
-		// Verify the token
-		tokenSting := parts[1]
-		claims, err := VerifyJwtToken(tokenSting)
-		if err != nil {
-			return nil, &HandlerError{Status: http.StatusUnauthorized, Message: ErrorResponse{Code: "E401", Message: "Unauthorized", Detail: "Invalid token"}}
+			// Check if the Authorization header is present
+			if authHeader == "" {
+				return nil, apperrors.Unauthorized("Missing token")
+			}
+
+			// Verify the token
+			ctx, herr := authenticateHeader(r.Context(), db, resolver, jwtCfg, authHeader)
+			if herr != nil {
+				return nil, herr
+			}
+
+			return next(w, r.WithContext(ctx))
 		}
+	}
+}
 
-		// Get the username and role from the claims and store them in the request context
-		ctx := context.WithValue(r.Context(), ContextUsernameKey, claims["username"].(string))
-		ctx = context.WithValue(ctx, ContextRoleKey, claims["role"].(string))
+// OptionalJWTAuthMiddleware is JWTAuthMiddleware for routes also open to anonymous clients, like /graphql:
+// requests without an Authorization header go through without claims, the handler decides what they can do.
+// A header with an invalid token is still rejected.
+func OptionalJWTAuthMiddleware(db *pgxpool.Pool, jwtCfg config.JWT) ApiMiddlewareFunc {
+	resolver := rbac.NewResolver(db)
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+				ctx, herr := authenticateHeader(r.Context(), db, resolver, jwtCfg, authHeader)
+				if herr != nil {
+					return nil, herr
+				}
+				r = r.WithContext(ctx)
+			}
+			return next(w, r)
+		}
+	}
+}
+
+// TokenRejected is called for each token failing verification, set by the server for the security alerts.
+// The token checks are plain functions shared by every router, so it can't be a handler field.
+var TokenRejected func(ctx context.Context)
+
+// tokenRejected counts the verification error err and reports it to TokenRejected. Expired tokens are
+// part of normal use and are not reported, a bad signature or a malformed token is.
+func tokenRejected(ctx context.Context, err error) {
+	metrics.ObserveTokenFailure(tokenFailureReason(err))
+	if TokenRejected == nil || errors.Is(err, jwt.ErrTokenExpired) {
+		return
+	}
+	TokenRejected(ctx)
+}
 
-		r = r.WithContext(ctx)
-		next(w, r)
+// tokenFailureReason is the metrics.Token* reason of the verification error err
+func tokenFailureReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return metrics.TokenExpired
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return metrics.TokenSignature
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwe.ErrInvalid), errors.Is(err, paseto.ErrInvalid):
+		return metrics.TokenMalformed
+	}
+	return metrics.TokenInvalid
+}
+
+// authenticateHeader runs AuthenticateToken on the token of an Authorization header
+func authenticateHeader(ctx context.Context, db *pgxpool.Pool, resolver *rbac.Resolver, jwtCfg config.JWT, authHeader string) (context.Context, *apperrors.Error) {
+	// Token should be in the format: "Bearer <Token>"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		metrics.ObserveTokenFailure(metrics.TokenMalformed)
+		return nil, apperrors.Unauthorized("Invalid token format")
+	}
+	return AuthenticateToken(ctx, db, resolver, jwtCfg, parts[1])
+}
 
-		return &HandlerSuccess{Status: http.StatusOK, Data: nil}, nil
+// AuthenticateToken verifies a token, checks that its session was not revoked and resolves the user's
+// permissions. The returned context holds the claims and permissions under the Context*Key keys.
+// It is shared by the JWT middlewares and the gRPC interceptors.
+func AuthenticateToken(ctx context.Context, db *pgxpool.Pool, resolver *rbac.Resolver, jwtCfg config.JWT, tokenString string) (context.Context, *apperrors.Error) {
+	claims, err := VerifyJwtToken(tokenString, jwtCfg)
+	if err != nil {
+		tokenRejected(ctx, err)
+		return nil, apperrors.Unauthorized("Invalid token")
 	}
 
+	username, _ := claims["username"].(string)
+	var roles []string
+	if claimRoles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range claimRoles {
+			if name, ok := role.(string); ok {
+				roles = append(roles, name)
+			}
+		}
+	}
+	sub, _ := claims["sub"].(string)
+	sid, _ := claims["sid"].(float64)
+	userID, err := strconv.Atoi(sub)
+	if err != nil || sid == 0 {
+		metrics.ObserveTokenFailure(metrics.TokenInvalid)
+		return nil, apperrors.Unauthorized("Invalid token")
+	}
+
+	// Check the session is still active
+	sessionID := int64(sid)
+	active, err := sessionActive(ctx, db, sessionID)
+	if err != nil {
+		log.Printf("[Middleware:AuthenticateToken] Error checking session %d: %v", sessionID, err)
+		return nil, apperrors.Internal()
+	}
+	if !active {
+		metrics.ObserveTokenFailure(metrics.TokenRevoked)
+		return nil, apperrors.Unauthorized("Session expired or revoked")
+	}
+
+	// Permissions come from the database so role changes apply without a new token
+	perms, err := resolver.Resolve(ctx, userID)
+	if err != nil {
+		log.Printf("[Middleware:AuthenticateToken] Error resolving permissions of user %d: %v", userID, err)
+		return nil, apperrors.Internal()
+	}
+
+	errreport.SetUser(ctx, userID)
+	accesslog.SetUser(ctx, userID)
+
+	// Store the claims in the context
+	ctx = context.WithValue(ctx, ContextUsernameKey, username)
+	ctx = context.WithValue(ctx, ContextRolesKey, roles)
+	ctx = context.WithValue(ctx, ContextUserIDKey, userID)
+	ctx = context.WithValue(ctx, ContextSessionIDKey, sessionID)
+	ctx = context.WithValue(ctx, ContextPermissionsKey, perms)
+	return ctx, nil
 }
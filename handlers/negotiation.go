@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hi-im-yan/jwt-with-go/msgpack"
+)
+
+// The adapters answer in the format asked by the Accept header: JSON (the default), XML or MessagePack.
+// Both are transcoded from the JSON of the response, so they carry the same fields under the same names:
+//
+//	<response><id>1</id><roles><item>admin</item></roles></response>
+//
+// XML documents have a <response> root, array elements are <item> and keys that aren't XML names are
+// written <entry key="...">. Request bodies are JSON whatever the Accept header.
+
+// responseFormat is a representation the adapters can answer with
+type responseFormat struct {
+	name        string
+	contentType string
+	encode      func(w io.Writer, v interface{}) error
+}
+
+var (
+	jsonFormat = responseFormat{name: "json", contentType: "application/json", encode: func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	}}
+	xmlFormat     = responseFormat{name: "xml", contentType: "application/xml", encode: encodeXML}
+	msgpackFormat = responseFormat{name: "msgpack", contentType: "application/msgpack", encode: func(w io.Writer, v interface{}) error {
+		body, err := msgpack.Marshal(v)
+		if err == nil {
+			_, err = w.Write(body)
+		}
+		return err
+	}}
+)
+
+// Media types of the Accept header, the aliases included
+var acceptedFormats = map[string]responseFormat{
+	"application/json":        jsonFormat,
+	"application/xml":         xmlFormat,
+	"text/xml":                xmlFormat,
+	"application/msgpack":     msgpackFormat,
+	"application/x-msgpack":   msgpackFormat,
+	"application/vnd.msgpack": msgpackFormat,
+}
+
+// negotiateFormat returns the format of the Accept header of r with the highest quality, JSON when it
+// names none of them
+func negotiateFormat(r *http.Request) responseFormat {
+	best, bestQ := jsonFormat, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		format, ok := acceptedFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		// On a tie the first one listed wins
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// writeBody answers status with body in the format negotiated for r. A nil body only sends the status.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	writeFormatted(w, r, status, body, negotiateFormat(r))
+}
+
+// writeProblem answers p in the format negotiated for r, with the problem media type of RFC 7807
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	format := negotiateFormat(r)
+	switch format.name {
+	case jsonFormat.name:
+		format.contentType = "application/problem+json"
+	case xmlFormat.name:
+		format.contentType = "application/problem+xml"
+		format.encode = func(w io.Writer, v interface{}) error {
+			return encodeXMLElement(w, v, xml.StartElement{Name: xml.Name{Space: "urn:ietf:rfc:7807", Local: "problem"}})
+		}
+	}
+	writeFormatted(w, r, p.Status, p, format)
+}
+
+func writeFormatted(w http.ResponseWriter, r *http.Request, status int, body interface{}, format responseFormat) {
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	if body == nil {
+		return
+	}
+	// The status is sent already, a failure can only be logged
+	if err := format.encode(w, body); err != nil {
+		log.Printf("[APIHandler:writeBody] Error encoding the %s response of request %s: %v", format.name, RequestID(r.Context()), err)
+	}
+}
+
+// XML element names, the reserved xml prefix left out
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// encodeXML writes v as the JSON encoding sees it, in a <response> element
+func encodeXML(w io.Writer, v interface{}) error {
+	return encodeXMLElement(w, v, xml.StartElement{Name: xml.Name{Local: "response"}})
+}
+
+// encodeXMLElement writes v as the JSON encoding sees it, in the root element
+func encodeXMLElement(w io.Writer, v interface{}, root xml.StartElement) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := writeXMLValue(enc, dec, root); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// writeXMLValue writes the next JSON value of dec as the element start
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, start xml.StartElement) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch token {
+	case json.Delim('['):
+		for dec.More() {
+			if err := writeXMLValue(enc, dec, xml.StartElement{Name: xml.Name{Local: "item"}}); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // ]
+			return err
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			child := xml.StartElement{Name: xml.Name{Local: key}}
+			if !xmlNamePattern.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "xml") {
+				child = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}}
+			}
+			if err := writeXMLValue(enc, dec, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // }
+			return err
+		}
+	case nil:
+		// An empty element
+	default:
+		var text string
+		switch v := token.(type) {
+		case string:
+			text = v
+		case json.Number:
+			text = v.String()
+		case bool:
+			text = strconv.FormatBool(v)
+		}
+		if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
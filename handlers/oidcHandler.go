@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/oauth2"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	EmailClaim   string
+	NameClaim    string
+	GroupsClaim  string
+	AdminGroup   string // members of this group also get the admin role
+}
+
+// OIDCHandler is a relying party for any OpenID Connect provider (Keycloak, Okta, Azure AD...).
+// It runs the authorization code flow with PKCE and, once the provider vouches for the user,
+// answers with this API's own JWT so the rest of the routes don't need to know about SSO.
+type OIDCHandler struct {
+	DB       *pgxpool.Pool
+	cfg      OIDCConfig
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	tokens   *AuthenticationHandler
+}
+
+type oidcLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// NewOIDCHandler fetches the provider discovery document, so it needs network access to the issuer
+func NewOIDCHandler(ctx context.Context, db *pgxpool.Pool, cfg OIDCConfig, tokens *AuthenticationHandler) (*OIDCHandler, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "name"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	return &OIDCHandler{
+		DB:  db,
+		cfg: cfg,
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       cfg.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		tokens:   tokens,
+	}, nil
+}
+
+func (oh *OIDCHandler) OIDCRouter() http.Handler {
+	r := chi.NewRouter()
+
+	r.HandleFunc("GET /login", ApiHandlerAdapter(oh.login))
+	r.HandleFunc("GET /callback", ApiHandlerAdapter(oh.callback))
+	return r
+}
+
+// @Summary      Start OIDC single sign-on
+// @Description  Redirects to the configured OpenID Connect provider. The authorization URL is also returned in the body for clients that don't follow redirects.
+// @Tags         auth
+// @Produce      json
+// @Success      302 {object} oidcLoginResponse
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/oidc/login [get]
+func (oh *OIDCHandler) login(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	state, errState := randomToken()
+	nonce, errNonce := randomToken()
+	if errState != nil || errNonce != nil {
+		log.Printf("[OIDCHandler:login] Error generating random values: %v %v", errState, errNonce)
+		return nil, apperrors.Internal()
+	}
+	pkceVerifier := oauth2.GenerateVerifier()
+
+	// The flow values travel in a short-lived cookie, so no server side storage is needed
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    strings.Join([]string{state, nonce, pkceVerifier}, "."),
+		Path:     "/auth/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := oh.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(pkceVerifier))
+	w.Header().Set("Location", authURL)
+	return &HandlerSuccess{Status: http.StatusFound, Data: &oidcLoginResponse{AuthorizationURL: authURL}}, nil
+}
+
+// @Summary      OIDC callback
+// @Description  Exchanges the authorization code, validates the ID token, provisions the local user and returns this API's JWT
+// @Tags         auth
+// @Produce      json
+// @Param        code  query string true "Authorization code"
+// @Param        state query string true "State returned by the provider"
+// @Success      200 {object} authResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      401 {object} apperrors.Response "Invalid ID token or unverified email"
+// @Failure      409 {object} apperrors.Response "Email of another account"
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/oidc/callback [get]
+func (oh *OIDCHandler) callback(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[OIDCHandler:callback] start")
+
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, apperrors.BadRequest("Login flow expired or was not started. Try again")
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: "/auth/oidc", MaxAge: -1})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || r.URL.Query().Get("state") != parts[0] {
+		return nil, apperrors.BadRequest("Invalid state")
+	}
+	nonce, pkceVerifier := parts[1], parts[2]
+
+	if providerErr := r.URL.Query().Get("error"); providerErr != "" {
+		log.Printf("[OIDCHandler:callback] Provider returned error: %s", providerErr)
+		return nil, apperrors.Unauthorized("Identity provider denied the login: " + providerErr)
+	}
+
+	oauth2Token, err := oh.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(pkceVerifier))
+	if err != nil {
+		log.Printf("[OIDCHandler:callback] Error exchanging code: %v", err)
+		return nil, apperrors.Unauthorized("Could not exchange authorization code")
+	}
+
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		log.Printf("[OIDCHandler:callback] Token response has no id_token")
+		return nil, apperrors.Unauthorized("Identity provider did not return an ID token")
+	}
+
+	idToken, err := oh.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		log.Printf("[OIDCHandler:callback] Error verifying ID token: %v", err)
+		return nil, apperrors.Unauthorized("Invalid ID token")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("[OIDCHandler:callback] Error parsing claims: %v", err)
+		return nil, apperrors.Unauthorized("Invalid ID token")
+	}
+
+	email, _ := claims[oh.cfg.EmailClaim].(string)
+	if email == "" {
+		return nil, apperrors.Unauthorized("ID token has no " + oh.cfg.EmailClaim + " claim")
+	}
+	// Anyone can put any address in their provider profile, only a verified one says who they are
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		log.Printf("[OIDCHandler:callback] Email %s of %s is not verified", email, idToken.Subject)
+		return nil, apperrors.Unauthorized("The identity provider has not verified this email")
+	}
+	name, _ := claims[oh.cfg.NameClaim].(string)
+	if name == "" {
+		name = email
+	}
+	roles := []string{rbac.RoleUser}
+	if oh.cfg.AdminGroup != "" && claimContains(claims[oh.cfg.GroupsClaim], oh.cfg.AdminGroup) {
+		roles = append(roles, rbac.RoleAdmin)
+	}
+
+	identity := externalIdentity{Issuer: idToken.Issuer, Subject: idToken.Subject}
+	u, err := provisionExternalUser(r.Context(), oh.DB, oh.tokens.UserChanged, oh.tokens.Config.EmailReusePolicy, oh.tokens.Config.PIIEncryptionKey, identity, name, email, roles)
+	if err != nil {
+		log.Printf("[OIDCHandler:callback] Error provisioning user: %v", err)
+		if errors.Is(err, errInvalidCredentials) {
+			return nil, apperrors.Unauthorized("This account was deleted")
+		}
+		if errors.Is(err, errExternalAccountConflict) {
+			return nil, apperrors.Conflict("An account already uses this email. Sign in with its password")
+		}
+		return nil, apperrors.Internal()
+	}
+
+	tokens, err := oh.tokens.CreateJwtToken(r, u, "")
+	if err != nil {
+		log.Printf("[OIDCHandler:callback] Error creating JWT token: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	log.Printf("[OIDCHandler:callback] end in %s", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   newAuthResponse("Login successful", tokens),
+	}, nil
+}
+
+// claimContains handles group claims sent either as a list or as a single string
+func claimContains(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Paginated lists embed pageQuery in their input (see Handle) and answer a listPage built by newPage:
+//
+//	{"items": [...], "total": 42, "page": 2, "per_page": 20, "next": "/admin/emails?page=3&per_page=20", "prev": "/admin/emails?page=1&per_page=20"}
+//
+// ?fields= applies to the items.
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageQuery is the page asked for in the query, the first one of defaultPerPage items by default
+type pageQuery struct {
+	Page    int `query:"page" validate:"omitempty,min=1"`
+	PerPage int `query:"per_page" validate:"omitempty,min=1,max=100"`
+}
+
+// page returns the page number and size asked for, the defaults filled in
+func (q pageQuery) page() (number, size int) {
+	number, size = q.Page, q.PerPage
+	if number == 0 {
+		number = 1
+	}
+	if size == 0 {
+		size = defaultPerPage
+	}
+	return number, min(size, maxPerPage)
+}
+
+// limit and offset of the SQL query of the page
+func (q pageQuery) limit() int {
+	_, size := q.page()
+	return size
+}
+
+func (q pageQuery) offset() int {
+	number, size := q.page()
+	return (number - 1) * size
+}
+
+// Page Response Model of the paginated lists
+type listPage struct {
+	Items   interface{} `json:"items" swaggertype:"array,object"`
+	Total   int         `json:"total"` // number of items of every page
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	// Path and query of the next and previous pages, null on the last and first ones
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+// newPage returns the page q asked for in r: items, out of total. The links keep the other parameters
+// of the query.
+func newPage[T any](r *http.Request, q pageQuery, items []T, total int) *listPage {
+	number, size := q.page()
+	if items == nil {
+		items = []T{}
+	}
+	p := &listPage{Items: items, Total: total, Page: number, PerPage: size}
+	if number*size < total {
+		p.Next = pageLink(r, number+1, size)
+	}
+	if number > 1 {
+		// Past the end the previous link goes back to the last page
+		last := max(1, (total+size-1)/size)
+		p.Prev = pageLink(r, min(number-1, last), size)
+	}
+	return p
+}
+
+func pageLink(r *http.Request, number, size int) *string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(number))
+	query.Set("per_page", strconv.Itoa(size))
+	link := r.URL.Path + "?" + query.Encode()
+	return &link
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// With ERROR_FORMAT=problem the adapters answer errors with RFC 7807 problem details instead of the
+// error object, as application/problem+json (application/problem+xml when XML is asked for):
+//
+//	{"type": "about:blank", "title": "Not found", "status": 404, "detail": "User with id 7 not found",
+//	 "instance": "/users/7", "code": "E404", "request_id": "1b7a..."}
+//
+// The problem is the whole body, the response envelope doesn't wrap it.
+
+type problemTypeBaseKey struct{}
+
+// Problem Response Model, the error body of ERROR_FORMAT=problem
+type problem struct {
+	// Prefix of ERROR_TYPE_BASE_URL followed by the code, about:blank without it
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// The extension members, the same as in the error object
+	Code      string            `json:"code"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// ProblemDetailsMiddleware answers the errors of the requests it handles as problem details. The type
+// of a problem is typeBaseURL followed by its code, about:blank when typeBaseURL is empty.
+func ProblemDetailsMiddleware(typeBaseURL string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), problemTypeBaseKey{}, typeBaseURL)))
+		})
+	}
+}
+
+// asProblem returns the problem details of message when they are turned on for r
+func asProblem(r *http.Request, status int, message *apperrors.Response) (*problem, bool) {
+	typeBase, ok := r.Context().Value(problemTypeBaseKey{}).(string)
+	if !ok {
+		return nil, false
+	}
+	p := &problem{
+		Type:      "about:blank",
+		Title:     message.Message,
+		Status:    status,
+		Detail:    message.Detail,
+		Instance:  r.URL.Path,
+		Code:      message.Code,
+		Fields:    message.Fields,
+		RequestID: message.RequestID,
+	}
+	if typeBase != "" {
+		p.Type = typeBase + message.Code
+	}
+	return p, true
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ProfileHandler manages extra profile fields. Admins define the fields and can mark them as required;
+// users missing a required field are stopped by ProfileCompletionMiddleware until they fill it in.
+type ProfileHandler struct {
+	cfg      *config.Config
+	db       *pgxpool.Pool
+	profiles repository.ProfileRepository
+}
+
+// Profile Field Response Model
+type profileField struct {
+	Key      string `json:"key"`
+	Label    string `json:"label"`
+	Required bool   `json:"required"`
+	Value    string `json:"value,omitempty"`
+}
+
+// Profile Field Request Model
+type profileFieldRequest struct {
+	Label    string `json:"label" validate:"required,max=100"`
+	Required bool   `json:"required"`
+}
+
+// profileFieldRequest of the field of the path
+type putProfileFieldRequest struct {
+	Key string `path:"key"`
+	profileFieldRequest
+}
+
+type profileResponse struct {
+	Fields  []profileField `json:"fields"`
+	Missing []string       `json:"missing"`
+}
+
+// Profile Request Model, maps field keys to values
+type profileRequest struct {
+	Values map[string]string `json:"values" validate:"required,min=1"`
+}
+
+func NewProfileHandler(cfg *config.Config, db *pgxpool.Pool, profiles repository.ProfileRepository) *ProfileHandler {
+	return &ProfileHandler{cfg: cfg, db: db, profiles: profiles}
+}
+
+// Configuration of the routes used by users to complete their own profile
+func (ph *ProfileHandler) ProfileRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(ph.db, ph.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(ph.db, ph.cfg.ProfileExemptRoutes)))
+
+	// Routes
+	r.HandleFunc("GET /", ApiHandlerAdapter(ph.getProfile))
+	r.HandleFunc("PUT /", ApiHandlerAdapter(Handle(http.StatusOK, ph.updateProfile)))
+
+	return r
+}
+
+// Configuration of the admin routes used to manage the fields
+func (ph *ProfileHandler) ProfileFieldsRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(ph.db, ph.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(ph.db, ph.cfg.ProfileExemptRoutes)), MiddlewareAdapter(RequirePermission(rbac.ProfileFields)))
+
+	// Routes
+	r.HandleFunc("GET /", ApiHandlerAdapter(ph.getFields))
+	r.HandleFunc("PUT /{key}", ApiHandlerAdapter(Handle(http.StatusOK, ph.putField)))
+	r.HandleFunc("DELETE /{key}", ApiHandlerAdapter(ph.deleteField))
+
+	return r
+}
+
+// @Summary      Get my profile
+// @Description  Returns every profile field with the value of the authenticated user and the required fields still missing
+// @Tags         profile
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} profileResponse
+// @Failure      401 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /profile [get]
+func (ph *ProfileHandler) getProfile(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+	profile, err := profileOf(r.Context(), ph.profiles, userID)
+	if err != nil {
+		log.Printf("[ProfileHandler:getProfile] Error querying profile: %v", err)
+		return nil, apperrors.Internal()
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: profile}, nil
+}
+
+// @Summary      Complete my profile
+// @Description  Sets values of profile fields for the authenticated user. An empty value clears the field
+// @Tags         profile
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body profileRequest true "Field values by key"
+// @Success      200 {object} profileResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /profile [put]
+func (ph *ProfileHandler) updateProfile(r *http.Request, profileReq *profileRequest) (*profileResponse, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[ProfileHandler:updateProfile] start")
+
+	// All the values are saved or none, an unknown field doesn't leave the others half applied
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+	if err := ph.profiles.SetValues(r.Context(), userID, profileReq.Values); err != nil {
+		log.Printf("[ProfileHandler:updateProfile] Error saving fields: %v", err)
+		var unknown *repository.UnknownFieldError
+		if errors.As(err, &unknown) {
+			return nil, apperrors.InvalidBody("Unknown profile field " + unknown.Key)
+		}
+		return nil, apperrors.Internal()
+	}
+
+	profile, err := profileOf(r.Context(), ph.profiles, userID)
+	if err != nil {
+		log.Printf("[ProfileHandler:updateProfile] Error querying profile: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	log.Printf("[ProfileHandler:updateProfile] end. Took %v", time.Since(start))
+	return profile, nil
+}
+
+// @Summary      List profile fields
+// @Description  Lists the profile fields users can fill in
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} profileField
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/profile-fields [get]
+func (ph *ProfileHandler) getFields(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	profile, err := profileOf(r.Context(), ph.profiles, 0)
+	if err != nil {
+		log.Printf("[ProfileHandler:getFields] Error querying fields: %v", err)
+		return nil, apperrors.Internal()
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: profile.Fields}, nil
+}
+
+// @Summary      Create or update a profile field
+// @Description  Defines a profile field. Marking it as required makes users without a value complete their profile before using the API
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        key path string true "Field key"
+// @Param        request body profileFieldRequest true "Field definition"
+// @Success      200 {object} profileField
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/profile-fields/{key} [put]
+func (ph *ProfileHandler) putField(r *http.Request, fieldReq *putProfileFieldRequest) (*profileField, *apperrors.Error) {
+	log.Printf("[ProfileHandler:putField] start")
+
+	key := fieldReq.Key
+	if !tagPattern.MatchString(key) {
+		return nil, apperrors.BadRequest("Keys must be 1-50 lowercase letters, digits, '-', '_' or ':'")
+	}
+
+	log.Printf("[ProfileHandler:putField] Saving field %s with {required: %t}", key, fieldReq.Required)
+	field, err := ph.profiles.PutField(r.Context(), key, fieldReq.Label, fieldReq.Required)
+	if err != nil {
+		log.Printf("[ProfileHandler:putField] Error saving field: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	return &profileField{Key: field.Key, Label: field.Label, Required: field.Required}, nil
+}
+
+// @Summary      Delete a profile field
+// @Description  Deletes a profile field and every value users gave for it
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        key path string true "Field key"
+// @Success      204
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/profile-fields/{key} [delete]
+func (ph *ProfileHandler) deleteField(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	key := chi.URLParam(r, "key")
+
+	err := ph.profiles.DeleteField(r.Context(), key)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, apperrors.NotFound("Profile field " + key + " not found")
+	}
+	if err != nil {
+		log.Printf("[ProfileHandler:deleteField] Error deleting field: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	return &HandlerSuccess{Status: http.StatusNoContent, Data: nil}, nil
+}
+
+// profileOf lists every field with the user's values (no values for user 0)
+func profileOf(ctx context.Context, profiles repository.ProfileRepository, userID int) (*profileResponse, error) {
+	fields, err := profiles.Fields(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &profileResponse{Fields: []profileField{}, Missing: []string{}}
+	for _, f := range fields {
+		if f.Required && f.Value == "" {
+			res.Missing = append(res.Missing, f.Key)
+		}
+		res.Fields = append(res.Fields, profileField{Key: f.Key, Label: f.Label, Required: f.Required, Value: f.Value})
+	}
+	return res, nil
+}
+
+// missingProfileFields returns the keys of the required fields the user did not fill in
+func missingProfileFields(ctx context.Context, db *pgxpool.Pool, userID int) ([]string, error) {
+	query := `SELECT f.key FROM profile_fields f
+		LEFT JOIN user_profile_values v ON v.field_key = f.key AND v.user_id = $1
+		WHERE f.required AND v.value IS NULL
+		ORDER BY f.key;`
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		missing = append(missing, key)
+	}
+	return missing, rows.Err()
+}
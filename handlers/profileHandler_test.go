@@ -0,0 +1,209 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/repository/mocks"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+// profileBody is the JSON of a profile answered by the /profile routes
+type profileBody struct {
+	Fields []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"fields"`
+	Missing []string `json:"missing"`
+}
+
+func newProfileHandler(profiles *mocks.ProfileRepository) *handlers.ProfileHandler {
+	return handlers.NewProfileHandler(&config.Config{}, nil, profiles)
+}
+
+// companyFields is the FieldsFunc of a repository with a required company field and an optional
+// phone field, alice having given the phone only
+func companyFields(ctx context.Context, userID int) ([]repository.ProfileField, error) {
+	fields := []repository.ProfileField{
+		{Key: "company", Label: "Company", Required: true},
+		{Key: "phone", Label: "Phone"},
+	}
+	if userID == alice.ID {
+		fields[1].Value = "555-0100"
+	}
+	return fields, nil
+}
+
+// aliceRequest returns a request of alice, with the path parameters given as name and value pairs
+func aliceRequest(t *testing.T, method, target string, body interface{}, params ...string) *http.Request {
+	u := testutil.User{ID: alice.ID, Name: alice.Name, Roles: alice.Roles, SessionID: 20}
+	r := testutil.AuthRequest(t, u, method, target, body)
+	for i := 0; i+1 < len(params); i += 2 {
+		r = testutil.WithPathParam(r, params[i], params[i+1])
+	}
+	return testutil.AsUser(r, u)
+}
+
+func TestGetProfile(t *testing.T) {
+	profiles := &mocks.ProfileRepository{FieldsFunc: companyFields}
+	r := aliceRequest(t, http.MethodGet, "/profile", nil)
+
+	rec := testutil.Serve(newProfileHandler(profiles).GetProfileRoute(), r)
+
+	testutil.AssertStatus(t, rec, http.StatusOK)
+	var got profileBody
+	testutil.DecodeJSON(t, rec, &got)
+	if len(got.Fields) != 2 || got.Fields[1].Value != "555-0100" {
+		t.Errorf("fields = %+v, want the phone of alice", got.Fields)
+	}
+	if len(got.Missing) != 1 || got.Missing[0] != "company" {
+		t.Errorf("missing = %v, want [company]", got.Missing)
+	}
+	assertCalls(t, profiles, []string{"Fields"})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   interface{}
+		err    error // returned by SetValues
+		status int
+		code   string
+		field  string
+		calls  []string
+	}{
+		{name: "saved", body: map[string]interface{}{"values": map[string]string{"company": "Acme"}}, status: http.StatusOK, calls: []string{"SetValues", "Fields"}},
+		{name: "missing values", body: map[string]interface{}{}, status: http.StatusBadRequest, code: "E400", field: "values"},
+		{name: "no values", body: map[string]interface{}{"values": map[string]string{}}, status: http.StatusBadRequest, code: "E400", field: "values"},
+		{
+			name:   "unknown field",
+			body:   map[string]interface{}{"values": map[string]string{"shoe_size": "42"}},
+			err:    &repository.UnknownFieldError{Key: "shoe_size"},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			calls:  []string{"SetValues"},
+		},
+		{
+			name:   "database error",
+			body:   map[string]interface{}{"values": map[string]string{"company": "Acme"}},
+			err:    errors.New("connection reset"),
+			status: http.StatusInternalServerError,
+			code:   "E500",
+			calls:  []string{"SetValues"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles := &mocks.ProfileRepository{
+				SetValuesFunc: func(ctx context.Context, userID int, values map[string]string) error {
+					if userID != alice.ID {
+						return errors.New("values saved for the wrong user")
+					}
+					return tt.err
+				},
+				FieldsFunc: companyFields,
+			}
+			r := aliceRequest(t, http.MethodPut, "/profile", tt.body)
+
+			rec := testutil.Serve(newProfileHandler(profiles).UpdateProfileRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, profiles, tt.calls)
+		})
+	}
+}
+
+func TestPutProfileField(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		body   interface{}
+		status int
+		code   string
+		field  string
+		calls  []string
+	}{
+		{name: "saved", key: "company", body: map[string]interface{}{"label": "Company", "required": true}, status: http.StatusOK, calls: []string{"PutField"}},
+		{name: "invalid key", key: "Company", body: map[string]interface{}{"label": "Company"}, status: http.StatusBadRequest, code: "E400"},
+		{name: "missing label", key: "company", body: map[string]interface{}{"required": true}, status: http.StatusBadRequest, code: "E400", field: "label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles := &mocks.ProfileRepository{
+				PutFieldFunc: func(ctx context.Context, key, label string, required bool) (*repository.ProfileField, error) {
+					return &repository.ProfileField{Key: key, Label: label, Required: required}, nil
+				},
+			}
+			r := adminRequest(t, http.MethodPut, "/admin/profile-fields/"+tt.key, tt.body, "key", tt.key)
+
+			rec := testutil.Serve(newProfileHandler(profiles).PutFieldRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got struct {
+					Key      string `json:"key"`
+					Required bool   `json:"required"`
+				}
+				testutil.DecodeJSON(t, rec, &got)
+				if got.Key != tt.key || !got.Required {
+					t.Errorf("field = %+v", got)
+				}
+			}
+			assertCalls(t, profiles, tt.calls)
+		})
+	}
+}
+
+func TestDeleteProfileField(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		status int
+		code   string
+	}{
+		{name: "deleted", key: "company", status: http.StatusNoContent},
+		{name: "not found", key: "shoe_size", status: http.StatusNotFound, code: "E404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles := &mocks.ProfileRepository{
+				DeleteFieldFunc: func(ctx context.Context, key string) error {
+					if key != "company" {
+						return repository.ErrNotFound
+					}
+					return nil
+				},
+			}
+			r := adminRequest(t, http.MethodDelete, "/admin/profile-fields/"+tt.key, nil, "key", tt.key)
+
+			rec := testutil.Serve(newProfileHandler(profiles).DeleteFieldRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, profiles, []string{"DeleteField"})
+		})
+	}
+}
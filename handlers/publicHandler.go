@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PublicHandler holds the read-only endpoints that can be called without a token.
+// They are registered in the public route group (see server.NewServer).
+type PublicHandler struct {
+	Config *config.Config
+	DB     *pgxpool.Pool
+}
+
+func NewPublicHandler(cfg *config.Config, db *pgxpool.Pool) *PublicHandler {
+	return &PublicHandler{Config: cfg, DB: db}
+}
+
+type emailAvailabilityResponse struct {
+	Email     string `json:"email"`
+	Available bool   `json:"available"`
+}
+
+// @Summary      Check email availability
+// @Description  Tells if an email can still be used to register a new account
+// @Tags         public
+// @Produce      json
+// @Param        email query string true "Email to check"
+// @Success      200 {object} emailAvailabilityResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      429 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /email-availability [get]
+func (ph *PublicHandler) EmailAvailability(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[PublicHandler:EmailAvailability] start")
+
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		return nil, apperrors.BadRequest("Query parameter 'email' is required")
+	}
+
+	var exists bool
+	err := ph.DB.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE email_lookup = $1 AND deleted_at IS NULL);`, pii.Lookup(email, ph.Config.PIIEncryptionKey)).Scan(&exists)
+	if err == nil && !exists {
+		exists, err = emailBlockedByDeletedAccount(r.Context(), ph.DB, ph.Config.EmailReusePolicy, ph.Config.PIIEncryptionKey, email)
+	}
+	if err != nil {
+		log.Printf("[PublicHandler:EmailAvailability] Error checking email: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	log.Printf("[PublicHandler:EmailAvailability] end. Took %v", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   &emailAvailabilityResponse{Email: email, Available: !exists},
+	}, nil
+}
+
+// @Summary      Get an event schema
+// @Description  Returns the JSON schema of the events of a type, as published to the message broker and sent to webhooks
+// @Tags         public
+// @Produce      json
+// @Param        type path string true "Event type, e.g. user.created"
+// @Success      200 {object} object
+// @Failure      404 {object} apperrors.Response
+// @Failure      429 {object} apperrors.Response
+// @Router       /events/schemas/{type} [get]
+func (ph *PublicHandler) EventSchema(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	eventType := chi.URLParam(r, "type")
+	schema, ok := events.Schema(eventType)
+	if !ok {
+		return nil, apperrors.NotFound("No event of type " + eventType)
+	}
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   schema,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter keeps one token bucket per client key (the client IP by default).
+// Buckets that were not used for a while are dropped so the map does not grow forever.
+// A limit of 0 requests per second lets everything through.
+type RateLimiter struct {
+	rps     rate.Limit
+	burst   int
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	rl := &RateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+	}
+	go rl.cleanup()
+	return rl
+}
+
+// Allow reports whether the client identified by key may do one more request now
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rps <= 0 {
+		return true
+	}
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter.Allow()
+}
+
+// RetryAfter is the Retry-After header value of rejected requests: the seconds until a new token
+func (rl *RateLimiter) RetryAfter() string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.rps <= 0 {
+		return "60"
+	}
+	return strconv.Itoa(int(math.Ceil(1 / float64(rl.rps))))
+}
+
+// SetLimit changes the limit, clients keep the tokens they have left
+func (rl *RateLimiter) SetLimit(rps float64, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rps, rl.burst = rate.Limit(rps), burst
+	for _, c := range rl.clients {
+		c.limiter.SetLimit(rl.rps)
+		c.limiter.SetBurst(burst)
+	}
+}
+
+func (rl *RateLimiter) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+
+		rl.mu.Lock()
+		for key, c := range rl.clients {
+			if time.Since(c.lastSeen) > 3*time.Minute {
+				delete(rl.clients, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// RateLimitMiddleware rejects requests with 429 once the client IP runs out of tokens
+func RateLimitMiddleware(rl *RateLimiter) ApiMiddlewareFunc {
+	return func(next ApiHandlerFunc) ApiHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+			if !rl.Allow(clientIP(r)) {
+				w.Header().Set("Retry-After", rl.RetryAfter())
+				return nil, apperrors.TooManyRequests("Rate limit exceeded. Try again later")
+			}
+			return next(w, r)
+		}
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+)
+
+// RealIPMiddleware replaces the address of the requests coming through a trusted proxy by the address of the
+// client the proxy forwarded, so the rate limits, the sessions, the login events and the access log see the client.
+// X-Forwarded-For is read from the right, skipping the trusted proxies: the entries left of the first untrusted one
+// were sent by the client and can't be believed. X-Real-IP is used when the proxy doesn't send X-Forwarded-For.
+// It must run before anything reading r.RemoteAddr.
+func RealIPMiddleware(trusted config.TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted.Prefixes) == 0 && !trusted.UnixSocket {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrustedProxy(trusted, r.RemoteAddr) {
+				if ip := forwardedIP(trusted, r); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isTrustedProxy tells if remoteAddr, the address of a connection or of a forwarding hop, is a trusted proxy
+func isTrustedProxy(trusted config.TrustedProxies, remoteAddr string) bool {
+	// Connections of the unix socket have no address
+	if remoteAddr == "" || remoteAddr == "@" {
+		return trusted.UnixSocket
+	}
+	addr, ok := parseIP(remoteAddr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range trusted.Prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP returns the address of the client as reported by the proxies, "" when they didn't report a valid one
+func forwardedIP(trusted config.TrustedProxies, r *http.Request) string {
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	if len(hops) == 1 && strings.TrimSpace(hops[0]) == "" {
+		hops = []string{r.Header.Get("X-Real-IP")}
+	}
+
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseIP(strings.TrimSpace(hops[i]))
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !isTrustedProxy(trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+// parseIP reads an address with or without a port
+func parseIP(s string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err == nil
+}
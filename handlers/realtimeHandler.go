@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/realtime"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Subprotocol browsers offer with the token, since they can't set headers on WebSocket connections:
+// new WebSocket(url, ["jwt", token])
+const wsTokenProtocol = "jwt"
+
+// RealtimeHandler streams the domain events to admin dashboards over WebSocket and Server-Sent Events (see the realtime package)
+type RealtimeHandler struct {
+	cfg      *config.Config
+	db       *pgxpool.Pool
+	hub      *realtime.Hub
+	resolver *rbac.Resolver
+	upgrader websocket.Upgrader
+}
+
+func NewRealtimeHandler(cfg *config.Config, db *pgxpool.Pool, hub *realtime.Hub) *RealtimeHandler {
+	return &RealtimeHandler{
+		cfg:      cfg,
+		db:       db,
+		hub:      hub,
+		resolver: rbac.NewResolver(db),
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{wsTokenProtocol},
+			// Connections are authenticated by a token the page has to send, never by cookies,
+			// so another origin can't open one on behalf of a logged in admin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// Configuration of routes
+func (rh *RealtimeHandler) RealtimeRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(wsTokenMiddleware, MiddlewareAdapter(JWTAuthMiddleware(rh.db, rh.cfg.JWT)), MiddlewareAdapter(RequirePermission(rbac.EventsStream)))
+
+	// Routes
+	r.HandleFunc("GET /admin", ApiHandlerAdapter(rh.adminStream))
+
+	return r
+}
+
+// Configuration of the Server-Sent Events route, for the clients that can't use WebSockets
+func (rh *RealtimeHandler) StreamRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(rh.db, rh.cfg.JWT)), MiddlewareAdapter(RequirePermission(rbac.EventsStream)))
+
+	// Routes
+	r.HandleFunc("GET /", ApiHandlerAdapter(rh.activityStream))
+
+	return r
+}
+
+// wsTokenMiddleware moves the token offered in the Sec-WebSocket-Protocol header to the Authorization
+// header, so JWTAuthMiddleware checks it like any other
+func wsTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			protocols := websocket.Subprotocols(r)
+			if len(protocols) == 2 && protocols[0] == wsTokenProtocol {
+				r.Header.Set("Authorization", "Bearer "+protocols[1])
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary      Stream user events
+// @Description  Upgrades to a WebSocket pushing the user.created, user.updated and user.deleted events as JSON text messages. Browsers send the token as the subprotocols ["jwt", token]. The connection is closed when the token stops being valid.
+// @Tags         admin
+// @Security     BearerAuth
+// @Success      101
+// @Failure      401 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Router       /ws/admin [get]
+func (rh *RealtimeHandler) adminStream(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return nil, apperrors.BadRequest("Expected a WebSocket upgrade request")
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	conn, err := rh.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered the client
+		log.Printf("[RealtimeHandler:adminStream] Error upgrading the connection of user %d: %v", userID, err)
+		return nil, nil
+	}
+
+	rh.hub.Serve(conn, rh.authorizer(token))
+	return nil, nil
+}
+
+// @Summary      Stream the activity feed
+// @Description  Streams the user.created, user.updated, user.deleted, login.succeeded and login.failed events as Server-Sent Events, for clients that can't use WebSockets. Each event carries its id: reconnecting with the Last-Event-ID header first replays the events missed meanwhile, as long as the server still holds them. The stream ends when the token stops being valid.
+// @Tags         admin
+// @Produce      text/event-stream
+// @Security     BearerAuth
+// @Param        Last-Event-ID header string false "Id of the last event received, to resume the stream"
+// @Success      200
+// @Failure      401 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Router       /events/stream [get]
+func (rh *RealtimeHandler) activityStream(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	rh.hub.ServeSSE(w, r, rh.authorizer(token))
+	return nil, nil
+}
+
+// authorizer checks token again for a long lived connection, the connection is closed once it fails.
+// The request context ends with QUERY_TIMEOUT, so each check gets its own.
+func (rh *RealtimeHandler) authorizer(token string) func() error {
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), rh.cfg.QueryTimeout)
+		defer cancel()
+		ctx, herr := AuthenticateToken(ctx, rh.db, rh.resolver, rh.cfg.JWT, token)
+		if herr != nil {
+			return errors.New(herr.Message.Detail)
+		}
+		if !PermissionsFrom(ctx).Has(rbac.EventsStream) {
+			return errors.New("Missing permission " + rbac.EventsStream)
+		}
+		return nil
+	}
+}
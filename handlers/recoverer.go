@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/errreport"
+)
+
+// RecovererMiddleware replaces chi's middleware.Recoverer: a panic is logged with its stack and the
+// request id, and the client gets the usual JSON 500 instead of a plain text one.
+func RecovererMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// Aborting on purpose is how net/http cancels a response, it must reach the server
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			stack := debug.Stack()
+			log.Printf("[Middleware:RecovererMiddleware] panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, RequestID(r.Context()), rec, stack)
+			errreport.RecordPanic(r.Context(), rec, stack)
+			// Upgraded connections have no response to write to
+			if r.Header.Get("Connection") == "Upgrade" {
+				return
+			}
+			writeError(w, r, apperrors.Internal())
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
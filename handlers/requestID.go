@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Incoming ids are copied into logs and responses, so only harmless ones are kept
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestIDMiddleware gives every request an id: the X-Request-ID sent by the client (or a proxy in front
+// of the API) when it looks valid, a random one otherwise. The id is sent back in the X-Request-ID header
+// and in error bodies, and is stored where the access log picks it up.
+// It must be the first middleware so everything after it sees the id.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.RequestIDKey, id)))
+	})
+}
+
+// RequestID returns the id RequestIDMiddleware gave to the request of ctx, or "" outside of a request
+func RequestID(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Every issued token belongs to a session row (its id travels in the "sid" claim), which records
+// the device it was created from. Revoking the session makes JWTAuthMiddleware reject the token.
+//
+// The session also gets a refresh token, handed out with the access token and stored as its hash.
+// POST /auth/refresh trades it for a new access token and a new refresh token, pushing the end of
+// the session JWT_REFRESH_TOKEN_TTL away, so clients stay signed in while the access tokens are
+// short-lived. A refresh token is bound to the device (user agent) of its session: sent from
+// another one it is taken as stolen and the session is revoked. So is a refresh token sent again
+// after it was rotated, the hash of the previous one is kept with the session to spot it.
+
+// Session Response Model
+type session struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	DeviceName string    `json:"device_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// Client is the device a session is opened from, shown in the session listing
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// ClientOf returns the device of an HTTP request
+func ClientOf(r *http.Request) Client {
+	return Client{UserAgent: r.UserAgent(), IP: clientIP(r)}
+}
+
+// Tokens are handed out when a session is opened or refreshed
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// newSession returns the session of userID to open with the device metadata of the client, with its
+// refresh token. The session expires after ttl unless it is refreshed.
+func newSession(client Client, userID int, deviceName string, ttl time.Duration) (*repository.Session, string, error) {
+	// sessions.device_name holds 100 characters, cutting bytes could split one
+	if runes := []rune(deviceName); len(runes) > 100 {
+		deviceName = string(runes[:100])
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s := &repository.Session{
+		UserID:           userID,
+		UserAgent:        client.UserAgent,
+		IP:               client.IP,
+		DeviceName:       deviceName,
+		ExpiresAt:        time.Now().Add(ttl),
+		RefreshTokenHash: hashToken(refreshToken),
+	}
+	return s, refreshToken, nil
+}
+
+// sessionActive tells if the session exists, is not expired and was not revoked
+func sessionActive(ctx context.Context, db *pgxpool.Pool, sessionID int64) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW());`
+	err := db.QueryRow(ctx, query, sessionID).Scan(&active)
+	return active, err
+}
+
+// @Summary      List my sessions
+// @Description  Lists the active sessions of the authenticated user with the device they were created from
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} session
+// @Failure      401 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/sessions [get]
+func (ah *AuthenticationHandler) listSessions(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AuthenticationHandler:listSessions] start")
+
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+	currentID, _ := r.Context().Value(ContextSessionIDKey).(int64)
+
+	records, err := ah.Accounts.Sessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("[AuthenticationHandler:listSessions] Error querying sessions: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	sessions := []session{}
+	for _, s := range records {
+		sessions = append(sessions, session{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			DeviceName: s.DeviceName,
+			CreatedAt:  s.CreatedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == currentID,
+		})
+	}
+
+	log.Printf("[AuthenticationHandler:listSessions] end in %s", time.Since(start))
+	return &HandlerSuccess{Status: http.StatusOK, Data: sessions}, nil
+}
+
+// @Summary      Revoke one of my sessions
+// @Description  Revokes a session of the authenticated user, tokens issued for it stop working immediately
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Session ID"
+// @Success      204
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/sessions/{id} [delete]
+func (ah *AuthenticationHandler) revokeSession(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[AuthenticationHandler:revokeSession] start")
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+	if herr := ah.RevokeSession(r.Context(), id); herr != nil {
+		return nil, herr
+	}
+	return &HandlerSuccess{Status: http.StatusNoContent, Data: nil}, nil
+}
+
+// RevokeSession revokes a session of the authenticated user, shared with the GraphQL logout
+func (ah *AuthenticationHandler) RevokeSession(ctx context.Context, id int64) *apperrors.Error {
+	userID, _ := ctx.Value(ContextUserIDKey).(int)
+
+	err := ah.Accounts.RevokeSession(ctx, userID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return apperrors.NotFound("Session with id " + strconv.FormatInt(id, 10) + " not found")
+	}
+	if err != nil {
+		log.Printf("[AuthenticationHandler:RevokeSession] Error revoking session: %v", err)
+		return apperrors.Internal()
+	}
+
+	log.Printf("[AuthenticationHandler:RevokeSession] Session %d of user %d revoked", id, userID)
+	return nil
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// errRefreshRefused is a refresh token that doesn't open an active session
+var errRefreshRefused = errors.New("refresh token refused")
+
+// @Summary      Refresh a session
+// @Description  Trades the refresh token of a session for a new access token and a new refresh token, the one sent stops working. It must come from the device the session was opened on and not have been traded already, otherwise the session is revoked.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body body refreshRequest true "Refresh token of the last login or refresh"
+// @Success      200 {object} authResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      401 {object} apperrors.Response "Invalid, expired or revoked refresh token"
+// @Failure      500 {object} apperrors.Response
+// @Router       /auth/refresh [post]
+func (ah *AuthenticationHandler) refresh(r *http.Request, req *refreshRequest) (*authResponse, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[AuthenticationHandler:refresh] start")
+
+	tokens, herr := ah.RefreshSession(r.Context(), ClientOf(r), req.RefreshToken)
+	if herr != nil {
+		return nil, herr
+	}
+
+	log.Printf("[AuthenticationHandler:refresh] end in %s", time.Since(start))
+	return newAuthResponse("Session refreshed", tokens), nil
+}
+
+// RefreshSession trades the refresh token of a session opened by client for new tokens.
+// It is shared by POST /auth/refresh, the gRPC AuthService and the GraphQL refresh mutation.
+func (ah *AuthenticationHandler) RefreshSession(ctx context.Context, client Client, refreshToken string) (Tokens, *apperrors.Error) {
+	if herr := validateRequest(&refreshRequest{RefreshToken: refreshToken}); herr != nil {
+		return Tokens{}, herr
+	}
+
+	tokens, err := ah.refreshSession(ctx, client, refreshToken)
+	if err != nil {
+		if errors.Is(err, errRefreshRefused) {
+			return Tokens{}, apperrors.Unauthorized("Invalid or expired refresh token")
+		}
+		log.Printf("[AuthenticationHandler:RefreshSession] Error refreshing session: %v", err)
+		return Tokens{}, apperrors.Internal()
+	}
+	return tokens, nil
+}
+
+// refreshSession rotates the refresh token of the session it belongs to and issues a new access token
+// for it. The session is locked meanwhile, so a token sent twice at once is only accepted once.
+func (ah *AuthenticationHandler) refreshSession(ctx context.Context, client Client, refreshToken string) (Tokens, error) {
+	var tokens Tokens
+	var revokedID int64
+	var revokedFor string // the Refresh* result of a revoked session
+	err := repository.WithTx(ctx, ah.DB, func(tx pgx.Tx) error {
+		var sessionID int64
+		var userAgent string
+		u := &user{}
+		query := `SELECT s.id, s.user_agent, u.id, u.name, ` + userRolesColumn + ` FROM sessions s JOIN users u ON u.id = s.user_id
+			WHERE s.refresh_token_hash = $1 AND s.revoked_at IS NULL AND s.expires_at > NOW() AND u.deleted_at IS NULL
+			FOR UPDATE OF s;`
+		err := tx.QueryRow(ctx, query, hashToken(refreshToken)).Scan(&sessionID, &userAgent, &u.ID, &u.Name, &u.Roles)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// A rotated token sent again was copied, the whole session goes
+			query = `UPDATE sessions SET revoked_at = NOW() WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL RETURNING id;`
+			err = tx.QueryRow(ctx, query, hashToken(refreshToken)).Scan(&revokedID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				metrics.ObserveRefresh(metrics.RefreshInvalid)
+				return errRefreshRefused
+			}
+			revokedFor = metrics.RefreshReused
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if userAgent != client.UserAgent {
+			if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1;`, sessionID); err != nil {
+				return err
+			}
+			revokedID, revokedFor = sessionID, metrics.RefreshDeviceMismatch
+			return nil
+		}
+
+		next, err := randomToken()
+		if err != nil {
+			return err
+		}
+		query = `UPDATE sessions SET previous_refresh_token_hash = refresh_token_hash, refresh_token_hash = $2, ip = $3, expires_at = $4, refreshed_at = NOW() WHERE id = $1;`
+		if _, err := tx.Exec(ctx, query, sessionID, hashToken(next), client.IP, time.Now().Add(ah.Config.JWT.RefreshTokenTTL)); err != nil {
+			return err
+		}
+		accessToken, err := ah.sessionToken(u, sessionID)
+		if err != nil {
+			return err
+		}
+		tokens = Tokens{AccessToken: accessToken, RefreshToken: next}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errRefreshRefused) {
+			metrics.ObserveRefresh(metrics.RefreshError)
+		}
+		return Tokens{}, err
+	}
+	if revokedID != 0 {
+		// Committed, so the session stays revoked
+		if revokedFor == metrics.RefreshReused {
+			log.Printf("[AuthenticationHandler:refreshSession] Session %d revoked, its previous refresh token was reused {user_agent: %s, ip: %s}", revokedID, client.UserAgent, client.IP)
+		} else {
+			log.Printf("[AuthenticationHandler:refreshSession] Session %d revoked, refreshed from another device {user_agent: %s, ip: %s}", revokedID, client.UserAgent, client.IP)
+		}
+		metrics.ObserveRefresh(revokedFor)
+		return Tokens{}, errRefreshRefused
+	}
+	metrics.ObserveRefresh(metrics.RefreshSuccess)
+	return tokens, nil
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/jwe"
+	"github.com/hi-im-yan/jwt-with-go/paseto"
+)
+
+// issueToken turns the claims into a token of the configured format: a JWT signed with the secret, encrypted
+// when an encryption key is set, or a PASETO v4.local token. Both carry the same claims, so VerifyJwtToken
+// returns the same map whatever the format.
+func issueToken(claims jwt.MapClaims, cfg config.JWT) (string, error) {
+	if cfg.Format == "paseto" {
+		return pasetoToken(claims, cfg.Keys.PasetoKey())
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(cfg.Keys.Secret())
+	key := cfg.Keys.EncryptionKey()
+	if err != nil || len(key) == 0 {
+		return tokenString, err
+	}
+	return jwe.Encrypt(tokenString, key)
+}
+
+// verificationKeys is the key set of a JWT signed with any of secrets, turned into its signing key by
+// derive when it isn't nil
+func verificationKeys(secrets [][]byte, derive func([]byte) []byte) jwt.VerificationKeySet {
+	set := jwt.VerificationKeySet{}
+	for _, secret := range secrets {
+		if derive != nil {
+			secret = derive(secret)
+		}
+		set.Keys = append(set.Keys, secret)
+	}
+	return set
+}
+
+// pasetoToken encrypts the claims in a v4.local token. PASETO dates are RFC 3339 strings, not the Unix
+// times of JWT.
+func pasetoToken(claims jwt.MapClaims, key []byte) (string, error) {
+	payload := make(map[string]interface{}, len(claims))
+	for name, value := range claims {
+		payload[name] = value
+	}
+	if exp, ok := claims["exp"].(int64); ok {
+		payload["exp"] = time.Unix(exp, 0).UTC().Format(time.RFC3339)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return paseto.Encrypt(key, body)
+}
+
+// verifyPasetoToken decrypts a v4.local token and checks it has not expired, give or take leeway. exp is
+// turned back into a Unix time, like in the claims of a JWT.
+func verifyPasetoToken(tokenString string, key []byte, leeway time.Duration) (jwt.MapClaims, error) {
+	body, err := paseto.Decrypt(key, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.MapClaims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, paseto.ErrInvalid
+	}
+
+	exp, _ := claims["exp"].(string)
+	expiresAt, err := time.Parse(time.RFC3339, exp)
+	if err != nil {
+		return nil, paseto.ErrInvalid
+	}
+	if !time.Now().Before(expiresAt.Add(leeway)) {
+		return nil, jwt.ErrTokenExpired
+	}
+	claims["exp"] = float64(expiresAt.Unix())
+	return claims, nil
+}
@@ -0,0 +1,131 @@
+package handlers_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/jwe"
+	"github.com/hi-im-yan/jwt-with-go/paseto"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+var (
+	encryptionKey = bytes.Repeat([]byte{1}, jwe.KeySize)
+	pasetoKey     = bytes.Repeat([]byte{3}, paseto.KeySize)
+)
+
+// encryptedToken returns the JWE of a token of alice signed with testutil.Secret, expiring at exp
+func encryptedToken(t *testing.T, exp time.Time, key []byte) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "2", "exp": exp.Unix()}).SignedString(testutil.Secret)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	token, err := jwe.Encrypt(signed, key)
+	if err != nil {
+		t.Fatalf("encrypting token: %v", err)
+	}
+	return token
+}
+
+func TestVerifyEncryptedJwtToken(t *testing.T) {
+	otherKey := bytes.Repeat([]byte{2}, jwe.KeySize)
+
+	tests := []struct {
+		name   string
+		token  string
+		leeway time.Duration
+		err    error // nil when the token is accepted
+	}{
+		{name: "valid", token: encryptedToken(t, time.Now().Add(time.Minute), encryptionKey)},
+		{name: "expired", token: encryptedToken(t, time.Now().Add(-time.Minute), encryptionKey), err: jwt.ErrTokenExpired},
+		{name: "expired within the leeway", token: encryptedToken(t, time.Now().Add(-time.Minute), encryptionKey), leeway: 2 * time.Minute},
+		{name: "encrypted with another key", token: encryptedToken(t, time.Now().Add(time.Minute), otherKey), err: jwe.ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.JWT{Keys: config.NewKeys(testutil.Secret, encryptionKey, nil), Leeway: tt.leeway}
+
+			claims, err := handlers.VerifyJwtToken(tt.token, cfg)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("VerifyJwtToken = %v, %v, want %v", claims, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyJwtToken: %v", err)
+			}
+			if claims["sub"] != "2" {
+				t.Errorf("claims = %v, want the claims of alice", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyEncryptedJwtTokenWithoutKey(t *testing.T) {
+	cfg := config.JWT{Keys: config.NewKeys(testutil.Secret, nil, nil)}
+
+	if _, err := handlers.VerifyJwtToken(encryptedToken(t, time.Now().Add(time.Minute), encryptionKey), cfg); err == nil {
+		t.Error("VerifyJwtToken accepted an encrypted token while JWT_ENCRYPTION_KEY is not set")
+	}
+}
+
+// pasetoToken returns the v4.local token of alice expiring at exp, an RFC 3339 date like in the tokens
+// issued with TOKEN_FORMAT=paseto
+func pasetoToken(t *testing.T, exp string, key []byte) string {
+	t.Helper()
+	token, err := paseto.Encrypt(key, []byte(`{"sub":"2","exp":"`+exp+`"}`))
+	if err != nil {
+		t.Fatalf("encrypting token: %v", err)
+	}
+	return token
+}
+
+func TestVerifyPasetoToken(t *testing.T) {
+	inAMinute := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	aMinuteAgo := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name   string
+		token  string
+		leeway time.Duration
+		err    error // nil when the token is accepted
+	}{
+		{name: "valid", token: pasetoToken(t, inAMinute, pasetoKey)},
+		{name: "expired", token: pasetoToken(t, aMinuteAgo, pasetoKey), err: jwt.ErrTokenExpired},
+		{name: "expired within the leeway", token: pasetoToken(t, aMinuteAgo, pasetoKey), leeway: 2 * time.Minute},
+		{name: "exp not a date", token: pasetoToken(t, "tomorrow", pasetoKey), err: paseto.ErrInvalid},
+		{name: "encrypted with another key", token: pasetoToken(t, inAMinute, encryptionKey), err: paseto.ErrInvalid},
+		{name: "footer added", token: pasetoToken(t, inAMinute, pasetoKey) + ".eyJraWQiOiIxIn0", err: paseto.ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.JWT{Format: "paseto", Keys: config.NewKeys(testutil.Secret, nil, pasetoKey), Leeway: tt.leeway}
+
+			claims, err := handlers.VerifyJwtToken(tt.token, cfg)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("VerifyJwtToken = %v, %v, want %v", claims, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyJwtToken: %v", err)
+			}
+			// exp is turned back into a Unix time, like in the claims of a JWT
+			if _, ok := claims["exp"].(float64); !ok || claims["sub"] != "2" {
+				t.Errorf("claims = %v, want the claims of alice", claims)
+			}
+		})
+	}
+}
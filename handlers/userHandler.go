@@ -2,40 +2,108 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/signedurl"
+	"github.com/hi-im-yan/jwt-with-go/storage"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserHandler struct {
+	cfg       *config.Config
 	db        *pgxpool.Pool
+	users     repository.UserRepository
+	profiles  repository.ProfileRepository
+	avatars   storage.Storage
+	mailer    mailer.Mailer
 	logPrefix string
+	// Signs the links of DownloadRouter
+	downloads *signedurl.Signer
+	// Builds the large data exports and carries out the erasures, set by UseJobs
+	jobs *jobs.Queue
+	// Events receives the created, updated and deleted users, set by the server
+	Events *events.Bus
+	// UserChanged is called for the anonymized users, set by the server
+	UserChanged UserChanged
+}
+
+// UserChanged is told about users written outside of the UserRepository (registration, role
+// grants...), so a cache of user lookups can drop them. Nil when nothing is cached.
+type UserChanged func(ctx context.Context, id int)
+
+func (changed UserChanged) notify(ctx context.Context, id int) {
+	if changed != nil {
+		changed(ctx, id)
+	}
 }
 
 // User Response Model
 type user struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
-	Role  string `json:"role"`
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	AvatarURL string   `json:"avatar_url,omitempty"`
+	// Set by PUT /users/{id} while the new email waits for confirmation
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// userRolesColumn selects the role names of the user aliased "u" as a text array
+const userRolesColumn = repository.UserRolesColumn
+
+func userFromRecord(u *repository.User) *user {
+	return &user{ID: u.ID, Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarURL: u.AvatarURL}
+}
+
+// event is the payload of the user.created and user.updated events
+func (u *user) event() events.User {
+	return events.User{ID: u.ID, Name: u.Name, Email: u.Email, Roles: u.Roles, AvatarURL: u.AvatarURL}
+}
+
+// Current User Response Model
+type currentUser struct {
+	user
+	Groups      []string         `json:"groups"`
+	Permissions []string         `json:"permissions"`
+	Profile     *profileResponse `json:"profile"`
 }
 
 // User Request Model
 type userRequest struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Name  string `json:"name" validate:"required,max=100"`
+	Email string `json:"email" validate:"required,email,max=100"`
 }
 
-func NewUserHandler(db *pgxpool.Pool) *UserHandler {
-	return &UserHandler{db: db, logPrefix: "UserHandler"}
+// userRequest of the user of the path
+type updateUserRequest struct {
+	ID int `path:"id"`
+	userRequest
+}
+
+func NewUserHandler(cfg *config.Config, db *pgxpool.Pool, users repository.UserRepository, avatars storage.Storage, m mailer.Mailer) *UserHandler {
+	return &UserHandler{
+		cfg:       cfg,
+		db:        db,
+		users:     users,
+		profiles:  repository.NewProfileRepository(db),
+		avatars:   avatars,
+		mailer:    m,
+		logPrefix: "UserHandler",
+		downloads: signedurl.New(downloadSigningKey(cfg.JWT)),
+	}
 }
 
 // Configuration of routes
@@ -44,14 +112,27 @@ func (uh *UserHandler) UserRouter() http.Handler {
 
 	// Middleware
 	r.Use(logSomething)
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(uh.db, uh.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(uh.db, uh.cfg.ProfileExemptRoutes)))
 
 	// Routes
-	r.With(MiddlewareAdapter(JWTAuthMiddleware), MiddlewareAdapter(OnlyAdminMiddleware)).HandleFunc("POST /", ApiHandlerAdapter(uh.insertUser))
-	r.With(MiddlewareAdapter(JWTAuthMiddleware)).HandleFunc("GET /", ApiHandlerAdapter(uh.getAllUsers))
-	r.With(MiddlewareAdapter(JWTAuthMiddleware)).HandleFunc("GET /{id}", ApiHandlerAdapter(uh.getUser))
-	r.With(MiddlewareAdapter(JWTAuthMiddleware)).HandleFunc("PUT /{id}", ApiHandlerAdapter(uh.updateUser))
-	r.With(MiddlewareAdapter(JWTAuthMiddleware), MiddlewareAdapter(OnlyAdminMiddleware)).HandleFunc("DELETE /{id}", ApiHandlerAdapter(uh.deleteUser))
-	r.With(MiddlewareAdapter(JWTAuthMiddleware), MiddlewareAdapter(OnlyAdminMiddleware)).HandleFunc("GET /mock", ApiHandlerAdapter(uh.getMockUser))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersCreate))).HandleFunc("POST /", ApiHandlerAdapter(IdempotencyMiddleware(uh.db, uh.cfg.IdempotencyKeyTTL)(Handle(http.StatusCreated, uh.insertUser))))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersList))).HandleFunc("GET /", ApiHandlerAdapter(uh.getAllUsers))
+	r.HandleFunc("GET /me", ApiHandlerAdapter(uh.getMe))
+	r.HandleFunc("GET /me/export", ApiHandlerAdapter(uh.exportMyData))
+	r.HandleFunc("GET /me/exports/{id}", ApiHandlerAdapter(uh.getMyDataExport))
+	r.HandleFunc("DELETE /me", ApiHandlerAdapter(uh.requestErasure))
+	r.HandleFunc("GET /me/erasure", ApiHandlerAdapter(uh.getMyErasure))
+	r.HandleFunc("POST /me/erasure/confirm", ApiHandlerAdapter(Handle(http.StatusOK, uh.confirmErasure)))
+	r.HandleFunc("DELETE /me/erasure", ApiHandlerAdapter(uh.cancelMyErasure))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersExport))).HandleFunc("GET /export", ApiHandlerAdapter(uh.exportUsers))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersExport))).HandleFunc("GET /export/link", ApiHandlerAdapter(uh.exportLink))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersRead))).HandleFunc("GET /{id}", ApiHandlerAdapter(uh.getUser))
+	r.With(MiddlewareAdapter(OwnerOrAdminMiddleware(rbac.UsersUpdate))).HandleFunc("PUT /{id}", ApiHandlerAdapter(Handle(http.StatusOK, uh.updateUser)))
+	r.With(MiddlewareAdapter(OwnerOrAdminMiddleware(rbac.UsersUpdate))).HandleFunc("PUT /{id}/avatar", ApiHandlerAdapter(uh.uploadAvatar))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersRead))).HandleFunc("GET /{id}/avatar/link", ApiHandlerAdapter(uh.avatarLink))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersHistory))).HandleFunc("GET /{id}/history", ApiHandlerAdapter(uh.getHistory))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersDelete))).HandleFunc("DELETE /{id}", ApiHandlerAdapter(uh.deleteUser))
+	r.With(MiddlewareAdapter(RequirePermission(rbac.UsersMock))).HandleFunc("GET /mock", ApiHandlerAdapter(uh.getMockUser))
 
 	return r
 }
@@ -69,9 +150,9 @@ func logSomething(next http.Handler) http.Handler {
 // @Produce      json
 // @Security     BearerAuth
 // @Success      200 {object} user
-// @Failure      404 {object} ErrorResponse
+// @Failure      404 {object} apperrors.Response
 // @Router       /users/mock [get]
-func (uh *UserHandler) getMockUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) getMockUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	shouldReturnUser := true
 
 	if shouldReturnUser {
@@ -81,10 +162,7 @@ func (uh *UserHandler) getMockUser(w http.ResponseWriter, r *http.Request) (*Han
 		}, nil
 	}
 
-	return nil, &HandlerError{
-		Status:  http.StatusNotFound,
-		Message: ErrorResponse{Code: "E404", Message: "User not found", Detail: ""},
-	}
+	return nil, apperrors.NotFound("User not found")
 }
 
 // @Summary      Insert a new user
@@ -94,122 +172,55 @@ func (uh *UserHandler) getMockUser(w http.ResponseWriter, r *http.Request) (*Han
 // @Produce      json
 // @Security     BearerAuth
 // @Param        request body userRequest true "User request"
+// @Param        Idempotency-Key header string false "Retries with the same key replay the first answer"
 // @Success      201 {object} user
-// @Failure      400 {object} ErrorResponse
-// @Failure      409 {object} ErrorResponse
-// @Failure      500 {object} ErrorResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      422 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
 // @Router       /users [post]
-func (uh *UserHandler) insertUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) insertUser(r *http.Request, insertUserReq *userRequest) (*user, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[UserHandler:insertUser] start")
 
-	defer r.Body.Close()
-
-	// parse request to userRequest struct
-	var insertUserReq userRequest
-	err := json.NewDecoder(r.Body).Decode(&insertUserReq)
-
-	// Could not parse json to request
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "Not a valid JSON"},
-		}
-	}
-
 	log.Printf("[UserHandler:insertUser] Request body received: %+v", insertUserReq)
 
-	// validate request body
-	reqName, reqEmail := insertUserReq.Name, insertUserReq.Email
-	if reqName == "" || reqEmail == "" {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Invalid request body", Detail: "name and email are required"},
-		}
+	insertedUser, herr := uh.CreateUser(r.Context(), insertUserReq.Name, insertUserReq.Email)
+	if herr != nil {
+		return nil, herr
 	}
 
-	log.Printf("[UserHandler:insertUser] Inserting user with {name: %s} and {email: %s}", reqName, reqEmail)
-
-	// insert user
-	query := `INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id, name, email;`
-	insertedUser := &user{}
-	err = uh.db.QueryRow(context.Background(), query, reqName, reqEmail).Scan(&insertedUser.ID, &insertedUser.Name, &insertedUser.Email)
-	if err != nil {
-		log.Printf("[UserHandler:insertUser] Error inserting user: %v", err)
-		// Check if the error is a PostgreSQL unique constraint violation
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == "23505" { // Unique constraint violation (email already exists)
-				return nil, &HandlerError{
-					Status: http.StatusConflict,
-					Message: ErrorResponse{
-						Code:    "E409",
-						Message: "Conflict",
-						Detail:  "Email is already in use. Please use a different email.",
-					},
-				}
-			}
-		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
-	}
-
-	log.Printf("[UserHandler:insertUser] Inserted user: %+v", insertedUser)
 	log.Printf("[UserHandler:insertUser] end. Took %v", time.Since(start))
-	return &HandlerSuccess{
-		Status: http.StatusCreated,
-		Data:   insertedUser,
-	}, nil
+	return insertedUser, nil
 }
 
 // @Summary      Get all users
-// @Description  Gets all users from the database
+// @Description  Gets all users from the database. Admins can filter by tags, only users having every given tag are returned
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
+// @Param        tag query []string false "Tag filter (Admin only), can be repeated" collectionFormat(multi)
+// @Param        If-None-Match header string false "ETag of a previous response"
 // @Success      200 {array} user
-// @Failure      500 {object} ErrorResponse
+// @Success      304 "Not modified since the ETag"
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
 // @Router       /users [get]
-func (uh *UserHandler) getAllUsers(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) getAllUsers(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[UserHandler:getAllUsers] start")
 
-	// Query all users
-	log.Printf("[UserHandler:getAllUsers] Querying all users")
-	rows, err := uh.db.Query(context.Background(), `SELECT id, name, email, role FROM users;`)
-	if err != nil {
-		log.Printf("[UserHandler:getAllUsers] Error querying all users: %v", err)
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
-	}
-	defer rows.Close()
-
-	// Scan all users
-	log.Printf("[UserHandler:getAllUsers] Creating users slice from rows")
-	var allUsers []user
-	for rows.Next() {
-		var u user
-		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role)
-		if err != nil {
-			log.Printf("[UserHandler:getAllUsers] Error scanning user row: %v. Parsing error.", err)
-			return nil, &HandlerError{
-				Status:  http.StatusInternalServerError,
-				Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-			}
-		}
-		allUsers = append(allUsers, u)
+	allUsers, herr := uh.ListUsers(r.Context(), r.URL.Query()["tag"])
+	if herr != nil {
+		return nil, herr
 	}
 
 	// Return all users
 	log.Printf("[UserHandler:getAllUsers] end. Took %v", time.Since(start))
-	return &HandlerSuccess{
+	return withETag(w, r, &HandlerSuccess{
 		Status: http.StatusOK,
 		Data:   allUsers,
-	}, nil
+	}), nil
 }
 
 // @Summary      Get user by ID
@@ -218,50 +229,84 @@ func (uh *UserHandler) getAllUsers(w http.ResponseWriter, r *http.Request) (*Han
 // @Produce      json
 // @Security     BearerAuth
 // @Param        id path int true "User ID"
+// @Param        If-None-Match header string false "ETag of a previous response"
 // @Success      200 {object} user
-// @Failure      400 {object} ErrorResponse
-// @Failure      404 {object} ErrorResponse
-// @Failure      500 {object} ErrorResponse
+// @Success      304 "Not modified since the ETag"
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
 // @Router       /users/{id} [get]
-func (uh *UserHandler) getUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) getUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[UserHandler:getUser] start")
 
 	// Parsing path parameter
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Not a valid id", Detail: "Path parameter 'id' must be an integer"},
-		}
+		return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
 	}
 
-	log.Printf("[UserHandler:getUser] Querying user with id %d", id)
-	var user user
-	err = uh.db.QueryRow(context.Background(), `SELECT id, name, email FROM users WHERE id = $1;`, id).Scan(&user.ID, &user.Name, &user.Email)
+	found, herr := uh.GetUser(r.Context(), id)
+	if herr != nil {
+		return nil, herr
+	}
+
+	log.Printf("[UserHandler:getUser] end. Took %v", time.Since(start))
+	return withETag(w, r, &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   found,
+	}), nil
+}
+
+// @Summary      Get the authenticated user
+// @Description  Returns the user the token belongs to, with their groups, effective permissions and profile fields
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} currentUser
+// @Failure      401 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/me [get]
+func (uh *UserHandler) getMe(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[UserHandler:getMe] start")
+
+	userID, _ := r.Context().Value(ContextUserIDKey).(int)
+
+	log.Printf("[UserHandler:getMe] Querying user with id %d", userID)
+	me := &currentUser{}
+	found, err := uh.users.Get(r.Context(), userID)
+	if err == nil {
+		me.user = *userFromRecord(found)
+		me.Groups, err = uh.users.Groups(r.Context(), userID)
+	}
+	if err == nil {
+		me.Profile, err = profileOf(r.Context(), uh.profiles, userID)
+	}
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, &HandlerError{
-				Status:  http.StatusNotFound,
-				Message: ErrorResponse{Code: "E404", Message: "Not found", Detail: "User with id " + idStr + " not found"},
-			}
-		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, apperrors.NotFound("User with id " + strconv.Itoa(userID) + " not found")
 		}
+		log.Printf("[UserHandler:getMe] Error querying user: %v", err)
+		return nil, apperrors.Internal()
 	}
 
-	log.Printf("[UserHandler:getUser] end. Took %v", time.Since(start))
+	me.Permissions = []string{}
+	for permission := range PermissionsFrom(r.Context()) {
+		me.Permissions = append(me.Permissions, permission)
+	}
+	sort.Strings(me.Permissions)
+
+	log.Printf("[UserHandler:getMe] end. Took %v", time.Since(start))
 	return &HandlerSuccess{
 		Status: http.StatusOK,
-		Data:   user,
+		Data:   me,
 	}, nil
 }
 
 // @Summary      Update user by ID
-// @Description  Updates a user's name and email (only self or admin allowed)
+// @Description  Updates a user's name (only self or users with users:update). A new email is not applied right away: a confirmation link is mailed to it and the address is returned as pending_email until confirmed
 // @Tags         users
 // @Accept       json
 // @Produce      json
@@ -269,141 +314,68 @@ func (uh *UserHandler) getUser(w http.ResponseWriter, r *http.Request) (*Handler
 // @Param        id path int true "User ID"
 // @Param        user body userRequest true "User data"
 // @Success      200 {object} user
-// @Failure      400 {object} ErrorResponse
-// @Failure      403 {object} ErrorResponse
-// @Failure      404 {object} ErrorResponse
-// @Failure      500 {object} ErrorResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      409 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
 // @Router       /users/{id} [put]
-func (uh *UserHandler) updateUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) updateUser(r *http.Request, updateUserReq *updateUserRequest) (*user, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[UserHandler:updateUser] start")
 
-	// Parsing path parameter
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Not a valid id", Detail: "Path parameter 'id' must be an integer"},
-		}
-	}
-
-	defer r.Body.Close()
-
-	// parse request to userRequest struct
-	var updateUserReq userRequest
-	err = json.NewDecoder(r.Body).Decode(&updateUserReq)
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Bad request", Detail: "Invalid request body"},
-		}
-	}
-
-	log.Printf("[UserHandler:updateUser] Request body received: %+v", updateUserReq)
-
-	// validate request
-	if updateUserReq.Name == "" || updateUserReq.Email == "" {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Bad request", Detail: "name and email are required"},
-		}
-	}
-
-	// query for id
-	log.Printf("[UserHandler:updateUser] Querying user with id %d", id)
-	queryById := `SELECT id, name FROM users WHERE id = $1;`
-	foundUser := &user{}
-	err = uh.db.QueryRow(context.Background(), queryById, id).Scan(&foundUser.ID, &foundUser.Name)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, &HandlerError{
-				Status:  http.StatusNotFound,
-				Message: ErrorResponse{Code: "E404", Message: "Not found", Detail: "User with id " + idStr + " not found"},
-			}
-		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
-	}
+	// OwnerOrAdminMiddleware already checked the caller may update this user
+	log.Printf("[UserHandler:updateUser] Request body received: %+v", updateUserReq.userRequest)
 
-	// check if user is authorized to update the user
-	// user can update only if he is the same user or he is an admin
-	log.Printf("[UserHandler:updateUser] Checking if user is authorized to update user with id %d", id)
-	if foundUser.ID != id || r.Context().Value("role") != "admin" {
-		return nil, &HandlerError{
-			Status:  http.StatusForbidden,
-			Message: ErrorResponse{Code: "E403", Message: "Forbidden", Detail: "You are no authorized to update another user than yourself"},
-		}
-	}
-
-	// update user
-	log.Printf("[UserHandler:updateUser] Updating user with id %d with {name: %s} and {email: %s}", id, updateUserReq.Name, updateUserReq.Email)
-	updatedUser := &user{}
-	query := `UPDATE users SET name = $1, email = $2 WHERE id = $3 RETURNING id, name, email;`
-	err = uh.db.QueryRow(context.Background(), query, updateUserReq.Name, updateUserReq.Email, id).Scan(&updatedUser.ID, &updatedUser.Name, &updatedUser.Email)
-	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
+	updatedUser, herr := uh.UpdateUser(r.Context(), updateUserReq.ID, updateUserReq.Name, updateUserReq.Email)
+	if herr != nil {
+		return nil, herr
 	}
 
-	log.Printf("[UserHandler:updateUser] User updated: %+v", updatedUser)
 	log.Printf("[UserHandler:updateUser] end. Took %v", time.Since(start))
-	return &HandlerSuccess{
-		Status: http.StatusOK,
-		Data:   updatedUser,
-	}, nil
+	return updatedUser, nil
 }
 
 // @Summary      Delete user by ID
-// @Description  Deletes a user by ID (Admin only)
+// @Description  Soft deletes a user by ID (Admin only). Whether the email can be reused depends on EMAIL_REUSE_POLICY
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
 // @Param        id path int true "User ID"
 // @Success      204
-// @Failure      400 {object} ErrorResponse
-// @Failure      404 {object} ErrorResponse
-// @Failure      500 {object} ErrorResponse
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
 // @Router       /users/{id} [delete]
-func (uh *UserHandler) deleteUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *HandlerError) {
+func (uh *UserHandler) deleteUser(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
 	start := time.Now()
 	log.Printf("[UserHandler:deleteUser] start")
 
 	// Parsing path parameter
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		return nil, &HandlerError{
-			Status:  http.StatusBadRequest,
-			Message: ErrorResponse{Code: "E400", Message: "Not a valid id", Detail: "Path parameter 'id' must be an integer"},
-		}
+		return nil, apperrors.BadRequest("Path parameter 'id' must be an integer")
 	}
 
-	// delete user
-	log.Printf("[UserHandler:deleteUser] Deleting user with id %d", id)
-	query := `DELETE FROM users WHERE id = $1;`
-	_, err = uh.db.Exec(context.Background(), query, id)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, &HandlerError{
-				Status:  http.StatusNotFound,
-				Message: ErrorResponse{Code: "E404", Message: "Not found", Detail: "User with id " + idStr + " not found"},
-			}
-		}
-		return nil, &HandlerError{
-			Status:  http.StatusInternalServerError,
-			Message: ErrorResponse{Code: "E500", Message: "Internal Server Error", Detail: "Something went wrong. Contact support or try again later"},
-		}
+	if herr := uh.DeleteUser(r.Context(), id); herr != nil {
+		return nil, herr
 	}
 
-	log.Printf("[UserHandler:deleteUser] User deleted with id %d", id)
 	log.Printf("[UserHandler:deleteUser] end. Took %v", time.Since(start))
 	return &HandlerSuccess{
 		Status: http.StatusNoContent,
 		Data:   nil,
 	}, nil
 }
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
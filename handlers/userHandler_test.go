@@ -0,0 +1,347 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/repository/mocks"
+	"github.com/hi-im-yan/jwt-with-go/testutil"
+)
+
+var admin = testutil.User{ID: 1, Name: "admin", Roles: []string{rbac.RoleAdmin}, SessionID: 10}
+
+var alice = repository.User{ID: 2, Name: "Alice", Email: "alice@example.com", Roles: []string{rbac.RoleUser}}
+
+// userBody is the JSON of a user answered by the /users routes
+type userBody struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	Roles        []string `json:"roles"`
+	PendingEmail string   `json:"pending_email"`
+}
+
+// newUserHandler returns a UserHandler reading users from users, without database: the emails of
+// deleted accounts can be reused immediately, so creating users doesn't look them up
+func newUserHandler(users *mocks.UserRepository) *handlers.UserHandler {
+	cfg := &config.Config{EmailReusePolicy: handlers.EmailReuseImmediate}
+	cfg.JWT.Keys = config.NewKeys(testutil.Secret, nil, nil)
+	return handlers.NewUserHandler(cfg, nil, users, nil, nil)
+}
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		get    func(ctx context.Context, id int) (*repository.User, error)
+		status int
+		code   string // of the error, empty on success
+		calls  []string
+	}{
+		{
+			name:   "found",
+			id:     "2",
+			get:    func(ctx context.Context, id int) (*repository.User, error) { u := alice; return &u, nil },
+			status: http.StatusOK,
+			calls:  []string{"Get"},
+		},
+		{
+			name:   "id not a number",
+			id:     "abc",
+			status: http.StatusBadRequest,
+			code:   "E400",
+		},
+		{
+			name:   "not found",
+			id:     "99",
+			get:    func(ctx context.Context, id int) (*repository.User, error) { return nil, repository.ErrNotFound },
+			status: http.StatusNotFound,
+			code:   "E404",
+			calls:  []string{"Get"},
+		},
+		{
+			name: "database error",
+			id:   "2",
+			get: func(ctx context.Context, id int) (*repository.User, error) {
+				return nil, errors.New("connection reset")
+			},
+			status: http.StatusInternalServerError,
+			code:   "E500",
+			calls:  []string{"Get"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{GetFunc: tt.get}
+			r := testutil.AuthRequest(t, admin, http.MethodGet, "/users/"+tt.id, nil)
+			r = testutil.AsUser(testutil.WithPathParam(r, "id", tt.id), admin, rbac.UsersRead)
+
+			rec := testutil.Serve(newUserHandler(users).GetUserRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got userBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.ID != alice.ID || got.Email != alice.Email {
+					t.Errorf("user = %+v, want %+v", got, alice)
+				}
+			}
+			assertCalls(t, users, tt.calls)
+		})
+	}
+}
+
+func TestGetAllUsers(t *testing.T) {
+	list := func(ctx context.Context, tags []string) ([]repository.User, error) {
+		return []repository.User{alice}, nil
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		permissions []string
+		status      int
+		code        string
+		calls       []string
+	}{
+		{name: "all users", target: "/users", permissions: []string{rbac.UsersList}, status: http.StatusOK, calls: []string{"List"}},
+		{name: "tags with users:annotate", target: "/users?tag=vip", permissions: []string{rbac.UsersList, rbac.UsersAnnotate}, status: http.StatusOK, calls: []string{"List"}},
+		{name: "tags without users:annotate", target: "/users?tag=vip", permissions: []string{rbac.UsersList}, status: http.StatusForbidden, code: "E403"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{ListFunc: list}
+			r := testutil.AsUser(testutil.AuthRequest(t, admin, http.MethodGet, tt.target, nil), admin, tt.permissions...)
+
+			rec := testutil.Serve(newUserHandler(users).GetAllUsersRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got []userBody
+				testutil.DecodeJSON(t, rec, &got)
+				if len(got) != 1 || got[0].ID != alice.ID {
+					t.Errorf("users = %+v, want [%+v]", got, alice)
+				}
+			}
+			assertCalls(t, users, tt.calls)
+		})
+	}
+}
+
+func TestInsertUser(t *testing.T) {
+	created := func(ctx context.Context, actorID int, name, email string) (*repository.User, error) {
+		if actorID != admin.ID {
+			return nil, errors.New("unexpected actor")
+		}
+		return &repository.User{ID: 3, Name: name, Email: email, Roles: []string{rbac.RoleUser}}, nil
+	}
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		create func(ctx context.Context, actorID int, name, email string) (*repository.User, error)
+		status int
+		code   string
+		field  string // invalid field reported
+		calls  []string
+	}{
+		{
+			name:   "created",
+			body:   map[string]string{"name": "Bob", "email": "bob@example.com"},
+			create: created,
+			status: http.StatusCreated,
+			calls:  []string{"Create"},
+		},
+		{
+			name:   "invalid email",
+			body:   map[string]string{"name": "Bob", "email": "not-an-email"},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			field:  "email",
+		},
+		{
+			name:   "missing name",
+			body:   map[string]string{"email": "bob@example.com"},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			field:  "name",
+		},
+		{
+			name:   "name too long",
+			body:   map[string]string{"name": strings.Repeat("b", 101), "email": "bob@example.com"},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			field:  "name",
+		},
+		{
+			name: "email in use",
+			body: map[string]string{"name": "Bob", "email": "alice@example.com"},
+			create: func(ctx context.Context, actorID int, name, email string) (*repository.User, error) {
+				return nil, repository.ErrConflict
+			},
+			status: http.StatusConflict,
+			code:   "E409",
+			calls:  []string{"Create"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{CreateFunc: tt.create}
+			r := testutil.AsUser(testutil.AuthRequest(t, admin, http.MethodPost, "/users", tt.body), admin, rbac.UsersCreate)
+
+			rec := testutil.Serve(newUserHandler(users).InsertUserRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got userBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.ID != 3 || got.Name != "Bob" || got.Email != "bob@example.com" {
+					t.Errorf("user = %+v", got)
+				}
+			}
+			assertCalls(t, users, tt.calls)
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	get := func(ctx context.Context, id int) (*repository.User, error) {
+		if id != alice.ID {
+			return nil, repository.ErrNotFound
+		}
+		u := alice
+		return &u, nil
+	}
+	rename := func(ctx context.Context, actorID, id int, name string) (*repository.User, error) {
+		u := alice
+		u.Name = name
+		return &u, nil
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		body   interface{}
+		status int
+		code   string
+		field  string
+		calls  []string
+	}{
+		{
+			name:   "renamed",
+			id:     "2",
+			body:   map[string]string{"name": "Alice Smith", "email": alice.Email},
+			status: http.StatusOK,
+			calls:  []string{"Get", "UpdateName"},
+		},
+		{
+			name:   "id not a number",
+			id:     "abc",
+			body:   map[string]string{"name": "Alice Smith", "email": alice.Email},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			field:  "id",
+		},
+		{
+			name:   "name too long",
+			id:     "2",
+			body:   map[string]string{"name": strings.Repeat("a", 101), "email": alice.Email},
+			status: http.StatusBadRequest,
+			code:   "E400",
+			field:  "name",
+		},
+		{
+			name:   "not found",
+			id:     "99",
+			body:   map[string]string{"name": "Nobody", "email": "nobody@example.com"},
+			status: http.StatusNotFound,
+			code:   "E404",
+			calls:  []string{"Get"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{GetFunc: get, UpdateNameFunc: rename}
+			r := testutil.AuthRequest(t, admin, http.MethodPut, "/users/"+tt.id, tt.body)
+			r = testutil.AsUser(testutil.WithPathParam(r, "id", tt.id), admin, rbac.UsersUpdate)
+
+			rec := testutil.Serve(newUserHandler(users).UpdateUserRoute(), r)
+
+			if tt.code != "" {
+				res := testutil.AssertError(t, rec, tt.status, tt.code)
+				if _, ok := res.Fields[tt.field]; tt.field != "" && !ok {
+					t.Errorf("fields = %v, want a problem with %s", res.Fields, tt.field)
+				}
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+				var got userBody
+				testutil.DecodeJSON(t, rec, &got)
+				if got.Name != "Alice Smith" || got.PendingEmail != "" {
+					t.Errorf("user = %+v", got)
+				}
+			}
+			assertCalls(t, users, tt.calls)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		err    error // returned by Delete
+		status int
+		code   string
+		calls  []string
+	}{
+		{name: "deleted", id: "2", status: http.StatusNoContent, calls: []string{"Delete"}},
+		{name: "id not a number", id: "abc", status: http.StatusBadRequest, code: "E400"},
+		{name: "not found", id: "99", err: repository.ErrNotFound, status: http.StatusNotFound, code: "E404", calls: []string{"Delete"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mocks.UserRepository{DeleteFunc: func(ctx context.Context, actorID, id int) error { return tt.err }}
+			r := testutil.AuthRequest(t, admin, http.MethodDelete, "/users/"+tt.id, nil)
+			r = testutil.AsUser(testutil.WithPathParam(r, "id", tt.id), admin, rbac.UsersDelete)
+
+			rec := testutil.Serve(newUserHandler(users).DeleteUserRoute(), r)
+
+			if tt.code != "" {
+				testutil.AssertError(t, rec, tt.status, tt.code)
+			} else {
+				testutil.AssertStatus(t, rec, tt.status)
+			}
+			assertCalls(t, users, tt.calls)
+		})
+	}
+}
+
+// assertCalls fails the test when the repository methods called differ from want
+func assertCalls(t *testing.T, repo interface{ Calls() []string }, want []string) {
+	t.Helper()
+	if got := repo.Calls(); !reflect.DeepEqual(got, want) && (len(got) > 0 || len(want) > 0) {
+		t.Errorf("repository calls = %v, want %v", got, want)
+	}
+}
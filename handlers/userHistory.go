@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// Every insert, update and delete of a users row is recorded by the users_history trigger
+// (migration 000014). The trigger finds out who made the change from the app.actor_id setting
+// of the transaction, which repository.WithActor sets.
+
+// History Entry Response Model
+type historyEntry struct {
+	ID        int64           `json:"id"`
+	ActorID   *int            `json:"actor_id"`
+	Operation string          `json:"operation"`
+	OldValues json.RawMessage `json:"old_values,omitempty" swaggertype:"object"`
+	NewValues json.RawMessage `json:"new_values,omitempty" swaggertype:"object"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// @Summary      Get the change history of a user
+// @Description  Lists every change made to the user account, most recent first, with who made it and the old and new values of the changed fields (requires users:history)
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "User ID"
+// @Success      200 {array} historyEntry
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /users/{id}/history [get]
+func (uh *UserHandler) getHistory(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	start := time.Now()
+	log.Printf("[UserHandler:getHistory] start")
+
+	id, _, herr := userIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	entries, err := uh.users.History(r.Context(), id)
+	if err != nil {
+		log.Printf("[UserHandler:getHistory] Error querying history of user %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+
+	history := make([]historyEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, historyEntry{
+			ID:        entry.ID,
+			ActorID:   entry.ActorID,
+			Operation: entry.Operation,
+			OldValues: entry.OldValues,
+			NewValues: entry.NewValues,
+			ChangedAt: entry.ChangedAt,
+		})
+	}
+
+	log.Printf("[UserHandler:getHistory] end. Took %v", time.Since(start))
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   history,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// The user operations behind the /users routes, shared with the gRPC UserService. They take the caller
+// from the context set by AuthenticateToken, and the routes (or interceptors) check the permissions first.
+
+// ListUsers returns every user, only the ones having all the tags when some are given
+func (uh *UserHandler) ListUsers(ctx context.Context, tags []string) ([]user, *apperrors.Error) {
+	// Tags are internal support annotations, so filtering by them needs the annotate permission
+	tags = uniqueStrings(tags)
+	if len(tags) > 0 {
+		if !PermissionsFrom(ctx).Has(rbac.UsersAnnotate) {
+			return nil, apperrors.Forbidden("Missing permission " + rbac.UsersAnnotate + " to filter users by tag")
+		}
+		log.Printf("[UserHandler:ListUsers] Filtering by tags %v", tags)
+	}
+
+	log.Printf("[UserHandler:ListUsers] Querying all users")
+	records, err := uh.users.List(ctx, tags)
+	if err != nil {
+		log.Printf("[UserHandler:ListUsers] Error querying all users: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	var allUsers []user
+	for i := range records {
+		allUsers = append(allUsers, *userFromRecord(&records[i]))
+	}
+	return allUsers, nil
+}
+
+func (uh *UserHandler) GetUser(ctx context.Context, id int) (*user, *apperrors.Error) {
+	log.Printf("[UserHandler:GetUser] Querying user with id %d", id)
+	found, err := uh.users.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, userNotFound(id)
+		}
+		return nil, apperrors.Internal()
+	}
+	return userFromRecord(found), nil
+}
+
+// CreateUser creates a user without password, who signs in through another backend or resets it
+func (uh *UserHandler) CreateUser(ctx context.Context, name, email string) (*user, *apperrors.Error) {
+	if herr := validateRequest(&userRequest{Name: name, Email: email}); herr != nil {
+		return nil, herr
+	}
+
+	// the email may still be held by a deleted account, depending on EMAIL_REUSE_POLICY
+	blocked, err := emailBlockedByDeletedAccount(ctx, uh.db, uh.cfg.EmailReusePolicy, uh.cfg.PIIEncryptionKey, email)
+	if err != nil {
+		log.Printf("[UserHandler:CreateUser] Error checking email reuse policy: %v", err)
+		return nil, apperrors.Internal()
+	}
+	if blocked {
+		return nil, apperrors.Conflict("Email belonged to a deleted account and can't be reused. Please use a different email.")
+	}
+
+	log.Printf("[UserHandler:CreateUser] Inserting user with {name: %s} and {email: %s}", name, email)
+
+	// insert user
+	actorID, _ := ctx.Value(ContextUserIDKey).(int)
+	inserted, err := uh.users.Create(ctx, actorID, name, email)
+	if err != nil {
+		log.Printf("[UserHandler:CreateUser] Error inserting user: %v", err)
+		return nil, apperrors.FromError(err)
+	}
+
+	insertedUser := userFromRecord(inserted)
+	log.Printf("[UserHandler:CreateUser] Inserted user: %+v", insertedUser)
+	uh.Events.Publish(ctx, events.UserCreated, insertedUser.event())
+	return insertedUser, nil
+}
+
+// UpdateUser renames a user. A new email is only requested: it applies once confirmed and is
+// returned as PendingEmail meanwhile.
+func (uh *UserHandler) UpdateUser(ctx context.Context, id int, name, email string) (*user, *apperrors.Error) {
+	if herr := validateRequest(&userRequest{Name: name, Email: email}); herr != nil {
+		return nil, herr
+	}
+
+	log.Printf("[UserHandler:UpdateUser] Querying user with id %d", id)
+	foundUser, err := uh.users.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, userNotFound(id)
+		}
+		return nil, apperrors.Internal()
+	}
+
+	// the email is the login identity, so it only changes once the new address is confirmed
+	pendingEmail := ""
+	if email != foundUser.Email {
+		log.Printf("[UserHandler:UpdateUser] Requesting email change of user %d to %s", id, email)
+		if herr := requestEmailChange(ctx, uh.db, uh.mailer, uh.cfg, id, email); herr != nil {
+			return nil, herr
+		}
+		pendingEmail = email
+	}
+
+	// update user
+	log.Printf("[UserHandler:UpdateUser] Updating user with id %d with {name: %s}", id, name)
+	actorID, _ := ctx.Value(ContextUserIDKey).(int)
+	updated, err := uh.users.UpdateName(ctx, actorID, id, name)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, userNotFound(id)
+		}
+		return nil, apperrors.Internal()
+	}
+
+	updatedUser := userFromRecord(updated)
+	updatedUser.PendingEmail = pendingEmail
+	log.Printf("[UserHandler:UpdateUser] User updated: %+v", updatedUser)
+	uh.Events.Publish(ctx, events.UserUpdated, updatedUser.event())
+	return updatedUser, nil
+}
+
+// DeleteUser soft deletes a user, the row is kept so EMAIL_REUSE_POLICY can be enforced
+func (uh *UserHandler) DeleteUser(ctx context.Context, id int) *apperrors.Error {
+	log.Printf("[UserHandler:DeleteUser] Deleting user with id %d", id)
+	actorID, _ := ctx.Value(ContextUserIDKey).(int)
+	if err := uh.users.Delete(ctx, actorID, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return userNotFound(id)
+		}
+		return apperrors.Internal()
+	}
+
+	log.Printf("[UserHandler:DeleteUser] User deleted with id %d", id)
+	uh.Events.Publish(ctx, events.UserDeleted, events.UserRef{ID: id})
+	return nil
+}
+
+func userNotFound(id int) *apperrors.Error {
+	return apperrors.NotFound("User with id " + strconv.Itoa(id) + " not found")
+}
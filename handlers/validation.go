@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+)
+
+// Request bodies declare their rules with `validate` struct tags (see userRequest), and decodeRequest
+// checks them right after decoding (Handle does both for typed handlers). Failed rules are reported by json field name, so clients can show
+// them next to the matching input.
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		// Parameters are reported by their name in the path or the query (see bindRequest)
+		if _, name := paramTag(field); name != "" {
+			return name
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// decodeRequest parses the JSON body into req and validates it. The error is ready to be returned by the handler:
+// a 400 with one entry per invalid field in Response.Fields.
+func decodeRequest(r *http.Request, req interface{}) *apperrors.Error {
+	if herr := decodeBody(r, req); herr != nil {
+		return herr
+	}
+	return validateRequest(req)
+}
+
+// validateRequest checks the rules of req, for requests that don't come as a JSON body (gRPC)
+func validateRequest(req interface{}) *apperrors.Error {
+	err := validate.Struct(req)
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return nil
+	}
+
+	fields := make(map[string]string, len(invalid))
+	details := make([]string, 0, len(invalid))
+	for _, fieldErr := range invalid {
+		problem := validationMessage(fieldErr)
+		fields[fieldErr.Field()] = problem
+		details = append(details, fieldErr.Field()+" "+problem)
+	}
+	return apperrors.InvalidFields(strings.Join(details, "; "), fields)
+}
+
+// validationMessage describes a failed rule, it is prefixed with the field name
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "min":
+		if isNumber(fieldErr.Kind()) {
+			return "must be at least " + fieldErr.Param()
+		}
+		return "must have at least " + fieldErr.Param() + " " + unitOf(fieldErr.Kind())
+	case "max":
+		if isNumber(fieldErr.Kind()) {
+			return "must be at most " + fieldErr.Param()
+		}
+		return "must have at most " + fieldErr.Param() + " " + unitOf(fieldErr.Kind())
+	case "http_url":
+		return "must be an http or https URL"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldErr.Param()), ", ")
+	default:
+		return "is invalid"
+	}
+}
+
+func isNumber(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+// unitOf is what the length of a value counts: entries of maps and slices, characters of strings
+func unitOf(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return "entries"
+	default:
+		return "characters"
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/buildinfo"
+)
+
+const VersionHeader = "X-App-Version"
+
+// VersionHeaderMiddleware sends the version of the binary in the X-App-Version header of every response,
+// so a client or a proxy log tells which release answered.
+func VersionHeaderMiddleware(next http.Handler) http.Handler {
+	version := buildinfo.Get().Version
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(VersionHeader, version)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary Build and version info
+// @Description Returns the version of the binary, the git commit and date it was built from and the Go runtime it runs on
+// @Tags index
+// @Produce json
+// @Success 200 {object} buildinfo.Info
+// @Router /version [get]
+func (ih *IndexHandler) Version(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	return &HandlerSuccess{Status: http.StatusOK, Data: buildinfo.Get()}, nil
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/hi-im-yan/jwt-with-go/webhooks"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WebhookHandler manages the endpoints notified of the user lifecycle events (see the webhooks package)
+type WebhookHandler struct {
+	cfg *config.Config
+	db  *pgxpool.Pool
+}
+
+// Webhook Response Model
+type webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	// Signing secret, only sent when the webhook is created or its secret rotated
+	Secret string `json:"secret,omitempty"`
+}
+
+// Webhook Request Model
+type webhookRequest struct {
+	URL string `json:"url" validate:"required,http_url,max=2000"`
+	// Any of user.created, user.updated, user.deleted and login.failed
+	Events []string `json:"events"`
+	// Defaults to true on creation, left unchanged by updates when omitted
+	Active *bool `json:"active"`
+}
+
+const webhookColumns = `id, url, events, active, created_at`
+
+func NewWebhookHandler(cfg *config.Config, db *pgxpool.Pool) *WebhookHandler {
+	return &WebhookHandler{cfg: cfg, db: db}
+}
+
+// Configuration of routes
+func (wh *WebhookHandler) WebhookRouter() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(MiddlewareAdapter(JWTAuthMiddleware(wh.db, wh.cfg.JWT)), MiddlewareAdapter(ProfileCompletionMiddleware(wh.db, wh.cfg.ProfileExemptRoutes)), MiddlewareAdapter(RequirePermission(rbac.WebhooksManage)))
+
+	// Routes
+	r.HandleFunc("GET /", ApiHandlerAdapter(wh.getWebhooks))
+	r.HandleFunc("POST /", ApiHandlerAdapter(wh.createWebhook))
+	r.HandleFunc("GET /{id}", ApiHandlerAdapter(wh.getWebhook))
+	r.HandleFunc("PUT /{id}", ApiHandlerAdapter(wh.updateWebhook))
+	r.HandleFunc("DELETE /{id}", ApiHandlerAdapter(wh.deleteWebhook))
+	r.HandleFunc("POST /{id}/secret", ApiHandlerAdapter(wh.rotateSecret))
+
+	return r
+}
+
+// @Summary      List webhooks
+// @Description  Lists the registered webhook endpoints, without their secrets
+// @Tags         webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array} webhook
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks [get]
+func (wh *WebhookHandler) getWebhooks(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[WebhookHandler:getWebhooks] start")
+
+	rows, err := wh.db.Query(r.Context(), `SELECT `+webhookColumns+` FROM webhooks ORDER BY id;`)
+	if err != nil {
+		log.Printf("[WebhookHandler:getWebhooks] Error querying webhooks: %v", err)
+		return nil, apperrors.Internal()
+	}
+	defer rows.Close()
+
+	hooks := []webhook{}
+	for rows.Next() {
+		var hook webhook
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Active, &hook.CreatedAt); err != nil {
+			log.Printf("[WebhookHandler:getWebhooks] Error scanning webhook: %v", err)
+			return nil, apperrors.Internal()
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   hooks,
+	}, nil
+}
+
+// @Summary      Register a webhook
+// @Description  Registers an endpoint for the given events. The answer holds the secret deliveries are signed with, it is not shown again
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body webhookRequest true "Webhook"
+// @Success      201 {object} webhook
+// @Failure      400 {object} apperrors.Response
+// @Failure      403 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks [post]
+func (wh *WebhookHandler) createWebhook(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[WebhookHandler:createWebhook] start")
+
+	webhookReq, herr := decodeWebhookRequest(r)
+	if herr != nil {
+		return nil, herr
+	}
+	active := webhookReq.Active == nil || *webhookReq.Active
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		log.Printf("[WebhookHandler:createWebhook] Error generating secret: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	var hook webhook
+	err = wh.db.QueryRow(r.Context(), `INSERT INTO webhooks (url, secret, events, active) VALUES ($1, $2, $3, $4) RETURNING `+webhookColumns+`;`,
+		webhookReq.URL, secret, webhookReq.Events, active).Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Active, &hook.CreatedAt)
+	if err != nil {
+		log.Printf("[WebhookHandler:createWebhook] Error inserting webhook: %v", err)
+		return nil, apperrors.Internal()
+	}
+	hook.Secret = secret
+
+	log.Printf("[WebhookHandler:createWebhook] Registered webhook %d for %v", hook.ID, hook.Events)
+	return &HandlerSuccess{
+		Status: http.StatusCreated,
+		Data:   hook,
+	}, nil
+}
+
+// @Summary      Get a webhook
+// @Description  Returns a webhook endpoint, without its secret
+// @Tags         webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Webhook ID"
+// @Success      200 {object} webhook
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks/{id} [get]
+func (wh *WebhookHandler) getWebhook(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	id, herr := webhookIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	return wh.webhookOf(r.Context(), id)
+}
+
+// @Summary      Update a webhook
+// @Description  Changes the URL, events or state of a webhook. Pending deliveries go to the new URL, none are sent once it is inactive
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Webhook ID"
+// @Param        request body webhookRequest true "Webhook"
+// @Success      200 {object} webhook
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks/{id} [put]
+func (wh *WebhookHandler) updateWebhook(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[WebhookHandler:updateWebhook] start")
+
+	id, herr := webhookIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	webhookReq, herr := decodeWebhookRequest(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	result, err := wh.db.Exec(r.Context(), `UPDATE webhooks SET url = $1, events = $2, active = COALESCE($3, active) WHERE id = $4;`,
+		webhookReq.URL, webhookReq.Events, webhookReq.Active, id)
+	if err != nil {
+		log.Printf("[WebhookHandler:updateWebhook] Error updating webhook: %v", err)
+		return nil, apperrors.Internal()
+	}
+	if result.RowsAffected() == 0 {
+		return nil, webhookNotFound(id)
+	}
+
+	return wh.webhookOf(r.Context(), id)
+}
+
+// @Summary      Delete a webhook
+// @Description  Deletes a webhook endpoint, its pending deliveries are dropped
+// @Tags         webhooks
+// @Security     BearerAuth
+// @Param        id path int true "Webhook ID"
+// @Success      204
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks/{id} [delete]
+func (wh *WebhookHandler) deleteWebhook(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[WebhookHandler:deleteWebhook] start")
+
+	id, herr := webhookIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+
+	result, err := wh.db.Exec(r.Context(), `DELETE FROM webhooks WHERE id = $1;`, id)
+	if err != nil {
+		log.Printf("[WebhookHandler:deleteWebhook] Error deleting webhook: %v", err)
+		return nil, apperrors.Internal()
+	}
+	if result.RowsAffected() == 0 {
+		return nil, webhookNotFound(id)
+	}
+
+	return &HandlerSuccess{Status: http.StatusNoContent}, nil
+}
+
+// @Summary      Rotate a webhook secret
+// @Description  Replaces the signing secret of a webhook and returns the new one. Deliveries are signed with it right away, including the retries of earlier events
+// @Tags         webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "Webhook ID"
+// @Success      200 {object} webhook
+// @Failure      400 {object} apperrors.Response
+// @Failure      404 {object} apperrors.Response
+// @Failure      500 {object} apperrors.Response
+// @Router       /admin/webhooks/{id}/secret [post]
+func (wh *WebhookHandler) rotateSecret(w http.ResponseWriter, r *http.Request) (*HandlerSuccess, *apperrors.Error) {
+	log.Printf("[WebhookHandler:rotateSecret] start")
+
+	id, herr := webhookIDParam(r)
+	if herr != nil {
+		return nil, herr
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		log.Printf("[WebhookHandler:rotateSecret] Error generating secret: %v", err)
+		return nil, apperrors.Internal()
+	}
+
+	var hook webhook
+	err = wh.db.QueryRow(r.Context(), `UPDATE webhooks SET secret = $1 WHERE id = $2 RETURNING `+webhookColumns+`;`, secret, id).
+		Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Active, &hook.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, webhookNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[WebhookHandler:rotateSecret] Error updating secret: %v", err)
+		return nil, apperrors.Internal()
+	}
+	hook.Secret = secret
+
+	log.Printf("[WebhookHandler:rotateSecret] Rotated the secret of webhook %d", id)
+	return &HandlerSuccess{
+		Status: http.StatusOK,
+		Data:   hook,
+	}, nil
+}
+
+func (wh *WebhookHandler) webhookOf(ctx context.Context, id int) (*HandlerSuccess, *apperrors.Error) {
+	var hook webhook
+	err := wh.db.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1;`, id).
+		Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Active, &hook.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, webhookNotFound(id)
+	}
+	if err != nil {
+		log.Printf("[WebhookHandler:webhookOf] Error querying webhook %d: %v", id, err)
+		return nil, apperrors.Internal()
+	}
+	return &HandlerSuccess{Status: http.StatusOK, Data: hook}, nil
+}
+
+func decodeWebhookRequest(r *http.Request) (*webhookRequest, *apperrors.Error) {
+	defer r.Body.Close()
+
+	var webhookReq webhookRequest
+	if herr := decodeRequest(r, &webhookReq); herr != nil {
+		return nil, herr
+	}
+	webhookReq.Events = uniqueStrings(webhookReq.Events)
+	if len(webhookReq.Events) == 0 {
+		return nil, apperrors.InvalidFields("events is required", map[string]string{"events": "is required"})
+	}
+	for _, event := range webhookReq.Events {
+		if !slices.Contains(webhooks.Events, event) {
+			problem := "must be any of " + strings.Join(webhooks.Events, ", ")
+			return nil, apperrors.InvalidFields("events "+problem, map[string]string{"events": problem})
+		}
+	}
+	return &webhookReq, nil
+}
+
+func webhookIDParam(r *http.Request) (int, *apperrors.Error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, apperrors.BadRequest("Path parameter 'id' must be an integer")
+	}
+	return id, nil
+}
+
+func webhookNotFound(id int) *apperrors.Error {
+	return apperrors.NotFound("Webhook with id " + strconv.Itoa(id) + " not found")
+}
@@ -0,0 +1,160 @@
+// Package janitor periodically deletes the rows that are of no use anymore: expired or revoked
+// sessions, expired email changes, invites and data exports, old idempotency keys, finished jobs,
+// sent or failed emails and the accounts deleted long ago.
+// Each task is a DELETE statement (or a transaction, see Task.Func) run on its own schedule, the
+// number of rows removed is exported as jwtapi_janitor_rows_deleted_total{task}.
+package janitor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Task deletes rows with Query every Interval. Query can use $1, the retention cutoff: rows that
+// ended (expired, revoked, finished...) before it are the ones to delete.
+type Task struct {
+	Name     string
+	Query    string
+	Interval time.Duration
+	// How long the ended rows are kept, e.g. for support or audits
+	Retention time.Duration
+	// Func replaces Query for the cleanups taking several statements. It runs in a transaction and
+	// returns the number of rows deleted.
+	Func func(ctx context.Context, tx pgx.Tx, cutoff time.Time) (int64, error)
+}
+
+type Janitor struct {
+	db    *pgxpool.Pool
+	tasks []Task
+}
+
+func New(db *pgxpool.Pool, tasks ...Task) *Janitor {
+	return &Janitor{db: db, tasks: tasks}
+}
+
+// Add schedules one more task, before Run
+func (j *Janitor) Add(task Task) {
+	j.tasks = append(j.tasks, task)
+}
+
+// DefaultTasks are the cleanups of the tables of the API. Sessions are kept for retention after they
+// ended, since revoked ones are what rejects their tokens until they expire anyway.
+func DefaultTasks(interval, retention, idempotencyKeyTTL time.Duration) []Task {
+	return []Task{
+		{Name: "sessions", Interval: interval, Retention: retention,
+			Query: `DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1;`},
+		{Name: "email_changes", Interval: interval,
+			Query: `DELETE FROM email_changes WHERE expires_at < $1;`},
+		{Name: "invites", Interval: interval, Retention: retention,
+			Query: `DELETE FROM invites WHERE accepted_at IS NULL AND (expires_at < $1 OR revoked_at < $1);`},
+		{Name: "idempotency_keys", Interval: interval, Retention: idempotencyKeyTTL,
+			Query: `DELETE FROM idempotency_keys WHERE created_at < $1;`},
+		{Name: "jobs", Interval: interval, Retention: retention,
+			Query: `DELETE FROM jobs WHERE status IN ('done', 'failed') AND finished_at < $1;`},
+		{Name: "data_exports", Interval: interval,
+			Query: `DELETE FROM data_exports WHERE expires_at < $1;`},
+		{Name: "erasure_requests", Interval: interval,
+			Query: `DELETE FROM erasure_requests WHERE confirmed_at IS NULL AND expires_at < $1;`},
+		{Name: "emails", Interval: interval, Retention: retention,
+			Query: `DELETE FROM emails WHERE finished_at < $1;`},
+	}
+}
+
+// PurgeDeletedUsers deletes the accounts soft deleted more than retention ago, along with the rows
+// referencing them. Their emails can then be registered again under EMAIL_REUSE_POLICY=after_purge.
+// Their change history is kept without the personal values, like after an erasure, and the purge
+// itself is not recorded in it. purged, when not nil, is called for each purged user so the user
+// cache drops them.
+func PurgeDeletedUsers(interval, retention time.Duration, purged func(ctx context.Context, id int)) Task {
+	purge := func(ctx context.Context, tx pgx.Tx, cutoff time.Time) (int64, error) {
+		ids, err := purgeDeletedUsers(ctx, tx, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		// Before the commit, but the lookups in between already find the users deleted
+		if purged != nil {
+			for _, id := range ids {
+				purged(ctx, id)
+			}
+		}
+		return int64(len(ids)), nil
+	}
+	return Task{Name: "deleted_users", Interval: interval, Retention: retention, Func: purge}
+}
+
+// purgeDeletedUsers returns the ids of the purged users
+func purgeDeletedUsers(ctx context.Context, tx pgx.Tx, cutoff time.Time) ([]int, error) {
+	// Read by the users_history trigger (migration 000028)
+	if _, err := tx.Exec(ctx, `SELECT set_config('app.skip_history', 'on', true);`); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM users WHERE deleted_at < $1 RETURNING id;`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	query := `UPDATE user_history SET old_values = old_values - $2::text[], new_values = new_values - $2::text[] WHERE user_id = ANY($1);`
+	if _, err := tx.Exec(ctx, query, ids, repository.PersonalHistoryKeys); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Run runs every task right away and then on its interval, until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, task := range j.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j.run(ctx, task)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(task.Interval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (j *Janitor) run(ctx context.Context, task Task) {
+	deleted, err := j.clean(ctx, task, time.Now().Add(-task.Retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[Janitor:run] Error cleaning %s: %v", task.Name, err)
+		}
+		return
+	}
+	metrics.ObserveJanitorDeletes(task.Name, deleted)
+	if deleted > 0 {
+		log.Printf("[Janitor:run] Deleted %d rows of %s", deleted, task.Name)
+	}
+}
+
+// clean runs the statements of task and returns the number of rows deleted
+func (j *Janitor) clean(ctx context.Context, task Task, cutoff time.Time) (int64, error) {
+	if task.Func == nil {
+		tag, err := j.db.Exec(ctx, task.Query, cutoff)
+		return tag.RowsAffected(), err
+	}
+	var deleted int64
+	err := repository.WithTx(ctx, j.db, func(tx pgx.Tx) error {
+		var err error
+		deleted, err = task.Func(ctx, tx, cutoff)
+		return err
+	})
+	return deleted, err
+}
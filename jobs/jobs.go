@@ -0,0 +1,194 @@
+// Package jobs runs slow tasks (sending emails, imports, webhook deliveries...) outside of the
+// request cycle. Jobs are rows of the jobs table: handlers enqueue them, possibly in the
+// transaction of the change that caused them, and the workers of Queue.Run pick them up with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of instances can share the table.
+// A failed job is retried with an exponential backoff until it runs out of attempts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Handler runs one job of a kind with its payload. An error makes the job retry later.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+	MaxAttempts  int
+	// Delay before the first retry, doubled on each following one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// A job running for longer is considered abandoned (its instance died) and is claimed again
+	LockTimeout time.Duration
+}
+
+type Queue struct {
+	db  *pgxpool.Pool
+	cfg Config
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewQueue(db *pgxpool.Pool, cfg Config) *Queue {
+	return &Queue{db: db, cfg: cfg, handlers: map[string]Handler{}}
+}
+
+// Register sets the handler of the jobs of kind, before Run
+func (q *Queue) Register(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Enqueue adds a job of kind, payload is encoded as JSON
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}) error {
+	return q.EnqueueTx(ctx, q.db, kind, payload)
+}
+
+// EnqueueTx adds a job through db, usually a transaction: the job only exists if it commits
+func (q *Queue) EnqueueTx(ctx context.Context, db repository.Querier, kind string, payload interface{}) error {
+	return q.EnqueueAtTx(ctx, db, kind, payload, time.Time{})
+}
+
+// EnqueueAtTx is EnqueueTx for a job that must not run before runAt, a zero runAt is right away
+func (q *Queue) EnqueueAtTx(ctx context.Context, db repository.Querier, kind string, payload interface{}, runAt time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("jobs: encoding %s payload: %w", kind, err)
+	}
+	if runAt.IsZero() {
+		_, err = db.Exec(ctx, `INSERT INTO jobs (kind, payload, max_attempts) VALUES ($1, $2, $3);`, kind, data, q.cfg.MaxAttempts)
+	} else {
+		_, err = db.Exec(ctx, `INSERT INTO jobs (kind, payload, max_attempts, run_at) VALUES ($1, $2, $3, $4);`, kind, data, q.cfg.MaxAttempts, runAt)
+	}
+	return err
+}
+
+// Run starts the workers and blocks until ctx is done and the jobs they were running returned.
+// Running jobs get their own context, so a shutdown lets them finish instead of cutting them off.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		// Keep going while there are jobs, wait for the next poll once the queue is empty
+		ran, err := q.runNext()
+		if err != nil {
+			log.Printf("[Jobs:work] Error claiming a job: %v", err)
+		}
+		if ran && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+type job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// runNext claims the next runnable job and runs it, ran is false when there was none
+func (q *Queue) runNext() (ran bool, err error) {
+	ctx := context.Background()
+	var j job
+	query := `UPDATE jobs SET status = 'running', locked_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= NOW()) OR (status = 'running' AND locked_at < $1)
+			ORDER BY run_at FOR UPDATE SKIP LOCKED LIMIT 1
+		)
+		RETURNING id, kind, payload, attempts, max_attempts;`
+	err = q.db.QueryRow(ctx, query, time.Now().Add(-q.cfg.LockTimeout)).Scan(&j.ID, &j.Kind, &j.Payload, &j.Attempts, &j.MaxAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	runErr := q.run(ctx, &j)
+	return true, q.finish(ctx, &j, runErr)
+}
+
+func (q *Queue) run(ctx context.Context, j *job) (err error) {
+	q.mu.RLock()
+	h, ok := q.handlers[j.Kind]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", j.Kind)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.LockTimeout)
+	defer cancel()
+	return h(ctx, j.Payload)
+}
+
+// finish records the outcome of a run: done, retried after a backoff, or failed for good
+func (q *Queue) finish(ctx context.Context, j *job, runErr error) error {
+	if runErr == nil {
+		metrics.ObserveJob(j.Kind, metrics.JobDone)
+		_, err := q.db.Exec(ctx, `UPDATE jobs SET status = 'done', finished_at = NOW(), locked_at = NULL WHERE id = $1;`, j.ID)
+		return err
+	}
+
+	if j.Attempts >= j.MaxAttempts {
+		log.Printf("[Jobs:finish] Job %d (%s) failed for good after %d attempts: %v", j.ID, j.Kind, j.Attempts, runErr)
+		metrics.ObserveJob(j.Kind, metrics.JobFailed)
+		_, err := q.db.Exec(ctx, `UPDATE jobs SET status = 'failed', finished_at = NOW(), locked_at = NULL, last_error = $2 WHERE id = $1;`, j.ID, runErr.Error())
+		return err
+	}
+
+	delay := q.backoff(j.Attempts)
+	log.Printf("[Jobs:finish] Job %d (%s) failed, attempt %d of %d, retrying in %v: %v", j.ID, j.Kind, j.Attempts, j.MaxAttempts, delay, runErr)
+	metrics.ObserveJob(j.Kind, metrics.JobRetried)
+	_, err := q.db.Exec(ctx, `UPDATE jobs SET status = 'pending', run_at = $2, locked_at = NULL, last_error = $3 WHERE id = $1;`,
+		j.ID, time.Now().Add(delay), runErr.Error())
+	return err
+}
+
+// backoff is the delay before the retry following the given attempt
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.cfg.Backoff
+	for i := 1; i < attempt && delay < q.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, q.cfg.MaxBackoff)
+}
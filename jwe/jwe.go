@@ -0,0 +1,107 @@
+// Package jwe encrypts tokens with JSON Web Encryption (RFC 7516), in the compact serialization with
+// direct encryption ("alg": "dir") and AES-256-GCM ("enc": "A256GCM"). The signed JWT is the plaintext,
+// so clients holding an encrypted token can't read its claims and the server verifies the signature
+// once the token is decrypted.
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// KeySize is the length of the A256GCM keys
+const KeySize = 32
+
+var ErrInvalid = errors.New("jwe: invalid token")
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	// Content type, "JWT" for a nested token
+	Cty string `json:"cty,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Encrypt returns the compact JWE of the token jwt
+func Encrypt(jwt string, key []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	protected, err := json.Marshal(header{Alg: "dir", Enc: "A256GCM", Cty: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := b64.EncodeToString(protected)
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	// The encoded header is the additional authenticated data, the tag ends the sealed bytes
+	sealed := aead.Seal(nil, iv, []byte(jwt), []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	// The encrypted key is empty with direct encryption
+	return strings.Join([]string{
+		encodedHeader,
+		"",
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ciphertext),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt returns the token encrypted in the compact JWE token
+func Decrypt(token string, key []byte) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", ErrInvalid
+	}
+	protected, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalid
+	}
+	var h header
+	if err := json.Unmarshal(protected, &h); err != nil || h.Alg != "dir" || h.Enc != "A256GCM" {
+		return "", ErrInvalid
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	iv, ivErr := b64.DecodeString(parts[2])
+	ciphertext, ciphertextErr := b64.DecodeString(parts[3])
+	tag, tagErr := b64.DecodeString(parts[4])
+	if ivErr != nil || ciphertextErr != nil || tagErr != nil || len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", ErrInvalid
+	}
+	plaintext, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted tells if token is in the compact JWE serialization (five parts) rather than a JWS (three)
+func IsEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("jwe: the key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	URL           string // ldap://host:389 or ldaps://host:636
+	BindDN        string // service account used to look users up, anonymous search when empty
+	BindPassword  string
+	BaseDN        string
+	UserAttribute string // attribute matched against the login email, e.g. "mail" or "userPrincipalName"
+	NameAttribute string // attribute used as display name, e.g. "cn" or "displayName"
+	Timeout       time.Duration
+}
+
+// Authenticator verifies credentials with the usual search-then-bind flow:
+// find the user's DN with the service account, then bind as that DN with the given password.
+type Authenticator struct {
+	cfg Config
+}
+
+// User is what the directory knows about an authenticated user
+type User struct {
+	DN     string
+	Name   string
+	Email  string
+	Groups []string
+}
+
+func NewAuthenticator(cfg Config) *Authenticator {
+	if cfg.UserAttribute == "" {
+		cfg.UserAttribute = "mail"
+	}
+	if cfg.NameAttribute == "" {
+		cfg.NameAttribute = "cn"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &Authenticator{cfg: cfg}
+}
+
+// URL is the address of the directory server, what its users are known by along with their DN
+func (a *Authenticator) URL() string {
+	return a.cfg.URL
+}
+
+// Authenticate returns ErrInvalidCredentials when the user does not exist or the password is wrong
+func (a *Authenticator) Authenticate(email, password string) (*User, error) {
+	conn, err := Dial(a.cfg.URL, a.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service account bind failed: %w", err)
+		}
+	}
+
+	entries, err := conn.SearchEqual(a.cfg.BaseDN, a.cfg.UserAttribute, email, []string{a.cfg.NameAttribute, "memberOf"})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if len(entries) > 1 {
+		return nil, fmt.Errorf("ldap: %d entries found for %s", len(entries), email)
+	}
+
+	entry := entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, err
+	}
+
+	return &User{
+		DN:     entry.DN,
+		Name:   entry.First(a.cfg.NameAttribute),
+		Email:  email,
+		Groups: entry.Attributes["memberOf"],
+	}, nil
+}
+
+// MemberOf tells if the user belongs to the group DN (DNs are compared case-insensitively)
+func (u *User) MemberOf(groupDN string) bool {
+	for _, g := range u.Groups {
+		if strings.EqualFold(g, groupDN) {
+			return true
+		}
+	}
+	return false
+}
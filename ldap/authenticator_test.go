@@ -0,0 +1,187 @@
+package ldap
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const (
+	serviceDN       = "cn=service,dc=example,dc=org"
+	servicePassword = "service-secret"
+	aliceDN         = "uid=alice,ou=people,dc=example,dc=org"
+	alicePassword   = "alice-secret"
+	adminsDN        = "cn=Admins,ou=groups,dc=example,dc=org"
+)
+
+// fakeDirectory is an LDAP server answering binds with its passwords and equality searches on mail
+// with its entries
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   map[string][]*Entry // by mail
+
+	mu    sync.Mutex
+	binds []string
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{serviceDN: servicePassword, aliceDN: alicePassword},
+		entries: map[string][]*Entry{
+			"alice@example.org": {{DN: aliceDN, Attributes: map[string][]string{"cn": {"Alice"}, "memberOf": {adminsDN}}}},
+			"twin@example.org":  {{DN: "uid=twin1,dc=example,dc=org"}, {DN: "uid=twin2,dc=example,dc=org"}},
+		},
+	}
+}
+
+// start serves the connections of the test and returns the ldap:// URL of the server
+func (d *fakeDirectory) start(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id, _ := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+		switch op.Tag {
+		case appBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+			code := int64(resultSuccess)
+			if want, ok := d.passwords[dn]; !ok || want != password {
+				code = resultInvalidCreds
+			}
+			reply(conn, id, result(appBindResponse, code))
+		case appSearchRequest:
+			filter := op.Children[6]
+			for _, e := range d.entries[filter.Children[1].Data.String()] {
+				reply(conn, id, entry(e))
+			}
+			reply(conn, id, result(appSearchResDone, resultSuccess))
+		case appUnbindRequest:
+			return
+		}
+	}
+}
+
+func reply(conn net.Conn, id int64, op *ber.Packet) {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	msg.AppendChild(op)
+	conn.Write(msg.Bytes())
+}
+
+func result(tag ber.Tag, code int64) *ber.Packet {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return res
+}
+
+func entry(e *Entry) *ber.Packet {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchResEntry, nil, "Search Result Entry")
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+	attrs := ber.NewSequence("Attributes")
+	for name, values := range e.Attributes {
+		attr := ber.NewSequence("Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	res.AppendChild(attrs)
+	return res
+}
+
+func TestAuthenticate(t *testing.T) {
+	url := newFakeDirectory().start(t)
+
+	tests := []struct {
+		name         string
+		bindPassword string
+		email        string
+		password     string
+		wantErr      error // nil when any error is fine
+		wantFail     bool
+	}{
+		{name: "valid credentials", bindPassword: servicePassword, email: "alice@example.org", password: alicePassword},
+		{name: "wrong password", bindPassword: servicePassword, email: "alice@example.org", password: "nope", wantErr: ErrInvalidCredentials, wantFail: true},
+		{name: "empty password is not an anonymous bind", bindPassword: servicePassword, email: "alice@example.org", password: "", wantErr: ErrInvalidCredentials, wantFail: true},
+		{name: "unknown user", bindPassword: servicePassword, email: "bob@example.org", password: "x", wantErr: ErrInvalidCredentials, wantFail: true},
+		{name: "several entries for the email", bindPassword: servicePassword, email: "twin@example.org", password: "x", wantFail: true},
+		{name: "service account rejected", bindPassword: "wrong", email: "alice@example.org", password: alicePassword, wantErr: ErrInvalidCredentials, wantFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthenticator(Config{URL: url, BindDN: serviceDN, BindPassword: tt.bindPassword, BaseDN: "dc=example,dc=org"})
+			u, err := a.Authenticate(tt.email, tt.password)
+			if tt.wantFail {
+				if err == nil {
+					t.Fatalf("Authenticate() = %+v, want an error", u)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if u.DN != aliceDN || u.Name != "Alice" || u.Email != tt.email {
+				t.Errorf("Authenticate() = %+v", u)
+			}
+			if !u.MemberOf("cn=admins,ou=groups,dc=example,dc=org") {
+				t.Errorf("MemberOf(admins) = false, groups %v", u.Groups)
+			}
+		})
+	}
+}
+
+func TestAuthenticateBindsAsTheUser(t *testing.T) {
+	dir := newFakeDirectory()
+	a := NewAuthenticator(Config{URL: dir.start(t), BindDN: serviceDN, BindPassword: servicePassword, BaseDN: "dc=example,dc=org"})
+	if _, err := a.Authenticate("alice@example.org", alicePassword); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	if len(dir.binds) != 2 || dir.binds[0] != serviceDN || dir.binds[1] != aliceDN {
+		t.Errorf("binds = %v, want the service account then the user", dir.binds)
+	}
+}
+
+func TestDialRejectsUnknownScheme(t *testing.T) {
+	if _, err := Dial("http://localhost", 0); err == nil {
+		t.Fatal("Dial(http://) succeeded")
+	}
+}
@@ -0,0 +1,227 @@
+// Package ldap is a minimal LDAP v3 client. It only implements what the login flow
+// needs: simple bind, a subtree search with an equality filter and unbind.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP protocol operations (RFC 4511, section 4.2)
+const (
+	appBindRequest     = 0
+	appBindResponse    = 1
+	appUnbindRequest   = 2
+	appSearchRequest   = 3
+	appSearchResEntry  = 4
+	appSearchResDone   = 5
+	filterEqualityTag  = 3
+	authSimpleTag      = 0
+	scopeWholeSubtree  = 2
+	derefNever         = 0
+	resultSuccess      = 0
+	resultInvalidCreds = 49
+)
+
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// Entry is a search result with its attributes
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// First returns the first value of the attribute or an empty string
+func (e *Entry) First(attribute string) string {
+	if v := e.Attributes[attribute]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type Conn struct {
+	conn      net.Conn
+	messageID int64
+}
+
+// Dial connects to an ldap:// or ldaps:// URL
+func Dial(rawURL string, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostPort(u, "389"))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "636"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return &Conn{conn: conn}, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.messageID++
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.messageID, "MessageID"))
+	msg.AppendChild(ber.Encode(ber.ClassApplication, ber.TypePrimitive, appUnbindRequest, nil, "Unbind Request"))
+	c.conn.Write(msg.Bytes())
+	return c.conn.Close()
+}
+
+// Bind does a simple bind. An empty password is rejected because servers treat it as an
+// anonymous bind, which would "succeed" for any DN.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appBindRequest, nil, "Bind Request")
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "Name"))
+	req.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, authSimpleTag, password, "Password"))
+
+	res, err := c.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	if res.Tag != appBindResponse {
+		return fmt.Errorf("ldap: unexpected response tag %d to bind", res.Tag)
+	}
+	return resultError(res)
+}
+
+// SearchEqual looks for entries under baseDN where attribute equals value
+func (c *Conn) SearchEqual(baseDN, attribute, value string, attributes []string) ([]*Entry, error) {
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchRequest, nil, "Search Request")
+	req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, baseDN, "Base DN"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeWholeSubtree, "Scope"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, derefNever, "Deref Aliases"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 2, "Size Limit"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "Time Limit"))
+	req.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "Types Only"))
+
+	filter := ber.Encode(ber.ClassContext, ber.TypeConstructed, filterEqualityTag, nil, "Equality Match")
+	filter.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "Attribute"))
+	filter.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+	req.AppendChild(filter)
+
+	attrs := ber.NewSequence("Attributes")
+	for _, a := range attributes {
+		attrs.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a, "Attribute"))
+	}
+	req.AppendChild(attrs)
+
+	id, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		res, err := c.read(id)
+		if err != nil {
+			return nil, err
+		}
+		switch res.Tag {
+		case appSearchResEntry:
+			entries = append(entries, parseEntry(res))
+		case appSearchResDone:
+			return entries, resultError(res)
+		default:
+			// search result references are ignored
+		}
+	}
+}
+
+func (c *Conn) roundTrip(op *ber.Packet) (*ber.Packet, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	return c.read(id)
+}
+
+func (c *Conn) send(op *ber.Packet) (int64, error) {
+	c.messageID++
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.messageID, "MessageID"))
+	msg.AppendChild(op)
+	_, err := c.conn.Write(msg.Bytes())
+	return c.messageID, err
+}
+
+// read returns the protocol operation of the next message, which must answer the request with the given id
+func (c *Conn) read(id int64) (*ber.Packet, error) {
+	msg, err := ber.ReadPacket(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.Children) < 2 {
+		return nil, errors.New("ldap: malformed response")
+	}
+	if gotID, _ := msg.Children[0].Value.(int64); gotID != id {
+		return nil, fmt.Errorf("ldap: unexpected message id %d (want %d)", gotID, id)
+	}
+	op := msg.Children[1]
+	if op.ClassType != ber.ClassApplication {
+		return nil, errors.New("ldap: malformed response")
+	}
+	return op, nil
+}
+
+// resultError converts an LDAPResult into an error (nil on success)
+func resultError(res *ber.Packet) error {
+	if len(res.Children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	code, _ := res.Children[0].Value.(int64)
+	switch code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCreds:
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("ldap: result code %d: %s", code, res.Children[2].Data.String())
+	}
+}
+
+func parseEntry(res *ber.Packet) *Entry {
+	entry := &Entry{Attributes: make(map[string][]string)}
+	if len(res.Children) < 2 {
+		return entry
+	}
+	entry.DN = res.Children[0].Data.String()
+	for _, attr := range res.Children[1].Children {
+		if len(attr.Children) < 2 {
+			continue
+		}
+		name := attr.Children[0].Data.String()
+		for _, v := range attr.Children[1].Children {
+			entry.Attributes[name] = append(entry.Attributes[name], v.Data.String())
+		}
+	}
+	return entry
+}
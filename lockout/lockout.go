@@ -0,0 +1,140 @@
+// Package lockout slows down password guessing: the failed logins of each account and client address are
+// counted over a window, and once there are too many the key is locked out for a delay doubling on each
+// further failure. Counters live in a Store: Redis, shared by every instance so an attacker can't spread
+// the attempts over them, or the memory of the process for single instance deployments.
+package lockout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"time"
+)
+
+// Store holds the failure counters and the locks
+type Store interface {
+	// Fail counts a failure of key and returns the failures of the window, which starts with the first one
+	Fail(ctx context.Context, key string, window time.Duration) (int, error)
+	// Failures returns the failures of the current window of key
+	Failures(ctx context.Context, key string) (int, error)
+	// Lock locks key out for d
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// Locked returns how long key stays locked out, 0 when it isn't
+	Locked(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the failures of key. Its lock, if any, runs out on its own
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy is how many failures lock a key out, and for how long
+type Policy struct {
+	MaxFailures int
+	Window      time.Duration
+	// Lock of the MaxFailures-th failure, doubled on each following one up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// delay returns the lock of the failures-th failure of the window, 0 while under MaxFailures
+func (p Policy) delay(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+	d := p.Delay
+	for i := p.MaxFailures; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Guard applies an account Policy and an address Policy to the logins
+type Guard struct {
+	store   Store
+	account Policy
+	address Policy
+}
+
+func NewGuard(store Store, account, address Policy) *Guard {
+	return &Guard{store: store, account: account, address: address}
+}
+
+// Locked returns how long the logins of email from ip are locked out, 0 when they are allowed.
+// The store failing doesn't lock anyone out, the rate limits still apply.
+func (g *Guard) Locked(ctx context.Context, email, ip string) time.Duration {
+	var longest time.Duration
+	for _, key := range []string{accountKey(email), addressKey(ip)} {
+		d, err := g.store.Locked(ctx, key)
+		if err != nil {
+			log.Printf("[Lockout:Locked] Error reading the lock of %s: %v", key, err)
+			continue
+		}
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// Failures returns the failed logins of the window of email or of ip, whichever has the most
+func (g *Guard) Failures(ctx context.Context, email, ip string) int {
+	var most int
+	for _, key := range []string{accountKey(email), addressKey(ip)} {
+		failures, err := g.store.Failures(ctx, key)
+		if err != nil {
+			log.Printf("[Lockout:Failures] Error reading the failures of %s: %v", key, err)
+			continue
+		}
+		most = max(most, failures)
+	}
+	return most
+}
+
+// Fail counts a failed login of email from ip, and locks them out when they went over their policy.
+// It returns the longest lock placed, 0 when none was.
+func (g *Guard) Fail(ctx context.Context, email, ip string) time.Duration {
+	return max(g.fail(ctx, accountKey(email), g.account), g.fail(ctx, addressKey(ip), g.address))
+}
+
+func (g *Guard) fail(ctx context.Context, key string, p Policy) time.Duration {
+	failures, err := g.store.Fail(ctx, key, p.Window)
+	if err != nil {
+		log.Printf("[Lockout:Fail] Error counting a failure of %s: %v", key, err)
+		return 0
+	}
+	d := p.delay(failures)
+	if d == 0 {
+		return 0
+	}
+	log.Printf("[Lockout:Fail] Locking %s out for %v after %d failures", key, d, failures)
+	if err := g.store.Lock(ctx, key, d); err != nil {
+		log.Printf("[Lockout:Fail] Error locking %s: %v", key, err)
+		return 0
+	}
+	return d
+}
+
+// Succeed forgets the failures of the account of email. Those of the address are kept, or an attacker
+// could clear them by logging into an account of their own between guesses.
+func (g *Guard) Succeed(ctx context.Context, email string) {
+	key := accountKey(email)
+	if err := g.store.Reset(ctx, key); err != nil {
+		log.Printf("[Lockout:Succeed] Error resetting %s: %v", key, err)
+	}
+}
+
+// Close releases the store: the cleanup of Memory stops, the connections to Redis are closed
+func (g *Guard) Close() error {
+	if c, ok := g.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Emails are hashed so the store holds no personal data
+func accountKey(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return "account:" + hex.EncodeToString(sum[:16])
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,125 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{MaxFailures: 3, Delay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		want     time.Duration
+	}{
+		{name: "under the limit", policy: p, failures: 2, want: 0},
+		{name: "at the limit", policy: p, failures: 3, want: time.Minute},
+		{name: "doubled", policy: p, failures: 4, want: 2 * time.Minute},
+		{name: "doubled twice", policy: p, failures: 5, want: 4 * time.Minute},
+		{name: "capped", policy: p, failures: 7, want: 10 * time.Minute},
+		{name: "capped far over the limit", policy: p, failures: 1000, want: 10 * time.Minute},
+		{name: "delay over the cap", policy: Policy{MaxFailures: 1, Delay: time.Hour, MaxDelay: time.Minute}, failures: 1, want: time.Minute},
+		{name: "turned off", policy: Policy{Delay: time.Minute, MaxDelay: time.Hour}, failures: 100, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.failures); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingStore is a Store whose every call fails
+type failingStore struct{}
+
+var errStore = errors.New("store down")
+
+func (failingStore) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	return 0, errStore
+}
+func (failingStore) Failures(ctx context.Context, key string) (int, error) { return 0, errStore }
+func (failingStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	return errStore
+}
+func (failingStore) Locked(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errStore
+}
+func (failingStore) Reset(ctx context.Context, key string) error { return errStore }
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	account := Policy{MaxFailures: 2, Window: time.Minute, Delay: time.Minute, MaxDelay: time.Hour}
+	address := Policy{MaxFailures: 3, Window: time.Minute, Delay: time.Minute, MaxDelay: time.Hour}
+	store := newMemory(time.Hour)
+	defer store.Close()
+	g := NewGuard(store, account, address)
+
+	if d := g.Fail(ctx, "alice@example.com", "10.0.0.1"); d != 0 {
+		t.Errorf("first failure locked out for %v", d)
+	}
+	if d := g.Fail(ctx, "alice@example.com", "10.0.0.1"); d != time.Minute {
+		t.Errorf("second failure locked out for %v, want the account locked for 1m", d)
+	}
+	if d := g.Locked(ctx, "alice@example.com", "10.0.0.2"); d <= 0 {
+		t.Error("account not locked out from another address")
+	}
+	if d := g.Locked(ctx, "bob@example.com", "10.0.0.1"); d != 0 {
+		t.Errorf("address locked out for %v after 2 failures, under its limit", d)
+	}
+
+	// The third failure of the address locks it out for every account
+	g.Fail(ctx, "bob@example.com", "10.0.0.1")
+	if d := g.Locked(ctx, "carol@example.com", "10.0.0.1"); d <= 0 {
+		t.Error("address not locked out after 3 failures")
+	}
+	if got := g.Failures(ctx, "carol@example.com", "10.0.0.1"); got != 3 {
+		t.Errorf("Failures = %d, want the 3 of the address", got)
+	}
+
+	// A successful login clears the failures of the account, not those of the address
+	g.Succeed(ctx, "alice@example.com")
+	if got := g.Failures(ctx, "alice@example.com", "10.0.0.9"); got != 0 {
+		t.Errorf("Failures of alice after a login = %d, want 0", got)
+	}
+	if got := g.Failures(ctx, "alice@example.com", "10.0.0.1"); got != 3 {
+		t.Errorf("Failures of the address after a login = %d, want 3", got)
+	}
+}
+
+func TestGuardStoreDown(t *testing.T) {
+	ctx := context.Background()
+	p := Policy{MaxFailures: 1, Window: time.Minute, Delay: time.Minute, MaxDelay: time.Hour}
+	g := NewGuard(failingStore{}, p, p)
+
+	if d := g.Fail(ctx, "alice@example.com", "10.0.0.1"); d != 0 {
+		t.Errorf("Fail = %v, want no lock while the store is down", d)
+	}
+	if d := g.Locked(ctx, "alice@example.com", "10.0.0.1"); d != 0 {
+		t.Errorf("Locked = %v, want nobody locked out while the store is down", d)
+	}
+	if got := g.Failures(ctx, "alice@example.com", "10.0.0.1"); got != 0 {
+		t.Errorf("Failures = %d, want 0", got)
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("Close of a store without Close = %v", err)
+	}
+}
+
+func TestAccountKeyHidesEmail(t *testing.T) {
+	key := accountKey("alice@example.com")
+	if key == accountKey("bob@example.com") {
+		t.Errorf("two emails share the key %s", key)
+	}
+	for _, part := range []string{"alice", "example"} {
+		if strings.Contains(key, part) {
+			t.Errorf("key %s carries the email", key)
+		}
+	}
+}
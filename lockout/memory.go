@@ -0,0 +1,120 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Store kept in the memory of the process, for single instance deployments without Redis.
+// With several instances each one counts its own failures, so an attacker gets MaxFailures per instance.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	// Closed by Close to stop the cleanup
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryEntry struct {
+	failures    int
+	windowEnd   time.Time
+	lockedUntil time.Time
+}
+
+func NewMemory() *Memory {
+	return newMemory(time.Minute)
+}
+
+// newMemory returns a Memory dropping the entries that are over every interval
+func newMemory(interval time.Duration) *Memory {
+	m := &Memory{entries: map[string]*memoryEntry{}, done: make(chan struct{})}
+	go m.cleanup(interval)
+	return m
+}
+
+// Close stops the cleanup of the entries, for the shutdown of the server
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
+
+func (m *Memory) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e, ok := m.entries[key]
+	if !ok {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	if now.After(e.windowEnd) {
+		e.failures, e.windowEnd = 0, now.Add(window)
+	}
+	e.failures++
+	return e.failures, nil
+}
+
+func (m *Memory) Failures(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && time.Now().Before(e.windowEnd) {
+		return e.failures, nil
+	}
+	return 0, nil
+}
+
+func (m *Memory) Lock(ctx context.Context, key string, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	e.lockedUntil = time.Now().Add(d)
+	return nil
+}
+
+func (m *Memory) Locked(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		return max(time.Until(e.lockedUntil), 0), nil
+	}
+	return 0, nil
+}
+
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		e.failures, e.windowEnd = 0, time.Time{}
+	}
+	return nil
+}
+
+// cleanup drops the entries whose window and lock are over, until Close is called
+func (m *Memory) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, e := range m.entries {
+				if now.After(e.windowEnd) && now.After(e.lockedUntil) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// expire moves the window and the lock of key into the past, as if they ran out
+func (m *Memory) expire(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.windowEnd, e.lockedUntil = time.Now().Add(-time.Second), time.Now().Add(-time.Second)
+	}
+}
+
+func (m *Memory) has(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[key]
+	return ok
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	m := newMemory(time.Hour)
+	defer m.Close()
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := m.Fail(ctx, "ip:10.0.0.1", time.Minute); got != want {
+			t.Fatalf("Fail = %d, want %d", got, want)
+		}
+	}
+	if got, _ := m.Failures(ctx, "ip:10.0.0.1"); got != 3 {
+		t.Errorf("Failures = %d, want 3", got)
+	}
+	if got, _ := m.Failures(ctx, "ip:10.0.0.2"); got != 0 {
+		t.Errorf("Failures of an unknown key = %d, want 0", got)
+	}
+
+	// A new window starts with the first failure after the last one ended
+	m.expire("ip:10.0.0.1")
+	if got, _ := m.Failures(ctx, "ip:10.0.0.1"); got != 0 {
+		t.Errorf("Failures once the window ended = %d, want 0", got)
+	}
+	if got, _ := m.Fail(ctx, "ip:10.0.0.1", time.Minute); got != 1 {
+		t.Errorf("Fail once the window ended = %d, want 1", got)
+	}
+
+	m.Reset(ctx, "ip:10.0.0.1")
+	if got, _ := m.Failures(ctx, "ip:10.0.0.1"); got != 0 {
+		t.Errorf("Failures after Reset = %d, want 0", got)
+	}
+}
+
+func TestMemoryLock(t *testing.T) {
+	ctx := context.Background()
+	m := newMemory(time.Hour)
+	defer m.Close()
+
+	if d, _ := m.Locked(ctx, "account:a"); d != 0 {
+		t.Errorf("Locked before Lock = %v, want 0", d)
+	}
+	m.Lock(ctx, "account:a", time.Minute)
+	if d, _ := m.Locked(ctx, "account:a"); d <= 0 || d > time.Minute {
+		t.Errorf("Locked = %v, want up to 1m", d)
+	}
+
+	// Reset keeps the lock, it runs out on its own
+	m.Reset(ctx, "account:a")
+	if d, _ := m.Locked(ctx, "account:a"); d <= 0 {
+		t.Error("Reset lifted the lock")
+	}
+	m.expire("account:a")
+	if d, _ := m.Locked(ctx, "account:a"); d != 0 {
+		t.Errorf("Locked once over = %v, want 0", d)
+	}
+}
+
+func TestMemoryCleanup(t *testing.T) {
+	ctx := context.Background()
+	m := newMemory(5 * time.Millisecond)
+
+	m.Fail(ctx, "ip:over", time.Minute)
+	m.expire("ip:over")
+	m.Fail(ctx, "ip:current", time.Minute)
+	m.Lock(ctx, "ip:locked", time.Minute)
+	m.expire("ip:locked")
+	m.Lock(ctx, "ip:locked", time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for m.has("ip:over") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m.has("ip:over") {
+		t.Fatal("the entry whose window and lock are over was kept")
+	}
+	if !m.has("ip:current") || !m.has("ip:locked") {
+		t.Error("an entry with a window or a lock still running was dropped")
+	}
+
+	// Once closed, nothing is dropped anymore
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	m.Close()
+	m.Fail(ctx, "ip:closed", time.Minute)
+	m.expire("ip:closed")
+	time.Sleep(30 * time.Millisecond)
+	if !m.has("ip:closed") {
+		t.Error("the cleanup still runs after Close")
+	}
+}
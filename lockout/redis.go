@@ -0,0 +1,67 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Store shared by every instance of the API. Counters and locks are keys expiring with
+// their window and delay, so nothing needs cleaning up.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis connects to url, like redis://:password@localhost:6379/0
+func NewRedis(url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client: redis.NewClient(opts), prefix: prefix}, nil
+}
+
+// failScript counts a failure, only the first one of a window sets the expiry
+var failScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+if failures == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return failures`)
+
+func (r *Redis) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	return failScript.Run(ctx, r.client, []string{r.prefix + "failures:" + key}, window.Milliseconds()).Int()
+}
+
+func (r *Redis) Failures(ctx context.Context, key string) (int, error) {
+	failures, err := r.client.Get(ctx, r.prefix+"failures:"+key).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return failures, err
+}
+
+func (r *Redis) Lock(ctx context.Context, key string, d time.Duration) error {
+	return r.client.Set(ctx, r.prefix+"lock:"+key, 1, d).Err()
+}
+
+func (r *Redis) Locked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.prefix+"lock:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Negative for a missing key
+	return max(ttl, 0), nil
+}
+
+func (r *Redis) Reset(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+"failures:"+key).Err()
+}
+
+// Close closes the connections to Redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,206 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers the commands of the Redis store from a map, as a hook of the client so no server is
+// needed. The failure script is run in Go, with the same effect as its Lua.
+type fakeRedis struct {
+	mu      sync.Mutex
+	now     time.Time
+	values  map[string]int64
+	expires map[string]time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{now: time.Now(), values: map[string]int64{}, expires: map[string]time.Time{}}
+}
+
+// newRedis returns a Redis store whose commands are answered by fake
+func newRedis(fake *fakeRedis) *Redis {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	client.AddHook(fake)
+	return &Redis{client: client, prefix: "test:"}
+}
+
+func (f *fakeRedis) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// get returns the value of key, false once it expired
+func (f *fakeRedis) get(key string) (int64, bool) {
+	if at, ok := f.expires[key]; ok && !f.now.Before(at) {
+		delete(f.values, key)
+		delete(f.expires, key)
+	}
+	v, ok := f.values[key]
+	return v, ok
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		args := cmd.Args()
+		arg := func(i int) string { return fmt.Sprint(args[i]) }
+		switch strings.ToLower(arg(0)) {
+		case "evalsha", "eval":
+			// EVALSHA sha 1 key window
+			key := arg(3)
+			failures, _ := f.get(key)
+			failures++
+			f.values[key] = failures
+			if failures == 1 {
+				ms, _ := strconv.ParseInt(arg(4), 10, 64)
+				f.expires[key] = f.now.Add(time.Duration(ms) * time.Millisecond)
+			}
+			cmd.(*redis.Cmd).SetVal(failures)
+		case "get":
+			if v, ok := f.get(arg(1)); ok {
+				cmd.(*redis.StringCmd).SetVal(strconv.FormatInt(v, 10))
+			} else {
+				cmd.SetErr(redis.Nil)
+			}
+		case "set":
+			// SET key value EX seconds, or PX milliseconds
+			n, _ := strconv.ParseInt(arg(4), 10, 64)
+			d := time.Duration(n) * time.Second
+			if strings.EqualFold(arg(3), "px") {
+				d = time.Duration(n) * time.Millisecond
+			}
+			v, _ := strconv.ParseInt(arg(2), 10, 64)
+			f.values[arg(1)], f.expires[arg(1)] = v, f.now.Add(d)
+			cmd.(*redis.StatusCmd).SetVal("OK")
+		case "pttl":
+			if _, ok := f.get(arg(1)); ok {
+				cmd.(*redis.DurationCmd).SetVal(f.expires[arg(1)].Sub(f.now))
+			} else {
+				// What the client makes of the -2 of a missing key
+				cmd.(*redis.DurationCmd).SetVal(-2)
+			}
+		case "del":
+			_, ok := f.get(arg(1))
+			delete(f.values, arg(1))
+			delete(f.expires, arg(1))
+			if ok {
+				cmd.(*redis.IntCmd).SetVal(1)
+			} else {
+				cmd.(*redis.IntCmd).SetVal(0)
+			}
+		default:
+			cmd.SetErr(fmt.Errorf("fakeRedis: unexpected command %v", args))
+		}
+		return cmd.Err()
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis()
+	r := newRedis(fake)
+
+	for want := 1; want <= 3; want++ {
+		got, err := r.Fail(ctx, "ip:10.0.0.1", time.Minute)
+		if err != nil || got != want {
+			t.Fatalf("Fail = %d, %v, want %d", got, err, want)
+		}
+		// Only the first failure starts the window
+		if want < 3 {
+			fake.advance(20 * time.Second)
+		}
+	}
+	if got, err := r.Failures(ctx, "ip:10.0.0.1"); err != nil || got != 3 {
+		t.Errorf("Failures = %d, %v, want 3", got, err)
+	}
+	if got, err := r.Failures(ctx, "ip:10.0.0.2"); err != nil || got != 0 {
+		t.Errorf("Failures of an unknown key = %d, %v, want 0", got, err)
+	}
+	if _, ok := fake.values["test:failures:ip:10.0.0.1"]; !ok {
+		t.Errorf("keys = %v, want them under the prefix", fake.values)
+	}
+
+	// The window started with the first failure, 40s ago
+	fake.advance(20 * time.Second)
+	if got, err := r.Failures(ctx, "ip:10.0.0.1"); err != nil || got != 0 {
+		t.Errorf("Failures once the window ended = %d, %v, want 0", got, err)
+	}
+	if got, err := r.Fail(ctx, "ip:10.0.0.1", time.Minute); err != nil || got != 1 {
+		t.Errorf("Fail once the window ended = %d, %v, want 1", got, err)
+	}
+
+	if err := r.Reset(ctx, "ip:10.0.0.1"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if got, err := r.Failures(ctx, "ip:10.0.0.1"); err != nil || got != 0 {
+		t.Errorf("Failures after Reset = %d, %v, want 0", got, err)
+	}
+}
+
+func TestRedisLock(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis()
+	r := newRedis(fake)
+
+	if d, err := r.Locked(ctx, "account:a"); err != nil || d != 0 {
+		t.Errorf("Locked before Lock = %v, %v, want 0", d, err)
+	}
+	if err := r.Lock(ctx, "account:a", 90*time.Second); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	fake.advance(30 * time.Second)
+	if d, err := r.Locked(ctx, "account:a"); err != nil || d != time.Minute {
+		t.Errorf("Locked = %v, %v, want the 1m left", d, err)
+	}
+
+	// Reset keeps the lock, it runs out on its own
+	if err := r.Reset(ctx, "account:a"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if d, _ := r.Locked(ctx, "account:a"); d <= 0 {
+		t.Error("Reset lifted the lock")
+	}
+	fake.advance(time.Minute)
+	if d, err := r.Locked(ctx, "account:a"); err != nil || d != 0 {
+		t.Errorf("Locked once over = %v, %v, want 0", d, err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestGuardOnRedis(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis()
+	p := Policy{MaxFailures: 2, Window: time.Minute, Delay: time.Minute, MaxDelay: 5 * time.Minute}
+	g := NewGuard(newRedis(fake), p, Policy{})
+
+	g.Fail(ctx, "alice@example.com", "10.0.0.1")
+	g.Fail(ctx, "alice@example.com", "10.0.0.1")
+	if d := g.Fail(ctx, "alice@example.com", "10.0.0.1"); d != 2*time.Minute {
+		t.Errorf("third failure locked out for %v, want the delay doubled to 2m", d)
+	}
+	if d := g.Locked(ctx, "alice@example.com", "10.0.0.2"); d != 2*time.Minute {
+		t.Errorf("Locked = %v, want 2m", d)
+	}
+}
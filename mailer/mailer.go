@@ -0,0 +1,41 @@
+// Package mailer sends the transactional emails of the API (email confirmation, invitations, erasure
+// confirmation, alerts...) through SMTP, or only logs them in development.
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	// Plain text, also the fallback of clients not showing HTML
+	Body string
+	// HTML version of Body, empty for plain text only
+	HTML string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer only logs the messages. It is meant for development, where links
+// (confirmation tokens...) can be copied from the logs.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	html := ""
+	if msg.HTML != "" {
+		html = " | With HTML"
+	}
+	log.Printf("[Mailer:LogMailer] To: %s | Subject: %s%s\n%s", msg.To, msg.Subject, html, msg.Body)
+	return nil
+}
+
+// NopMailer drops the messages
+type NopMailer struct{}
+
+func (NopMailer) Send(ctx context.Context, msg Message) error {
+	return nil
+}
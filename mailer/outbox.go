@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Job kind of the sends
+const sendJob = "email.send"
+
+// Statuses of the emails table
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// ErrNotFailed is returned by Retry for an email that is not failed, or doesn't exist
+var ErrNotFailed = errors.New("mailer: no failed email with this id")
+
+// Outbox is a Mailer storing the messages in the emails table and sending them with next from a job,
+// so a slow or down mail server never holds a request and failed sends are retried with the backoff of
+// the jobs package. An email still failing after its last attempt is left failed, for an admin to retry.
+type Outbox struct {
+	db          *pgxpool.Pool
+	queue       *jobs.Queue
+	next        Mailer
+	piiKey      []byte
+	maxAttempts int
+}
+
+// NewOutbox registers the send jobs on queue. maxAttempts must be the one of queue, the last attempt
+// marks the email failed. The recipients and the bodies are encrypted with piiKey.
+func NewOutbox(db *pgxpool.Pool, queue *jobs.Queue, next Mailer, piiKey []byte, maxAttempts int) *Outbox {
+	o := &Outbox{db: db, queue: queue, next: next, piiKey: piiKey, maxAttempts: maxAttempts}
+	queue.Register(sendJob, o.send)
+	return o
+}
+
+// Send queues msg, the error is only about storing it
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	// The bodies hold the name of the recipient and the confirmation links
+	stored := msg
+	for _, value := range []*string{&stored.To, &stored.Body, &stored.HTML} {
+		var err error
+		if *value, err = pii.Encrypt(*value, o.piiKey); err != nil {
+			return err
+		}
+	}
+	return repository.WithTx(ctx, o.db, func(tx pgx.Tx) error {
+		var id int64
+		query := `INSERT INTO emails (recipient, subject, body, html) VALUES ($1, $2, $3, $4) RETURNING id;`
+		if err := tx.QueryRow(ctx, query, stored.To, stored.Subject, stored.Body, stored.HTML).Scan(&id); err != nil {
+			return err
+		}
+		return o.queue.EnqueueTx(ctx, tx, sendJob, map[string]int64{"email_id": id})
+	})
+}
+
+// Retry queues a failed email again, with all its attempts
+func (o *Outbox) Retry(ctx context.Context, id int64) error {
+	return repository.WithTx(ctx, o.db, func(tx pgx.Tx) error {
+		query := `UPDATE emails SET status = $2, attempts = 0, finished_at = NULL WHERE id = $1 AND status = $3;`
+		tag, err := tx.Exec(ctx, query, id, StatusPending, StatusFailed)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFailed
+		}
+		return o.queue.EnqueueTx(ctx, tx, sendJob, map[string]int64{"email_id": id})
+	})
+}
+
+// send is the job sending one email. Its error makes the job retry, except on the last attempt.
+func (o *Outbox) send(ctx context.Context, payload json.RawMessage) error {
+	var p struct {
+		EmailID int64 `json:"email_id"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var msg Message
+	var attempts int
+	query := `UPDATE emails SET attempts = attempts + 1 WHERE id = $1 AND status = $2
+		RETURNING recipient, subject, body, html, attempts;`
+	err := o.db.QueryRow(ctx, query, p.EmailID, StatusPending).Scan(&msg.To, &msg.Subject, &msg.Body, &msg.HTML, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Already sent by a job that was claimed again, or deleted by the janitor
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, value := range []*string{&msg.To, &msg.Body, &msg.HTML} {
+		if err == nil {
+			*value, err = pii.Decrypt(*value, o.piiKey)
+		}
+	}
+	if err == nil {
+		err = o.next.Send(ctx, msg)
+	}
+	if err == nil {
+		_, err = o.db.Exec(ctx, `UPDATE emails SET status = $2, body = '', html = '', last_error = NULL, finished_at = NOW() WHERE id = $1;`, p.EmailID, StatusSent)
+		return err
+	}
+
+	if attempts >= o.maxAttempts {
+		log.Printf("[Mailer:Outbox] Email %d failed for good after %d attempts: %v", p.EmailID, attempts, err)
+		_, dbErr := o.db.Exec(ctx, `UPDATE emails SET status = $2, last_error = $3, finished_at = NOW() WHERE id = $1;`, p.EmailID, StatusFailed, err.Error())
+		return dbErr
+	}
+	if _, dbErr := o.db.Exec(ctx, `UPDATE emails SET last_error = $2 WHERE id = $1;`, p.EmailID, err.Error()); dbErr != nil {
+		log.Printf("[Mailer:Outbox] Error recording the failure of email %d: %v", p.EmailID, dbErr)
+	}
+	return fmt.Errorf("sending email %d: %w", p.EmailID, err)
+}
@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+// The emails are templates/<name>.txt, a text/template defining "subject" whose body is the plain
+// text of the email, and optionally templates/<name>.html, an html/template defining "content",
+// shown in layout.html. Both get the same data.
+//
+//go:embed templates
+var templateFiles embed.FS
+
+type template struct {
+	text *texttemplate.Template
+	// Nil for the emails only sent as plain text
+	html *htmltemplate.Template
+}
+
+var templates = mustParseTemplates()
+
+func mustParseTemplates() map[string]*template {
+	layout := htmltemplate.Must(htmltemplate.New("layout").Option("missingkey=error").ParseFS(templateFiles, "templates/layout.html"))
+
+	parsed := map[string]*template{}
+	texts, err := fs.Glob(templateFiles, "templates/*.txt")
+	if err != nil {
+		panic(err)
+	}
+	for _, path := range texts {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "templates/"), ".txt")
+		t := &template{
+			text: texttemplate.Must(texttemplate.New(name+".txt").Option("missingkey=error").ParseFS(templateFiles, path)),
+		}
+		if t.text.Lookup("subject") == nil {
+			panic("mailer: " + path + " doesn't define a subject")
+		}
+		if _, err := fs.Stat(templateFiles, "templates/"+name+".html"); err == nil {
+			t.html = htmltemplate.Must(htmltemplate.Must(layout.Clone()).ParseFS(templateFiles, "templates/"+name+".html"))
+		}
+		parsed[name] = t
+	}
+	return parsed
+}
+
+// Render builds the email of the template name for to, with data as the dot of its templates
+func Render(to, name string, data interface{}) (Message, error) {
+	t, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("mailer: no template %q", name)
+	}
+
+	var subject, body, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: rendering the subject of %s: %w", name, err)
+	}
+	if err := t.text.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("mailer: rendering %s: %w", name, err)
+	}
+	if t.html != nil {
+		if err := t.html.ExecuteTemplate(&html, "layout", data); err != nil {
+			return Message{}, fmt.Errorf("mailer: rendering the HTML of %s: %w", name, err)
+		}
+	}
+	return Message{To: to, Subject: strings.TrimSpace(subject.String()), Body: body.String(), HTML: html.String()}, nil
+}
+
+// SendTemplate renders the template name for to and sends it with m
+func SendTemplate(ctx context.Context, m Mailer, to, name string, data interface{}) error {
+	msg, err := Render(to, name, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, msg)
+}
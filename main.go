@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -9,10 +11,17 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/hi-im-yan/jwt-with-go/buildinfo"
+	"github.com/hi-im-yan/jwt-with-go/cmd"
+	"github.com/hi-im-yan/jwt-with-go/config"
 	_ "github.com/hi-im-yan/jwt-with-go/docs" // this is important!
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/hi-im-yan/jwt-with-go/repository"
 	"github.com/hi-im-yan/jwt-with-go/server"
+	"github.com/hi-im-yan/jwt-with-go/servertiming"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
 	_ "github.com/swaggo/http-swagger"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -31,65 +40,110 @@ import (
 // @in header
 // @name Authorization
 func main() {
-	db := connectDB()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(cmd.ConfigCommand(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(cmd.AdminCommand(os.Args[2:], os.Stdout))
+	}
+
+	flags, err := cmd.ParseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := flags.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd.ConfigureLogging(cfg)
+
+	if cfg.Migrate != "skip" {
+		runMigrations(cfg.DB.URL())
+	}
+	if cfg.Migrate == "only" {
+		return
+	}
+
+	db := connectDB(cfg.DB.URL(), cfg.DB)
 	defer db.Close()
+	var replica *pgxpool.Pool
+	if cfg.DB.ReplicaURL != "" {
+		replica = connectDB(cfg.DB.ReplicaURL, cfg.DB)
+		defer replica.Close()
+	}
 
-	if err := ensureAdminExists(db); err != nil {
+	// Emails written before PII_ENCRYPTION_KEY was set are encrypted now
+	encrypted, err := repository.EncryptEmails(context.Background(), db, cfg.PIIEncryptionKey)
+	if err != nil {
 		log.Fatal(err)
 	}
+	if encrypted > 0 {
+		fmt.Printf("🔒 Encrypted the email of %d users\n", encrypted)
+	}
 
-	server := server.NewServer("8080", db)
+	if err := ensureAdminExists(cfg, db); err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Println("Starting server on port " + server.Port)
+	deps, err := server.NewDeps(cfg, db, replica)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, err := server.NewServer(cfg, db, deps)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// SIGHUP and POST /admin/config/reload
+	server.Reloader = flags.Reload
+
+	fmt.Println("Starting server " + buildinfo.Get().String() + " on " + cfg.ListenAddr)
 
 	if err := server.Start(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func ensureAdminExists(db *pgxpool.Pool) error {
+func ensureAdminExists(cfg *config.Config, db *pgxpool.Pool) error {
 	var count int
-	err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE role = 'admin'").Scan(&count)
+	err := db.QueryRow(context.Background(), `SELECT COUNT(*) FROM users u JOIN user_roles ur ON ur.user_id = u.id JOIN roles r ON r.id = ur.role_id
+		WHERE r.name = 'admin' AND u.deleted_at IS NULL`).Scan(&count)
 	if err != nil {
 		return err
 	}
 
 	if count == 0 {
+		if cfg.AdminEmail == "" || cfg.AdminPassword == "" {
+			return errors.New("there is no admin account: ADMIN_EMAIL and ADMIN_PASSWORD are required to create it")
+		}
+
 		// Hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(os.Getenv("ADMIN_PASSWORD")), bcrypt.DefaultCost)
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(cfg.AdminPassword), bcrypt.DefaultCost)
 		if err != nil {
 			return err
 		}
 
-		_, err = db.Exec(context.Background(), "INSERT INTO users (name, email, password, role) VALUES ($1, $2, $3, $4)",
-			"Admin", os.Getenv("ADMIN_EMAIL"), string(hashedPassword), "admin")
+		email, err := pii.Encrypt(cfg.AdminEmail, cfg.PIIEncryptionKey)
 		if err != nil {
 			return err
 		}
-		fmt.Println("✅ Admin account created: ", os.Getenv("ADMIN_EMAIL"))
+		_, err = db.Exec(context.Background(), `WITH admin AS (
+				INSERT INTO users (name, email, email_lookup, password) VALUES ($1, $2, $3, $4) RETURNING id
+			)
+			INSERT INTO user_roles (user_id, role_id) SELECT admin.id, roles.id FROM admin, roles WHERE roles.name = $5`,
+			"Admin", email, pii.Lookup(cfg.AdminEmail, cfg.PIIEncryptionKey), string(hashedPassword), "admin")
+		if err != nil {
+			return err
+		}
+		fmt.Println("✅ Admin account created: ", cfg.AdminEmail)
 	}
 	return nil
 }
 
-func connectDB() *pgxpool.Pool {
-	// Load .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Read database credentials from environment variables
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-
-	// Construct database URL
-	databaseURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		dbUser, dbPass, dbHost, dbPort, dbName)
-
-	// Run Migrations
+func runMigrations(databaseURL string) {
 	m, err := migrate.New("file://migrations", databaseURL)
 	if err != nil {
 		log.Fatal("Migration error:", err)
@@ -100,9 +154,28 @@ func connectDB() *pgxpool.Pool {
 	}
 
 	fmt.Println("Migrations completed successfully!")
+}
 
+func connectDB(url string, dbConfig config.DB) *pgxpool.Pool {
 	// Connect to PostgreSQL
-	db, err := pgxpool.New(context.Background(), databaseURL)
+	poolConfig, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+	poolConfig.ConnConfig.Tracer = servertiming.QueryTracer{}
+	if dbConfig.SlowQueryThreshold > 0 {
+		poolConfig.ConnConfig.Tracer = multitracer.New(servertiming.QueryTracer{}, metrics.SlowQueryTracer{Threshold: dbConfig.SlowQueryThreshold})
+	}
+	pool := dbConfig.Pool
+	if pool.MaxConns > 0 {
+		poolConfig.MaxConns = pool.MaxConns
+	}
+	poolConfig.MinConns = pool.MinConns
+	poolConfig.MaxConnLifetime = pool.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = pool.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = pool.HealthCheckPeriod
+
+	db, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Fatalf("Unable to connect to database: %v", err)
 	}
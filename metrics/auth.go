@@ -0,0 +1,95 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jwtapi_auth_logins_total",
+		Help: "Number of login attempts by result (success, failure or error).",
+	}, []string{"result"})
+	registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jwtapi_auth_registrations_total",
+		Help: "Number of accounts created, by method (password or invite).",
+	}, []string{"method"})
+	tokensIssued = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jwtapi_auth_tokens_issued_total",
+		Help: "Number of access tokens issued, by a login or a refresh.",
+	})
+	refreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jwtapi_auth_refreshes_total",
+		Help: "Number of session refreshes by result (success, invalid, device_mismatch or error).",
+	}, []string{"result"})
+	tokenFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jwtapi_auth_token_failures_total",
+		Help: "Number of tokens refused, by reason (expired, malformed, signature, invalid or revoked).",
+	}, []string{"reason"})
+	lockouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jwtapi_auth_lockouts_total",
+		Help: "Number of lockouts placed after too many failed logins.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(logins, registrations, tokensIssued, refreshes, tokenFailures, lockouts)
+}
+
+// Login result labels
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure" // wrong credentials
+	LoginError   = "error"   // the credentials could not be checked
+)
+
+// Registration method labels
+const (
+	RegistrationPassword = "password" // POST /register
+	RegistrationInvite   = "invite"   // an accepted invitation
+)
+
+// Refresh result labels
+const (
+	RefreshSuccess        = "success"
+	RefreshInvalid        = "invalid"         // unknown, expired or revoked refresh token
+	RefreshDeviceMismatch = "device_mismatch" // sent from another device, the session was revoked
+	RefreshReused         = "reused"          // an already rotated refresh token, the session was revoked
+	RefreshError          = "error"
+)
+
+// Token failure reason labels
+const (
+	TokenExpired   = "expired"
+	TokenMalformed = "malformed" // not a token, or a token that can't be decoded or decrypted
+	TokenSignature = "signature" // forged or tampered with
+	TokenInvalid   = "invalid"   // any other problem: wrong algorithm, missing claims, not valid yet...
+	TokenRevoked   = "revoked"   // its session expired or was revoked
+)
+
+// ObserveLogin counts a login attempt with one of the Login* results
+func ObserveLogin(result string) {
+	logins.WithLabelValues(result).Inc()
+}
+
+// ObserveRegistration counts an account created with one of the Registration* methods
+func ObserveRegistration(method string) {
+	registrations.WithLabelValues(method).Inc()
+}
+
+// ObserveTokenIssued counts an access token issued
+func ObserveTokenIssued() {
+	tokensIssued.Inc()
+}
+
+// ObserveRefresh counts a session refresh with one of the Refresh* results
+func ObserveRefresh(result string) {
+	refreshes.WithLabelValues(result).Inc()
+}
+
+// ObserveTokenFailure counts a token refused for one of the Token* reasons
+func ObserveTokenFailure(reason string) {
+	tokenFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveLockout counts a lockout placed
+func ObserveLockout() {
+	lockouts.Inc()
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var janitorDeletes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "jwtapi_janitor_rows_deleted_total",
+	Help: "Number of rows deleted by the janitor, by cleanup task.",
+}, []string{"task"})
+
+func init() {
+	prometheus.MustRegister(janitorDeletes)
+}
+
+// ObserveJanitorDeletes counts the rows deleted by a run of task
+func ObserveJanitorDeletes(task string, rows int64) {
+	janitorDeletes.WithLabelValues(task).Add(float64(rows))
+}
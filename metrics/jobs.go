@@ -0,0 +1,24 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "jwtapi_jobs_runs_total",
+	Help: "Number of background job runs by kind and result (done, retried or failed).",
+}, []string{"kind", "result"})
+
+func init() {
+	prometheus.MustRegister(jobRuns)
+}
+
+// Job run result labels
+const (
+	JobDone    = "done"
+	JobRetried = "retried" // failed, will run again after a backoff
+	JobFailed  = "failed"  // failed and out of attempts
+)
+
+// ObserveJob counts a run of a job of kind with one of the Job* results
+func ObserveJob(kind, result string) {
+	jobRuns.WithLabelValues(kind, result).Inc()
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reads the pgx pool statistics on every scrape
+type poolCollector struct {
+	db *pgxpool.Pool
+
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+}
+
+// RegisterPoolMetrics exposes the connection pool statistics of db (connections in use, idle,
+// waits for a free connection...)
+func RegisterPoolMetrics(db *pgxpool.Pool) {
+	prometheus.MustRegister(&poolCollector{
+		db:                   db,
+		acquiredConns:        prometheus.NewDesc("jwtapi_db_pool_acquired_connections", "Number of connections currently in use.", nil, nil),
+		idleConns:            prometheus.NewDesc("jwtapi_db_pool_idle_connections", "Number of idle connections in the pool.", nil, nil),
+		totalConns:           prometheus.NewDesc("jwtapi_db_pool_total_connections", "Number of open connections in the pool.", nil, nil),
+		maxConns:             prometheus.NewDesc("jwtapi_db_pool_max_connections", "Maximum size of the pool.", nil, nil),
+		acquireCount:         prometheus.NewDesc("jwtapi_db_pool_acquires_total", "Number of successful connection acquires.", nil, nil),
+		emptyAcquireCount:    prometheus.NewDesc("jwtapi_db_pool_empty_acquires_total", "Number of acquires that had to wait for a connection.", nil, nil),
+		canceledAcquireCount: prometheus.NewDesc("jwtapi_db_pool_canceled_acquires_total", "Number of acquires canceled by their context.", nil, nil),
+		acquireDuration:      prometheus.NewDesc("jwtapi_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections.", nil, nil),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.emptyAcquireCount
+	ch <- c.canceledAcquireCount
+	ch <- c.acquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// LogPoolStats logs the pool statistics every interval, for deployments without Prometheus.
+// It runs in its own goroutine for the lifetime of the process.
+func LogPoolStats(db *pgxpool.Pool, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			stat := db.Stat()
+			log.Printf("[Metrics:LogPoolStats] connections: %d in use, %d idle, %d open of %d max | acquires: %d, %d waited, %d canceled, %v spent waiting",
+				stat.AcquiredConns(), stat.IdleConns(), stat.TotalConns(), stat.MaxConns(),
+				stat.AcquireCount(), stat.EmptyAcquireCount(), stat.CanceledAcquireCount(), stat.AcquireDuration())
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var slowQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "jwtapi_db_slow_queries_total",
+	Help: "Number of queries slower than DB_SLOW_QUERY_THRESHOLD, by statement (select, insert, update, delete or other).",
+}, []string{"statement"})
+
+func init() {
+	prometheus.MustRegister(slowQueries)
+}
+
+// Longest SQL logged, the rest is cut
+const slowQueryMaxSQL = 2000
+
+var (
+	sqlStrings = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumbers = regexp.MustCompile(`([^$\w.])\d+(?:\.\d+)?\b`)
+	sqlSpaces  = regexp.MustCompile(`\s+`)
+)
+
+type slowQueryStartKey struct{}
+
+// SlowQueryTracer is a pgx tracer logging the queries that take Threshold or more, with their SQL and
+// the number of their arguments, and counting them in jwtapi_db_slow_queries_total. The arguments
+// themselves are never logged, and the literals of the SQL are replaced by ?, as they can hold
+// personal data or secrets.
+type SlowQueryTracer struct {
+	Threshold time.Duration
+}
+
+func (t SlowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, slowQueryStartKey{}, slowQueryStart{at: time.Now(), sql: data.SQL, args: len(data.Args)})
+}
+
+func (t SlowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(slowQueryStartKey{}).(slowQueryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	if elapsed < t.Threshold {
+		return
+	}
+
+	slowQueries.WithLabelValues(statementOf(start.sql)).Inc()
+	outcome := "done"
+	if data.Err != nil {
+		outcome = "failed: " + data.Err.Error()
+	}
+	log.Printf("[Metrics:SlowQuery] Query took %v with %d arguments (%s): %s", elapsed.Round(time.Millisecond), start.args, outcome, sanitizeSQL(start.sql))
+}
+
+type slowQueryStart struct {
+	at   time.Time
+	sql  string
+	args int
+}
+
+// sanitizeSQL replaces the literals of sql by ? and puts it on one line
+func sanitizeSQL(sql string) string {
+	sql = sqlStrings.ReplaceAllString(sql, "?")
+	sql = sqlNumbers.ReplaceAllString(sql, "${1}?")
+	sql = strings.TrimSpace(sqlSpaces.ReplaceAllString(sql, " "))
+	if len(sql) > slowQueryMaxSQL {
+		sql = sql[:slowQueryMaxSQL] + "..."
+	}
+	return sql
+}
+
+// statementOf is the label of sql, its first keyword. Queries starting with a WITH clause are "other".
+func statementOf(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch keyword := strings.ToLower(fields[0]); keyword {
+	case "select", "insert", "update", "delete":
+		return keyword
+	}
+	return "other"
+}
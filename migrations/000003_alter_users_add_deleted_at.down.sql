@@ -0,0 +1,4 @@
+DROP INDEX users_email_active_key;
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN deleted_at;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE user_tags;
+DROP TABLE user_notes;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE user_notes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX user_notes_user_id_idx ON user_notes (user_id);
+
+CREATE TABLE user_tags (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (user_id, tag)
+);
+CREATE INDEX user_tags_tag_idx ON user_tags (tag);
\ No newline at end of file
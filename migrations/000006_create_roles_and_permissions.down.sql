@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN role VARCHAR(20);
+UPDATE users SET role = roles.name FROM roles WHERE roles.id = users.role_id;
+ALTER TABLE users ALTER COLUMN role SET NOT NULL;
+ALTER TABLE users DROP COLUMN role_id;
+
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
\ No newline at end of file
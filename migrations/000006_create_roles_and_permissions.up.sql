@@ -0,0 +1,38 @@
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL
+);
+
+CREATE TABLE permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO roles (name) VALUES ('admin'), ('user');
+INSERT INTO roles (name) SELECT DISTINCT role FROM users ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:list', 'List users'),
+    ('users:read', 'Read any user'),
+    ('users:create', 'Create users'),
+    ('users:update', 'Update any user'),
+    ('users:delete', 'Delete users'),
+    ('users:annotate', 'Manage support notes and tags on users'),
+    ('users:mock', 'Read the mock user');
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r CROSS JOIN permissions p WHERE r.name = 'admin';
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name IN ('users:list', 'users:read') WHERE r.name = 'user';
+
+ALTER TABLE users ADD COLUMN role_id INT REFERENCES roles(id);
+UPDATE users SET role_id = roles.id FROM roles WHERE roles.name = users.role;
+ALTER TABLE users ALTER COLUMN role_id SET NOT NULL;
+ALTER TABLE users DROP COLUMN role;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'profile:fields';
+DROP TABLE user_profile_values;
+DROP TABLE profile_fields;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE profile_fields (
+    key VARCHAR(50) PRIMARY KEY,
+    label VARCHAR(100) NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE user_profile_values (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    field_key VARCHAR(50) NOT NULL REFERENCES profile_fields(key) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (user_id, field_key)
+);
+
+INSERT INTO permissions (name, description) VALUES ('profile:fields', 'Manage profile fields and which ones are required');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'profile:fields' WHERE r.name = 'admin';
\ No newline at end of file
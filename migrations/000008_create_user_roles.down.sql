@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE name = 'roles:assign';
+
+ALTER TABLE users ADD COLUMN role_id INT REFERENCES roles(id);
+UPDATE users SET role_id = (
+    SELECT ur.role_id FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+    WHERE ur.user_id = users.id ORDER BY (r.name = 'admin') DESC, r.name LIMIT 1
+);
+UPDATE users SET role_id = (SELECT id FROM roles WHERE name = 'user') WHERE role_id IS NULL;
+ALTER TABLE users ALTER COLUMN role_id SET NOT NULL;
+
+DROP TABLE user_roles;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE user_roles (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+CREATE INDEX user_roles_role_id_idx ON user_roles (role_id);
+
+INSERT INTO user_roles (user_id, role_id) SELECT id, role_id FROM users;
+ALTER TABLE users DROP COLUMN role_id;
+
+INSERT INTO permissions (name, description) VALUES ('roles:assign', 'Grant and revoke roles of users');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'roles:assign' WHERE r.name = 'admin';
\ No newline at end of file
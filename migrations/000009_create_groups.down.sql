@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE name = 'groups:manage';
+
+DROP TABLE group_permissions;
+DROP TABLE group_members;
+DROP TABLE groups;
\ No newline at end of file
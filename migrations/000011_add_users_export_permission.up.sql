@@ -0,0 +1,3 @@
+INSERT INTO permissions (name, description) VALUES ('users:export', 'Export the user list as CSV or JSON');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'users:export' WHERE r.name = 'admin';
\ No newline at end of file
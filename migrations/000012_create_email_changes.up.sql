@@ -0,0 +1,7 @@
+CREATE TABLE email_changes (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
\ No newline at end of file
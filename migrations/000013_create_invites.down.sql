@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'users:invite';
+
+DROP TABLE invites;
\ No newline at end of file
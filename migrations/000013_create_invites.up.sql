@@ -0,0 +1,14 @@
+CREATE TABLE invites (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(100) NOT NULL,
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO permissions (name, description) VALUES ('users:invite', 'Invite new users with a role');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'users:invite' WHERE r.name = 'admin';
\ No newline at end of file
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE name = 'users:history';
+
+DROP TRIGGER users_history ON users;
+DROP FUNCTION record_user_history();
+DROP TABLE user_history;
\ No newline at end of file
@@ -0,0 +1,53 @@
+-- No foreign keys: the history of a user must outlive a purge of the row
+CREATE TABLE user_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    actor_id INT,
+    operation VARCHAR(10) NOT NULL,
+    old_values JSONB,
+    new_values JSONB,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX user_history_user_id_idx ON user_history (user_id, changed_at);
+
+-- The API tags its transactions with the acting user (SET LOCAL app.actor_id), other changes
+-- (migrations, manual fixes) are recorded without an actor. Only changed columns are stored and
+-- the password hash never is, a password change shows up as "password": "changed".
+CREATE FUNCTION record_user_history() RETURNS trigger AS $$
+DECLARE
+    actor INT := NULLIF(current_setting('app.actor_id', true), '')::INT;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, new_values)
+            VALUES (NEW.id, actor, TG_OP, to_jsonb(NEW) - 'password');
+        RETURN NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values)
+            VALUES (OLD.id, actor, TG_OP, to_jsonb(OLD) - 'password');
+        RETURN OLD;
+    END IF;
+
+    SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, to_jsonb(NEW) -> o.key)
+        INTO old_row, new_row
+        FROM jsonb_each(to_jsonb(OLD) - 'password') o
+        WHERE (to_jsonb(NEW) -> o.key) IS DISTINCT FROM o.value;
+    IF OLD.password IS DISTINCT FROM NEW.password THEN
+        old_row := COALESCE(old_row, '{}'::JSONB) || '{"password": "changed"}';
+        new_row := COALESCE(new_row, '{}'::JSONB) || '{"password": "changed"}';
+    END IF;
+    IF old_row IS NOT NULL THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values, new_values)
+            VALUES (NEW.id, actor, TG_OP, old_row, new_row);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_history AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION record_user_history();
+
+INSERT INTO permissions (name, description) VALUES ('users:history', 'Read the change history of users');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'users:history' WHERE r.name = 'admin';
\ No newline at end of file
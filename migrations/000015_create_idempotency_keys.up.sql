@@ -0,0 +1,11 @@
+-- Responses of POST requests sent with an Idempotency-Key header, replayed when the client retries.
+-- scope is the route and the caller, so two clients can't collide on the same key.
+CREATE TABLE idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status INT, -- NULL while the first request is running
+    response JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope, key)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name = 'config:reload';
\ No newline at end of file
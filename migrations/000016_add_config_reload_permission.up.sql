@@ -0,0 +1,3 @@
+INSERT INTO permissions (name, description) VALUES ('config:reload', 'Reload the configuration of the running server');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'config:reload' WHERE r.name = 'admin';
\ No newline at end of file
@@ -0,0 +1,18 @@
+-- Background jobs, run by the workers of the jobs package outside of the request cycle.
+-- A job is pending until a worker claims it (running), then done, or back to pending with a later
+-- run_at when it failed and has attempts left, or failed for good.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX jobs_runnable_idx ON jobs (run_at) WHERE status IN ('pending', 'running');
\ No newline at end of file
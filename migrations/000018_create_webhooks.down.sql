@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'webhooks:manage';
+
+DROP TABLE webhooks;
\ No newline at end of file
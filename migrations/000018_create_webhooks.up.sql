@@ -0,0 +1,14 @@
+-- Endpoints notified of the user lifecycle events (see the webhooks package). Each delivery is
+-- signed with the secret of its endpoint.
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    events TEXT[] NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (name, description) VALUES ('webhooks:manage', 'Register and manage webhook endpoints');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'webhooks:manage' WHERE r.name = 'admin';
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name = 'events:stream';
\ No newline at end of file
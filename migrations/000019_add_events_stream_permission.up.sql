@@ -0,0 +1,3 @@
+INSERT INTO permissions (name, description) VALUES ('events:stream', 'Receive the user events live on /ws/admin');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'events:stream' WHERE r.name = 'admin';
\ No newline at end of file
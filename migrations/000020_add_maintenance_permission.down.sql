@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name = 'maintenance:manage';
\ No newline at end of file
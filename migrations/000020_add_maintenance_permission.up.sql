@@ -0,0 +1,3 @@
+INSERT INTO permissions (name, description) VALUES ('maintenance:manage', 'Turn the maintenance mode on and off');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'maintenance:manage' WHERE r.name = 'admin';
\ No newline at end of file
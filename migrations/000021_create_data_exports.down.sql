@@ -0,0 +1 @@
+DROP TABLE data_exports;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- Archives of the personal data of a user (GET /users/me/export) too large to be built during the
+-- request. A job fills content and marks the export ready, the janitor deletes it once expired.
+CREATE TABLE data_exports (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    content BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX data_exports_user_id_idx ON data_exports (user_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'users:erase';
+ALTER TABLE users DROP COLUMN anonymized_at;
+DROP TABLE erasure_requests;
\ No newline at end of file
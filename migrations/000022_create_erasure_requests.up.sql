@@ -0,0 +1,19 @@
+-- Requests to erase the personal data of a user. A request made by the user waits for the token mailed
+-- to them (token_hash, valid until expires_at), then the account is anonymized by a job once erase_at
+-- is reached. Deleting the row before cancels the erasure.
+CREATE TABLE erasure_requests (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE,
+    requested_by INT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    confirmed_at TIMESTAMP,
+    erase_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Anonymized users keep their row, so what references them stays valid
+ALTER TABLE users ADD COLUMN anonymized_at TIMESTAMP;
+
+INSERT INTO permissions (name, description) VALUES ('users:erase', 'Erase the personal data of users');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'users:erase' WHERE r.name = 'admin';
\ No newline at end of file
@@ -0,0 +1,37 @@
+-- Fails while emails are encrypted, they don't fit in VARCHAR(100)
+CREATE OR REPLACE FUNCTION record_user_history() RETURNS trigger AS $$
+DECLARE
+    actor INT := NULLIF(current_setting('app.actor_id', true), '')::INT;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, new_values)
+            VALUES (NEW.id, actor, TG_OP, to_jsonb(NEW) - 'password');
+        RETURN NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values)
+            VALUES (OLD.id, actor, TG_OP, to_jsonb(OLD) - 'password');
+        RETURN OLD;
+    END IF;
+
+    SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, to_jsonb(NEW) -> o.key)
+        INTO old_row, new_row
+        FROM jsonb_each(to_jsonb(OLD) - 'password') o
+        WHERE (to_jsonb(NEW) -> o.key) IS DISTINCT FROM o.value;
+    IF OLD.password IS DISTINCT FROM NEW.password THEN
+        old_row := COALESCE(old_row, '{}'::JSONB) || '{"password": "changed"}';
+        new_row := COALESCE(new_row, '{}'::JSONB) || '{"password": "changed"}';
+    END IF;
+    IF old_row IS NOT NULL THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values, new_values)
+            VALUES (NEW.id, actor, TG_OP, old_row, new_row);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX users_email_lookup_active_key;
+CREATE UNIQUE INDEX users_email_active_key ON users (email) WHERE deleted_at IS NULL;
+ALTER TABLE users DROP COLUMN email_lookup;
+ALTER TABLE users ALTER COLUMN email TYPE VARCHAR(100);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'emails:manage';
+
+DROP TABLE emails;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- Outgoing emails (see mailer.Outbox). Each one is sent by a job and stays pending while it is retried,
+-- then ends sent or, after the last attempt, failed until an admin retries it. The recipient is encrypted
+-- like users.email, the bodies are cleared once sent since they hold confirmation links.
+CREATE TABLE emails (
+    id BIGSERIAL PRIMARY KEY,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    html TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+CREATE INDEX emails_status_idx ON emails (status, created_at);
+
+INSERT INTO permissions (name, description) VALUES ('emails:manage', 'See the delivery of the outgoing emails and retry the failed ones');
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'emails:manage' WHERE r.name = 'admin';
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name = 'debug:pprof';
\ No newline at end of file
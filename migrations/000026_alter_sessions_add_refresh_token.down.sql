@@ -0,0 +1,3 @@
+DROP INDEX sessions_refresh_token_hash_key;
+ALTER TABLE sessions DROP COLUMN refreshed_at;
+ALTER TABLE sessions DROP COLUMN refresh_token_hash;
\ No newline at end of file
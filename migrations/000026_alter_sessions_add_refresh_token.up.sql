@@ -0,0 +1,6 @@
+-- Sessions are renewed with a refresh token, stored as its SHA-256 like the other one-time tokens.
+-- It changes on every refresh, so the hash is of the last one handed out. Sessions opened before
+-- have none and end with their access token.
+ALTER TABLE sessions ADD COLUMN refresh_token_hash CHAR(64);
+ALTER TABLE sessions ADD COLUMN refreshed_at TIMESTAMP;
+CREATE UNIQUE INDEX sessions_refresh_token_hash_key ON sessions (refresh_token_hash);
@@ -0,0 +1,12 @@
+-- Users authenticated by an external identity provider are found by who the provider says they are:
+-- the OIDC issuer and sub, or the LDAP server and DN. The email alone is never enough to link one to
+-- an account.
+CREATE TABLE external_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX external_identities_user_id_idx ON external_identities (user_id);
@@ -0,0 +1,36 @@
+-- The janitor purges the accounts deleted long ago with app.skip_history set: recording the deleted
+-- row would keep the personal data the purge removes
+CREATE OR REPLACE FUNCTION record_user_history() RETURNS trigger AS $$
+DECLARE
+    actor INT := NULLIF(current_setting('app.actor_id', true), '')::INT;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF current_setting('app.skip_history', true) = 'on' THEN
+        RETURN COALESCE(NEW, OLD);
+    END IF;
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, new_values)
+            VALUES (NEW.id, actor, TG_OP, to_jsonb(NEW) - 'password' - 'email_lookup');
+        RETURN NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values)
+            VALUES (OLD.id, actor, TG_OP, to_jsonb(OLD) - 'password' - 'email_lookup');
+        RETURN OLD;
+    END IF;
+
+    SELECT jsonb_object_agg(o.key, o.value), jsonb_object_agg(o.key, to_jsonb(NEW) -> o.key)
+        INTO old_row, new_row
+        FROM jsonb_each(to_jsonb(OLD) - 'password' - 'email_lookup') o
+        WHERE (to_jsonb(NEW) -> o.key) IS DISTINCT FROM o.value;
+    IF OLD.password IS DISTINCT FROM NEW.password THEN
+        old_row := COALESCE(old_row, '{}'::JSONB) || '{"password": "changed"}';
+        new_row := COALESCE(new_row, '{}'::JSONB) || '{"password": "changed"}';
+    END IF;
+    IF old_row IS NOT NULL THEN
+        INSERT INTO user_history (user_id, actor_id, operation, old_values, new_values)
+            VALUES (NEW.id, actor, TG_OP, old_row, new_row);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
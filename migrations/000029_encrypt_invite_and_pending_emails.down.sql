@@ -0,0 +1,5 @@
+-- Fails while emails are encrypted, they don't fit in VARCHAR(100)
+ALTER TABLE email_changes ALTER COLUMN new_email TYPE VARCHAR(100);
+DROP INDEX invites_email_lookup_idx;
+ALTER TABLE invites DROP COLUMN email_lookup;
+ALTER TABLE invites ALTER COLUMN email TYPE VARCHAR(100);
@@ -0,0 +1,9 @@
+-- The emails of the invites and of the pending email changes are encrypted like users.email when
+-- PII_ENCRYPTION_KEY is set, the invites are looked up by the HMAC in email_lookup. Without the key
+-- both hold the email in clear. The rows written before are encrypted at startup.
+ALTER TABLE invites ALTER COLUMN email TYPE TEXT;
+ALTER TABLE invites ADD COLUMN email_lookup TEXT;
+UPDATE invites SET email_lookup = email;
+ALTER TABLE invites ALTER COLUMN email_lookup SET NOT NULL;
+CREATE INDEX invites_email_lookup_idx ON invites (email_lookup);
+ALTER TABLE email_changes ALTER COLUMN new_email TYPE TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX sessions_previous_refresh_token_hash_idx;
+ALTER TABLE sessions DROP COLUMN previous_refresh_token_hash;
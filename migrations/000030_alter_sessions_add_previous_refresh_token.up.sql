@@ -0,0 +1,4 @@
+-- The refresh token replaced by the last refresh, also as its SHA-256. A rotated token sent again
+-- means it was copied: whoever holds the current one may be the thief, so the session is revoked.
+ALTER TABLE sessions ADD COLUMN previous_refresh_token_hash CHAR(64);
+CREATE INDEX sessions_previous_refresh_token_hash_idx ON sessions (previous_refresh_token_hash);
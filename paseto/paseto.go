@@ -0,0 +1,115 @@
+// Package paseto seals and opens PASETO v4.local tokens
+// (https://github.com/paseto-standard/paseto-spec/blob/master/docs/01-Protocol-Versions/Version4.md):
+// the payload is encrypted with XChaCha20 and authenticated with a keyed BLAKE2b. There is a single
+// algorithm per version, so there is no header for an attacker to pick a weaker one.
+package paseto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// KeySize is the length of the v4.local keys
+const KeySize = 32
+
+const (
+	header   = "v4.local."
+	nonceLen = 32
+	tagLen   = 32
+)
+
+var ErrInvalid = errors.New("paseto: invalid token")
+
+var b64 = base64.RawURLEncoding
+
+// Encrypt returns the v4.local token of payload, without footer
+func Encrypt(key, payload []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", errors.New("paseto: the key must be 32 bytes")
+	}
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	encKey, counterNonce, authKey := splitKey(key, nonce)
+	stream, err := chacha20.NewUnauthenticatedCipher(encKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(payload))
+	stream.XORKeyStream(ciphertext, payload)
+	tag := mac(authKey, pae([]byte(header), nonce, ciphertext, nil, nil))
+
+	body := append(append(nonce, ciphertext...), tag...)
+	return header + b64.EncodeToString(body), nil
+}
+
+// Decrypt returns the payload of a v4.local token. Tokens with a footer are refused.
+func Decrypt(key []byte, token string) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("paseto: the key must be 32 bytes")
+	}
+	if !IsToken(token) || strings.Contains(token[len(header):], ".") {
+		return nil, ErrInvalid
+	}
+	body, err := b64.DecodeString(token[len(header):])
+	if err != nil || len(body) < nonceLen+tagLen {
+		return nil, ErrInvalid
+	}
+	nonce, ciphertext, tag := body[:nonceLen], body[nonceLen:len(body)-tagLen], body[len(body)-tagLen:]
+
+	encKey, counterNonce, authKey := splitKey(key, nonce)
+	if subtle.ConstantTimeCompare(tag, mac(authKey, pae([]byte(header), nonce, ciphertext, nil, nil))) != 1 {
+		return nil, ErrInvalid
+	}
+	stream, err := chacha20.NewUnauthenticatedCipher(encKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, len(ciphertext))
+	stream.XORKeyStream(payload, ciphertext)
+	return payload, nil
+}
+
+// IsToken tells if token is a v4.local token
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, header)
+}
+
+// splitKey derives the encryption key, the XChaCha20 nonce and the authentication key of a token
+func splitKey(key, nonce []byte) (encKey, counterNonce, authKey []byte) {
+	h, _ := blake2b.New(56, key)
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+
+	h, _ = blake2b.New(32, key)
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(nonce)
+	return tmp[:32], tmp[32:], h.Sum(nil)
+}
+
+func mac(key, message []byte) []byte {
+	h, _ := blake2b.New(tagLen, key)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// pae is the pre-authentication encoding of the pieces: their count then each piece prefixed by its
+// length, as 64-bit little endian integers
+func pae(pieces ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(piece)))
+		out = append(out, piece...)
+	}
+	return out
+}
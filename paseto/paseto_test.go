@@ -0,0 +1,144 @@
+package paseto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+var (
+	key      = bytes.Repeat([]byte{1}, KeySize)
+	otherKey = bytes.Repeat([]byte{2}, KeySize)
+	payload  = []byte(`{"sub":"2","exp":"2030-01-01T00:00:00Z"}`)
+)
+
+// seal is Encrypt with a footer and an implicit assertion, which the tokens of this package never carry
+func seal(t *testing.T, key, payload, footer, implicit []byte) string {
+	t.Helper()
+	nonce := bytes.Repeat([]byte{3}, nonceLen)
+	encKey, counterNonce, authKey := splitKey(key, nonce)
+	stream, err := chacha20.NewUnauthenticatedCipher(encKey, counterNonce)
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	ciphertext := make([]byte, len(payload))
+	stream.XORKeyStream(ciphertext, payload)
+	tag := mac(authKey, pae([]byte(header), nonce, ciphertext, footer, implicit))
+
+	token := header + b64.EncodeToString(append(append(nonce, ciphertext...), tag...))
+	if len(footer) > 0 {
+		token += "." + b64.EncodeToString(footer)
+	}
+	return token
+}
+
+// flip returns token with the byte at offset i of its body changed
+func flip(t *testing.T, token string, i int) string {
+	t.Helper()
+	body, err := b64.DecodeString(token[len(header):])
+	if err != nil {
+		t.Fatalf("decoding %q: %v", token, err)
+	}
+	body[i] ^= 1
+	return header + b64.EncodeToString(body)
+}
+
+func TestRoundTrip(t *testing.T) {
+	token, err := Encrypt(key, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsToken(token) || strings.Count(token, ".") != 2 {
+		t.Errorf("token %q is not a v4.local token without footer", token)
+	}
+	if strings.Contains(token, b64.EncodeToString(payload)) {
+		t.Errorf("token %q carries the payload in clear", token)
+	}
+
+	got, err := Decrypt(key, token)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Decrypt = %s, want %s", got, payload)
+	}
+}
+
+func TestEncryptUsesFreshNonces(t *testing.T) {
+	first, err := Encrypt(key, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	second, err := Encrypt(key, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if first == second {
+		t.Errorf("two encryptions of the same payload are both %q", first)
+	}
+}
+
+func TestDecryptRefused(t *testing.T) {
+	token, err := Encrypt(key, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		key   []byte
+	}{
+		{name: "tampered nonce", token: flip(t, token, 0), key: key},
+		{name: "tampered ciphertext", token: flip(t, token, nonceLen), key: key},
+		{name: "tampered tag", token: flip(t, token, nonceLen+len(payload)+tagLen-1), key: key},
+		{name: "truncated", token: token[:len(header)+40], key: key},
+		{name: "wrong key", token: token, key: otherKey},
+		{name: "other version", token: "v3.local." + token[len(header):], key: key},
+		{name: "public token", token: "v4.public." + token[len(header):], key: key},
+		{name: "not base64", token: header + "!!" + token[len(header)+2:], key: key},
+		// The footer is authenticated, the tokens of this package have none
+		{name: "footer added", token: token + "." + b64.EncodeToString([]byte(`{"kid":"1"}`)), key: key},
+		{name: "sealed with a footer", token: seal(t, key, payload, []byte(`{"kid":"1"}`), nil), key: key},
+		// So is the implicit assertion, which is always empty here
+		{name: "sealed with an implicit assertion", token: seal(t, key, payload, nil, []byte("audience")), key: key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.key, tt.token)
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("Decrypt = %s, %v, want %v", got, err, ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestSealMatchesEncrypt(t *testing.T) {
+	// Without footer nor implicit assertion seal makes the tokens Encrypt does, so the refused ones
+	// above are refused for them only
+	got, err := Decrypt(key, seal(t, key, payload, nil, nil))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Decrypt = %s, want %s", got, payload)
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	if _, err := Encrypt(key[:16], payload); err == nil {
+		t.Error("Encrypt with a 16 bytes key succeeded")
+	}
+
+	token, err := Encrypt(key, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := Decrypt(nil, token); err == nil || errors.Is(err, ErrInvalid) {
+		t.Errorf("Decrypt without key = %v, want a key error", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Package pii encrypts personal data, like the emails of the users table, before it is written to the
+// database, so a leaked dump or backup doesn't reveal it. Values are sealed with AES-256-GCM and a random
+// nonce, so the same email encrypts differently every time; Lookup derives the deterministic value that
+// equality lookups and unique indexes use instead.
+//
+// Every function takes the key of PII_ENCRYPTION_KEY and leaves values in clear when it is nil, so the
+// callers don't need a separate code path for a database without encryption.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// KeySize is the length of the AES-256 keys
+const KeySize = 32
+
+// Prefix starts the encrypted values, its version allows another scheme later
+const Prefix = "pii:v1:"
+
+var (
+	ErrInvalid = errors.New("pii: invalid encrypted value")
+	// ErrNoKey is returned for an encrypted value read without a key
+	ErrNoKey = errors.New("pii: encrypted value and no PII_ENCRYPTION_KEY")
+)
+
+// Encrypt returns the value to store for value, value itself without a key
+func Encrypt(value string, key []byte) (string, error) {
+	if key == nil {
+		return value, nil
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// Decrypt returns the value stored as stored. Values in clear, written before the encryption was
+// turned on, are returned as they are.
+func Decrypt(stored string, key []byte) (string, error) {
+	if !IsEncrypted(stored) {
+		return stored, nil
+	}
+	if key == nil {
+		return "", ErrNoKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, Prefix))
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", ErrInvalid
+	}
+	value, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(value), nil
+}
+
+// IsEncrypted tells if stored was returned by Encrypt with a key
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, Prefix)
+}
+
+// Lookup returns the HMAC-SHA256 of value in hex, the same for the same value, to find rows by an
+// encrypted column. The HMAC key is derived from key, so the lookups don't reveal the encryption key.
+// Without a key it is value itself.
+func Lookup(value string, key []byte) string {
+	if key == nil {
+		return value
+	}
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("pii lookup"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("pii: the key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
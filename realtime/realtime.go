@@ -0,0 +1,149 @@
+// Package realtime pushes the domain events published on the events bus to long lived connections:
+// admin dashboards on the /ws/admin WebSocket, and the Server-Sent Events activity feed of /events/stream.
+// Each event is sent as the JSON encoded events.Event.
+//
+// The bus is in-process, so a connection only gets the events of the requests served by its instance.
+// The last events are kept in memory so SSE clients can resume after a reconnection. Clients that can't
+// keep up are disconnected rather than slowing down the requests publishing the events.
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/events"
+)
+
+// Events are the types pushed to the WebSocket connections
+var Events = []string{events.UserCreated, events.UserUpdated, events.UserDeleted}
+
+// FeedEvents are the types of the activity feed: the user lifecycle and the logins
+var FeedEvents = []string{events.UserCreated, events.UserUpdated, events.UserDeleted, events.LoginSucceeded, events.LoginFailed}
+
+const (
+	// Messages queued for a connection before it is considered too slow and closed
+	sendBuffer = 64
+	// Events kept for the clients resuming a stream
+	historySize = 500
+	// Time a write gets before the connection is considered dead
+	writeWait = 10 * time.Second
+	// Connections are pinged every pingPeriod, and their credentials checked again
+	pingPeriod = 30 * time.Second
+)
+
+// message is an event ready to be sent
+type message struct {
+	id   string
+	typ  string
+	data []byte
+}
+
+type client struct {
+	types []string
+	send  chan message
+	once  sync.Once
+	// Closed when the client must stop, for reason
+	done   chan struct{}
+	reason stopReason
+}
+
+type stopReason int
+
+const (
+	stopTooSlow stopReason = iota + 1
+	stopShutdown
+	// The client closed the connection
+	stopGone
+)
+
+// stop ends the connection of the client. It can be called from any goroutine.
+func (c *client) stop(reason stopReason) {
+	c.once.Do(func() {
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+// Hub holds the connections and broadcasts the events to them
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	history []message
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*client]struct{})}
+}
+
+// Subscribe pushes the events of bus listed in FeedEvents, Events being part of them
+func (h *Hub) Subscribe(bus *events.Bus) {
+	bus.Subscribe(h.broadcast, FeedEvents...)
+}
+
+func (h *Hub) broadcast(ctx context.Context, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[Realtime:broadcast] Error encoding a %s event: %v", e.Type, err)
+		return
+	}
+	m := message{id: e.ID, typ: e.Type, data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.history) == historySize {
+		h.history = slices.Delete(h.history, 0, 1)
+	}
+	h.history = append(h.history, m)
+	for c := range h.clients {
+		if !slices.Contains(c.types, m.typ) {
+			continue
+		}
+		select {
+		case c.send <- m:
+		default:
+			log.Printf("[Realtime:broadcast] Closing a connection too slow to receive event %s", e.ID)
+			c.stop(stopTooSlow)
+		}
+	}
+}
+
+// join registers a client receiving types. When lastID is set, the events of the history published
+// after it are returned to be sent first, or the whole history when lastID is too old to be found.
+func (h *Hub) join(types []string, lastID string) (*client, []message) {
+	c := &client{types: types, send: make(chan message, sendBuffer), done: make(chan struct{})}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	if lastID == "" {
+		return c, nil
+	}
+	// IndexFunc gives -1 for an unknown id, so everything is replayed
+	missed := h.history[slices.IndexFunc(h.history, func(m message) bool { return m.id == lastID })+1:]
+	var replay []message
+	for _, m := range missed {
+		if slices.Contains(types, m.typ) {
+			replay = append(replay, m)
+		}
+	}
+	return c, replay
+}
+
+func (h *Hub) leave(c *client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+// Close disconnects every client, for the shutdown of the server
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.stop(stopShutdown)
+	}
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long EventSource clients wait before reconnecting, in milliseconds
+const sseRetry = 3000
+
+// ServeSSE streams the events listed in FeedEvents to w as Server-Sent Events, until the client goes
+// away, Close is called or authorize fails (see Serve). Every event carries its id, so EventSource
+// clients reconnecting with the Last-Event-ID header first get the events they missed, as long as
+// they are still in memory.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request, authorize func() error) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server, each write gets its own deadline instead
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Proxies like nginx would hold the events back otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	c, replay := h.join(FeedEvents, r.Header.Get("Last-Event-ID"))
+	log.Printf("[Realtime:ServeSSE] Stream to %s opened, replaying %d events", r.RemoteAddr, len(replay))
+	defer func() {
+		h.leave(c)
+		log.Printf("[Realtime:ServeSSE] Stream to %s closed", r.RemoteAddr)
+	}()
+
+	send := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	event := func(m message) bool {
+		return send("id: %s\nevent: %s\ndata: %s\n\n", m.id, m.typ, m.data)
+	}
+
+	if !send("retry: %d\n\n", sseRetry) {
+		return
+	}
+	for _, m := range replay {
+		if !event(m) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			if !event(m) {
+				return
+			}
+		case <-ping.C:
+			if err := authorize(); err != nil {
+				log.Printf("[Realtime:ServeSSE] Closing stream to %s: %v", r.RemoteAddr, err)
+				return
+			}
+			// A comment, ignored by clients, keeps proxies from closing an idle stream
+			if !send(": ping\n\n") {
+				return
+			}
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package realtime
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clients must answer the pings within pongWait
+const pongWait = pingPeriod + writeWait
+
+// Serve pushes the events listed in Events to conn until the client goes away or Close is called.
+// Every pingPeriod it calls authorize, which should check the credentials of the connection are still
+// valid: the connection is closed as soon as it returns an error.
+func (h *Hub) Serve(conn *websocket.Conn, authorize func() error) {
+	c, _ := h.join(Events, "")
+	log.Printf("[Realtime:Serve] WebSocket from %s opened", conn.RemoteAddr())
+	defer func() {
+		h.leave(c)
+		conn.Close()
+		log.Printf("[Realtime:Serve] WebSocket from %s closed", conn.RemoteAddr())
+	}()
+
+	// Clients send nothing but control frames, reading handles the pongs and notices when they leave
+	go func() {
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				c.stop(stopGone)
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, m.data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := authorize(); err != nil {
+				closeWebSocket(conn, websocket.ClosePolicyViolation, err.Error())
+				return
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-c.done:
+			switch c.reason {
+			case stopTooSlow:
+				closeWebSocket(conn, websocket.ClosePolicyViolation, "too slow")
+			case stopShutdown:
+				closeWebSocket(conn, websocket.CloseGoingAway, "server shutting down")
+			}
+			return
+		}
+	}
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, text string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeWait))
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Session is a sessions row: the device a user signed in from, which the tokens of the session are
+// bound to
+type Session struct {
+	ID         int64
+	UserID     int
+	UserAgent  string
+	IP         string
+	DeviceName string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	// SHA-256 of the refresh token, only written
+	RefreshTokenHash string
+}
+
+// AccountRepository writes the accounts and sessions opened by the auth routes
+type AccountRepository interface {
+	// Register creates a user with a password and the user role, and opens its first session in the
+	// same transaction: a failed session doesn't leave an account the client never got a token for.
+	// It returns the user with the id of the session, an *Error of kind ErrConflict when the email is taken.
+	Register(ctx context.Context, name, email, passwordHash string, s *Session) (*User, int64, error)
+	// OpenSession stores the session and returns its id
+	OpenSession(ctx context.Context, s *Session) (int64, error)
+	// Sessions returns the active (not expired nor revoked) sessions of the user, newest first
+	Sessions(ctx context.Context, userID int) ([]Session, error)
+	// RevokeSession returns ErrNotFound when the user has no such active session
+	RevokeSession(ctx context.Context, userID int, id int64) error
+}
+
+// PgAccountRepository is the AccountRepository of the Postgres database
+type PgAccountRepository struct {
+	db *pgxpool.Pool
+	// Key of the encrypted emails, nil when they are in clear
+	piiKey []byte
+}
+
+func NewAccountRepository(db *pgxpool.Pool) *PgAccountRepository {
+	return &PgAccountRepository{db: db}
+}
+
+// WithPIIKey encrypts the emails of the registered users with key (PII_ENCRYPTION_KEY)
+func (repo *PgAccountRepository) WithPIIKey(key []byte) *PgAccountRepository {
+	repo.piiKey = key
+	return repo
+}
+
+// SessionOpener stores a session and returns its id, like AccountRepository.OpenSession
+type SessionOpener func(ctx context.Context, s *Session) (int64, error)
+
+// OpenSessionWith returns the SessionOpener storing the sessions through db, which can be the
+// transaction creating their user
+func OpenSessionWith(db Querier) SessionOpener {
+	return func(ctx context.Context, s *Session) (int64, error) {
+		return createSession(ctx, db, s)
+	}
+}
+
+func createSession(ctx context.Context, db Querier, s *Session) (int64, error) {
+	var id int64
+	query := `INSERT INTO sessions (user_id, user_agent, ip, device_name, expires_at, refresh_token_hash) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+	err := db.QueryRow(ctx, query, s.UserID, s.UserAgent, s.IP, s.DeviceName, s.ExpiresAt, s.RefreshTokenHash).Scan(&id)
+	return id, Translate(err)
+}
+
+func (repo *PgAccountRepository) Register(ctx context.Context, name, email, passwordHash string, s *Session) (*User, int64, error) {
+	encrypted, err := pii.Encrypt(email, repo.piiKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `WITH new_user AS (
+			INSERT INTO users (name, email, email_lookup, password) VALUES ($1, $2, $3, $4) RETURNING id, name
+		), new_role AS (
+			INSERT INTO user_roles (user_id, role_id) SELECT new_user.id, roles.id FROM new_user, roles WHERE roles.name = 'user'
+		)
+		SELECT id, name, ARRAY['user'] FROM new_user;`
+	u := &User{Email: email}
+	var sessionID int64
+	err = WithTx(ctx, repo.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, name, encrypted, pii.Lookup(email, repo.piiKey), passwordHash).Scan(&u.ID, &u.Name, &u.Roles)
+		if err != nil {
+			return err
+		}
+		opened := *s
+		opened.UserID = u.ID
+		sessionID, err = createSession(ctx, tx, &opened)
+		return err
+	})
+	if err != nil {
+		return nil, 0, Translate(err)
+	}
+	return u, sessionID, nil
+}
+
+func (repo *PgAccountRepository) OpenSession(ctx context.Context, s *Session) (int64, error) {
+	return createSession(ctx, repo.db, s)
+}
+
+func (repo *PgAccountRepository) Sessions(ctx context.Context, userID int) ([]Session, error) {
+	query := `SELECT id, user_id, user_agent, ip, device_name, created_at, expires_at FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW() ORDER BY created_at DESC;`
+	rows, err := repo.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.DeviceName, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func (repo *PgAccountRepository) RevokeSession(ctx context.Context, userID int, id int64) error {
+	return execOne(ctx, repo.db, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`, id, userID)
+}
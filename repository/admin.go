@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Note is a support note an admin left on a user account
+type Note struct {
+	ID        int
+	UserID    int
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// AdminRepository reads and writes what admins manage on user accounts: support notes, tags and
+// roles. It doesn't check that the user exists, see UserRepository.Exists.
+type AdminRepository interface {
+	// Notes returns the notes of the user, newest first
+	Notes(ctx context.Context, userID int) ([]Note, error)
+	AddNote(ctx context.Context, userID int, author, body string) (*Note, error)
+	Tags(ctx context.Context, userID int) ([]string, error)
+	// AddTag is a no-op for a tag the user already has
+	AddTag(ctx context.Context, userID int, tag string) error
+	// RemoveTag returns ErrNotFound when the user doesn't have the tag
+	RemoveTag(ctx context.Context, userID int, tag string) error
+	Roles(ctx context.Context, userID int) ([]string, error)
+	// GrantRole is a no-op for an unknown role or a role the user already has
+	GrantRole(ctx context.Context, userID int, role string) error
+	// RevokeRole returns ErrNotFound when the user doesn't have the role
+	RevokeRole(ctx context.Context, userID int, role string) error
+}
+
+// PgAdminRepository is the AdminRepository of the Postgres database
+type PgAdminRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAdminRepository(db *pgxpool.Pool) *PgAdminRepository {
+	return &PgAdminRepository{db: db}
+}
+
+func (repo *PgAdminRepository) Notes(ctx context.Context, userID int) ([]Note, error) {
+	rows, err := repo.db.Query(ctx, `SELECT id, user_id, author, body, created_at FROM user_notes WHERE user_id = $1 ORDER BY created_at DESC;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Author, &n.Body, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+func (repo *PgAdminRepository) AddNote(ctx context.Context, userID int, author, body string) (*Note, error) {
+	query := `INSERT INTO user_notes (user_id, author, body) VALUES ($1, $2, $3) RETURNING id, user_id, author, body, created_at;`
+	n := &Note{}
+	err := repo.db.QueryRow(ctx, query, userID, author, body).Scan(&n.ID, &n.UserID, &n.Author, &n.Body, &n.CreatedAt)
+	if err != nil {
+		return nil, Translate(err)
+	}
+	return n, nil
+}
+
+func (repo *PgAdminRepository) Tags(ctx context.Context, userID int) ([]string, error) {
+	rows, err := repo.db.Query(ctx, `SELECT tag FROM user_tags WHERE user_id = $1 ORDER BY tag;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (repo *PgAdminRepository) AddTag(ctx context.Context, userID int, tag string) error {
+	_, err := repo.db.Exec(ctx, `INSERT INTO user_tags (user_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING;`, userID, tag)
+	return Translate(err)
+}
+
+func (repo *PgAdminRepository) RemoveTag(ctx context.Context, userID int, tag string) error {
+	return execOne(ctx, repo.db, `DELETE FROM user_tags WHERE user_id = $1 AND tag = $2;`, userID, tag)
+}
+
+func (repo *PgAdminRepository) Roles(ctx context.Context, userID int) ([]string, error) {
+	var roles []string
+	err := repo.db.QueryRow(ctx, `SELECT `+UserRolesColumn+` FROM users u WHERE u.id = $1;`, userID).Scan(&roles)
+	return roles, Translate(err)
+}
+
+func (repo *PgAdminRepository) GrantRole(ctx context.Context, userID int, role string) error {
+	query := `INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = $2 ON CONFLICT DO NOTHING;`
+	_, err := repo.db.Exec(ctx, query, userID, role)
+	return Translate(err)
+}
+
+func (repo *PgAdminRepository) RevokeRole(ctx context.Context, userID int, role string) error {
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2);`
+	return execOne(ctx, repo.db, query, userID, role)
+}
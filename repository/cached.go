@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/cache"
+	"github.com/hi-im-yan/jwt-with-go/pii"
+)
+
+const usersListKey = "users:list"
+
+// CachedUserRepository puts a cache-aside layer in front of a UserRepository: Get and the unfiltered
+// List are answered from the cache when possible, and the writes made through it drop the entries
+// they change. Writes made elsewhere (registration, role grants...) must call Invalidate.
+// Cache failures are logged and the repository is used instead, the cache never fails a request.
+// The entries hold the users with their emails, so they are encrypted with piiKey like the users table.
+type CachedUserRepository struct {
+	UserRepository
+	cache  cache.Cache
+	ttl    time.Duration
+	piiKey []byte
+}
+
+func NewCachedUserRepository(repo UserRepository, c cache.Cache, ttl time.Duration, piiKey []byte) *CachedUserRepository {
+	return &CachedUserRepository{UserRepository: repo, cache: c, ttl: ttl, piiKey: piiKey}
+}
+
+func userKey(id int) string {
+	return "user:" + strconv.Itoa(id)
+}
+
+// Invalidate drops the cached lookups of the user, and the list holding it
+func (repo *CachedUserRepository) Invalidate(ctx context.Context, id int) {
+	if err := repo.cache.Delete(ctx, userKey(id), usersListKey); err != nil {
+		log.Printf("[Repository:CachedUserRepository] Error invalidating user %d: %v", id, err)
+	}
+}
+
+func (repo *CachedUserRepository) List(ctx context.Context, tags []string) ([]User, error) {
+	// Tag filters are rare admin queries, not worth a cache entry per combination
+	if len(tags) > 0 {
+		return repo.UserRepository.List(ctx, tags)
+	}
+	var users []User
+	if repo.load(ctx, usersListKey, &users) {
+		return users, nil
+	}
+	users, err := repo.UserRepository.List(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	repo.store(ctx, usersListKey, users)
+	return users, nil
+}
+
+func (repo *CachedUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	var u User
+	if repo.load(ctx, userKey(id), &u) {
+		return &u, nil
+	}
+	found, err := repo.UserRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	repo.store(ctx, userKey(id), found)
+	return found, nil
+}
+
+func (repo *CachedUserRepository) Create(ctx context.Context, actorID int, name, email string) (*User, error) {
+	u, err := repo.UserRepository.Create(ctx, actorID, name, email)
+	if err == nil {
+		repo.Invalidate(ctx, u.ID)
+	}
+	return u, err
+}
+
+func (repo *CachedUserRepository) UpdateName(ctx context.Context, actorID, id int, name string) (*User, error) {
+	u, err := repo.UserRepository.UpdateName(ctx, actorID, id, name)
+	repo.Invalidate(ctx, id)
+	return u, err
+}
+
+func (repo *CachedUserRepository) UpdateAvatar(ctx context.Context, actorID, id int, avatarURL string) (*User, error) {
+	u, err := repo.UserRepository.UpdateAvatar(ctx, actorID, id, avatarURL)
+	repo.Invalidate(ctx, id)
+	return u, err
+}
+
+func (repo *CachedUserRepository) Delete(ctx context.Context, actorID, id int) error {
+	err := repo.UserRepository.Delete(ctx, actorID, id)
+	repo.Invalidate(ctx, id)
+	return err
+}
+
+// load decodes the cached value of key into v, false on a miss
+func (repo *CachedUserRepository) load(ctx context.Context, key string, v interface{}) bool {
+	data, ok, err := repo.cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("[Repository:CachedUserRepository] Error reading %s from the cache: %v", key, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	// An entry that doesn't decrypt (written with another key) is a miss
+	decrypted, err := pii.Decrypt(string(data), repo.piiKey)
+	return err == nil && json.Unmarshal([]byte(decrypted), v) == nil
+}
+
+func (repo *CachedUserRepository) store(ctx context.Context, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	var encrypted string
+	if err == nil {
+		encrypted, err = pii.Encrypt(string(data), repo.piiKey)
+	}
+	if err == nil {
+		err = repo.cache.Set(ctx, key, []byte(encrypted), repo.ttl)
+	}
+	if err != nil {
+		log.Printf("[Repository:CachedUserRepository] Error writing %s to the cache: %v", key, err)
+	}
+}
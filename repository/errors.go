@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrNotFound is returned when the row does not exist (or is soft deleted)
+	ErrNotFound = errors.New("repository: not found")
+	// ErrConflict is returned when a write breaks a unique constraint, like an email already in use
+	ErrConflict = errors.New("repository: conflict")
+	// ErrInvalidReference is returned when a write points to a row that doesn't exist (foreign key violation)
+	ErrInvalidReference = errors.New("repository: invalid reference")
+	// ErrSerialization is returned when a transaction lost against a concurrent one (serialization failure
+	// or deadlock). Running it again can succeed.
+	ErrSerialization = errors.New("repository: serialization failure")
+)
+
+// Error is what Translate turns the errors of the database into. errors.Is matches it with its Kind,
+// one of the errors above, and errors.As still finds the *pgconn.PgError it comes from.
+type Error struct {
+	Kind error
+	// Name of the broken constraint, like users_email_lookup_active_key
+	Constraint string
+	// The error of the driver, nil when it doesn't come from one (mocks)
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Constraint != "" {
+		msg += " (" + e.Constraint + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Translate maps the errors of the driver to the errors of this package: no rows to ErrNotFound and the
+// unique violation, foreign key violation and serialization failure codes to an *Error. Other errors,
+// and errors already translated, are returned as they are.
+func Translate(err error) error {
+	var translated *Error
+	if err == nil || errors.Is(err, ErrNotFound) || errors.As(err, &translated) {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case "23505": // unique_violation
+		return &Error{Kind: ErrConflict, Constraint: pgErr.ConstraintName, Err: err}
+	case "23503": // foreign_key_violation
+		return &Error{Kind: ErrInvalidReference, Constraint: pgErr.ConstraintName, Err: err}
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return &Error{Kind: ErrSerialization, Err: err}
+	}
+	return err
+}
+
+// ConstraintOf returns the name of the constraint err broke, empty when it isn't a translated constraint
+// violation
+func ConstraintOf(err error) string {
+	var translated *Error
+	if errors.As(Translate(err), &translated) {
+		return translated.Constraint
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Group is a groups row with the ids of its active members and its permission names
+type Group struct {
+	ID          int
+	Name        string
+	Description string
+	Members     []int
+	Permissions []string
+	CreatedAt   time.Time
+}
+
+// GroupRepository reads and writes the user groups, their members and the permissions granted to them
+type GroupRepository interface {
+	List(ctx context.Context) ([]Group, error)
+	Get(ctx context.Context, id int) (*Group, error)
+	// Create returns an *Error of kind ErrConflict when the name is taken
+	Create(ctx context.Context, name, description string) (*Group, error)
+	Update(ctx context.Context, id int, name, description string) (*Group, error)
+	Delete(ctx context.Context, id int) error
+	// AddMember is a no-op for a member, it returns ErrInvalidReference when the group doesn't exist
+	AddMember(ctx context.Context, id, userID int) error
+	// RemoveMember returns ErrNotFound when the user is not a member
+	RemoveMember(ctx context.Context, id, userID int) error
+	// GrantPermission is a no-op for an unknown or already granted permission, it returns
+	// ErrInvalidReference when the group doesn't exist
+	GrantPermission(ctx context.Context, id int, permission string) error
+	// RevokePermission returns ErrNotFound when the group doesn't have the permission
+	RevokePermission(ctx context.Context, id int, permission string) error
+}
+
+// groupColumns selects a group aliased "g" with its member ids and permission names
+const groupColumns = `g.id, g.name, g.description,
+	ARRAY(SELECT gm.user_id FROM group_members gm JOIN users u ON u.id = gm.user_id WHERE gm.group_id = g.id AND u.deleted_at IS NULL ORDER BY gm.user_id),
+	ARRAY(SELECT p.name FROM group_permissions gp JOIN permissions p ON p.id = gp.permission_id WHERE gp.group_id = g.id ORDER BY p.name),
+	g.created_at`
+
+// PgGroupRepository is the GroupRepository of the Postgres database
+type PgGroupRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewGroupRepository(db *pgxpool.Pool) *PgGroupRepository {
+	return &PgGroupRepository{db: db}
+}
+
+func scanGroup(row pgx.Row) (*Group, error) {
+	g := &Group{}
+	if err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Members, &g.Permissions, &g.CreatedAt); err != nil {
+		return nil, Translate(err)
+	}
+	return g, nil
+}
+
+func (repo *PgGroupRepository) List(ctx context.Context) ([]Group, error) {
+	rows, err := repo.db.Query(ctx, `SELECT `+groupColumns+` FROM groups g ORDER BY g.name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *g)
+	}
+	return groups, rows.Err()
+}
+
+func (repo *PgGroupRepository) Get(ctx context.Context, id int) (*Group, error) {
+	return scanGroup(repo.db.QueryRow(ctx, `SELECT `+groupColumns+` FROM groups g WHERE g.id = $1;`, id))
+}
+
+func (repo *PgGroupRepository) Create(ctx context.Context, name, description string) (*Group, error) {
+	return scanGroup(repo.db.QueryRow(ctx, `WITH g AS (
+			INSERT INTO groups (name, description) VALUES ($1, $2) RETURNING *
+		)
+		SELECT `+groupColumns+` FROM g;`, name, description))
+}
+
+func (repo *PgGroupRepository) Update(ctx context.Context, id int, name, description string) (*Group, error) {
+	tag, err := repo.db.Exec(ctx, `UPDATE groups SET name = $1, description = $2 WHERE id = $3;`, name, description, id)
+	if err != nil {
+		return nil, Translate(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+	return repo.Get(ctx, id)
+}
+
+func (repo *PgGroupRepository) Delete(ctx context.Context, id int) error {
+	return execOne(ctx, repo.db, `DELETE FROM groups WHERE id = $1;`, id)
+}
+
+func (repo *PgGroupRepository) AddMember(ctx context.Context, id, userID int) error {
+	_, err := repo.db.Exec(ctx, `INSERT INTO group_members (group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`, id, userID)
+	return Translate(err)
+}
+
+func (repo *PgGroupRepository) RemoveMember(ctx context.Context, id, userID int) error {
+	return execOne(ctx, repo.db, `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2;`, id, userID)
+}
+
+func (repo *PgGroupRepository) GrantPermission(ctx context.Context, id int, permission string) error {
+	query := `INSERT INTO group_permissions (group_id, permission_id) SELECT $1, id FROM permissions WHERE name = $2 ON CONFLICT DO NOTHING;`
+	_, err := repo.db.Exec(ctx, query, id, permission)
+	return Translate(err)
+}
+
+func (repo *PgGroupRepository) RevokePermission(ctx context.Context, id int, permission string) error {
+	query := `DELETE FROM group_permissions WHERE group_id = $1 AND permission_id = (SELECT id FROM permissions WHERE name = $2);`
+	return execOne(ctx, repo.db, query, id, permission)
+}
@@ -0,0 +1,39 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// AccountRepository mocks repository.AccountRepository
+type AccountRepository struct {
+	RegisterFunc      func(ctx context.Context, name, email, passwordHash string, s *repository.Session) (*repository.User, int64, error)
+	OpenSessionFunc   func(ctx context.Context, s *repository.Session) (int64, error)
+	SessionsFunc      func(ctx context.Context, userID int) ([]repository.Session, error)
+	RevokeSessionFunc func(ctx context.Context, userID int, id int64) error
+
+	recorder
+}
+
+var _ repository.AccountRepository = (*AccountRepository)(nil)
+
+func (m *AccountRepository) Register(ctx context.Context, name, email, passwordHash string, s *repository.Session) (*repository.User, int64, error) {
+	m.called("AccountRepository", "Register", m.RegisterFunc != nil)
+	return m.RegisterFunc(ctx, name, email, passwordHash, s)
+}
+
+func (m *AccountRepository) OpenSession(ctx context.Context, s *repository.Session) (int64, error) {
+	m.called("AccountRepository", "OpenSession", m.OpenSessionFunc != nil)
+	return m.OpenSessionFunc(ctx, s)
+}
+
+func (m *AccountRepository) Sessions(ctx context.Context, userID int) ([]repository.Session, error) {
+	m.called("AccountRepository", "Sessions", m.SessionsFunc != nil)
+	return m.SessionsFunc(ctx, userID)
+}
+
+func (m *AccountRepository) RevokeSession(ctx context.Context, userID int, id int64) error {
+	m.called("AccountRepository", "RevokeSession", m.RevokeSessionFunc != nil)
+	return m.RevokeSessionFunc(ctx, userID, id)
+}
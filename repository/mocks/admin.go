@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// AdminRepository mocks repository.AdminRepository
+type AdminRepository struct {
+	NotesFunc      func(ctx context.Context, userID int) ([]repository.Note, error)
+	AddNoteFunc    func(ctx context.Context, userID int, author, body string) (*repository.Note, error)
+	TagsFunc       func(ctx context.Context, userID int) ([]string, error)
+	AddTagFunc     func(ctx context.Context, userID int, tag string) error
+	RemoveTagFunc  func(ctx context.Context, userID int, tag string) error
+	RolesFunc      func(ctx context.Context, userID int) ([]string, error)
+	GrantRoleFunc  func(ctx context.Context, userID int, role string) error
+	RevokeRoleFunc func(ctx context.Context, userID int, role string) error
+
+	recorder
+}
+
+var _ repository.AdminRepository = (*AdminRepository)(nil)
+
+func (m *AdminRepository) Notes(ctx context.Context, userID int) ([]repository.Note, error) {
+	m.called("AdminRepository", "Notes", m.NotesFunc != nil)
+	return m.NotesFunc(ctx, userID)
+}
+
+func (m *AdminRepository) AddNote(ctx context.Context, userID int, author, body string) (*repository.Note, error) {
+	m.called("AdminRepository", "AddNote", m.AddNoteFunc != nil)
+	return m.AddNoteFunc(ctx, userID, author, body)
+}
+
+func (m *AdminRepository) Tags(ctx context.Context, userID int) ([]string, error) {
+	m.called("AdminRepository", "Tags", m.TagsFunc != nil)
+	return m.TagsFunc(ctx, userID)
+}
+
+func (m *AdminRepository) AddTag(ctx context.Context, userID int, tag string) error {
+	m.called("AdminRepository", "AddTag", m.AddTagFunc != nil)
+	return m.AddTagFunc(ctx, userID, tag)
+}
+
+func (m *AdminRepository) RemoveTag(ctx context.Context, userID int, tag string) error {
+	m.called("AdminRepository", "RemoveTag", m.RemoveTagFunc != nil)
+	return m.RemoveTagFunc(ctx, userID, tag)
+}
+
+func (m *AdminRepository) Roles(ctx context.Context, userID int) ([]string, error) {
+	m.called("AdminRepository", "Roles", m.RolesFunc != nil)
+	return m.RolesFunc(ctx, userID)
+}
+
+func (m *AdminRepository) GrantRole(ctx context.Context, userID int, role string) error {
+	m.called("AdminRepository", "GrantRole", m.GrantRoleFunc != nil)
+	return m.GrantRoleFunc(ctx, userID, role)
+}
+
+func (m *AdminRepository) RevokeRole(ctx context.Context, userID int, role string) error {
+	m.called("AdminRepository", "RevokeRole", m.RevokeRoleFunc != nil)
+	return m.RevokeRoleFunc(ctx, userID, role)
+}
@@ -0,0 +1,69 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// GroupRepository mocks repository.GroupRepository
+type GroupRepository struct {
+	ListFunc             func(ctx context.Context) ([]repository.Group, error)
+	GetFunc              func(ctx context.Context, id int) (*repository.Group, error)
+	CreateFunc           func(ctx context.Context, name, description string) (*repository.Group, error)
+	UpdateFunc           func(ctx context.Context, id int, name, description string) (*repository.Group, error)
+	DeleteFunc           func(ctx context.Context, id int) error
+	AddMemberFunc        func(ctx context.Context, id, userID int) error
+	RemoveMemberFunc     func(ctx context.Context, id, userID int) error
+	GrantPermissionFunc  func(ctx context.Context, id int, permission string) error
+	RevokePermissionFunc func(ctx context.Context, id int, permission string) error
+
+	recorder
+}
+
+var _ repository.GroupRepository = (*GroupRepository)(nil)
+
+func (m *GroupRepository) List(ctx context.Context) ([]repository.Group, error) {
+	m.called("GroupRepository", "List", m.ListFunc != nil)
+	return m.ListFunc(ctx)
+}
+
+func (m *GroupRepository) Get(ctx context.Context, id int) (*repository.Group, error) {
+	m.called("GroupRepository", "Get", m.GetFunc != nil)
+	return m.GetFunc(ctx, id)
+}
+
+func (m *GroupRepository) Create(ctx context.Context, name, description string) (*repository.Group, error) {
+	m.called("GroupRepository", "Create", m.CreateFunc != nil)
+	return m.CreateFunc(ctx, name, description)
+}
+
+func (m *GroupRepository) Update(ctx context.Context, id int, name, description string) (*repository.Group, error) {
+	m.called("GroupRepository", "Update", m.UpdateFunc != nil)
+	return m.UpdateFunc(ctx, id, name, description)
+}
+
+func (m *GroupRepository) Delete(ctx context.Context, id int) error {
+	m.called("GroupRepository", "Delete", m.DeleteFunc != nil)
+	return m.DeleteFunc(ctx, id)
+}
+
+func (m *GroupRepository) AddMember(ctx context.Context, id, userID int) error {
+	m.called("GroupRepository", "AddMember", m.AddMemberFunc != nil)
+	return m.AddMemberFunc(ctx, id, userID)
+}
+
+func (m *GroupRepository) RemoveMember(ctx context.Context, id, userID int) error {
+	m.called("GroupRepository", "RemoveMember", m.RemoveMemberFunc != nil)
+	return m.RemoveMemberFunc(ctx, id, userID)
+}
+
+func (m *GroupRepository) GrantPermission(ctx context.Context, id int, permission string) error {
+	m.called("GroupRepository", "GrantPermission", m.GrantPermissionFunc != nil)
+	return m.GrantPermissionFunc(ctx, id, permission)
+}
+
+func (m *GroupRepository) RevokePermission(ctx context.Context, id int, permission string) error {
+	m.called("GroupRepository", "RevokePermission", m.RevokePermissionFunc != nil)
+	return m.RevokePermissionFunc(ctx, id, permission)
+}
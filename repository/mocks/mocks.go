@@ -0,0 +1,28 @@
+// Package mocks has stand-ins of the repository interfaces for handler tests. Each method runs the
+// func field of the same name and records the call, so a test sets only the funcs the code under
+// test should reach: a call to a method whose func is nil panics with the method name.
+package mocks
+
+import "sync"
+
+// recorder records the calls of a mock
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+// Calls returns the names of the methods called so far, in order
+func (r *recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func (r *recorder) called(mock, method string, set bool) {
+	r.mu.Lock()
+	r.calls = append(r.calls, method)
+	r.mu.Unlock()
+	if !set {
+		panic("mocks." + mock + ": unexpected call to " + method)
+	}
+}
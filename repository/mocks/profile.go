@@ -0,0 +1,39 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// ProfileRepository mocks repository.ProfileRepository
+type ProfileRepository struct {
+	FieldsFunc      func(ctx context.Context, userID int) ([]repository.ProfileField, error)
+	SetValuesFunc   func(ctx context.Context, userID int, values map[string]string) error
+	PutFieldFunc    func(ctx context.Context, key, label string, required bool) (*repository.ProfileField, error)
+	DeleteFieldFunc func(ctx context.Context, key string) error
+
+	recorder
+}
+
+var _ repository.ProfileRepository = (*ProfileRepository)(nil)
+
+func (m *ProfileRepository) Fields(ctx context.Context, userID int) ([]repository.ProfileField, error) {
+	m.called("ProfileRepository", "Fields", m.FieldsFunc != nil)
+	return m.FieldsFunc(ctx, userID)
+}
+
+func (m *ProfileRepository) SetValues(ctx context.Context, userID int, values map[string]string) error {
+	m.called("ProfileRepository", "SetValues", m.SetValuesFunc != nil)
+	return m.SetValuesFunc(ctx, userID, values)
+}
+
+func (m *ProfileRepository) PutField(ctx context.Context, key, label string, required bool) (*repository.ProfileField, error) {
+	m.called("ProfileRepository", "PutField", m.PutFieldFunc != nil)
+	return m.PutFieldFunc(ctx, key, label, required)
+}
+
+func (m *ProfileRepository) DeleteField(ctx context.Context, key string) error {
+	m.called("ProfileRepository", "DeleteField", m.DeleteFieldFunc != nil)
+	return m.DeleteFieldFunc(ctx, key)
+}
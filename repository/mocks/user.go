@@ -0,0 +1,75 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hi-im-yan/jwt-with-go/repository"
+)
+
+// UserRepository mocks repository.UserRepository
+type UserRepository struct {
+	ListFunc         func(ctx context.Context, tags []string) ([]repository.User, error)
+	GetFunc          func(ctx context.Context, id int) (*repository.User, error)
+	ExistsFunc       func(ctx context.Context, id int) (bool, error)
+	GroupsFunc       func(ctx context.Context, id int) ([]string, error)
+	CreateFunc       func(ctx context.Context, actorID int, name, email string) (*repository.User, error)
+	UpdateNameFunc   func(ctx context.Context, actorID, id int, name string) (*repository.User, error)
+	UpdateAvatarFunc func(ctx context.Context, actorID, id int, avatarURL string) (*repository.User, error)
+	DeleteFunc       func(ctx context.Context, actorID, id int) error
+	ExportFunc       func(ctx context.Context, fn func(*repository.User) error) error
+	HistoryFunc      func(ctx context.Context, id int) ([]repository.HistoryEntry, error)
+
+	recorder
+}
+
+var _ repository.UserRepository = (*UserRepository)(nil)
+
+func (m *UserRepository) List(ctx context.Context, tags []string) ([]repository.User, error) {
+	m.called("UserRepository", "List", m.ListFunc != nil)
+	return m.ListFunc(ctx, tags)
+}
+
+func (m *UserRepository) Get(ctx context.Context, id int) (*repository.User, error) {
+	m.called("UserRepository", "Get", m.GetFunc != nil)
+	return m.GetFunc(ctx, id)
+}
+
+func (m *UserRepository) Exists(ctx context.Context, id int) (bool, error) {
+	m.called("UserRepository", "Exists", m.ExistsFunc != nil)
+	return m.ExistsFunc(ctx, id)
+}
+
+func (m *UserRepository) Groups(ctx context.Context, id int) ([]string, error) {
+	m.called("UserRepository", "Groups", m.GroupsFunc != nil)
+	return m.GroupsFunc(ctx, id)
+}
+
+func (m *UserRepository) Create(ctx context.Context, actorID int, name, email string) (*repository.User, error) {
+	m.called("UserRepository", "Create", m.CreateFunc != nil)
+	return m.CreateFunc(ctx, actorID, name, email)
+}
+
+func (m *UserRepository) UpdateName(ctx context.Context, actorID, id int, name string) (*repository.User, error) {
+	m.called("UserRepository", "UpdateName", m.UpdateNameFunc != nil)
+	return m.UpdateNameFunc(ctx, actorID, id, name)
+}
+
+func (m *UserRepository) UpdateAvatar(ctx context.Context, actorID, id int, avatarURL string) (*repository.User, error) {
+	m.called("UserRepository", "UpdateAvatar", m.UpdateAvatarFunc != nil)
+	return m.UpdateAvatarFunc(ctx, actorID, id, avatarURL)
+}
+
+func (m *UserRepository) Delete(ctx context.Context, actorID, id int) error {
+	m.called("UserRepository", "Delete", m.DeleteFunc != nil)
+	return m.DeleteFunc(ctx, actorID, id)
+}
+
+func (m *UserRepository) Export(ctx context.Context, fn func(*repository.User) error) error {
+	m.called("UserRepository", "Export", m.ExportFunc != nil)
+	return m.ExportFunc(ctx, fn)
+}
+
+func (m *UserRepository) History(ctx context.Context, id int) ([]repository.HistoryEntry, error) {
+	m.called("UserRepository", "History", m.HistoryFunc != nil)
+	return m.HistoryFunc(ctx, id)
+}
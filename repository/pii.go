@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/hi-im-yan/jwt-with-go/pii"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PersonalHistoryKeys are the keys of the user_history values holding personal data, removed when
+// the account is erased or purged
+var PersonalHistoryKeys = []string{"name", "email", "avatar_url"}
+
+// EncryptEmails encrypts the emails still in clear in the users table, the change history, the invites,
+// the pending email changes and the outbox, for a database that ran without PII_ENCRYPTION_KEY before.
+// It returns the number of users encrypted. Without
+// a key it only makes sure no email is encrypted, since they couldn't be read.
+func EncryptEmails(ctx context.Context, db *pgxpool.Pool, key []byte) (int, error) {
+	if key == nil {
+		var encrypted bool
+		err := db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email LIKE $1);`, pii.Prefix+"%").Scan(&encrypted)
+		if err == nil && encrypted {
+			err = errors.New("the emails of the users table are encrypted: PII_ENCRYPTION_KEY is required")
+		}
+		return 0, err
+	}
+
+	var count int
+	err := WithTx(ctx, db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT id, email FROM users WHERE email NOT LIKE $1 FOR UPDATE;`, pii.Prefix+"%")
+		if err != nil {
+			return err
+		}
+		type clearEmail struct {
+			id    int
+			email string
+		}
+		emails, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (clearEmail, error) {
+			var e clearEmail
+			err := row.Scan(&e.id, &e.email)
+			return e, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range emails {
+			encrypted, err := pii.Encrypt(e.email, key)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `UPDATE users SET email = $2, email_lookup = $3 WHERE id = $1;`, e.id, encrypted, pii.Lookup(e.email, key))
+			if err != nil {
+				return err
+			}
+		}
+		count = len(emails)
+
+		columns := []struct{ table, column, lookupColumn string }{
+			{"invites", "email", "email_lookup"},
+			{"email_changes", "new_email", ""},
+			{"emails", "recipient", ""},
+			{"emails", "body", ""},
+			{"emails", "html", ""},
+		}
+		for _, c := range columns {
+			if err := encryptColumn(ctx, tx, key, c.table, c.column, c.lookupColumn); err != nil {
+				return err
+			}
+		}
+
+		// Including the entries just recorded by the updates above, which hold the emails in clear
+		return encryptHistoryEmails(ctx, tx, key)
+	})
+	return count, err
+}
+
+// encryptColumn encrypts the values of column still in clear, and stores their lookup value in
+// lookupColumn unless it is empty. The rows are found by their id column, or user_id for email_changes.
+func encryptColumn(ctx context.Context, tx pgx.Tx, key []byte, table, column, lookupColumn string) error {
+	id := "id"
+	if table == "email_changes" {
+		id = "user_id"
+	}
+	rows, err := tx.Query(ctx, `SELECT `+id+`, `+column+` FROM `+table+` WHERE `+column+` <> '' AND `+column+` NOT LIKE $1 FOR UPDATE;`, pii.Prefix+"%")
+	if err != nil {
+		return err
+	}
+	type clearValue struct {
+		id    int64
+		value string
+	}
+	values, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (clearValue, error) {
+		var v clearValue
+		err := row.Scan(&v.id, &v.value)
+		return v, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		encrypted, err := pii.Encrypt(v.value, key)
+		if err != nil {
+			return err
+		}
+		if lookupColumn == "" {
+			_, err = tx.Exec(ctx, `UPDATE `+table+` SET `+column+` = $2 WHERE `+id+` = $1;`, v.id, encrypted)
+		} else {
+			_, err = tx.Exec(ctx, `UPDATE `+table+` SET `+column+` = $2, `+lookupColumn+` = $3 WHERE `+id+` = $1;`, v.id, encrypted, pii.Lookup(v.value, key))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encryptHistoryEmails(ctx context.Context, tx pgx.Tx, key []byte) error {
+	query := `SELECT id, old_values, new_values FROM user_history
+		WHERE old_values->>'email' NOT LIKE $1 OR new_values->>'email' NOT LIKE $1;`
+	rows, err := tx.Query(ctx, query, pii.Prefix+"%")
+	if err != nil {
+		return err
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (HistoryEntry, error) {
+		var entry HistoryEntry
+		err := row.Scan(&entry.ID, &entry.OldValues, &entry.NewValues)
+		return entry, err
+	})
+	if err != nil {
+		return err
+	}
+
+	// One side of an entry can be encrypted already
+	encrypt := func(email string) (string, error) {
+		if pii.IsEncrypted(email) {
+			return email, nil
+		}
+		return pii.Encrypt(email, key)
+	}
+	for _, entry := range entries {
+		if entry.OldValues, err = mapHistoryEmail(entry.OldValues, encrypt); err != nil {
+			return err
+		}
+		if entry.NewValues, err = mapHistoryEmail(entry.NewValues, encrypt); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE user_history SET old_values = $2, new_values = $3 WHERE id = $1;`, entry.ID, entry.OldValues, entry.NewValues)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptHistoryEmail decrypts the email of the changed columns of a history entry
+func decryptHistoryEmail(values []byte, key []byte) ([]byte, error) {
+	return mapHistoryEmail(values, func(email string) (string, error) { return pii.Decrypt(email, key) })
+}
+
+// mapHistoryEmail replaces the email of the changed columns of a history entry by fn(email). The
+// other columns, and values without an email, are left as they are.
+func mapHistoryEmail(values []byte, fn func(email string) (string, error)) ([]byte, error) {
+	if values == nil {
+		return nil, nil
+	}
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal(values, &columns); err != nil {
+		return nil, err
+	}
+	var email string
+	if raw, ok := columns["email"]; !ok || json.Unmarshal(raw, &email) != nil {
+		return values, nil
+	}
+
+	email, err := fn(email)
+	if err != nil {
+		return nil, err
+	}
+	if columns["email"], err = json.Marshal(email); err != nil {
+		return nil, err
+	}
+	return json.Marshal(columns)
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ProfileField is a profile field an admin defined, with the value of a user when read for one
+type ProfileField struct {
+	Key      string
+	Label    string
+	Required bool
+	Value    string
+}
+
+// UnknownFieldError is returned by SetValues for a key that isn't a profile field
+type UnknownFieldError struct {
+	Key string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "repository: unknown profile field " + e.Key
+}
+
+// ProfileRepository reads and writes the profile fields and the values users give them
+type ProfileRepository interface {
+	// Fields returns every field with the values of the user, without values for user 0
+	Fields(ctx context.Context, userID int) ([]ProfileField, error)
+	// SetValues saves the values of the user by field key, a blank value clears the field. All the
+	// values are saved or none: an unknown key fails with an *UnknownFieldError.
+	SetValues(ctx context.Context, userID int, values map[string]string) error
+	// PutField creates the field or updates its label and required flag
+	PutField(ctx context.Context, key, label string, required bool) (*ProfileField, error)
+	// DeleteField deletes the field with every value of it, ErrNotFound when there is none
+	DeleteField(ctx context.Context, key string) error
+}
+
+// PgProfileRepository is the ProfileRepository of the Postgres database
+type PgProfileRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewProfileRepository(db *pgxpool.Pool) *PgProfileRepository {
+	return &PgProfileRepository{db: db}
+}
+
+func (repo *PgProfileRepository) Fields(ctx context.Context, userID int) ([]ProfileField, error) {
+	query := `SELECT f.key, f.label, f.required, COALESCE(v.value, '') FROM profile_fields f
+		LEFT JOIN user_profile_values v ON v.field_key = f.key AND v.user_id = $1
+		ORDER BY f.key;`
+	rows, err := repo.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []ProfileField{}
+	for rows.Next() {
+		var f ProfileField
+		if err := rows.Scan(&f.Key, &f.Label, &f.Required, &f.Value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+func (repo *PgProfileRepository) SetValues(ctx context.Context, userID int, values map[string]string) error {
+	return WithTx(ctx, repo.db, func(tx pgx.Tx) error {
+		for key, value := range values {
+			var err error
+			if strings.TrimSpace(value) == "" {
+				_, err = tx.Exec(ctx, `DELETE FROM user_profile_values WHERE user_id = $1 AND field_key = $2;`, userID, key)
+			} else {
+				// the foreign key rejects unknown fields
+				_, err = tx.Exec(ctx, `INSERT INTO user_profile_values (user_id, field_key, value) VALUES ($1, $2, $3)
+					ON CONFLICT (user_id, field_key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW();`, userID, key, value)
+			}
+			if errors.Is(Translate(err), ErrInvalidReference) {
+				return &UnknownFieldError{Key: key}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (repo *PgProfileRepository) PutField(ctx context.Context, key, label string, required bool) (*ProfileField, error) {
+	query := `INSERT INTO profile_fields (key, label, required) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET label = EXCLUDED.label, required = EXCLUDED.required
+		RETURNING key, label, required;`
+	f := &ProfileField{}
+	err := repo.db.QueryRow(ctx, query, key, label, required).Scan(&f.Key, &f.Label, &f.Required)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (repo *PgProfileRepository) DeleteField(ctx context.Context, key string) error {
+	return execOne(ctx, repo.db, `DELETE FROM profile_fields WHERE key = $1;`, key)
+}
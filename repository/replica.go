@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long reads stay on the primary once the replica failed
+const replicaRetryAfter = 30 * time.Second
+
+// ReadRouter sends read-only queries to a replica and falls back to the primary while the
+// replica is unavailable. Replicas lag a little behind the primary, so only reads that can
+// tolerate it should go through it.
+type ReadRouter struct {
+	Primary *pgxpool.Pool
+	// Replica is nil when there is none, everything then goes to Primary
+	Replica *pgxpool.Pool
+
+	downUntil atomic.Int64 // unix nanoseconds
+}
+
+// Read runs fn with the replica, or with the primary when there is no replica, it recently failed
+// or it fails now. fn can run twice, so it must not have side effects before its query returns.
+func (rr *ReadRouter) Read(ctx context.Context, fn func(db *pgxpool.Pool) error) error {
+	if rr.Replica == nil || time.Now().UnixNano() < rr.downUntil.Load() {
+		return fn(rr.Primary)
+	}
+	err := fn(rr.Replica)
+	if err == nil || !unavailable(err) || ctx.Err() != nil {
+		return err
+	}
+
+	log.Printf("[Repository:ReadRouter] Replica unavailable, reading from the primary for %v: %v", replicaRetryAfter, err)
+	rr.downUntil.Store(time.Now().Add(replicaRetryAfter).UnixNano())
+	return fn(rr.Primary)
+}
+
+// unavailable reports whether err comes from the database not being reachable or not accepting
+// queries, rather than from the query itself
+func unavailable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08: connection exception, class 57: operator intervention (shutting down, starting up...)
+		return pgErr.Code[:2] == "08" || pgErr.Code[:2] == "57"
+	}
+	return true
+}
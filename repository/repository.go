@@ -0,0 +1,54 @@
+// Package repository keeps the SQL of the API in one place. Handlers depend on the interfaces
+// (UserRepository...) so they can run against fakes; the pgx implementations live next to them.
+package repository
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Querier is what a pool and a transaction have in common, so helpers can run their queries
+// on their own or as part of a caller's transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+var (
+	_ Querier = (*pgxpool.Pool)(nil)
+	_ Querier = (pgx.Tx)(nil)
+)
+
+// WithTx runs fn in a transaction: it is committed when fn returns nil and rolled back when fn
+// returns an error or panics. Use it whenever several statements must succeed or fail together.
+func WithTx(ctx context.Context, db *pgxpool.Pool, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, db, fn)
+}
+
+// WithActor runs fn in a transaction tagged with the user making the change. The users_history
+// trigger records it as the actor of every change made to users in that transaction.
+func WithActor(ctx context.Context, db *pgxpool.Pool, actorID int, fn func(tx pgx.Tx) error) error {
+	return WithTx(ctx, db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT set_config('app.actor_id', $1, true);`, strconv.Itoa(actorID)); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// execOne runs a write that must change a row, ErrNotFound when it changed none
+func execOne(ctx context.Context, db Querier, query string, args ...interface{}) error {
+	tag, err := db.Exec(ctx, query, args...)
+	if err != nil {
+		return Translate(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/pii"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// User is a users row with its role names
+type User struct {
+	ID        int
+	Name      string
+	Email     string
+	Roles     []string
+	AvatarURL string
+	CreatedAt time.Time
+}
+
+// HistoryEntry is one change recorded by the users_history trigger. OldValues and NewValues
+// are JSON objects holding the changed columns only.
+type HistoryEntry struct {
+	ID        int64
+	ActorID   *int
+	Operation string
+	OldValues []byte
+	NewValues []byte
+	ChangedAt time.Time
+}
+
+// UserRepository reads and writes active (not soft deleted) users. Writes take the id of the
+// user making the change, for the change history.
+type UserRepository interface {
+	// List returns the users, only the ones having every tag when tags are given
+	List(ctx context.Context, tags []string) ([]User, error)
+	Get(ctx context.Context, id int) (*User, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	// Groups returns the names of the groups the user belongs to
+	Groups(ctx context.Context, id int) ([]string, error)
+	Create(ctx context.Context, actorID int, name, email string) (*User, error)
+	UpdateName(ctx context.Context, actorID, id int, name string) (*User, error)
+	UpdateAvatar(ctx context.Context, actorID, id int, avatarURL string) (*User, error)
+	// Delete soft deletes the user, the row is kept so EMAIL_REUSE_POLICY can be enforced
+	Delete(ctx context.Context, actorID, id int) error
+	// Export calls fn for every user as rows are read, without loading them all in memory
+	Export(ctx context.Context, fn func(*User) error) error
+	History(ctx context.Context, id int) ([]HistoryEntry, error)
+}
+
+// UserRolesColumn selects the role names of the user aliased "u" as a text array
+const UserRolesColumn = `ARRAY(SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id ORDER BY r.name)`
+
+const userColumns = `u.id, u.name, u.email, ` + UserRolesColumn + `, COALESCE(u.avatar_url, ''), u.created_at`
+
+// PgUserRepository is the UserRepository of the Postgres database. List and Get read from the
+// replica, when there is one; every other query goes to the primary.
+type PgUserRepository struct {
+	db    *pgxpool.Pool
+	reads *ReadRouter
+	// Key of the encrypted emails, nil when they are in clear
+	piiKey []byte
+}
+
+func NewUserRepository(db *pgxpool.Pool) *PgUserRepository {
+	return &PgUserRepository{db: db, reads: &ReadRouter{Primary: db}}
+}
+
+// WithReplica sends the reads of List and Get to replica, a nil replica keeps them on the primary
+func (repo *PgUserRepository) WithReplica(replica *pgxpool.Pool) *PgUserRepository {
+	repo.reads = &ReadRouter{Primary: repo.db, Replica: replica}
+	return repo
+}
+
+// WithPIIKey encrypts the emails written with key (PII_ENCRYPTION_KEY) and decrypts the ones read
+func (repo *PgUserRepository) WithPIIKey(key []byte) *PgUserRepository {
+	repo.piiKey = key
+	return repo
+}
+
+func (repo *PgUserRepository) scanUser(row pgx.Row) (*User, error) {
+	u := &User{}
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Roles, &u.AvatarURL, &u.CreatedAt)
+	if err != nil {
+		return nil, Translate(err)
+	}
+	if u.Email, err = pii.Decrypt(u.Email, repo.piiKey); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (repo *PgUserRepository) List(ctx context.Context, tags []string) ([]User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.deleted_at IS NULL`
+	var args []interface{}
+	if len(tags) > 0 {
+		query += ` AND u.id IN (SELECT user_id FROM user_tags WHERE tag = ANY($1) GROUP BY user_id HAVING COUNT(*) = $2)`
+		args = append(args, tags, len(tags))
+	}
+
+	var users []User
+	err := repo.reads.Read(ctx, func(db *pgxpool.Pool) error {
+		rows, err := db.Query(ctx, query+` ORDER BY u.id;`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users = nil
+		for rows.Next() {
+			u, err := repo.scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, *u)
+		}
+		return rows.Err()
+	})
+	return users, err
+}
+
+func (repo *PgUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	var u *User
+	err := repo.reads.Read(ctx, func(db *pgxpool.Pool) (err error) {
+		u, err = repo.scanUser(db.QueryRow(ctx, `SELECT `+userColumns+` FROM users u WHERE u.id = $1 AND u.deleted_at IS NULL;`, id))
+		return err
+	})
+	return u, err
+}
+
+func (repo *PgUserRepository) Exists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := repo.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL);`, id).Scan(&exists)
+	return exists, err
+}
+
+func (repo *PgUserRepository) Groups(ctx context.Context, id int) ([]string, error) {
+	var groups []string
+	query := `SELECT ARRAY(SELECT g.name FROM group_members gm JOIN groups g ON g.id = gm.group_id WHERE gm.user_id = $1 ORDER BY g.name);`
+	err := repo.db.QueryRow(ctx, query, id).Scan(&groups)
+	return groups, err
+}
+
+func (repo *PgUserRepository) Create(ctx context.Context, actorID int, name, email string) (*User, error) {
+	encrypted, err := pii.Encrypt(email, repo.piiKey)
+	if err != nil {
+		return nil, err
+	}
+	var u *User
+	err = WithActor(ctx, repo.db, actorID, func(tx pgx.Tx) (err error) {
+		u, err = repo.scanUser(tx.QueryRow(ctx, `WITH u AS (
+				INSERT INTO users (name, email, email_lookup) VALUES ($1, $2, $3) RETURNING *
+			)
+			SELECT `+userColumns+` FROM u;`, name, encrypted, pii.Lookup(email, repo.piiKey)))
+		return err
+	})
+	return u, Translate(err)
+}
+
+func (repo *PgUserRepository) UpdateName(ctx context.Context, actorID, id int, name string) (*User, error) {
+	return repo.update(ctx, actorID, `UPDATE users u SET name = $2 WHERE u.id = $1 AND u.deleted_at IS NULL RETURNING `+userColumns+`;`, id, name)
+}
+
+func (repo *PgUserRepository) UpdateAvatar(ctx context.Context, actorID, id int, avatarURL string) (*User, error) {
+	return repo.update(ctx, actorID, `UPDATE users u SET avatar_url = $2 WHERE u.id = $1 AND u.deleted_at IS NULL RETURNING `+userColumns+`;`, id, avatarURL)
+}
+
+func (repo *PgUserRepository) update(ctx context.Context, actorID int, query string, args ...interface{}) (*User, error) {
+	var u *User
+	err := WithActor(ctx, repo.db, actorID, func(tx pgx.Tx) (err error) {
+		u, err = repo.scanUser(tx.QueryRow(ctx, query, args...))
+		return err
+	})
+	return u, Translate(err)
+}
+
+func (repo *PgUserRepository) Delete(ctx context.Context, actorID, id int) error {
+	var tag pgconn.CommandTag
+	err := WithActor(ctx, repo.db, actorID, func(tx pgx.Tx) (err error) {
+		tag, err = tx.Exec(ctx, `UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL;`, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PgUserRepository) Export(ctx context.Context, fn func(*User) error) error {
+	rows, err := repo.db.Query(ctx, `SELECT `+userColumns+` FROM users u WHERE u.deleted_at IS NULL ORDER BY u.id;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := repo.scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (repo *PgUserRepository) History(ctx context.Context, id int) ([]HistoryEntry, error) {
+	query := `SELECT id, actor_id, operation, old_values, new_values, changed_at FROM user_history
+		WHERE user_id = $1 ORDER BY changed_at DESC, id DESC;`
+	rows, err := repo.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Operation, &entry.OldValues, &entry.NewValues, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		if entry.OldValues, err = decryptHistoryEmail(entry.OldValues, repo.piiKey); err != nil {
+			return nil, err
+		}
+		if entry.NewValues, err = decryptHistoryEmail(entry.NewValues, repo.piiKey); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/sigv4"
+)
+
+// AWS reads a secret of AWS Secrets Manager with a GetSecretValue call. The secret string must be
+// a JSON object, which is what the console stores for key/value secrets.
+type AWS struct {
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	SecretID     string
+	client       *http.Client
+}
+
+func (a *AWS) Fetch(ctx context.Context) (map[string]string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	if err != nil {
+		return nil, err
+	}
+	url := "https://secretsmanager." + a.Region + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds := sigv4.Credentials{AccessKey: a.AccessKey, SecretKey: a.SecretKey, SessionToken: a.SessionToken}
+	sigv4.Sign(req, payload, creds, a.Region, "secretsmanager", time.Now().UTC())
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("secrets: aws %s: %s: %s", a.SecretID, res.Status, body)
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("secrets: aws %s: %w", a.SecretID, err)
+	}
+	if secret.SecretString == nil {
+		return nil, fmt.Errorf("secrets: aws %s: binary secrets are not supported", a.SecretID)
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(*secret.SecretString), &values); err != nil {
+		return nil, fmt.Errorf("secrets: aws %s: the secret must be a JSON object of settings: %w", a.SecretID, err)
+	}
+	return settings(values), nil
+}
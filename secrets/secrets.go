@@ -0,0 +1,50 @@
+// Package secrets fetches settings like JWT_SECRET, DB_PASSWORD or SMTP_PASSWORD from a secrets manager
+// at startup, so they don't have to be written in the config file. A secret holds a JSON object whose
+// keys are the environment variables it provides, e.g. {"JWT_SECRET": "...", "DB_PASSWORD": "..."}.
+// Backends: HashiCorp Vault (KV engine) and AWS Secrets Manager.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/config"
+)
+
+type Provider interface {
+	// Fetch returns the settings held by the secret, by environment variable name
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// New returns the provider selected by cfg.Provider, nil for none
+func New(cfg config.Secrets) Provider {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch cfg.Provider {
+	case "vault":
+		return &Vault{Addr: cfg.VaultAddr, Token: cfg.VaultToken, Path: cfg.VaultPath, client: client}
+	case "aws":
+		return &AWS{
+			Region:       cfg.AWSRegion,
+			AccessKey:    cfg.AWSAccessKeyID,
+			SecretKey:    cfg.AWSSecretKey,
+			SessionToken: cfg.AWSSessionToken,
+			SecretID:     cfg.AWSSecretID,
+			client:       client,
+		}
+	}
+	return nil
+}
+
+// settings turns the values of a secret into environment variable values
+func settings(values map[string]interface{}) map[string]string {
+	env := make(map[string]string, len(values))
+	for key, value := range values {
+		if value == nil {
+			continue
+		}
+		env[key] = fmt.Sprint(value)
+	}
+	return env
+}
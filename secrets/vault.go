@@ -0,0 +1,62 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Vault reads a secret of a KV engine with a token. Both versions of the engine are supported:
+// for version 2 the path includes data/, like secret/data/jwt-with-go.
+type Vault struct {
+	Addr   string
+	Token  string
+	Path   string
+	client *http.Client
+}
+
+func (v *Vault) Fetch(ctx context.Context) (map[string]string, error) {
+	url := strings.TrimRight(v.Addr, "/") + "/v1/" + strings.TrimLeft(v.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("secrets: vault %s: %s: %s", v.Path, res.Status, body)
+	}
+
+	// KV version 1 answers {"data": {...}}, version 2 {"data": {"data": {...}, "metadata": {...}}}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("secrets: vault %s: %w", v.Path, err)
+	}
+	raw := secret.Data
+	if inner, ok := raw["data"]; ok && raw["metadata"] != nil {
+		if err := json.Unmarshal(inner, &raw); err != nil {
+			return nil, fmt.Errorf("secrets: vault %s: %w", v.Path, err)
+		}
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return nil, fmt.Errorf("secrets: vault %s: %s: %w", v.Path, key, err)
+		}
+		values[key] = decoded
+	}
+	return settings(values), nil
+}
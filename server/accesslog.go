@@ -0,0 +1,32 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/hi-im-yan/jwt-with-go/accesslog"
+	"github.com/hi-im-yan/jwt-with-go/config"
+)
+
+// newAccessLog picks the access log middleware from ACCESS_LOG ("json" by default, "text" or "off").
+// The returned closer is the rotated file of ACCESS_LOG_FILE, nil on stdout.
+func newAccessLog(cfg *config.Config) (func(http.Handler) http.Handler, io.Closer, error) {
+	switch cfg.AccessLog.Format {
+	case "text":
+		return middleware.Logger, nil, nil
+	case "off":
+		return func(next http.Handler) http.Handler { return next }, nil, nil
+	}
+	if cfg.AccessLog.File == "" {
+		return accesslog.Middleware(os.Stdout), nil, nil
+	}
+	file, err := accesslog.OpenFile(cfg.AccessLog.File, cfg.AccessLog.MaxSize, cfg.AccessLog.MaxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("[Server:newAccessLog] Writing the access log to %s", cfg.AccessLog.File)
+	return accesslog.Middleware(file), file, nil
+}
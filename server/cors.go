@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/cors"
+	"github.com/hi-im-yan/jwt-with-go/config"
+)
+
+// reloadableCORS is the CORS middleware with options that can be swapped by a configuration reload.
+// CORS is off (requests pass through) while no origin is allowed.
+type reloadableCORS struct {
+	current atomic.Pointer[cors.Cors]
+}
+
+func newReloadableCORS(cfg config.CORS) *reloadableCORS {
+	c := &reloadableCORS{}
+	c.set(cfg)
+	return c
+}
+
+func (c *reloadableCORS) set(cfg config.CORS) {
+	if len(cfg.AllowedOrigins) == 0 {
+		c.current.Store(nil)
+		return
+	}
+	c.current.Store(cors.New(cors.Options{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		ExposedHeaders:   cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	}))
+}
+
+func (c *reloadableCORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if current := c.current.Load(); current != nil {
+			current.Handler(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/alerts"
+	"github.com/hi-im-yan/jwt-with-go/broker"
+	"github.com/hi-im-yan/jwt-with-go/buildinfo"
+	"github.com/hi-im-yan/jwt-with-go/cache"
+	"github.com/hi-im-yan/jwt-with-go/captcha"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/errreport"
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/ldap"
+	"github.com/hi-im-yan/jwt-with-go/lockout"
+	"github.com/hi-im-yan/jwt-with-go/mailer"
+	"github.com/hi-im-yan/jwt-with-go/realtime"
+	"github.com/hi-im-yan/jwt-with-go/repository"
+	"github.com/hi-im-yan/jwt-with-go/storage"
+	"github.com/hi-im-yan/jwt-with-go/webhooks"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Deps are the implementations the handlers are built with. NewDeps picks them from the config;
+// tests and other entry points can build their own, or replace single fields, before NewServer.
+// replica is the pool of the read replica, nil when DB_REPLICA_URL is not set.
+type Deps struct {
+	Users    repository.UserRepository
+	Accounts repository.AccountRepository
+	Groups   repository.GroupRepository
+	Profiles repository.ProfileRepository
+	Admin    repository.AdminRepository
+	Verifier handlers.CredentialVerifier
+	Avatars  storage.Storage
+	// Mailer queues the emails in Outbox, which sends them through the backend of MAILER
+	Mailer mailer.Mailer
+	Outbox *mailer.Outbox
+	// Jobs queues background jobs, its workers are run by Server.Start
+	Jobs *jobs.Queue
+	// Events receives the domain events published by the handlers
+	Events *events.Bus
+	// Webhooks delivers the user lifecycle events through Jobs, it is subscribed to Events
+	Webhooks *webhooks.Dispatcher
+	// Realtime pushes the user lifecycle events to the /ws/admin connections, it is subscribed to Events
+	Realtime *realtime.Hub
+	// UserChanged drops the cached lookups of a user changed outside of Users, nil without a cache
+	UserChanged handlers.UserChanged
+	// Lockout counts the failed logins, nil turns the lockout off
+	Lockout *lockout.Guard
+	// Captcha checks the CAPTCHA of registrations and logins, nil while CAPTCHA_PROVIDER is none
+	Captcha *captcha.Verifier
+	// Alerts warns of suspicious activity through Jobs, it is subscribed to Events. Nil without a destination
+	Alerts *alerts.Alerter
+	// Errors reports the 5xx responses and panics to an error tracker, nil without ERROR_REPORTING_DSN
+	Errors *errreport.Reporter
+}
+
+func NewDeps(cfg *config.Config, db, replica *pgxpool.Pool) (*Deps, error) {
+	deps := &Deps{
+		Users:    repository.NewUserRepository(db).WithReplica(replica).WithPIIKey(cfg.PIIEncryptionKey),
+		Accounts: repository.NewAccountRepository(db).WithPIIKey(cfg.PIIEncryptionKey),
+		Groups:   repository.NewGroupRepository(db),
+		Profiles: repository.NewProfileRepository(db),
+		Admin:    repository.NewAdminRepository(db),
+		Verifier: newCredentialVerifier(cfg, db),
+		Avatars:  newAvatarStorage(cfg),
+		Events:   events.NewBus(),
+		Jobs: jobs.NewQueue(db, jobs.Config{
+			Workers:      cfg.Jobs.Workers,
+			PollInterval: cfg.Jobs.PollInterval,
+			MaxAttempts:  cfg.Jobs.MaxAttempts,
+			Backoff:      cfg.Jobs.Backoff,
+			MaxBackoff:   cfg.Jobs.MaxBackoff,
+			LockTimeout:  cfg.Jobs.LockTimeout,
+		}),
+	}
+	deps.Outbox = mailer.NewOutbox(db, deps.Jobs, newMailer(cfg), cfg.PIIEncryptionKey, cfg.Jobs.MaxAttempts)
+	deps.Mailer = deps.Outbox
+	deps.Events.SubscribeAll(events.Log)
+	deps.Webhooks = webhooks.NewDispatcher(db, deps.Jobs, cfg.WebhookTimeout)
+	deps.Webhooks.Subscribe(deps.Events)
+	deps.Realtime = realtime.NewHub()
+	deps.Realtime.Subscribe(deps.Events)
+	pub, err := newBrokerPublisher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pub != nil {
+		broker.NewForwarder(pub, deps.Jobs, cfg.Broker.TopicPrefix).Subscribe(deps.Events)
+	}
+
+	if deps.Lockout, err = newLockout(cfg); err != nil {
+		return nil, err
+	}
+	deps.Events.Subscribe(handlers.LockoutMailer(cfg, db, deps.Mailer), events.LoginLocked)
+	deps.Captcha = newCaptcha(cfg)
+	if deps.Errors, err = errreport.New(cfg.ErrorReporting.DSN, cfg.ErrorReporting.Environment, buildinfo.Release(), cfg.ErrorReporting.Timeout); err != nil {
+		return nil, err
+	}
+	if cfg.Alerts.Enabled() {
+		deps.Alerts = alerts.New(db, deps.Jobs, deps.Mailer, alerts.Config{
+			WebhookURL:         cfg.Alerts.WebhookURL,
+			SlackWebhookURL:    cfg.Alerts.SlackWebhookURL,
+			Email:              cfg.Alerts.Email,
+			AdminLoginFailures: cfg.Alerts.AdminLoginFailures,
+			TokenFailures:      cfg.Alerts.TokenFailures,
+			Window:             cfg.Alerts.Window,
+			Timeout:            cfg.WebhookTimeout,
+			PIIKey:             cfg.PIIEncryptionKey,
+		})
+		deps.Alerts.Subscribe(deps.Events)
+		handlers.TokenRejected = deps.Alerts.TokenRejected
+	}
+
+	c, err := newCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		cached := repository.NewCachedUserRepository(deps.Users, c, cfg.UserCacheTTL, cfg.PIIEncryptionKey)
+		deps.Users = cached
+		deps.UserChanged = cached.Invalidate
+		if lv, ok := deps.Verifier.(*handlers.LDAPVerifier); ok {
+			lv.UserChanged = cached.Invalidate
+		}
+	}
+	return deps, nil
+}
+
+// newCache picks the cache of user lookups from CACHE_BACKEND ("none" by default, "memory" or "redis")
+func newCache(cfg *config.Config) (cache.Cache, error) {
+	switch cfg.CacheBackend {
+	case "memory":
+		log.Printf("[Server:newCache] Caching user lookups in memory for %v", cfg.UserCacheTTL)
+		return cache.NewLRU(cfg.CacheMaxEntries), nil
+	case "redis":
+		log.Printf("[Server:newCache] Caching user lookups in Redis for %v", cfg.UserCacheTTL)
+		return cache.NewRedis(cfg.RedisURL, "jwtapi:")
+	}
+	return nil, nil
+}
+
+// newLockout picks where the failed logins are counted from LOCKOUT_BACKEND ("memory" by default or "redis")
+func newLockout(cfg *config.Config) (*lockout.Guard, error) {
+	var store lockout.Store
+	if cfg.Lockout.Backend == "redis" {
+		log.Printf("[Server:newLockout] Counting failed logins in Redis")
+		redisStore, err := lockout.NewRedis(cfg.RedisURL, "jwtapi:lockout:")
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	} else {
+		store = lockout.NewMemory()
+	}
+	policy := lockout.Policy{Window: cfg.Lockout.Window, Delay: cfg.Lockout.Delay, MaxDelay: cfg.Lockout.MaxDelay}
+	account, address := policy, policy
+	account.MaxFailures, address.MaxFailures = cfg.Lockout.AccountMaxFailures, cfg.Lockout.IPMaxFailures
+	return lockout.NewGuard(store, account, address), nil
+}
+
+// newCaptcha returns the verifier of CAPTCHA_PROVIDER, nil for none
+func newCaptcha(cfg *config.Config) *captcha.Verifier {
+	if cfg.Captcha.Provider == "none" {
+		return nil
+	}
+	verifyURL := cfg.Captcha.VerifyURL
+	if verifyURL == "" {
+		verifyURL = captcha.VerifyURLs[cfg.Captcha.Provider]
+	}
+	log.Printf("[Server:newCaptcha] Checking CAPTCHA tokens with %s", verifyURL)
+	return captcha.New(verifyURL, cfg.Captcha.Secret, 10*time.Second)
+}
+
+// newMailer picks how the emails are sent from MAILER ("log" by default, "smtp" or "none")
+func newMailer(cfg *config.Config) mailer.Mailer {
+	switch cfg.Mailer.Backend {
+	case "smtp":
+		log.Printf("[Server:newMailer] Sending emails through %s:%s", cfg.Mailer.SMTPHost, cfg.Mailer.SMTPPort)
+		return &mailer.SMTP{
+			Host:     cfg.Mailer.SMTPHost,
+			Port:     cfg.Mailer.SMTPPort,
+			Username: cfg.Mailer.SMTPUsername,
+			Password: cfg.Mailer.SMTPPassword,
+			From:     cfg.Mailer.SMTPFrom,
+			Security: cfg.Mailer.SMTPSecurity,
+			Timeout:  cfg.Mailer.Timeout,
+		}
+	case "none":
+		return mailer.NopMailer{}
+	}
+	return mailer.LogMailer{}
+}
+
+// newBrokerPublisher picks the broker the events are forwarded to from EVENTS_BROKER ("none" by default, "nats" or "kafka")
+func newBrokerPublisher(cfg *config.Config) (broker.Publisher, error) {
+	switch cfg.Broker.Backend {
+	case "nats":
+		log.Printf("[Server:newBrokerPublisher] Forwarding events to NATS subjects %s*", cfg.Broker.TopicPrefix)
+		return broker.NewNATS(cfg.Broker.NATSURL, cfg.Broker.Timeout)
+	case "kafka":
+		log.Printf("[Server:newBrokerPublisher] Forwarding events to Kafka topics %s*", cfg.Broker.TopicPrefix)
+		return broker.NewKafka(cfg.Broker.KafkaRESTURL, cfg.Broker.Timeout)
+	}
+	return nil, nil
+}
+
+// newCredentialVerifier picks the login backend from AUTH_BACKEND ("local" by default or "ldap")
+func newCredentialVerifier(cfg *config.Config, db *pgxpool.Pool) handlers.CredentialVerifier {
+	if cfg.AuthBackend != "ldap" {
+		return &handlers.LocalVerifier{DB: db, PIIKey: cfg.PIIEncryptionKey}
+	}
+
+	log.Printf("[Server:newCredentialVerifier] Using LDAP authentication against %s", cfg.LDAP.URL)
+	return &handlers.LDAPVerifier{
+		DB: db,
+		LDAP: ldap.NewAuthenticator(ldap.Config{
+			URL:           cfg.LDAP.URL,
+			BindDN:        cfg.LDAP.BindDN,
+			BindPassword:  cfg.LDAP.BindPassword,
+			BaseDN:        cfg.LDAP.BaseDN,
+			UserAttribute: cfg.LDAP.UserAttribute,
+			NameAttribute: cfg.LDAP.NameAttribute,
+		}),
+		AdminGroup:       cfg.LDAP.AdminGroup,
+		EmailReusePolicy: cfg.EmailReusePolicy,
+		PIIKey:           cfg.PIIEncryptionKey,
+	}
+}
+
+// newAvatarStorage picks where avatars are stored from AVATAR_STORAGE ("local" by default or "s3").
+// Local files are only downloaded through signed links.
+func newAvatarStorage(cfg *config.Config) storage.Storage {
+	if cfg.AvatarStorage == "s3" {
+		log.Printf("[Server:newAvatarStorage] Storing avatars in S3 bucket %s", cfg.S3.Bucket)
+		return storage.NewS3(storage.S3Config{
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Endpoint:  cfg.S3.Endpoint,
+			PublicURL: cfg.S3.PublicURL,
+		})
+	}
+	return storage.NewLocal(cfg.AvatarLocalDir)
+}
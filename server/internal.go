@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newInternalRouter returns the routes of the INTERNAL_ADDR listener, for operators and scrapers only:
+// the Prometheus metrics, the pprof profiles and the admin maintenance routes, without authentication.
+func newInternalRouter(adh *handlers.AdminHandler, accessLog func(http.Handler) http.Handler) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Use(handlers.RequestIDMiddleware)
+	r.Use(accessLog)
+	r.Use(handlers.RecovererMiddleware)
+
+	r.Handle("GET /metrics", metrics.Handler())
+	r.Mount("/debug", middleware.Profiler())
+	r.Mount("/admin", adh.InternalRouter())
+
+	return r
+}
+
+// newInternalServer serves router on INTERNAL_ADDR. CPU profiles and traces stream for as long as
+// requested, so writes have no deadline.
+func (s *Server) newInternalServer(router http.Handler) *http.Server {
+	srv := s.newHTTPServer(s.Config.InternalAddr, router)
+	srv.WriteTimeout = 0
+	return srv
+}
+
+// newPublicProfiler returns the pprof profiles for the public listener (PPROF_ENABLED without INTERNAL_ADDR),
+// only served to the users holding debug:pprof. The write deadline of the server is lifted for them,
+// like on the internal listener.
+func newPublicProfiler(cfg *config.Config, db *pgxpool.Pool) http.Handler {
+	r := chi.NewRouter()
+	r.Use(handlers.MiddlewareAdapter(handlers.JWTAuthMiddleware(db, cfg.JWT)), handlers.MiddlewareAdapter(handlers.RequirePermission(rbac.DebugPprof)))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				log.Printf("[Server:newPublicProfiler] Error lifting the write deadline: %v", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Mount("/", middleware.Profiler())
+	return r
+}
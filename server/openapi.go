@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hi-im-yan/jwt-with-go/buildinfo"
+	"github.com/swaggo/swag"
+)
+
+// openAPIHandler serves the spec generated by swag (docs/swagger.json) with the address it is served
+// from and the version of the binary, instead of the localhost defaults of the annotations. The address
+// comes from publicBaseURL when set, from the request otherwise.
+func openAPIHandler(publicBaseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registered, ok := swag.GetSwagger(swag.Name).(*swag.Spec)
+		if !ok {
+			http.Error(w, "OpenAPI document not available", http.StatusNotFound)
+			return
+		}
+
+		spec := *registered
+		spec.Host, spec.BasePath, spec.Schemes = r.Host, "/", []string{"http"}
+		if r.TLS != nil {
+			spec.Schemes = []string{"https"}
+		}
+		if u, err := url.Parse(publicBaseURL); err == nil && u.Host != "" {
+			spec.Host, spec.Schemes = u.Host, []string{u.Scheme}
+			if u.Path != "" {
+				spec.BasePath = "/" + strings.Trim(u.Path, "/")
+			}
+		}
+		if version := buildinfo.Release(); version != "" {
+			spec.Version = version
+		}
+
+		doc := spec.ReadDoc()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if _, err := w.Write([]byte(doc)); err != nil {
+			log.Printf("[Server:openAPIHandler] Error writing the OpenAPI document: %v", err)
+		}
+	}
+}
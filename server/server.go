@@ -1,49 +1,454 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"github.com/hi-im-yan/jwt-with-go/config"
+	"github.com/hi-im-yan/jwt-with-go/errreport"
+	"github.com/hi-im-yan/jwt-with-go/graph"
+	"github.com/hi-im-yan/jwt-with-go/grpcapi"
 	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/janitor"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/hi-im-yan/jwt-with-go/lockout"
+	"github.com/hi-im-yan/jwt-with-go/metrics"
+	"github.com/hi-im-yan/jwt-with-go/realtime"
+	"github.com/hi-im-yan/jwt-with-go/servertiming"
+	"github.com/hi-im-yan/jwt-with-go/static"
 	"github.com/jackc/pgx/v5/pgxpool"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	Port   string
 	Router *chi.Mux
 	DB     *pgxpool.Pool
+	Config *config.Config
+	// Reloader reads the configuration again, for ReloadConfig
+	Reloader func() (*config.Config, error)
+
+	// What a reload can change
+	reloadMu      sync.Mutex
+	limiter       *handlers.RateLimiter
+	publicLimiter *handlers.RateLimiter
+	authHandler   *handlers.AuthenticationHandler
+	cors          *reloadableCORS
+	maintenance   *handlers.Maintenance
+	// MAINTENANCE_MODE and MAINTENANCE_MESSAGE of the last load, a reload only applies them when they changed
+	maintenanceMode    bool
+	maintenanceMessage string
+
+	jobs    *jobs.Queue
+	janitor *janitor.Janitor
+	// Set when GRPC_ADDR is
+	grpc *grpc.Server
+	// Set when INTERNAL_ADDR is
+	internal *chi.Mux
+	// The file of ACCESS_LOG_FILE, closed once stopped
+	accessLogFile io.Closer
+	realtime      *realtime.Hub
+	// Stopped once the requests are done
+	lockout *lockout.Guard
 }
 
-func NewServer(port string, db *pgxpool.Pool) *Server {
+// NewServer registers the middlewares and routes, with handlers built from deps
+func NewServer(cfg *config.Config, db *pgxpool.Pool, deps *Deps) (*Server, error) {
 	s := &Server{
-		Port:   port,
-		Router: chi.NewRouter(),
-		DB:     db,
+		Port:     cfg.Port,
+		Router:   chi.NewRouter(),
+		DB:       db,
+		Config:   cfg,
+		jobs:     deps.Jobs,
+		realtime: deps.Realtime,
+		lockout:  deps.Lockout,
+		janitor:  janitor.New(db, janitor.DefaultTasks(cfg.JanitorInterval, cfg.JanitorRetention, cfg.IdempotencyKeyTTL)...),
 	}
 
-	s.Router.Use(middleware.Logger)
-	s.Router.Use(middleware.Recoverer)
+	accessLog, accessLogFile, err := newAccessLog(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.accessLogFile = accessLogFile
+
+	if cfg.DeletedUserRetention > 0 && cfg.EmailReusePolicy != handlers.EmailReuseNever {
+		s.janitor.Add(janitor.PurgeDeletedUsers(cfg.JanitorInterval, cfg.DeletedUserRetention, deps.UserChanged))
+	}
 
-	// Index Routes
-	ih := handlers.NewIndexHandler()
-	s.Router.HandleFunc("GET /", handlers.ApiHandlerAdapter(ih.HealthCheck))
+	s.Router.Use(handlers.RequestIDMiddleware)
+	s.Router.Use(handlers.VersionHeaderMiddleware)
+	// Early, so the errors of the middlewares below are problems too
+	if cfg.ErrorFormat == "problem" {
+		s.Router.Use(handlers.ProblemDetailsMiddleware(cfg.ErrorTypeBaseURL))
+	}
+	s.Router.Use(handlers.RealIPMiddleware(cfg.TrustedProxies))
+	s.Router.Use(accessLog)
+	s.Router.Use(metrics.Middleware)
+	s.Router.Use(errreport.Middleware(deps.Errors))
+	s.Router.Use(handlers.RecovererMiddleware)
+	// It would cut the event stream after QUERY_TIMEOUT, the stream bounds its periodic token checks itself.
+	// CPU profiles and traces last as long as they were asked to.
+	s.Router.Use(exceptPaths(handlers.QueryTimeoutMiddleware(cfg.QueryTimeout), "/events/stream", "/debug/pprof/profile", "/debug/pprof/trace"))
+	if cfg.LogBodies {
+		s.Router.Use(handlers.BodyLoggingMiddleware)
+	}
+	s.Router.Use(handlers.BodyLimitMiddleware(cfg.MaxBodyBytes))
+	// Before the rate limits, so preflight requests are answered without spending tokens.
+	// Both are always installed, a reload can turn them on.
+	s.cors = newReloadableCORS(cfg.CORS)
+	s.Router.Use(s.cors.Handler)
+	// The probes keep answering so the instance isn't restarted, and the mode must be possible to turn off
+	s.maintenance = handlers.NewMaintenance(cfg.MaintenanceMode, cfg.MaintenanceMessage)
+	s.maintenanceMode, s.maintenanceMessage = cfg.MaintenanceMode, cfg.MaintenanceMessage
+	// Login and refresh stay open so an admin can get a token to turn the mode off
+	s.Router.Use(exceptPaths(handlers.MiddlewareAdapter(handlers.MaintenanceMiddleware(s.maintenance)), "/", "/healthz", "/readyz", "/metrics", "/admin/maintenance", "/auth/login", "/auth/refresh"))
+	s.limiter = handlers.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	s.Router.Use(exceptPaths(handlers.MiddlewareAdapter(handlers.RateLimitMiddleware(s.limiter)), "/healthz", "/readyz"))
+	if cfg.ServerTimingEnabled {
+		s.Router.Use(servertiming.Middleware)
+	}
+	if cfg.ResponseEnvelope {
+		s.Router.Use(handlers.ResponseEnvelopeMiddleware)
+	}
 
-	// Swagger Route
-	s.Router.HandleFunc("GET /swagger/*", httpSwagger.WrapHandler)
+	// Public Routes
+	// Anything registered in this group is reachable without a JWT, so only read-only
+	// endpoints belong here. The group has its own per-IP rate limit.
+	ih := handlers.NewIndexHandler(s.DB)
+	ph := handlers.NewPublicHandler(cfg, s.DB)
+	s.publicLimiter = handlers.NewRateLimiter(cfg.PublicRateLimitRPS, cfg.PublicRateLimitBurst)
+	s.Router.Group(func(r chi.Router) {
+		r.Use(handlers.MiddlewareAdapter(handlers.RateLimitMiddleware(s.publicLimiter)))
+
+		r.HandleFunc("GET /", handlers.ApiHandlerAdapter(ih.HealthCheck))
+		r.HandleFunc("GET /version", handlers.ApiHandlerAdapter(ih.Version))
+		r.HandleFunc("GET /email-availability", handlers.ApiHandlerAdapter(ph.EmailAvailability))
+		r.HandleFunc("GET /events/schemas/{type}", handlers.ApiHandlerAdapter(ph.EventSchema))
+	})
+
+	// Probe Routes
+	// Kept out of the public group so orchestrator probes are never rate limited
+	s.Router.HandleFunc("GET /healthz", handlers.ApiHandlerAdapter(ih.Liveness))
+	s.Router.HandleFunc("GET /readyz", handlers.ApiHandlerAdapter(ih.Readiness))
+
+	// Metrics Route
+	// Moved to the internal listener when there is one
+	metrics.StartBusinessMetrics(s.DB, cfg.BusinessMetricsInterval)
+	metrics.RegisterPoolMetrics(s.DB)
+	metrics.LogPoolStats(s.DB, cfg.DB.Pool.StatsLogInterval)
+	if cfg.InternalAddr == "" {
+		s.Router.Handle("GET /metrics", metrics.Handler())
+	}
+
+	// Swagger Routes
+	// The UI loads the spec from /openapi.json, so its "Try it out" calls this server
+	s.Router.HandleFunc("GET /openapi.json", openAPIHandler(cfg.PublicBaseURL))
+	s.Router.HandleFunc("GET /swagger/*", httpSwagger.Handler(httpSwagger.URL("/openapi.json")))
 
 	// Authentication Routes
-	ah := handlers.NewAuthenticationHandler(s.DB)
+	ah := handlers.NewAuthenticationHandler(cfg, s.DB, deps.Accounts, deps.Verifier)
+	ah.UserChanged = deps.UserChanged
+	ah.Events = deps.Events
+	ah.Lockout = deps.Lockout
+	ah.Captcha = deps.Captcha
+	s.authHandler = ah
 	s.Router.Mount("/auth", ah.AuthRouter())
 
+	// OIDC single sign-on, only enabled when an issuer is configured
+	if cfg.OIDC.IssuerURL != "" {
+		oh, err := handlers.NewOIDCHandler(context.Background(), s.DB, handlers.OIDCConfig{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+			EmailClaim:   cfg.OIDC.EmailClaim,
+			NameClaim:    cfg.OIDC.NameClaim,
+			GroupsClaim:  cfg.OIDC.GroupsClaim,
+			AdminGroup:   cfg.OIDC.AdminGroup,
+		}, ah)
+		if err != nil {
+			return nil, err
+		}
+		s.Router.Mount("/auth/oidc", oh.OIDCRouter())
+	}
+
 	// User Routes
-	uh := handlers.NewUserHandler(s.DB)
+	uh := handlers.NewUserHandler(cfg, s.DB, deps.Users, deps.Avatars, deps.Mailer)
+	uh.Events = deps.Events
+	uh.UserChanged = deps.UserChanged
+	uh.UseJobs(deps.Jobs)
 	s.Router.Mount("/users", uh.UserRouter())
 
-	return s
+	// Group Routes
+	gh := handlers.NewGroupHandler(cfg, s.DB, deps.Groups, deps.Users)
+	s.Router.Mount("/groups", gh.GroupRouter())
+
+	// Profile Routes
+	prh := handlers.NewProfileHandler(cfg, s.DB, deps.Profiles)
+	s.Router.Mount("/profile", prh.ProfileRouter())
+
+	// Admin Routes
+	adh := handlers.NewAdminHandler(cfg, s.DB, deps.Admin, deps.Users, deps.Mailer)
+	adh.Reload = s.ReloadConfig
+	adh.UserChanged = deps.UserChanged
+	adh.Events = deps.Events
+	adh.Maintenance = s.maintenance
+	s.Router.Mount("/admin", adh.AdminRouter())
+	s.Router.Mount("/admin/profile-fields", prh.ProfileFieldsRouter())
+	s.Router.Mount("/admin/erasures", uh.ErasureAdminRouter())
+
+	// Admin Dashboard Routes
+	// HTML pages for browsers, authenticated by a cookie instead of the Authorization header
+	dsh := handlers.NewDashboardHandler(cfg, s.DB, deps.Users, ah, adh)
+	s.Router.Mount("/admin/ui", dsh.DashboardRouter())
+
+	// Webhook Routes
+	wh := handlers.NewWebhookHandler(cfg, s.DB)
+	s.Router.Mount("/admin/webhooks", wh.WebhookRouter())
+
+	// Email Routes
+	eh := handlers.NewEmailHandler(cfg, s.DB, deps.Outbox)
+	s.Router.Mount("/admin/emails", eh.EmailRouter())
+
+	// WebSocket Routes
+	rth := handlers.NewRealtimeHandler(cfg, s.DB, deps.Realtime)
+	s.Router.Mount("/ws", rth.RealtimeRouter())
+	s.Router.Mount("/events/stream", rth.StreamRouter())
+
+	// GraphQL Route
+	// Open to anonymous requests for login, the resolvers check the token and permissions of the others
+	s.Router.With(handlers.MiddlewareAdapter(handlers.OptionalJWTAuthMiddleware(s.DB, cfg.JWT))).
+		Handle("POST /graphql", graph.NewHandler(ah, uh))
+
+	// Internal Routes
+	// Metrics, profiling and maintenance, served on their own address kept out of the load balancer
+	if cfg.InternalAddr != "" {
+		s.internal = newInternalRouter(adh, accessLog)
+	} else if cfg.PprofEnabled {
+		s.Router.Mount("/debug", newPublicProfiler(cfg, s.DB))
+	}
+
+	// gRPC API, served on its own address with the same handlers
+	if cfg.GRPCAddr != "" {
+		s.grpc = grpcapi.NewServer(cfg, s.DB, ah, uh)
+	}
+
+	// Signed download links, authorized by their signature instead of a token
+	s.Router.Mount("/downloads", uh.DownloadRouter())
+
+	// Frontend bundle, the unknown paths under its prefix get its index.html
+	if cfg.Static.Dir != "" {
+		s.Router.Handle("GET "+cfg.Static.Path+"/*", http.StripPrefix(cfg.Static.Path, static.Handler(cfg.Static.Dir, cfg.Static.MaxAge)))
+		s.Router.Handle("GET "+cfg.Static.Path, http.RedirectHandler(cfg.Static.Path+"/", http.StatusMovedPermanently))
+	}
+
+	return s, nil
 }
 
+// ReloadConfig reads the configuration with Reloader and applies the rate limits, the CORS options and the
+// maintenance mode. The log level is applied by the Reloader. Any other setting needs a restart. When the new configuration
+// is invalid the error is returned and the current one is kept.
+func (s *Server) ReloadConfig() error {
+	if s.Reloader == nil {
+		return errors.New("configuration reload is not available")
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	cfg, err := s.Reloader()
+	if err != nil {
+		return err
+	}
+
+	s.limiter.SetLimit(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	s.publicLimiter.SetLimit(cfg.PublicRateLimitRPS, cfg.PublicRateLimitBurst)
+	s.authHandler.SetRateLimits(cfg)
+	s.cors.set(cfg.CORS)
+	// Left alone when unchanged, so a reload doesn't undo what was set through /admin/maintenance
+	if cfg.MaintenanceMode != s.maintenanceMode || cfg.MaintenanceMessage != s.maintenanceMessage {
+		s.maintenance.Set(cfg.MaintenanceMode, cfg.MaintenanceMessage)
+		s.maintenanceMode, s.maintenanceMessage = cfg.MaintenanceMode, cfg.MaintenanceMessage
+	}
+	log.Printf("[Server:ReloadConfig] Configuration reloaded: log level %s, rate limits, CORS and maintenance mode applied, other settings need a restart", cfg.LogLevel)
+	return nil
+}
+
+// Start serves requests and runs the background job workers and the janitor until SIGINT or SIGTERM.
+// It then stops accepting connections and claiming jobs, waits up to ShutdownTimeout for the in-flight requests
+// and running jobs to finish and closes the database pool. SIGHUP reloads the configuration (see ReloadConfig).
 func (s *Server) Start() error {
-	return http.ListenAndServe(":"+s.Port, s.Router)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := s.ReloadConfig(); err != nil {
+					log.Printf("[Server:Start] Configuration not reloaded: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		s.jobs.Run(jobsCtx)
+		close(jobsDone)
+	}()
+	go s.janitor.Run(jobsCtx)
+	// Rotated key files are picked up while running, see config.Keys
+	go s.Config.JWT.Keys.Watch(jobsCtx, s.Config.JWT.KeyFilesInterval)
+
+	srv := s.newHTTPServer(s.Config.ListenAddr, s.Router)
+	serveErr := make(chan error, 5)
+	var httpSrv *http.Server
+	if s.Config.TLS.Enabled() {
+		var certFile, keyFile string
+		certFile, keyFile, httpSrv = s.setupTLS(srv)
+		go func() {
+			serveErr <- srv.ListenAndServeTLS(certFile, keyFile)
+		}()
+	} else {
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+	}
+	if httpSrv != nil {
+		go func() {
+			serveErr <- httpSrv.ListenAndServe()
+		}()
+	}
+	// The socket is served by srv too, in plain HTTP: the proxy in front of it terminates TLS
+	if s.Config.ListenSocket != "" {
+		lis, err := listenUnix(s.Config.ListenSocket, s.Config.ListenSocketMode)
+		if err != nil {
+			return err
+		}
+		log.Printf("[Server:Start] Serving HTTP on unix socket %s", s.Config.ListenSocket)
+		go func() {
+			serveErr <- srv.Serve(lis)
+		}()
+	}
+	var internalSrv *http.Server
+	if s.internal != nil {
+		internalSrv = s.newInternalServer(s.internal)
+		log.Printf("[Server:Start] Serving metrics, pprof and maintenance routes on %s", s.Config.InternalAddr)
+		go func() {
+			serveErr <- internalSrv.ListenAndServe()
+		}()
+	}
+	if s.grpc != nil {
+		lis, err := net.Listen("tcp", s.Config.GRPCAddr)
+		if err != nil {
+			return err
+		}
+		log.Printf("[Server:Start] Serving gRPC on %s", s.Config.GRPCAddr)
+		go func() {
+			serveErr <- s.grpc.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
+
+	log.Printf("[Server:Start] Shutting down, waiting up to %v for in-flight requests", s.Config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+	if httpSrv != nil {
+		httpSrv.Shutdown(shutdownCtx)
+	}
+	if s.grpc != nil {
+		stopGRPC(shutdownCtx, s.grpc)
+	}
+	if internalSrv != nil {
+		internalSrv.Shutdown(shutdownCtx)
+	}
+	// Shutdown doesn't wait for the upgraded connections, they are told to reconnect elsewhere
+	s.realtime.Close()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("[Server:Start] Requests still running after %v were cut off: %v", s.Config.ShutdownTimeout, err)
+	}
+	stopJobs()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		log.Printf("[Server:Start] Jobs still running after %v were cut off, they will run again", s.Config.ShutdownTimeout)
+	}
+
+	if s.lockout != nil {
+		s.lockout.Close()
+	}
+	s.DB.Close()
+	if s.accessLogFile != nil {
+		s.accessLogFile.Close()
+	}
+	log.Printf("[Server:Start] Server stopped")
+	return err
+}
+
+// stopGRPC waits for the running calls like Shutdown does, and cuts them off when ctx is done
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// newHTTPServer returns a server with the configured timeouts, so slow clients can't hold connections forever
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       s.Config.HTTP.ReadTimeout,
+		ReadHeaderTimeout: s.Config.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      s.Config.HTTP.WriteTimeout,
+		IdleTimeout:       s.Config.HTTP.IdleTimeout,
+		MaxHeaderBytes:    s.Config.HTTP.MaxHeaderBytes,
+	}
+}
+
+// exceptPaths applies mw to every request but the ones for the given paths
+func exceptPaths(mw func(http.Handler) http.Handler, paths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range paths {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnix listens on the unix socket path with the permissions mode. A socket left over by a
+// process that didn't stop cleanly is replaced, one still accepting connections is an error.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is used by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The file is created with the umask, set the permissions the proxy needs
+	if err := os.Chmod(path, mode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
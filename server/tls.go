@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS prepares srv for HTTPS from the TLS config. It returns the certificate files to serve
+// (empty with autocert, which provides the certificates itself) and the plain HTTP server to run
+// next to it when TLS_HTTP_PORT is set.
+func (s *Server) setupTLS(srv *http.Server) (certFile, keyFile string, httpSrv *http.Server) {
+	cfg := s.Config.TLS
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	_, httpsPort, _ := net.SplitHostPort(s.Config.ListenAddr)
+	redirect := http.HandlerFunc(redirectToHTTPS(httpsPort))
+
+	if len(cfg.AutocertDomains) > 0 {
+		log.Printf("[Server:setupTLS] Serving HTTPS with Let's Encrypt certificates for %v", cfg.AutocertDomains)
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		// Also answers the tls-alpn-01 challenges, so no HTTP listener is required
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		if cfg.HTTPPort != "" {
+			httpSrv = s.newHTTPServer(":"+cfg.HTTPPort, m.HTTPHandler(redirect))
+		}
+		return "", "", httpSrv
+	}
+
+	log.Printf("[Server:setupTLS] Serving HTTPS with certificate %s", cfg.CertFile)
+	if cfg.HTTPPort != "" {
+		httpSrv = s.newHTTPServer(":"+cfg.HTTPPort, redirect)
+	}
+	return cfg.CertFile, cfg.KeyFile, httpSrv
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS port
+func redirectToHTTPS(httpsPort string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,111 @@
+// Package servertiming collects how long each part of a request took (database, bcrypt...)
+// and reports it in the Server-Timing response header, so clients can see where latency goes.
+//
+// Code measures a part with Track or Add using the request context; both are no-ops when
+// the middleware is not installed.
+package servertiming
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Timings accumulates durations per metric name for one request
+type Timings struct {
+	mu      sync.Mutex
+	metrics map[string]time.Duration
+	order   []string
+}
+
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Add adds d to the metric, metrics measured several times in a request are summed
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.metrics[name]; !ok {
+		t.order = append(t.order, name)
+	}
+	t.metrics[name] += d
+}
+
+// Track starts measuring the metric and returns the function that stops it
+//
+//	defer servertiming.Track(ctx, "bcrypt")()
+func Track(ctx context.Context, name string) func() {
+	t := FromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.Add(name, time.Since(start)) }
+}
+
+func (t *Timings) header(total time.Duration) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(t.order)+1)
+	for _, name := range t.order {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.2f", name, float64(t.metrics[name].Microseconds())/1000))
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.2f", float64(total.Microseconds())/1000))
+	return strings.Join(parts, ", ")
+}
+
+// Middleware stores a Timings in the request context and writes the Server-Timing header right
+// before the response headers are sent
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := &Timings{metrics: make(map[string]time.Duration)}
+		tw := &timingWriter{ResponseWriter: w, timings: t, start: time.Now()}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), contextKey{}, t)))
+	})
+}
+
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *Timings
+	start       time.Time
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.Header().Set("Server-Timing", tw.timings.header(time.Since(tw.start)))
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades take over the connection, the upgraders look for http.Hijacker
+func (tw *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(tw.ResponseWriter).Hijack()
+}
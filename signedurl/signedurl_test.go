@@ -0,0 +1,101 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// staticKey is the key function of a key that is never rotated
+func staticKey(key string) func() []byte {
+	return func() []byte { return []byte(key) }
+}
+
+// parse returns the URL of a signed link, failing the test when it isn't one
+func parse(t *testing.T, link string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", link, err)
+	}
+	return u
+}
+
+func TestVerify(t *testing.T) {
+	signer := New(staticKey("download:secret"))
+	link := signer.Sign("/downloads/users-export", url.Values{"format": {"csv"}}, now.Add(15*time.Minute))
+
+	tests := []struct {
+		name string
+		link string
+		now  time.Time
+		err  error // nil when the link is accepted
+	}{
+		{name: "valid", link: link, now: now},
+		{name: "parameters reordered", link: reorder(link), now: now},
+		{name: "expired", link: link, now: now.Add(15 * time.Minute), err: ErrExpired},
+		{name: "other path", link: strings.Replace(link, "users-export", "avatars/1", 1), now: now, err: ErrInvalid},
+		{name: "other query", link: strings.Replace(link, "format=csv", "format=json", 1), now: now, err: ErrInvalid},
+		{name: "parameter added", link: link + "&id=1", now: now, err: ErrInvalid},
+		{name: "expiry pushed back", link: pushBack(t, link), now: now.Add(time.Hour), err: ErrInvalid},
+		{name: "signature missing", link: "/downloads/users-export?expires=" + url.QueryEscape(parse(t, link).Query().Get("expires")) + "&format=csv", now: now, err: ErrInvalid},
+		{name: "expiry missing", link: "/downloads/users-export?format=csv", now: now, err: ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := signer.Verify(parse(t, tt.link), tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Verify(%q) = %v, want %v", tt.link, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	key := "download:old"
+	signer := New(func() []byte { return []byte(key) })
+	before := signer.Sign("/downloads/users-export", nil, now.Add(15*time.Minute))
+
+	key = "download:new"
+	after := signer.Sign("/downloads/users-export", nil, now.Add(15*time.Minute))
+
+	if err := signer.Verify(parse(t, before), now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify of a link signed with the rotated key = %v, want %v", err, ErrInvalid)
+	}
+	if err := signer.Verify(parse(t, after), now); err != nil {
+		t.Errorf("Verify of a link signed with the new key = %v", err)
+	}
+}
+
+func TestVerifyOtherKey(t *testing.T) {
+	link := New(staticKey("download:secret")).Sign("/downloads/avatars/1", nil, now.Add(time.Minute))
+
+	if err := New(staticKey("download:other")).Verify(parse(t, link), now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify = %v, want %v", err, ErrInvalid)
+	}
+}
+
+// reorder returns link with its query parameters in reverse order
+func reorder(link string) string {
+	path, query, _ := strings.Cut(link, "?")
+	params := strings.Split(query, "&")
+	for i, j := 0, len(params)-1; i < j; i, j = i+1, j-1 {
+		params[i], params[j] = params[j], params[i]
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// pushBack returns link expiring two hours from now, with its signature kept
+func pushBack(t *testing.T, link string) string {
+	u := parse(t, link)
+	query := u.Query()
+	query.Set("expires", strconv.FormatInt(now.Add(2*time.Hour).Unix(), 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
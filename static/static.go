@@ -0,0 +1,80 @@
+// Package static serves a frontend bundle (the build output of a single page application) from a
+// directory, so it can ship with the API in the same binary deployment.
+//
+// Files are cached by browsers for the configured max age, except index.html which is revalidated on
+// each load so a new deployment is picked up. Paths without a file get index.html, letting the frontend
+// router handle them, unless they look like an asset (they have an extension): a missing script must be
+// a 404, not an HTML page the browser fails to run.
+package static
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const index = "index.html"
+
+// Handler serves the files of dir. Requests must have the mount prefix stripped.
+func Handler(dir string, maxAge time.Duration) http.Handler {
+	root := os.DirFS(dir)
+	cacheControl := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = index
+		}
+		f, info, err := open(root, name)
+		if errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+			name = index
+			f, info, err = open(root, name)
+		}
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("[Static:Handler] Error opening %s: %v", name, err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		if info.Name() == index {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		// Files of os.DirFS are *os.File
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f.(io.ReadSeeker))
+	})
+}
+
+// open returns the file name of root, or the index.html of the directory name. Directories are never listed.
+func open(root fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	info, err := fs.Stat(root, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, index)
+		info, err = fs.Stat(root, name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, info, nil
+}
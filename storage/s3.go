@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/sigv4"
+)
+
+type S3Config struct {
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Endpoint of an S3 compatible service (MinIO...). Objects are addressed path style
+	// (Endpoint/Bucket/key) when set, virtual host style on AWS otherwise.
+	Endpoint string
+	// PublicURL is the prefix of the returned URLs, e.g. a CDN in front of the bucket.
+	// Defaults to the object URL.
+	PublicURL string
+}
+
+// S3 stores objects with plain PutObject/DeleteObject calls signed with AWS Signature Version 4 (see package sigv4).
+// Objects are uploaded with a public-read ACL so the returned URLs work without credentials.
+type S3 struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func NewS3(cfg S3Config) *S3 {
+	return &S3{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *S3) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Acl", "public-read")
+	if err := s.do(req, data); err != nil {
+		return "", err
+	}
+
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/") + "/" + key, nil
+	}
+	return s.objectURL(key), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, nil)
+}
+
+func (s *S3) objectURL(key string) string {
+	escaped := (&url.URL{Path: key}).EscapedPath()
+	if s.cfg.Endpoint != "" {
+		return strings.TrimRight(s.cfg.Endpoint, "/") + "/" + s.cfg.Bucket + "/" + escaped
+	}
+	return "https://" + s.cfg.Bucket + ".s3." + s.cfg.Region + ".amazonaws.com/" + escaped
+}
+
+func (s *S3) do(req *http.Request, payload []byte) error {
+	sigv4.Sign(req, payload, sigv4.Credentials{AccessKey: s.cfg.AccessKey, SecretKey: s.cfg.SecretKey}, s.cfg.Region, "s3", time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// DELETE answers 204, also for missing keys
+	if res.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("storage: s3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, body)
+	}
+	return nil
+}
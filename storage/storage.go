@@ -0,0 +1,13 @@
+// Package storage stores uploaded files (user avatars) and returns the URL they are served from.
+// Backends: local disk (downloaded through the signed links of the API) and S3 or any S3 compatible service.
+package storage
+
+import "context"
+
+type Storage interface {
+	// Put stores data under key, replacing what was there, and returns its public URL, empty when the
+	// backend keeps its files private
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+	// Delete removes key. Deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,147 @@
+// Package testutil has the boilerplate of handler tests: building JSON and authenticated requests,
+// serving them through the adapters, and checking the answer. Tests using it live in an external
+// test package (package handlers_test), since testutil itself imports handlers.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hi-im-yan/jwt-with-go/apperrors"
+	"github.com/hi-im-yan/jwt-with-go/handlers"
+	"github.com/hi-im-yan/jwt-with-go/rbac"
+)
+
+// Secret signs the tokens of Token and AuthRequest, give config.NewKeys(Secret, nil, nil) to the config.JWT
+// of the server under test
+var Secret = []byte("testutil-secret")
+
+// User is who a test request is sent as
+type User struct {
+	ID        int
+	Name      string
+	Roles     []string
+	SessionID int64 // JWTAuthMiddleware looks it up in the sessions table
+}
+
+// Token returns a JWT with the claims CreateJwtToken would give to u, signed with Secret and valid for an hour
+func Token(t testing.TB, u User) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"sub":      strconv.Itoa(u.ID),
+		"sid":      u.SessionID,
+		"username": u.Name,
+		"roles":    u.Roles,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(Secret)
+	if err != nil {
+		t.Fatalf("testutil: signing token: %v", err)
+	}
+	return token
+}
+
+// NewRequest returns a request whose body is body encoded as JSON, no body when it is nil
+func NewRequest(t testing.TB, method, target string, body interface{}) *http.Request {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("testutil: encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// AuthRequest is NewRequest with a bearer token of u, for routes behind JWTAuthMiddleware
+func AuthRequest(t testing.TB, u User, method, target string, body interface{}) *http.Request {
+	t.Helper()
+	r := NewRequest(t, method, target, body)
+	r.Header.Set("Authorization", "Bearer "+Token(t, u))
+	return r
+}
+
+// WithPathParam sets a parameter of the path the way the chi router would, for handlers called
+// directly reading chi.URLParam or binding `path:"..."` fields
+func WithPathParam(r *http.Request, key, value string) *http.Request {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		rctx = chi.NewRouteContext()
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	}
+	rctx.URLParams.Add(key, value)
+	return r
+}
+
+// AsUser puts in the context what JWTAuthMiddleware would, so a handler can be called directly
+// without a database to check the session and resolve the permissions
+func AsUser(r *http.Request, u User, permissions ...string) *http.Request {
+	perms := rbac.Permissions{}
+	for _, permission := range permissions {
+		perms[permission] = true
+	}
+	ctx := context.WithValue(r.Context(), handlers.ContextUsernameKey, u.Name)
+	ctx = context.WithValue(ctx, handlers.ContextRolesKey, u.Roles)
+	ctx = context.WithValue(ctx, handlers.ContextUserIDKey, u.ID)
+	ctx = context.WithValue(ctx, handlers.ContextSessionIDKey, u.SessionID)
+	ctx = context.WithValue(ctx, handlers.ContextPermissionsKey, perms)
+	return r.WithContext(ctx)
+}
+
+// Serve runs handler through ApiHandlerAdapter and returns the recorded answer
+func Serve(handler handlers.ApiHandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handlers.ApiHandlerAdapter(handler).ServeHTTP(rec, r)
+	return rec
+}
+
+// DecodeJSON decodes the body of a successful answer into v
+func DecodeJSON(t testing.TB, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(v); err != nil {
+		t.Fatalf("testutil: decoding body %q: %v", rec.Body.String(), err)
+	}
+}
+
+// DecodeError decodes the body of an error answer
+func DecodeError(t testing.TB, rec *httptest.ResponseRecorder) apperrors.Response {
+	t.Helper()
+	var res apperrors.Response
+	DecodeJSON(t, rec, &res)
+	return res
+}
+
+// AssertStatus fails the test when the answer doesn't have the status
+func AssertStatus(t testing.TB, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (body %s)", rec.Code, status, rec.Body.String())
+	}
+}
+
+// AssertError fails the test when the answer isn't an error with the status and code, like 404 "E404".
+// It returns the error body for further checks.
+func AssertError(t testing.TB, rec *httptest.ResponseRecorder, status int, code string) apperrors.Response {
+	t.Helper()
+	AssertStatus(t, rec, status)
+	res := DecodeError(t, rec)
+	if res.Code != code {
+		t.Fatalf("error code = %q, want %q (detail %q)", res.Code, code, res.Detail)
+	}
+	return res
+}
@@ -0,0 +1,158 @@
+// Package webhooks notifies the endpoints registered in the webhooks table of the user lifecycle events
+// published on the events bus. Each event queues one delivery job per subscribed endpoint, so a slow or
+// down endpoint never holds a request and failed deliveries are retried with the backoff of the jobs package.
+//
+// Deliveries are POSTs of the JSON encoded events.Event with these headers:
+//
+//	X-Webhook-Event      the event type, e.g. user.created
+//	X-Webhook-ID         the event id, the same on every retry so receivers can drop duplicates
+//	X-Webhook-Timestamp  unix time of the attempt
+//	X-Webhook-Signature  sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint secret>
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hi-im-yan/jwt-with-go/events"
+	"github.com/hi-im-yan/jwt-with-go/jobs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Events are the types endpoints can subscribe to
+var Events = []string{events.UserCreated, events.UserUpdated, events.UserDeleted, events.LoginFailed}
+
+// Job kind of the deliveries
+const deliverJob = "webhook.deliver"
+
+// delivery is the payload of a delivery job. The endpoint is read again on each attempt,
+// so a changed URL or secret applies to the pending deliveries and a disabled endpoint gets none.
+type delivery struct {
+	WebhookID int             `json:"webhook_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+type Dispatcher struct {
+	db     *pgxpool.Pool
+	queue  *jobs.Queue
+	client *http.Client
+}
+
+// NewDispatcher registers the delivery jobs on queue. Endpoints get timeout to answer a delivery.
+func NewDispatcher(db *pgxpool.Pool, queue *jobs.Queue, timeout time.Duration) *Dispatcher {
+	d := &Dispatcher{db: db, queue: queue, client: &http.Client{Timeout: timeout}}
+	queue.Register(deliverJob, d.deliver)
+	return d
+}
+
+// Subscribe sends the events endpoints can subscribe to (see Events) published on bus
+func (d *Dispatcher) Subscribe(bus *events.Bus) {
+	bus.Subscribe(d.emit, Events...)
+}
+
+// emit queues a delivery of e to every active endpoint subscribed to its type. Errors are only
+// logged: an event is never a reason to fail the request that caused it.
+func (d *Dispatcher) emit(ctx context.Context, e events.Event) {
+	rows, err := d.db.Query(ctx, `SELECT id FROM webhooks WHERE active AND $1 = ANY(events);`, e.Type)
+	if err != nil {
+		log.Printf("[Webhooks:emit] Error querying the endpoints of %s: %v", e.Type, err)
+		return
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		log.Printf("[Webhooks:emit] Error reading the endpoints of %s: %v", e.Type, err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	event, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[Webhooks:emit] Error encoding a %s event: %v", e.Type, err)
+		return
+	}
+	for _, webhookID := range ids {
+		if err := d.queue.Enqueue(ctx, deliverJob, delivery{WebhookID: webhookID, Event: event}); err != nil {
+			log.Printf("[Webhooks:emit] Error queuing %s event %s for webhook %d: %v", e.Type, e.ID, webhookID, err)
+		}
+	}
+}
+
+// deliver posts an event to its endpoint, any answer but a 2xx makes the job retry
+func (d *Dispatcher) deliver(ctx context.Context, payload json.RawMessage) error {
+	var job delivery
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("webhooks: decoding delivery: %w", err)
+	}
+	var event events.Event
+	if err := json.Unmarshal(job.Event, &event); err != nil {
+		return fmt.Errorf("webhooks: decoding event: %w", err)
+	}
+
+	var url, secret string
+	var active bool
+	err := d.db.QueryRow(ctx, `SELECT url, secret, active FROM webhooks WHERE id = $1;`, job.WebhookID).Scan(&url, &secret, &active)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !active) {
+		log.Printf("[Webhooks:deliver] Webhook %d was deleted or disabled, dropping event %s", job.WebhookID, event.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(job.Event))
+	if err != nil {
+		return fmt.Errorf("webhooks: building request to %s: %w", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jwt-with-go-webhooks")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(secret, timestamp, job.Event))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhooks: posting %s to webhook %d: %w", event.Type, job.WebhookID, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhooks: webhook %d answered %s to %s", job.WebhookID, res.Status, event.Type)
+	}
+	log.Printf("[Webhooks:deliver] Delivered %s event %s to webhook %d", event.Type, event.ID, job.WebhookID)
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret. Receivers compute it
+// the same way and compare it with the X-Webhook-Signature header, then reject old timestamps.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates the signing secret of a new endpoint
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}